@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,7 +12,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/muesli/reflow/wordwrap"
+	"github.com/charmbracelet/x/ansi"
 )
 
 // ChatComponent represents the chat view
@@ -35,12 +37,44 @@ type ChatComponent struct {
 	markdownEnabled  bool
 
 	// Raw session history for debugging/inspection
-	rawSessionHistory []string
+	rawSessionHistory []rawEntry
 
 	// Tool call tracking - maps tool call ID to chat message index
 	toolCallMessageIndex map[string]int
+	toolOutputTail       map[string][]string // last lines of live output of running tool calls
+	toolOutputDirty      bool                // live output changed since the last render
+
+	// Folding of long messages. Messages keep their full content, only the view is folded.
+	FoldLinesOver int          // fold messages rendering to more lines than this, 0 disables
+	unfolded      map[int]bool // messages expanded in scroll mode
+	foldSpans     []foldSpan   // rendered line span of each foldable message
+
+	messageStarts []int // first rendered line of each message
+
+	WrapMode  string // WrapModeWrap or WrapModeScroll to keep long lines and scroll sideways
+	WrapWidth int    // columns set with :wrap, 0 wraps at the terminal width
+
+	// Last tool call jumped to, so jumps near the bottom don't get stuck when
+	// the viewport can't scroll the message to the top
+	toolJump       int
+	toolJumpOffset int
+	toolJumped     bool
+}
+
+// foldSpan is where a message that can be folded sits in the rendered chat
+type foldSpan struct {
+	message    int
+	start, end int // viewport lines, end exclusive
 }
 
+// Wrap modes of the chat and raw session views, set by ui.wrap_mode
+const (
+	WrapModeWrap   = "wrap"
+	WrapModeScroll = "scroll"
+
+	horizontalScrollStep = 8 // columns moved by h and l in scroll mode
+)
+
 const (
 	asimiPrefix           = "🎏  "
 	completeSuccessPrefix = "🐉  "
@@ -182,7 +216,7 @@ func NewChatComponentWithStatus(width, height int, markdownEnabled bool, getStat
 		TouchScrollSpeed:     3,        // Lines to scroll per touch movement unit
 		markdownRenderer:     renderer, // Only set when markdown rendering is enabled
 		markdownEnabled:      markdownEnabled,
-		rawSessionHistory:    make([]string, 0),
+		rawSessionHistory:    make([]rawEntry, 0),
 		toolCallMessageIndex: make(map[string]int),
 		Style: lipgloss.NewStyle().
 			Width(width).
@@ -211,8 +245,11 @@ func (c *ChatComponent) Clear() {
 	c.ScrollLocked = false
 	c.TouchStartY = 0
 	c.TouchDragging = false
-	c.rawSessionHistory = make([]string, 0)
+	c.rawSessionHistory = make([]rawEntry, 0)
 	c.toolCallMessageIndex = make(map[string]int)
+	c.unfolded = nil
+	c.foldSpans = nil
+	c.toolJumped = false
 
 	c.Viewport.SetContent(ms)
 	c.Viewport.GotoTop()
@@ -328,6 +365,16 @@ func (c *ChatComponent) ScrollToBottom() {
 	}
 }
 
+// ScrollLeft scrolls the view left by cols columns when long lines aren't wrapped
+func (c *ChatComponent) ScrollLeft(cols int) {
+	c.Viewport.ScrollLeft(cols)
+}
+
+// ScrollRight scrolls the view right by cols columns when long lines aren't wrapped
+func (c *ChatComponent) ScrollRight(cols int) {
+	c.Viewport.ScrollRight(cols)
+}
+
 // ScrollUpOneLine scrolls up by one line
 func (c *ChatComponent) ScrollUpOneLine() {
 	c.Viewport.ScrollUp(1)
@@ -468,11 +515,33 @@ func (c *ChatComponent) FinalizeLastAIMessage() bool {
 	return isFailure
 }
 
+// ReopenLastAIMessage undoes FinalizeLastAIMessage so the next streamed chunks extend the last
+// AI message, it reports whether the last message is one
+func (c *ChatComponent) ReopenLastAIMessage() bool {
+	if len(c.Messages) == 0 {
+		return false
+	}
+	last := c.Messages[len(c.Messages)-1]
+	for _, prefix := range []string{"Asimi:SUCCESS: ", "Asimi:FAILURE: "} {
+		if content, ok := strings.CutPrefix(last, prefix); ok {
+			c.Messages[len(c.Messages)-1] = "Asimi: " + content
+			return true
+		}
+	}
+	return strings.HasPrefix(last, "Asimi:")
+}
+
 // UpdateContent updates the viewport content based on the messages
 func (c *ChatComponent) UpdateContent() {
+	c.toolOutputDirty = false
 	var messageViews []string
-	for _, message := range c.Messages {
+	c.foldSpans = c.foldSpans[:0]
+	c.messageStarts = c.messageStarts[:0]
+	renderedLines := 0
+	for i, message := range c.Messages {
 		var messageStyle lipgloss.Style
+		first := len(messageViews)
+		c.messageStarts = append(c.messageStarts, renderedLines)
 
 		// Check if this is a thinking message
 		if strings.HasPrefix(message, shellUserPrefix) {
@@ -495,7 +564,7 @@ func (c *ChatComponent) UpdateContent() {
 					Border(lipgloss.RoundedBorder()).
 					BorderForeground(lipgloss.Color("#373702")) // Terminal7 dark border
 
-				wrappedThinking := wordwrap.String("💭 Thinking: "+thinkingContent, c.Width-4)
+				wrappedThinking := c.wrap("💭 Thinking: "+thinkingContent, c.wrapWidth()-4)
 				messageViews = append(messageViews, thinkingStyle.Render(wrappedThinking))
 			}
 
@@ -512,7 +581,7 @@ func (c *ChatComponent) UpdateContent() {
 
 				userContent := strings.TrimSpace(strings.TrimPrefix(message, "You:"))
 
-				wrapWidth := c.Width
+				wrapWidth := c.wrapWidth()
 				const indentSpaces = 8
 				if wrapWidth > indentSpaces {
 					wrapWidth -= indentSpaces
@@ -521,7 +590,7 @@ func (c *ChatComponent) UpdateContent() {
 					wrapWidth = 1
 				}
 
-				wrapped := wordwrap.String(userContent, wrapWidth)
+				wrapped := c.wrap(userContent, wrapWidth)
 				indent := strings.Repeat(" ", indentSpaces)
 				lines := strings.Split(wrapped, "\n")
 				for i := range lines {
@@ -561,9 +630,23 @@ func (c *ChatComponent) UpdateContent() {
 					Foreground(lipgloss.Color("#01FAFA")). // Terminal7 text color
 					Padding(0, 1)
 				messageViews = append(messageViews,
-					messageStyle.Render(wordwrap.String(message, c.Width)))
+					messageStyle.Render(c.wrap(message, c.wrapWidth())))
+			}
+		}
+
+		if len(messageViews) == first {
+			continue
+		}
+		view := strings.Join(messageViews[first:], "\n")
+		lines := strings.Count(view, "\n") + 1
+		if c.FoldLinesOver > 0 && lines > c.FoldLinesOver {
+			if !c.unfolded[i] {
+				view = foldView(view, c.FoldLinesOver)
 			}
+			c.foldSpans = append(c.foldSpans, foldSpan{message: i, start: renderedLines, end: renderedLines + strings.Count(view, "\n") + 1})
 		}
+		messageViews = append(messageViews[:first], view)
+		renderedLines += strings.Count(view, "\n") + 1
 	}
 	content := lipgloss.JoinVertical(lipgloss.Left, messageViews...)
 	c.Viewport.SetContent(content)
@@ -574,6 +657,90 @@ func (c *ChatComponent) UpdateContent() {
 	}
 }
 
+// foldView keeps the first keep lines of a rendered message and a marker for the rest
+func foldView(view string, keep int) string {
+	lines := strings.Split(view, "\n")
+	marker := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).
+		Render(fmt.Sprintf("  [+%d lines] (z in scroll mode to expand)", len(lines)-keep))
+	return strings.Join(append(lines[:keep:keep], marker), "\n")
+}
+
+// ToggleFold folds or expands the lowest long message on screen.
+// It returns false when no long message is visible.
+func (c *ChatComponent) ToggleFold() bool {
+	top := c.Viewport.YOffset
+	bottom := top + c.Viewport.Height
+	for i := len(c.foldSpans) - 1; i >= 0; i-- {
+		span := c.foldSpans[i]
+		if span.start >= bottom || span.end <= top {
+			continue
+		}
+		if c.unfolded == nil {
+			c.unfolded = make(map[int]bool)
+		}
+		c.unfolded[span.message] = !c.unfolded[span.message]
+		c.UpdateContent()
+		// Keep the message in view when folding it back
+		if span.start < c.Viewport.YOffset {
+			c.Viewport.SetYOffset(span.start)
+		}
+		return true
+	}
+	return false
+}
+
+// JumpToToolCall scrolls to the start of the next or previous tool call message,
+// wrapping around at the ends. It returns the message index, whether it wrapped and
+// false when the chat has no tool calls.
+func (c *ChatComponent) JumpToToolCall(forward bool) (index int, wrapped bool, ok bool) {
+	var indices []int
+	for _, idx := range c.toolCallMessageIndex {
+		if idx < len(c.messageStarts) {
+			indices = append(indices, idx)
+		}
+	}
+	if len(indices) == 0 {
+		return 0, false, false
+	}
+	sort.Ints(indices)
+
+	// Measure from the last jump while the viewport hasn't moved, else from the top line
+	after := func(idx int) bool { return c.messageStarts[idx] > c.Viewport.YOffset }
+	before := func(idx int) bool { return c.messageStarts[idx] < c.Viewport.YOffset }
+	if c.toolJumped && c.toolJumpOffset == c.Viewport.YOffset {
+		after = func(idx int) bool { return idx > c.toolJump }
+		before = func(idx int) bool { return idx < c.toolJump }
+	}
+
+	target := -1
+	if forward {
+		for _, idx := range indices {
+			if after(idx) {
+				target = idx
+				break
+			}
+		}
+		if target < 0 {
+			target, wrapped = indices[0], true
+		}
+	} else {
+		for i := len(indices) - 1; i >= 0; i-- {
+			if before(indices[i]) {
+				target = indices[i]
+				break
+			}
+		}
+		if target < 0 {
+			target, wrapped = indices[len(indices)-1], true
+		}
+	}
+
+	c.Viewport.SetYOffset(c.messageStarts[target])
+	c.UserScrolled = true
+	c.toolJump, c.toolJumpOffset, c.toolJumped = target, c.Viewport.YOffset, true
+	return target, wrapped, true
+}
+
 // renderMarkdown renders markdown content with glamour
 func (c *ChatComponent) renderMarkdown(content string) string {
 	if !c.markdownEnabled || c.markdownRenderer == nil {
@@ -588,20 +755,43 @@ func (c *ChatComponent) renderMarkdown(content string) string {
 
 	// Apply word wrapping to the rendered output.
 	// Glamour is configured with WordWrap(0) to disable its internal wrapping,
-	// so we wrap here using the current viewport width.
-	// wordwrap.String() preserves ANSI escape sequences, allowing proper
+	// so we wrap here using the current viewport width or the one set with :wrap.
+	// c.wrap() preserves ANSI escape sequences, allowing proper
 	// re-wrapping on terminal resize without recreating the renderer.
-	wrapped := wordwrap.String(rendered, c.Width-2)
+	wrapped := c.wrap(rendered, c.wrapWidth()-2)
 
 	return strings.TrimSpace(wrapped)
 }
 
 func (c *ChatComponent) renderPlainText(content string) string {
-	width := c.Width - 2
+	return strings.TrimSpace(c.wrap(content, c.wrapWidth()-2))
+}
+
+// wrapWidth is the width messages are wrapped at: the one set with :wrap, else the terminal's
+func (c *ChatComponent) wrapWidth() int {
+	if c.WrapWidth > 0 {
+		return c.WrapWidth
+	}
+	return c.Width
+}
+
+// SetWrapWidth wraps the messages at cols columns whatever the terminal width, 0 to follow
+// it again, and renders them anew
+func (c *ChatComponent) SetWrapWidth(cols int) {
+	c.WrapWidth = max(cols, 0)
+	c.UpdateContent()
+}
+
+// wrap wraps s at width columns, breaking words longer than a line, unless
+// the wrap mode is scroll. ANSI sequences and wide runes are measured properly.
+func (c *ChatComponent) wrap(s string, width int) string {
+	if c.WrapMode == WrapModeScroll {
+		return s
+	}
 	if width < 1 {
 		width = 1
 	}
-	return strings.TrimSpace(wordwrap.String(content, width))
+	return ansi.Wrap(s, width, "")
 }
 
 // extractThinkingContent separates thinking content from regular content
@@ -761,21 +951,76 @@ func (c ChatComponent) View() string {
 
 // ===== Raw History Management =====
 
-// AddToRawHistory adds an entry to the raw session history with a timestamp
+// rawEntry is an entry of the raw session history
+type rawEntry struct {
+	kind    string // e.g. STREAM_CHUNK or TOOL_SUCCESS
+	text    string
+	message int // the chat message it concerns, for :errors to jump to
+}
+
+// rawErrorKinds are the raw history entries :errors lists
+var rawErrorKinds = []string{"TOOL_ERROR", "STREAM_ERROR", "ERROR"}
+
+// AddToRawHistory adds an entry to the raw session history with a timestamp, concerning the
+// message added next or, when none is, the last one
 func (c *ChatComponent) AddToRawHistory(prefix, content string) {
+	c.AddToRawHistoryAt(prefix, content, len(c.Messages))
+}
+
+// AddToRawHistoryAt adds an entry to the raw session history concerning the chat message at index
+func (c *ChatComponent) AddToRawHistoryAt(prefix, content string, index int) {
 	timestamp := time.Now().Format("15:04:05")
 	entry := fmt.Sprintf("[%s] %s: %s", timestamp, prefix, content)
-	c.rawSessionHistory = append(c.rawSessionHistory, entry)
+	c.rawSessionHistory = append(c.rawSessionHistory, rawEntry{kind: prefix, text: entry, message: index})
 }
 
-// GetRawHistory returns the raw session history
-func (c *ChatComponent) GetRawHistory() []string {
-	return c.rawSessionHistory
+// RawErrors returns the error entries of the raw session history, oldest first
+func (c *ChatComponent) RawErrors() []rawEntry {
+	var errs []rawEntry
+	for _, entry := range c.rawSessionHistory {
+		if slices.Contains(rawErrorKinds, entry.kind) {
+			errs = append(errs, entry)
+		}
+	}
+	return errs
+}
+
+// ScrollToMessage scrolls to the start of the message at index, the last one when it's past
+// the end, returning false when the chat is empty
+func (c *ChatComponent) ScrollToMessage(index int) bool {
+	if len(c.messageStarts) == 0 {
+		return false
+	}
+	index = min(max(index, 0), len(c.messageStarts)-1)
+	c.Viewport.SetYOffset(c.messageStarts[index])
+	c.UserScrolled = true
+	return true
+}
+
+// GetRawHistory returns the raw session history entries of the kinds keep accepts,
+// or all of them when keep is nil
+func (c *ChatComponent) GetRawHistory(keep func(kind string) bool) []string {
+	entries := make([]string, 0, len(c.rawSessionHistory))
+	for _, entry := range c.rawSessionHistory {
+		if keep == nil || keep(entry.kind) {
+			entries = append(entries, entry.text)
+		}
+	}
+	return entries
+}
+
+// RawHistoryKinds counts the raw session history entries of each kind
+func (c *ChatComponent) RawHistoryKinds() map[string]int {
+	kinds := make(map[string]int)
+	for _, entry := range c.rawSessionHistory {
+		kinds[entry.kind]++
+	}
+	return kinds
 }
 
 // ClearRawHistory clears the raw session history
 func (c *ChatComponent) ClearRawHistory() {
-	c.rawSessionHistory = make([]string, 0)
+	c.rawSessionHistory = make([]rawEntry, 0)
 }
 
 // ===== Tool Call Tracking =====
@@ -823,8 +1068,49 @@ func (c *ChatComponent) HandleToolCallExecuting(msg ToolCallExecutingMsg) {
 	}
 }
 
+// liveOutputLines is how many lines of a running tool's output are shown
+const liveOutputLines = 5
+
+// HandleToolCallOutputChunk adds a line of output under a running tool call. Re-rendering
+// the chat on every line is too slow for chatty commands, so the chunk is only rendered by
+// the next FlushToolOutput. It reports whether the caller should schedule one.
+func (c *ChatComponent) HandleToolCallOutputChunk(msg ToolCallOutputChunkMsg) bool {
+	idx, exists := c.GetToolCallMessageIndex(msg.Call.ID)
+	if !exists || idx >= len(c.Messages) {
+		return false
+	}
+	if c.toolOutputTail == nil {
+		c.toolOutputTail = make(map[string][]string)
+	}
+	tail := append(c.toolOutputTail[msg.Call.ID], msg.Chunk)
+	if len(tail) > liveOutputLines {
+		tail = tail[len(tail)-liveOutputLines:]
+	}
+	c.toolOutputTail[msg.Call.ID] = tail
+
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(formatToolCall(msg.Call.Tool.Name(), "⚙️", msg.Call.Input, "", nil), "\n"))
+	for _, line := range tail {
+		b.WriteString("\n")
+		b.WriteString(treeMidPrefix)
+		b.WriteString(line)
+	}
+	c.Messages[idx] = b.String()
+	schedule := !c.toolOutputDirty
+	c.toolOutputDirty = true
+	return schedule
+}
+
+// FlushToolOutput renders the live tool output received since the last render
+func (c *ChatComponent) FlushToolOutput() {
+	if c.toolOutputDirty {
+		c.UpdateContent()
+	}
+}
+
 // HandleToolCallSuccess handles a successful tool call message
 func (c *ChatComponent) HandleToolCallSuccess(msg ToolCallSuccessMsg) {
+	delete(c.toolOutputTail, msg.Call.ID)
 	formatted := formatToolCall(msg.Call.Tool.Name(), checkPrefix, msg.Call.Input, msg.Call.Result, nil)
 	// Update the existing message if we have its index
 	if idx, exists := c.GetToolCallMessageIndex(msg.Call.ID); exists && idx < len(c.Messages) {
@@ -840,6 +1126,7 @@ func (c *ChatComponent) HandleToolCallSuccess(msg ToolCallSuccessMsg) {
 
 // HandleToolCallError handles a failed tool call message
 func (c *ChatComponent) HandleToolCallError(msg ToolCallErrorMsg) {
+	delete(c.toolOutputTail, msg.Call.ID)
 	formatted := formatToolCall(msg.Call.Tool.Name(), "⁉️", msg.Call.Input, "", msg.Call.Error)
 	// Update the existing message if we have its index
 	if idx, exists := c.GetToolCallMessageIndex(msg.Call.ID); exists && idx < len(c.Messages) {
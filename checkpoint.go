@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Checkpoint is a snapshot of the working tree taken before a tool call that may change files.
+// It is stored as a commit no branch points at, leaving HEAD, the index and the stash alone,
+// so git gc prunes it after gc.pruneExpire, two weeks by default.
+type Checkpoint struct {
+	Commit string
+	Tree   string
+	Tool   string // the tool call, or :restore, it was taken before
+	Time   time.Time
+}
+
+// checkpointTools are the tools that may change files, git.checkpoints snapshots the working
+// tree before each of their calls
+var checkpointTools = []string{"write_file", "replace_text", "run_in_shell"}
+
+// checkpointEnv signs the checkpoint commits, which works without a git identity configured
+var checkpointEnv = []string{
+	"GIT_AUTHOR_NAME=asimi", "GIT_AUTHOR_EMAIL=asimi@localhost",
+	"GIT_COMMITTER_NAME=asimi", "GIT_COMMITTER_EMAIL=asimi@localhost",
+}
+
+// runGitPlumbing runs git in dir with extra environment and returns its trimmed stdout, the
+// error carrying stderr
+func runGitPlumbing(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// withTempIndex calls fn with the environment of a throwaway index, a copy of the repository's
+// so git add only hashes the files changed since it was last refreshed
+func withTempIndex(root string, copyIndex bool, fn func(env []string) error) error {
+	dir, err := os.MkdirTemp("", "asimi-checkpoint-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	index := filepath.Join(dir, "index")
+
+	if copyIndex {
+		indexPath, err := runGitPlumbing(root, nil, "rev-parse", "--git-path", "index")
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(indexPath) {
+			indexPath = filepath.Join(root, indexPath)
+		}
+		if err := copyFile(indexPath, index); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("copying the index: %w", err)
+		}
+	}
+	return fn(append([]string{"GIT_INDEX_FILE=" + index}, checkpointEnv...))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// snapshotWorkTree records the files of the working tree of the repository at dir, ignored
+// files aside, as a commit on top of HEAD and returns it
+func snapshotWorkTree(dir, tool string) (Checkpoint, error) {
+	root, err := runGitPlumbing(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	checkpoint := Checkpoint{Tool: tool, Time: time.Now()}
+	err = withTempIndex(root, true, func(env []string) error {
+		if _, err := runGitPlumbing(root, env, "add", "-A"); err != nil {
+			return err
+		}
+		if checkpoint.Tree, err = runGitPlumbing(root, env, "write-tree"); err != nil {
+			return err
+		}
+		args := []string{"commit-tree", checkpoint.Tree, "-m", "asimi checkpoint before " + tool}
+		if head, err := runGitPlumbing(root, nil, "rev-parse", "--verify", "-q", "HEAD"); err == nil {
+			args = append(args, "-p", head)
+		}
+		checkpoint.Commit, err = runGitPlumbing(root, env, args...)
+		return err
+	})
+	return checkpoint, err
+}
+
+// restoreWorkTree reverts the working tree at dir to a checkpoint: the files it holds are
+// written back and the ones created since, current holding them, are removed
+func restoreWorkTree(dir string, checkpoint, current Checkpoint) error {
+	root, err := runGitPlumbing(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	added, err := runGitPlumbing(root, nil, "diff", "--name-only", "--no-renames", "--diff-filter=A", "-z", checkpoint.Tree, current.Tree)
+	if err != nil {
+		return err
+	}
+	for _, path := range strings.Split(added, "\x00") {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(root, path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+	}
+	return withTempIndex(root, false, func(env []string) error {
+		if _, err := runGitPlumbing(root, env, "read-tree", checkpoint.Tree); err != nil {
+			return err
+		}
+		_, err := runGitPlumbing(root, env, "checkout-index", "-a", "-f")
+		return err
+	})
+}
+
+// checkpoint snapshots the working tree before a call of a tool that may change files, when
+// git.checkpoints is on. A snapshot identical to the last one isn't kept again.
+func (s *Session) checkpoint(tool string) {
+	if !s.gitConfig.Checkpoints || !slices.Contains(checkpointTools, tool) {
+		return
+	}
+	checkpoint, err := snapshotWorkTree(s.WorkingDir, tool)
+	if err != nil {
+		// Outside a git repository there is nothing to snapshot with
+		slog.Debug("checkpoint skipped", "tool", tool, "error", err)
+		return
+	}
+	if n := len(s.checkpoints); n > 0 && s.checkpoints[n-1].Tree == checkpoint.Tree {
+		return
+	}
+	s.checkpoints = append(s.checkpoints, checkpoint)
+}
+
+// Checkpoints returns the snapshots of the working tree taken this session, oldest first
+func (s *Session) Checkpoints() []Checkpoint {
+	return s.checkpoints
+}
+
+// RestoreCheckpoint reverts the working tree to checkpoint n, counting from 1. The tree is
+// checkpointed first, so restoring can be undone by restoring that checkpoint.
+func (s *Session) RestoreCheckpoint(n int) error {
+	if n < 1 || n > len(s.checkpoints) {
+		return fmt.Errorf("no checkpoint %d", n)
+	}
+	target := s.checkpoints[n-1]
+	current, err := snapshotWorkTree(s.WorkingDir, ":restore")
+	if err != nil {
+		return err
+	}
+	if current.Tree == target.Tree {
+		return nil
+	}
+	s.checkpoints = append(s.checkpoints, current)
+	return restoreWorkTree(s.WorkingDir, target, current)
+}
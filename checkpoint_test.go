@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestSession_Checkpoints(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	git := func(args ...string) string {
+		out, err := runGitPlumbing(dir, checkpointEnv, args...)
+		require.NoError(t, err)
+		return out
+	}
+	git("init", "-q")
+	require.NoError(t, os.WriteFile("a.txt", []byte("one\n"), 0644))
+	require.NoError(t, os.WriteFile(".gitignore", []byte("build.log\n"), 0644))
+	git("add", "a.txt", ".gitignore")
+	git("commit", "-q", "-m", "init")
+	head := git("rev-parse", "HEAD")
+	require.NoError(t, os.WriteFile("notes.txt", []byte("untracked\n"), 0644))
+
+	callTool := func(sess *Session, name, args string) {
+		tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}
+		sess.processToolCalls(context.Background(), []llms.ToolCall{tc})
+	}
+	write := func(sess *Session, path, content string) {
+		callTool(sess, "write_file", fmt.Sprintf(`{"path":%q,"content":%q}`, path, content))
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Off: no checkpoints
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	write(sess, "a.txt", "off\n")
+	require.Empty(t, sess.Checkpoints())
+
+	sess, err = NewSession(&mockLLMNoTools{}, &Config{Git: GitConfig{Checkpoints: true}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	write(sess, "a.txt", "two\n")
+	callTool(sess, "read_file", `{"path":"a.txt"}`)
+	write(sess, "new.txt", "new\n")
+	require.NoError(t, os.WriteFile("build.log", []byte("ignored\n"), 0644))
+	write(sess, "new.txt", "new\n")
+	// The tree is unchanged since the last checkpoint, which isn't kept twice
+	write(sess, "new.txt", "new\n")
+	checkpoints := sess.Checkpoints()
+	require.Len(t, checkpoints, 3)
+	require.Equal(t, "write_file", checkpoints[0].Tool)
+
+	// Back to before the first edit: a.txt as it was, new.txt gone, untracked and ignored files kept
+	require.NoError(t, sess.RestoreCheckpoint(1))
+	require.Equal(t, "off\n", read("a.txt"))
+	require.NoFileExists(t, "new.txt")
+	require.Equal(t, "untracked\n", read("notes.txt"))
+	require.Equal(t, "ignored\n", read("build.log"))
+	require.Len(t, sess.Checkpoints(), 4)
+	require.Equal(t, ":restore", sess.Checkpoints()[3].Tool)
+
+	// HEAD and the index are left alone
+	require.Equal(t, head, git("rev-parse", "HEAD"))
+	git("diff", "--cached", "--quiet")
+
+	// Restoring the checkpoint the restore took undoes it
+	require.NoError(t, sess.RestoreCheckpoint(4))
+	require.Equal(t, "two\n", read("a.txt"))
+	require.Equal(t, "new\n", read("new.txt"))
+	require.Error(t, sess.RestoreCheckpoint(6))
+}
+
+func TestSession_CheckpointsOutsideGit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{Git: GitConfig{Checkpoints: true}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: "write_file", Arguments: `{"path":"a.txt","content":"x"}`}}
+	sess.processToolCalls(context.Background(), []llms.ToolCall{tc})
+	require.FileExists(t, "a.txt")
+	require.Empty(t, sess.Checkpoints())
+}
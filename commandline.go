@@ -21,11 +21,11 @@ type Toast struct {
 type (
 	commandReadyMsg       struct{ command string }
 	commandCancelledMsg   struct{}
-	commandTextChangedMsg struct{}                // Signals completion update needed
-	navigateCompletionMsg struct{ direction int } // -1 for up, +1 for down
-	acceptCompletionMsg   struct{}                // Tab pressed
-	navigateHistoryMsg    struct{ direction int } // For completion or history
-	yesNoResponseMsg      struct{ answer bool }   // true for yes, false for no
+	commandTextChangedMsg struct{}                      // Signals completion update needed
+	navigateCompletionMsg struct{ direction int }       // -1 for up, +1 for down
+	acceptCompletionMsg   struct{}                      // Tab pressed
+	navigateHistoryMsg    struct{ direction int }       // For completion or history
+	yesNoResponseMsg      struct{ answer, always bool } // true for yes, false for no, always with a in EnterYesNoAlwaysMode
 )
 
 // Mode management - single unified message for all mode changes
@@ -62,6 +62,7 @@ type CommandLineComponent struct {
 
 	// Yes/No prompt support
 	yesNoQuestion string // The question being asked
+	yesNoAlways   bool   // a answers always too
 }
 
 // NewCommandLineComponent creates a new command line component
@@ -155,10 +156,18 @@ func (cl *CommandLineComponent) EnterYesNoMode(question string) tea.Cmd {
 	}
 }
 
+// EnterYesNoAlwaysMode enters yes/no prompt mode where a answers always, yes from now on
+func (cl *CommandLineComponent) EnterYesNoAlwaysMode(question string) tea.Cmd {
+	cmd := cl.EnterYesNoMode(question)
+	cl.yesNoAlways = true
+	return cmd
+}
+
 // ExitYesNoMode exits yes/no mode and returns to idle
 func (cl *CommandLineComponent) ExitYesNoMode() tea.Cmd {
 	cl.mode = CommandLineIdle
 	cl.yesNoQuestion = ""
+	cl.yesNoAlways = false
 	cl.input = ""
 	cl.cursorPos = 0
 	return func() tea.Msg {
@@ -272,7 +281,11 @@ func (cl *CommandLineComponent) Update() {
 func (cl *CommandLineComponent) View() string {
 	// Priority 1: Show yes/no prompt if in yes/no mode
 	if cl.mode == CommandLineYesNo {
-		promptText := cl.yesNoQuestion + " (y/n) " + cl.input
+		choices := " (y/n) "
+		if cl.yesNoAlways {
+			choices = " (y/n/a) "
+		}
+		promptText := cl.yesNoQuestion + choices + cl.input
 		var displayText string
 		if cl.showCursor {
 			cursorStyle := lipgloss.NewStyle().Reverse(true)
@@ -428,12 +441,18 @@ func (cl *CommandLineComponent) HandleKey(msg tea.KeyMsg) (tea.Cmd, bool) {
 		case "n", "N":
 			cl.input = "n"
 			return nil, true
+		case "a", "A":
+			if cl.yesNoAlways {
+				cl.input = "a"
+			}
+			return nil, true
 		case "enter":
-			if cl.input == "y" {
+			if cl.input == "y" || cl.input == "a" {
+				always := cl.input == "a"
 				exitCmd := cl.ExitYesNoMode()
 				return tea.Batch(
 					exitCmd,
-					func() tea.Msg { return yesNoResponseMsg{answer: true} },
+					func() tea.Msg { return yesNoResponseMsg{answer: true, always: always} },
 				), true
 			} else if cl.input == "n" {
 				exitCmd := cl.ExitYesNoMode()
@@ -2,16 +2,25 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	"github.com/aymanbagabas/go-udiff"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/tmc/langchaingo/llms"
 )
 
 //go:embed prompts/init.tmpl
@@ -20,6 +29,9 @@ var initializePrompt string
 //go:embed prompts/compact.txt
 var compactPrompt string
 
+//go:embed prompts/agents_regenerate.txt
+var agentsRegeneratePrompt string
+
 //go:embed dotagents/sandbox/bashrc
 var sandboxBashrc string
 
@@ -30,6 +42,35 @@ type InitTemplateData struct {
 	MissingFiles []string
 	ClearMode    bool
 	AgentsFile   string // The agents file name (AGENTS.md or CLAUDE.md)
+	Project      ProjectType
+}
+
+// ProjectType describes the primary language and build system detected in the project root
+type ProjectType struct {
+	Language string
+	Manifest string
+	Build    string
+	Test     string
+	Lint     string
+}
+
+// projectTypes lists the supported manifests in detection order
+var projectTypes = []ProjectType{
+	{Language: "Go", Manifest: "go.mod", Build: "go build ./...", Test: "go test ./...", Lint: "go vet ./..."},
+	{Language: "Rust", Manifest: "Cargo.toml", Build: "cargo build", Test: "cargo test", Lint: "cargo clippy"},
+	{Language: "Python", Manifest: "pyproject.toml", Build: "pip install -e .", Test: "pytest", Lint: "ruff check ."},
+	{Language: "JavaScript/TypeScript", Manifest: "package.json", Build: "npm run build", Test: "npm test", Lint: "npm run lint"},
+}
+
+// detectProjectType looks for a known manifest in the current directory and returns
+// the matching project type, or the zero value when none is found
+func detectProjectType() ProjectType {
+	for _, pt := range projectTypes {
+		if _, err := os.Stat(pt.Manifest); err == nil {
+			return pt
+		}
+	}
+	return ProjectType{}
 }
 
 // Command represents a slash command
@@ -37,10 +78,27 @@ type Command struct {
 	Name        string
 	Description string
 	Handler     func(*TUIModel, []string) tea.Cmd
+	Raw         bool // the handler gets the text after the command name unsplit, see Args
+}
+
+// Args splits a command line into the arguments for the command's handler. Raw commands
+// get the rest of the line after the command name as a single argument, none when empty
+func (c Command) Args(line string) []string {
+	if !c.Raw {
+		return strings.Fields(line)[1:]
+	}
+	line = strings.TrimSpace(line)
+	end := strings.IndexAny(line, " \t")
+	if end < 0 {
+		return nil
+	}
+	return []string{strings.TrimSpace(line[end:])}
 }
 
 // compactConversationMsg is sent when the compact command is executed
-type compactConversationMsg struct{}
+type compactConversationMsg struct {
+	diff bool // preview the compaction with :compact --diff before applying it
+}
 
 // CommandRegistry holds all available commands
 type CommandRegistry struct {
@@ -72,13 +130,57 @@ func NewCommandRegistry() CommandRegistry {
 	registry.RegisterCommand("new", "Start a new session", handleNewSessionCommand)
 	registry.RegisterCommand("quit", "Quit the application", handleQuitCommand)
 	registry.RegisterCommand("models", "Select AI model", handleModelsCommand)
-	registry.RegisterCommand("context", "Show context usage details", handleContextCommand)
-	registry.RegisterCommand("resume", "Resume a previous session", handleResumeCommand)
-	registry.RegisterCommand("export", "Export conversation to file and open in $EDITOR (usage: :export [full|conversation])", handleExportCommand)
+	registry.RegisterCommand("endpoint", "Set up and check a custom OpenAI-compatible endpoint", handleEndpointCommand)
+	registry.RegisterCommand("context", "Show context usage details (usage: :context [limit|clear])", handleContextCommand)
+	registry.RegisterCommand("count", "Count the tokens of a file or text (usage: :count @file | :count <text>)", handleCountCommand)
+	registry.RegisterCommand("resume", "Resume a previous session (usage: :resume [--all-branches] [--tag <name>])", handleResumeCommand)
+	registry.RegisterCommand("sessions", "Show the space the stored sessions take (usage: :sessions du)", handleSessionsCommand)
+	registry.RegisterCommand("tag", "Tag the session to find it in :resume, -name removes a tag (usage: :tag <name...>)", handleTagCommand)
+	registry.RegisterCommand("edit", "Edit the prompt in $EDITOR", handleEditCommand)
+	registry.RegisterCommand("export", "Export conversation to file and open in $EDITOR (usage: :export [--pick] [full|conversation|json])", handleExportCommand)
 	registry.RegisterCommand("init", "Init project to work with asimi (usage: /init [clear])", handleInitCommand)
-	registry.RegisterCommand("compact", "Compact conversation history to reduce context usage", handleCompactCommand)
+	registry.RegisterCommand("compact", "Compact conversation history to reduce context usage, --diff previews it before applying (usage: :compact [--diff])", handleCompactCommand)
 	registry.RegisterCommand("1", "Jump to the beginning of the chat history", handleScrollTopCommand)
 	registry.RegisterCommand("update", "Check for and install updates", handleUpdateCommand)
+	registry.RegisterCommand("attach-last", "Add the output of the last shell command to the context", handleAttachLastCommand)
+	registry.RegisterCommand("use-result", "Add a tool result to the context (usage: :use-result [n])", handleUseResultCommand)
+	registry.RegisterCommand("compare", "Run a prompt against two models (usage: :compare <modelA> <modelB> <prompt>)", handleCompareCommand)
+	registry.RegisterCommand("bench", "Time the first token of each authenticated provider (usage: :bench <prompt>)", handleBenchCommand)
+	registry.RegisterCommand("dump", "Show the exact messages sent to the model, system for the system prompt (usage: :dump [system])", handleDumpCommand)
+	registry.RegisterCommand("system", "Show the system prompt sent to the model", handleSystemCommand)
+	registry.RegisterCommand("dump-last", "Write the raw model requests and responses of the last turn to a JSON file", handleDumpLastCommand)
+	registry.RegisterCommand("profile", "Switch to a provider and model profile from the config (usage: :profile <name>)", handleProfileCommand)
+	registry.RegisterCommand("persona", "Apply a persona from the config (usage: :persona <name>)", handlePersonaCommand)
+	registry.RegisterCommand("open", "View a file read-only without adding it to the context (usage: :open <path>)", handleOpenCommand)
+	registry.RegisterCommand("blame", "Show who last changed each line of a file (usage: :blame <path> [N[-M]])", handleBlameCommand)
+	registry.RegisterCommand("agents", "Tidy the agents file with the model (usage: :agents regenerate)", handleAgentsCommand)
+	registry.RegisterCommand("log-view", "Follow the tool calls and turns in a condensed log, :q returns to the chat", handleLogViewCommand)
+	registry.RegisterCommand("keys", "List the key bindings of each mode", handleKeysCommand)
+	registry.RegisterCommand("config", "Show the effective config and where each value came from (usage: :config [key])", handleConfigCommand)
+	registry.RegisterCommand("whoami", "Show the provider, model, auth method, project and shell runner in use", handleWhoamiCommand)
+	registry.RegisterCommand("cost", "Show the tokens and cost of each turn of the session", handleCostCommand)
+	registry.RegisterCommand("perf", "Show where the time of the last turn went", handlePerfCommand)
+	registry.RegisterCommand("errors", "List the tool, stream and other errors of the session, or jump to error N in the chat (usage: :errors [N])", handleErrorsCommand)
+	registry.RegisterCommand("metrics", "Show the calls, errors and time of each tool over the session", handleMetricsCommand)
+	registry.RegisterCommand("plan", "Plan mode: the model outlines steps with read-only tools", handlePlanCommand)
+	registry.RegisterRawCommand("tool", "Call a tool directly with JSON arguments, bypassing the model (usage: :tool <name> <json-args>)", handleToolCommand)
+	registry.RegisterCommand("think", "Set the extended thinking token budget, 0 or off turns it off (usage: :think <n>|off)", handleThinkCommand)
+	registry.RegisterCommand("gauge", "Show the context usage as a colored bar in the status bar (usage: :gauge on|off)", handleGaugeCommand)
+	registry.RegisterCommand("brief", "Ask the model for concise replies, up to llm.brief_lines lines (usage: :brief on|off)", handleBriefCommand)
+	registry.RegisterCommand("stream", "Stream replies as they come, or show each whole once complete (usage: :stream on|off)", handleStreamCommand)
+	registry.RegisterCommand("wrap", "Wrap the chat at a fixed width whatever the terminal's, auto to follow it (usage: :wrap <cols>|auto)", handleWrapCommand)
+	registry.RegisterCommand("continue", "Ask the model to keep going, its reply extends the last one", handleContinueCommand)
+	registry.RegisterCommand("redraw", "Clear the screen and draw it again (also Ctrl+L)", handleRedrawCommand)
+	registry.RegisterCommand("act", "Act mode: give the model back all its tools, accept also carries out the plan of its last reply (usage: :act [accept])", handleActCommand)
+	registry.RegisterRawCommand("replace", "Find and replace in files, previewing the diff first, quote text with spaces and \"\" deletes (usage: :replace [-r] <glob> <old> <new>)", handleReplaceCommand)
+	registry.RegisterCommand("restore", "List the checkpoints taken before the model's edits, or revert the working tree to one (usage: :restore [n])", handleRestoreCommand)
+	registry.RegisterCommand("changed", "List the files changed in the last N commits, add adds them to the context (usage: :changed [N] [add])", handleChangedCommand)
+	registry.RegisterCommand("loop", "Show or reset the tool call loop detection (usage: :loop [reset])", handleLoopCommand)
+	registry.RegisterCommand("autosave", "Pause or resume saving the session after each turn (usage: :autosave on|off [save])", handleAutoSaveCommand)
+	registry.RegisterCommand("raw", "Toggle the raw session view, filter narrows it to entry types (usage: :raw [filter [TYPE...]])", handleRawCommand)
+	registry.RegisterCommand("encoding", "Show or set the encoding of the files that aren't UTF-8 (usage: :encoding [name])", handleEncodingCommand)
+	registry.RegisterCommand("sandbox", "Run shell commands in the sandbox or on the host (usage: :sandbox on|off)", handleSandboxCommand)
+	registry.RegisterCommand("container", "Show, restart or stop the sandbox container (usage: :container [status|restart|stop])", handleContainerCommand)
 
 	return registry
 }
@@ -99,6 +201,16 @@ func (cr *CommandRegistry) RegisterCommand(name, description string, handler fun
 	}
 }
 
+// RegisterRawCommand registers a command whose handler parses its arguments itself, from the
+// rest of the command line passed unsplit
+func (cr *CommandRegistry) RegisterRawCommand(name, description string, handler func(*TUIModel, []string) tea.Cmd) {
+	cr.RegisterCommand(name, description, handler)
+	if cmd, ok := cr.Commands[normalizeCommandName(name)]; ok {
+		cmd.Raw = true
+		cr.Commands[cmd.Name] = cmd
+	}
+}
+
 // GetCommand gets a command by name
 func (cr CommandRegistry) GetCommand(name string) (Command, bool) {
 	normalized := normalizeCommandName(name)
@@ -139,6 +251,61 @@ func (cr CommandRegistry) FindCommand(prefix string) (exactMatch Command, matche
 	return Command{}, matchedCommands, false
 }
 
+// maxSuggestionDistance is the largest edit distance for which an unknown
+// command still gets a "did you mean" suggestion
+const maxSuggestionDistance = 2
+
+// SuggestCommand returns the registered command closest to name by edit distance,
+// or "" when nothing is close enough to be a likely typo
+func (cr CommandRegistry) SuggestCommand(name string) string {
+	normalized := normalizeCommandName(name)
+	if normalized == "" {
+		return ""
+	}
+
+	best := ""
+	bestDistance := maxSuggestionDistance + 1
+	for _, cmdName := range cr.order {
+		distance := editDistance(normalized, cmdName)
+		// Registration order breaks ties, so common commands win
+		if distance < bestDistance && distance < len([]rune(normalized)) {
+			best, bestDistance = cmdName, distance
+		}
+	}
+	return best
+}
+
+// UnknownCommandMessage builds the toast shown for an unknown command, with a suggestion when one is close
+func (cr CommandRegistry) UnknownCommandMessage(name string) string {
+	name = normalizeCommandName(name)
+	if suggestion := cr.SuggestCommand(name); suggestion != "" {
+		return fmt.Sprintf("Unknown command ':%s'. Did you mean ':%s'?", name, suggestion)
+	}
+	return fmt.Sprintf("Unknown command: %s", name)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
 // GetAllCommands returns all registered commands
 func (cr CommandRegistry) GetAllCommands() []Command {
 	var commands []Command
@@ -191,6 +358,15 @@ func handleNewSessionCommand(model *TUIModel, args []string) tea.Cmd {
 }
 
 func handleQuitCommand(model *TUIModel, args []string) tea.Cmd {
+	// In a file opened with :open, :q closes the file like in vi
+	if model.content.GetActiveView() == ViewFile {
+		model.prompt.Focus()
+		return model.content.ShowChat()
+	}
+	if model.logView {
+		model.logView = false
+		return nil
+	}
 	// Shutdown handles saving the session and waiting for completion
 	model.shutdown()
 	// Quit the application
@@ -198,18 +374,103 @@ func handleQuitCommand(model *TUIModel, args []string) tea.Cmd {
 }
 
 func handleContextCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) > 0 && args[0] == "clear" && model.session != nil {
+		files := len(model.session.ContextFiles)
+		model.session.ClearContext()
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cleared %d context files", files)) }
+	}
 	return func() tea.Msg {
 		if model.session == nil {
 			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
 		}
+		if len(args) > 0 && args[0] == "limit" {
+			return showContextMsg{content: renderContextLimits(model.session)}
+		}
+		if len(args) > 0 && args[0] == "git" {
+			report, err := gitStatusReport(".")
+			if err != nil {
+				return showSystemMsg(fmt.Sprintf("Cannot read the git status: %v", err))
+			}
+			if err := model.session.AddContextFile(gitStatusContext, report); err != nil {
+				return showSystemMsg(fmt.Sprintf("Cannot add the git status: %v", err))
+			}
+			return showSystemMsg("Added the git status to the context of the next prompt")
+		}
 		info := model.session.GetContextInfo()
 		return showContextMsg{content: renderContextInfo(info)}
 	}
 }
 
+// handleCountCommand counts the tokens of a file or some text with the session's tokenizer,
+// the one :context uses, and prices them as input, to see what a snippet costs before sending it
+func handleCountCommand(model *TUIModel, args []string) tea.Cmd {
+	return func() tea.Msg {
+		if model.session == nil {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+		if len(args) == 0 {
+			return showSystemMsg("Usage: :count @file | :count <text>")
+		}
+		text, what := strings.Join(args, " "), "The text"
+		if path, ok := strings.CutPrefix(text, "@"); ok && len(args) == 1 {
+			if err := validatePathWithinProject(path); err != nil {
+				return showSystemMsg(fmt.Sprintf("Cannot count %s: %v", path, err))
+			}
+			content, _, err := readTextFile(path)
+			if err != nil {
+				return showSystemMsg(fmt.Sprintf("Cannot count %s: %v", path, err))
+			}
+			text, what = content, path
+		}
+		tokens, window := model.session.countTokens(text), model.session.getModelContextSize()
+		modelName := model.session.getModelName()
+		msg := fmt.Sprintf("%s is %d tokens, %.1f%% of %s's %s context window",
+			what, tokens, percentage(tokens, window), modelName, formatTokenCount(window))
+		if price, ok := lookupModelPrice(modelName); ok {
+			msg += fmt.Sprintf(", about %s as input", formatCost(float64(tokens)*price.Input/1_000_000))
+		}
+		return showSystemMsg(msg)
+	}
+}
+
+// renderContextLimits shows the context files loaded so far against tools.max_context_files and tools.max_context_bytes
+func renderContextLimits(session *Session) string {
+	files, bytes := session.ContextUsage()
+	maxFiles, maxBytes := session.ContextLimits()
+
+	limit := func(value int) string {
+		if value <= 0 {
+			return "unlimited"
+		}
+		return fmt.Sprint(value)
+	}
+
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLn("Context file limits:")
+	msg.WriteLnf("Files: %d / %s", files, limit(maxFiles))
+	msg.WriteLnf("Bytes: %d / %s", bytes, limit(maxBytes))
+	return msg.String()
+}
+
 func handleResumeCommand(model *TUIModel, args []string) tea.Cmd {
+	var tag string
+	var allBranches bool
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--all-branches":
+			allBranches = true
+		case args[i] == "--tag" && i+1 < len(args):
+			i++
+			tag = normalizeTag(args[i])
+		default:
+			return func() tea.Msg { return showSystemMsg("Usage: :resume [--all-branches] [--tag <name>]") }
+		}
+	}
+
 	// Immediately show the resume view with loading state
 	showResumeCmd := model.content.ShowResume([]Session{})
+	model.content.resume.exportType = ""
+	model.content.resume.allBranches = allBranches
 	model.content.resume.SetLoading(true)
 
 	// Load sessions in the background
@@ -243,6 +504,9 @@ func handleResumeCommand(model *TUIModel, args []string) tea.Cmd {
 			if err != nil {
 				return sessionResumeErrorMsg{err: fmt.Errorf("failed to initialize session store: %w", err)}
 			}
+			// Trimming happens at startup, resuming never deletes sessions
+			store.SetMaxStorageBytes(model.config.Session.MaxStorageBytes)
+			store.SetPreserveIncompleteToolCalls(model.config.Session.PreserveIncompleteToolCalls)
 
 			if model.sessionStore != nil {
 				model.sessionStore.Close()
@@ -259,7 +523,11 @@ func handleResumeCommand(model *TUIModel, args []string) tea.Cmd {
 			listLimit = model.config.Session.ListLimit
 		}
 
-		sessions, err := model.sessionStore.ListSessions(listLimit)
+		list := model.sessionStore.ListTaggedSessions
+		if allBranches {
+			list = model.sessionStore.ListAllBranchesSessions
+		}
+		sessions, err := list(tag, listLimit)
 		if err != nil {
 			return sessionResumeErrorMsg{err: fmt.Errorf("failed to list sessions: %w", err)}
 		}
@@ -272,7 +540,10 @@ func handleResumeCommand(model *TUIModel, args []string) tea.Cmd {
 }
 
 func handleExportCommand(model *TUIModel, args []string) tea.Cmd {
-	if model.session == nil {
+	pick := len(args) > 0 && args[0] == "--pick"
+	if pick {
+		args = args[1:]
+	} else if model.session == nil {
 		return func() tea.Msg {
 			return showSystemMsg("No active session to export. Start a conversation first.")
 		}
@@ -286,14 +557,34 @@ func handleExportCommand(model *TUIModel, args []string) tea.Cmd {
 			exportType = ExportTypeFull
 		case "conversation":
 			exportType = ExportTypeConversation
+		case "json":
+			exportType = ExportTypeJSON
 		default:
-			model.commandLine.AddToast(fmt.Sprintf("Unknown export type '%s'. Use 'full' or 'conversation'", args[0]), "error", 3000)
+			model.commandLine.AddToast(fmt.Sprintf("Unknown export type '%s'. Use 'full', 'conversation' or 'json'", args[0]), "error", time.Second*3)
 			return nil
 		}
 	}
 
-	// Export the session to a file
-	filepath, err := exportSession(model.session, exportType)
+	if pick {
+		// The resume picker, its selection exported rather than resumed
+		cmd := handleResumeCommand(model, nil)
+		model.content.resume.exportType = exportType
+		return cmd
+	}
+
+	return exportAndEdit(model, model.session, exportType)
+}
+
+// exportPickedSession exports a session chosen in the :export --pick picker, with the
+// security settings of the running config
+func exportPickedSession(model *TUIModel, session *Session, exportType ExportType) tea.Cmd {
+	session.systemConfig = model.config
+	return exportAndEdit(model, session, exportType)
+}
+
+// exportAndEdit exports a session to a file and opens it in $EDITOR
+func exportAndEdit(model *TUIModel, session *Session, exportType ExportType) tea.Cmd {
+	filepath, err := exportSession(session, exportType)
 	if err != nil {
 		return func() tea.Msg {
 			return showSystemMsg(fmt.Sprintf("Export failed: %v", err))
@@ -306,11 +597,43 @@ func handleExportCommand(model *TUIModel, args []string) tea.Cmd {
 		if err != nil {
 			return showSystemMsg(fmt.Sprintf("Editor exited with error: %v", err))
 		}
-		model.commandLine.AddToast(fmt.Sprintf("Conversation exported successfully (%s).", exportType), "success", 3000)
+		model.commandLine.AddToast(fmt.Sprintf("Conversation exported successfully (%s).", exportType), "success", time.Second*3)
 		return nil
 	})
 }
 
+// promptEditedMsg reports that the editor opened by :edit on the prompt draft at path exited
+type promptEditedMsg struct {
+	path string
+	err  error
+}
+
+// handleEditCommand writes the prompt to a temp file and opens it in $EDITOR, suspending the
+// TUI until the editor exits. The edited draft replaces the prompt on return.
+func handleEditCommand(model *TUIModel, args []string) tea.Cmd {
+	path, err := writePromptDraft(model.prompt.Value())
+	if err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot write the prompt draft: %v", err)) }
+	}
+	return tea.ExecProcess(openInEditor(path), func(err error) tea.Msg {
+		return promptEditedMsg{path: path, err: err}
+	})
+}
+
+// writePromptDraft saves the prompt to a temp file for the editor
+func writePromptDraft(prompt string) (string, error) {
+	f, err := os.CreateTemp("", "asimi-prompt-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(prompt); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
 func handleInitCommand(model *TUIModel, args []string) tea.Cmd {
 	if model.session == nil {
 		return func() tea.Msg {
@@ -429,6 +752,7 @@ func handleInitCommand(model *TUIModel, args []string) tea.Cmd {
 			MissingFiles: missingFiles,
 			ClearMode:    clearMode,
 			AgentsFile:   agentsFile,
+			Project:      detectProjectType(),
 		}
 
 		// Parse and execute the template
@@ -469,6 +793,7 @@ type startConversationMsg struct {
 	initialMessages  []string                // Messages to display after clearing history (before streaming starts)
 	onStreamComplete func(*TUIModel) tea.Cmd // Optional guardrail function to run after stream completes
 	RunOnHost        bool                    // When true, use host shell runner instead of podman
+	continueLast     bool                    // When true, the reply extends the last AI message in the chat
 }
 
 // verifyInit runs validation checks after init completes
@@ -781,6 +1106,19 @@ func handleCompactCommand(model *TUIModel, args []string) tea.Cmd {
 		}
 	}
 
+	diff := slices.Contains(args, "--diff")
+	if len(args) > 0 && (!diff || len(args) > 1) {
+		return func() tea.Msg { return showSystemMsg("Usage: :compact [--diff]") }
+	}
+
+	toolCalls, files := model.session.CompactionLoss()
+	// --diff asks before applying anyway
+	if !diff && toolCalls > 0 && len(model.session.Messages) > 2 && model.config != nil && model.config.LLM.ConfirmCompact {
+		model.pendingCompact = true
+		model.prompt.Blur()
+		return model.commandLine.EnterYesNoMode(fmt.Sprintf("Compact the conversation? %s", compactionLossNote(toolCalls, files)))
+	}
+
 	return func() tea.Msg {
 		// Check if there's enough conversation to compact
 		if len(model.session.Messages) <= 2 {
@@ -791,72 +1129,1546 @@ func handleCompactCommand(model *TUIModel, args []string) tea.Cmd {
 		if program != nil {
 			msg := NewChatMsgBuilder(systemPrefix)
 			msg.WriteLn("Compacting conversation history...")
+			if toolCalls > 0 {
+				msg.WriteLn(compactionLossNote(toolCalls, files))
+			}
 			msg.WriteLn("This may take a moment as we summarize the conversation.")
 			program.Send(showContextMsg{content: msg.String()})
 		}
 
 		// Send the compact request
-		return compactConversationMsg{}
+		return compactConversationMsg{diff: diff}
 	}
 }
 
-func handleScrollTopCommand(model *TUIModel, args []string) tea.Cmd {
-	if model == nil || model.content.GetActiveView() != ViewChat {
+// renderCompactPreview sets what a compaction discards beside the summary replacing it, for
+// :compact --diff
+func renderCompactPreview(p CompactPreview) string {
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLn("Compaction preview:")
+	msg.WriteLn("")
+	msg.WriteLnf("%-10s %10s %10s", "", "Before", "After")
+	msg.WriteLnf("%-10s %10s %10s", "Tokens", formatTokenCount(p.BeforeTokens), formatTokenCount(p.AfterTokens))
+	msg.WriteLnf("%-10s %10d %10d", "Messages", p.Messages, 2)
+	msg.WriteLnf("%-10s %10d %10s", "Turns", p.Turns, "summary")
+	msg.WriteLn("")
+	msg.WriteLnf("%d turns in %d messages are discarded for a summary of %d characters.", p.Turns, p.Messages, len(p.Summary))
+	return msg.String()
+}
+
+// compactionLossNote tells how much tool history compaction collapses
+func compactionLossNote(toolCalls, files int) string {
+	return fmt.Sprintf("%d tool calls and the changes to %d files are collapsed into the summary, their details are dropped.", toolCalls, files)
+}
+
+func handleAttachLastCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	last := model.lastShellResult
+	if last == nil || last.err != nil {
+		return func() tea.Msg {
+			return showSystemMsg("No shell command output to attach. Run a command with :!<command> first.")
+		}
+	}
+
+	content := last.output
+	if last.exitCode != "0" {
+		content += fmt.Sprintf("\n(exit code: %s)", last.exitCode)
+	}
+	name := "shell:" + last.command
+	if err := model.session.AddContextFile(name, redactSecrets(content)); err != nil {
+		model.commandLine.AddToast(fmt.Sprintf("Not attached: %v", err), "error", time.Second*4)
 		return nil
 	}
-	model.content.Chat.ScrollToTop()
-	return nil
+
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Attached output of `%s` to the context for the next prompt", last.command))
+	}
 }
 
-type updateCheckMsg struct {
-	hasUpdate bool
-	latest    string
-	err       error
+// handleUseResultCommand adds the Nth tool result of the session to the context of the next
+// prompt, so it can refer to "result N". Without a number it lists the results.
+func handleUseResultCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	results := model.session.ToolResults()
+	if len(results) == 0 {
+		return func() tea.Msg { return showSystemMsg("No tool results in this session yet") }
+	}
+	if len(args) == 0 {
+		msg := NewChatMsgBuilder(systemPrefix)
+		msg.WriteLn("Tool results, add one with :use-result <n>:")
+		for i, r := range results {
+			first, _, _ := strings.Cut(strings.TrimSpace(r.Content), "\n")
+			msg.WriteLnf("%3d  %-14s %s", i+1, r.Name, ansi.Truncate(first, 60, "…"))
+		}
+		return func() tea.Msg { return showContextMsg{content: msg.String()} }
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(results) {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Usage: :use-result <n>, with n from 1 to %d", len(results)))
+		}
+	}
+	result := results[n-1]
+	name := fmt.Sprintf("result %d: %s", n, result.Name)
+	if err := model.session.AddContextFile(name, redactSecrets(result.Content)); err != nil {
+		model.commandLine.AddToast(fmt.Sprintf("Not attached: %v", err), "error", time.Second*4)
+		return nil
+	}
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Attached result %d of %s to the context for the next prompt", n, result.Name))
+	}
 }
 
-type updateCompleteMsg struct {
-	success bool
+func handleDumpCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) > 0 && args[0] == "system" {
+		return handleSystemCommand(model, nil)
+	}
+	return func() tea.Msg {
+		if model.session == nil {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+		dump := renderMessageDump(model.session.SanitizedMessages(), len(model.session.ContextFiles))
+		return showContextMsg{content: dump}
+	}
+}
+
+// handleSystemCommand shows the system prompt as sent to the model, with the environment
+// and the agents file rendered in, to check template and AGENTS.md changes
+func handleSystemCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	model.prompt.Blur()
+	return model.content.ShowFile(systemPromptView(model.session.Messages))
+}
+
+func handleDumpLastCommand(model *TUIModel, args []string) tea.Cmd {
+	return func() tea.Msg {
+		if model.session == nil {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+		path, err := dumpLastTurn(model.session)
+		if err != nil {
+			return showSystemMsg(fmt.Sprintf("Cannot dump the last turn: %v", err))
+		}
+		return showSystemMsg(fmt.Sprintf("Last turn written to %s (secrets redacted)", path))
+	}
+}
+
+// agentsRegeneratedMsg carries a model-tidied agents file waiting for the user's approval
+type agentsRegeneratedMsg struct {
+	path    string
+	current string
+	cleaned string
 	err     error
 }
 
-func handleUpdateCommand(model *TUIModel, args []string) tea.Cmd {
+func handleAgentsCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) != 1 || args[0] != "regenerate" {
+		return func() tea.Msg { return showSystemMsg("Usage: :agents regenerate") }
+	}
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+
+	path := "AGENTS.md"
+	if model.config != nil && model.config.Session.AgentsFile != "" {
+		path = model.config.Session.AgentsFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Cannot read %s: %v. Use :init to create it.", path, err))
+		}
+	}
+	llm := model.session.llm
+
 	return func() tea.Msg {
-		// Show checking message
 		if program != nil {
-			program.Send(showSystemMsg("Checking for updates..."))
+			program.Send(showSystemMsg(fmt.Sprintf("Regenerating %s...", path)))
 		}
-
-		// Check for updates
-		latest, hasUpdate, err := CheckForUpdates(version)
+		prompt := fmt.Sprintf("%s\n\n---\n\n%s", agentsRegeneratePrompt, data)
+		cleaned, err := llms.GenerateFromSinglePrompt(context.Background(), limitedModel{llm}, prompt)
 		if err != nil {
-			return updateCheckMsg{hasUpdate: false, err: err}
+			return agentsRegeneratedMsg{path: path, err: fmt.Errorf("failed to regenerate %s: %w", path, err)}
 		}
+		return agentsRegeneratedMsg{path: path, current: string(data), cleaned: stripMarkdownFence(cleaned)}
+	}
+}
 
-		if !hasUpdate {
-			return updateCheckMsg{hasUpdate: false, latest: version}
-		}
+// stripMarkdownFence removes a code fence the model wrapped its whole reply in, despite being asked not to
+func stripMarkdownFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") {
+		return s + "\n"
+	}
+	firstLine := strings.Index(s, "\n")
+	if firstLine < 0 {
+		return s + "\n"
+	}
+	return strings.TrimSpace(strings.TrimSuffix(s[firstLine+1:], "```")) + "\n"
+}
 
-		// Update available - show confirmation
-		return updateCheckMsg{hasUpdate: true, latest: latest.Version.String()}
+// renderAgentsDiff shows the proposed agents file changes as a unified diff
+func renderAgentsDiff(msg agentsRegeneratedMsg) string {
+	out := NewChatMsgBuilder(systemPrefix)
+	out.WriteLnf("Proposed %s:", msg.path)
+	out.WriteLn("```diff")
+	for _, line := range strings.Split(strings.TrimSuffix(udiff.Unified(msg.path, msg.path+" (regenerated)", msg.current, msg.cleaned), "\n"), "\n") {
+		out.WriteLn(line)
 	}
+	out.WriteLn("```")
+	return out.String()
 }
 
-func handleUpdateConfirm(model *TUIModel) tea.Cmd {
+// writeRegeneratedAgentsFile backs the current agents file up to <path>.bak and writes the new content
+func writeRegeneratedAgentsFile(msg agentsRegeneratedMsg) (string, error) {
+	backup := msg.path + ".bak"
+	if err := os.WriteFile(backup, []byte(msg.current), 0o644); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", msg.path, err)
+	}
+	if err := os.WriteFile(msg.path, []byte(msg.cleaned), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", msg.path, err)
+	}
+	return backup, nil
+}
+
+func handlePerfCommand(model *TUIModel, args []string) tea.Cmd {
 	return func() tea.Msg {
-		// Show updating message
-		if program != nil {
+		if model.session == nil {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+		timing := model.session.LastTurnTiming()
+		if timing.Started.IsZero() {
+			return showSystemMsg("No turn to measure yet. Send a prompt first.")
+		}
+		return showContextMsg{content: renderTurnTiming(timing)}
+	}
+}
+
+// renderTurnTiming formats the breakdown of a turn for :perf
+func renderTurnTiming(timing TurnTiming) string {
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLn("Last turn:")
+	msg.WriteLnf("Prompt build: %s", timing.PromptBuild.Round(time.Microsecond))
+	msg.WriteLnf("First token: %s", timing.FirstToken.Round(time.Millisecond))
+	msg.WriteLnf("Model time: %s over %d request(s)", timing.Generation.Round(time.Millisecond), timing.LLMCalls)
+	if timing.Total == 0 {
+		msg.WriteLnf("Total: still running (%s so far)", time.Since(timing.Started).Round(time.Millisecond))
+	} else {
+		msg.WriteLnf("Total: %s", timing.Total.Round(time.Millisecond))
+	}
+	return msg.String()
+}
+
+// handleErrorsCommand lists the errors in the raw session history, numbered for :errors N to
+// scroll the chat to the message of the Nth
+func handleErrorsCommand(model *TUIModel, args []string) tea.Cmd {
+	errs := model.content.Chat.RawErrors()
+	if len(args) == 0 {
+		return func() tea.Msg {
+			if len(errs) == 0 {
+				return showSystemMsg("No errors in this session.")
+			}
 			msg := NewChatMsgBuilder(systemPrefix)
-			msg.WriteLn("Downloading and installing update...")
-			msg.WriteLn("This may take a moment.")
-			program.Send(showContextMsg{content: msg.String()})
+			msg.WriteLnf("%d errors in this session, :errors N jumps to one:", len(errs))
+			msg.WriteLn("")
+			for i, entry := range errs {
+				first, _, _ := strings.Cut(entry.text, "\n")
+				msg.WriteLnf("%3d. %s", i+1, first)
+			}
+			return showContextMsg{content: msg.String()}
 		}
+	}
 
-		// Perform update
-		err := SelfUpdate(version)
+	n, err := strconv.Atoi(args[0])
+	if err != nil || len(args) > 1 {
+		return func() tea.Msg { return showSystemMsg("Usage: :errors [N]") }
+	}
+	if n < 1 || n > len(errs) {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("No error %d, this session has %d", n, len(errs))) }
+	}
+	if !model.content.Chat.ScrollToMessage(errs[n-1].message) {
+		return func() tea.Msg { return showSystemMsg("The chat is empty") }
+	}
+	model.commandLine.AddToast(fmt.Sprintf("Error %d of %d", n, len(errs)), "info", time.Second*2)
+	return nil
+}
+
+// handleToolCommand calls a tool with the arguments given, bypassing the model, to debug it. The
+// arguments are everything after the name, rejoined with single spaces
+func handleToolCommand(model *TUIModel, raw []string) tea.Cmd {
+	// The JSON goes to the tool as typed, its spacing included
+	name, argsJSON, _ := strings.Cut(strings.Join(raw, " "), " ")
+	argsJSON = strings.TrimSpace(argsJSON)
+	if name == "" || argsJSON == "" {
+		return func() tea.Msg {
+			return showSystemMsg(`Usage: :tool <name> <json-args>, e.g. :tool read_file {"path":"main.go"}`)
+		}
+	}
+	session := model.session
+	return func() tea.Msg {
+		if session == nil {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+		out, err := session.RunTool(context.Background(), name, argsJSON)
 		if err != nil {
-			return updateCompleteMsg{success: false, err: err}
+			return showSystemMsg(fmt.Sprintf("%s failed: %v", name, err))
 		}
+		msg := NewChatMsgBuilder(systemPrefix)
+		msg.WriteLnf("%s %s:", name, argsJSON)
+		msg.WriteLn("")
+		msg.WriteLn(out)
+		return showContextMsg{content: msg.String()}
+	}
+}
 
-		return updateCompleteMsg{success: true}
+func handleMetricsCommand(model *TUIModel, args []string) tea.Cmd {
+	return func() tea.Msg {
+		if model.session == nil {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+		metrics := model.session.ToolMetrics()
+		if len(metrics) == 0 {
+			return showSystemMsg("No tool calls in this session yet.")
+		}
+		return showContextMsg{content: renderToolMetrics(metrics)}
+	}
+}
+
+// renderToolMetrics formats the per tool usage for :metrics, the most called tools first
+func renderToolMetrics(metrics map[string]ToolMetric) string {
+	tools := slices.SortedFunc(maps.Keys(metrics), func(a, b string) int {
+		return cmp.Or(cmp.Compare(metrics[b].Calls, metrics[a].Calls), cmp.Compare(a, b))
+	})
+
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLn("Tool usage this session:")
+	msg.WriteLn("")
+	msg.WriteLnf("%-24s %6s %6s %10s %10s", "Tool", "Calls", "Errors", "Total", "Average")
+	var total ToolMetric
+	for _, tool := range tools {
+		metric := metrics[tool]
+		total.Calls += metric.Calls
+		total.Errors += metric.Errors
+		total.Total += metric.Total
+		msg.WriteLnf("%-24s %6d %6d %10s %10s", tool, metric.Calls, metric.Errors,
+			metric.Total.Round(time.Millisecond), (metric.Total / time.Duration(metric.Calls)).Round(time.Millisecond))
+	}
+	msg.WriteLnf("%-24s %6d %6d %10s", "All", total.Calls, total.Errors, total.Total.Round(time.Millisecond))
+	return msg.String()
+}
+
+// handleConfigCommand shows the effective config, or the keys under one, with each value
+// annotated by where it came from, to untangle the defaults, files, env and flags
+func handleConfigCommand(model *TUIModel, args []string) tea.Cmd {
+	return func() tea.Msg {
+		if len(args) > 1 {
+			return showSystemMsg("Usage: :config [key], e.g. :config llm")
+		}
+		if model.config == nil {
+			return showSystemMsg("No config loaded.")
+		}
+		filter := ""
+		if len(args) == 1 {
+			filter = args[0]
+		}
+		values := renderConfig(model.config, filter)
+		if values == "" {
+			return showSystemMsg(fmt.Sprintf("No config key %s", filter))
+		}
+		msg := NewChatMsgBuilder(systemPrefix)
+		msg.WriteLn("Effective config, each value with where it came from: default, user, project, env, flag or profile")
+		msg.WriteLn("")
+		for _, line := range strings.Split(strings.TrimSuffix(values, "\n"), "\n") {
+			msg.WriteLn(line)
+		}
+		return showContextMsg{content: msg.String()}
+	}
+}
+
+func handleSessionsCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) != 1 || args[0] != "du" {
+		return func() tea.Msg { return showSystemMsg("Usage: :sessions du") }
+	}
+	store := model.sessionStore
+	if store == nil {
+		return func() tea.Msg {
+			return showSystemMsg("Session storage is disabled. Set session.enabled = true to keep sessions.")
+		}
+	}
+	var limit int64
+	var dbPath string
+	if model.config != nil {
+		limit = model.config.Session.MaxStorageBytes
+		dbPath = model.config.Storage.DatabasePath
+	}
+	return func() tea.Msg {
+		usage, err := store.Usage()
+		if err != nil {
+			return showSystemMsg(fmt.Sprintf("Cannot measure the stored sessions: %v", err))
+		}
+		msg := NewChatMsgBuilder(systemPrefix)
+		msg.WriteLnf("Sessions: %d stored, %s", usage.Sessions, formatBytes(usage.Bytes))
+		if limit > 0 {
+			msg.WriteLnf("Limit: %s (%.0f%% used)", formatBytes(limit), float64(usage.Bytes)*100/float64(limit))
+		} else {
+			msg.WriteLn("Limit: none, set session.max_storage_bytes to trim the oldest sessions")
+		}
+		if info, err := os.Stat(dbPath); err == nil {
+			msg.WriteLnf("Database: %s, %s on disk", dbPath, formatBytes(info.Size()))
+		}
+		return showContextMsg{content: msg.String()}
+	}
+}
+
+func handleWhoamiCommand(model *TUIModel, args []string) tea.Cmd {
+	return func() tea.Msg {
+		return showContextMsg{content: renderWhoami(model.config, model.session)}
+	}
+}
+
+// renderWhoami summarizes the active configuration for :whoami, naming the auth method
+// without showing the key or token
+func renderWhoami(config *Config, session *Session) string {
+	var llmConfig LLMConfig
+	if config != nil {
+		llmConfig = config.LLM
+	}
+	repoInfo := GetRepoInfo()
+	if session != nil {
+		if session.config != nil {
+			llmConfig = *session.config
+		}
+		repoInfo = session.repoInfo
+	}
+
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLnf("Provider: %s", cmp.Or(llmConfig.Provider, "not configured"))
+	msg.WriteLnf("Model: %s", cmp.Or(llmConfig.Model, "not configured"))
+	switch {
+	case llmConfig.AuthToken != "":
+		msg.WriteLn("Auth: OAuth")
+	case llmConfig.APIKey != "":
+		msg.WriteLn("Auth: API key")
+	default:
+		msg.WriteLn("Auth: none")
+	}
+	if wd, err := os.Getwd(); err == nil {
+		msg.WriteLnf("Working dir: %s", wd)
+	}
+	msg.WriteLnf("Project: %s", cmp.Or(repoInfo.Slug, "not a git repository"))
+	if repoInfo.Branch != "" {
+		msg.WriteLnf("Branch: %s", repoInfo.Branch)
+	}
+	msg.WriteLnf("Shell runner: %s", getShellRunnerInfo().Type)
+	if session != nil {
+		msg.WriteLnf("Tools: %d enabled", len(session.activeToolDefs()))
+	}
+	return msg.String()
+}
+
+func handleOpenCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		return func() tea.Msg { return showSystemMsg("Usage: :open <path>") }
+	}
+	file, err := loadFileForView(strings.Join(args, " "))
+	if err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot open file: %v", err)) }
+	}
+	model.prompt.Blur()
+	return model.content.ShowFile(file)
+}
+
+// handleBlameCommand opens a file, or a range of its lines, with the commit and author of each line
+func handleBlameCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) == 0 || len(args) > 2 {
+		return func() tea.Msg { return showSystemMsg("Usage: :blame <path> [N[-M]]") }
+	}
+	first, last := 1, 0
+	if len(args) == 2 {
+		start, end, isRange := strings.Cut(args[1], "-")
+		var err error
+		if first, err = strconv.Atoi(start); err == nil && isRange {
+			last, err = strconv.Atoi(end)
+		}
+		if err != nil {
+			return func() tea.Msg { return showSystemMsg("Usage: :blame <path> [N[-M]]") }
+		}
+		if !isRange {
+			last = first
+		}
+	}
+
+	file, err := loadBlameForView(args[0], first, last)
+	if err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot blame file: %v", err)) }
+	}
+	model.prompt.Blur()
+	return model.content.ShowFile(file)
+}
+
+func handlePersonaCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	if len(args) == 0 {
+		var names []string
+		if model.config != nil {
+			for name := range model.config.Personas {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		msg := NewChatMsgBuilder(systemPrefix)
+		if current := model.session.Persona(); current != "" {
+			msg.WriteLnf("Current persona: %s", current)
+		}
+		if len(names) == 0 {
+			msg.WriteLn("No personas configured. Add them under [personas.<name>] in asimi.conf.")
+		} else {
+			msg.WriteLnf("Available personas: %s", strings.Join(names, ", "))
+		}
+		return func() tea.Msg { return showContextMsg{content: msg.String()} }
+	}
+
+	name := args[0]
+	if err := model.session.ApplyPersona(model.config, name); err != nil {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Cannot apply persona: %v", err))
+		}
+	}
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Persona %q applied", name))
+	}
+}
+
+// handleEndpointCommand opens the modal asking for an OpenAI-compatible endpoint, which is
+// checked before it's saved and used
+func handleEndpointCommand(model *TUIModel, args []string) tea.Cmd {
+	var baseURL, modelName string
+	if model.config != nil && model.config.LLM.Provider == "openai" {
+		baseURL = model.config.LLM.BaseURL
+		modelName = model.config.LLM.Model
+	}
+	model.prompt.Blur()
+	model.endpointModal = NewEndpointModal(baseURL, modelName)
+	return nil
+}
+
+func handleProfileCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.config == nil {
+		return func() tea.Msg { return showSystemMsg("No configuration loaded, cannot switch profile") }
+	}
+	if len(args) == 0 {
+		var names []string
+		for name := range model.config.Profiles {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		msg := NewChatMsgBuilder(systemPrefix)
+		if current := model.config.Profile; current != "" {
+			msg.WriteLnf("Current profile: %s", current)
+		}
+		if len(names) == 0 {
+			msg.WriteLn("No profiles configured. Add them under [profiles.<name>] in asimi.conf.")
+		} else {
+			msg.WriteLnf("Available profiles: %s", strings.Join(names, ", "))
+		}
+		return func() tea.Msg { return showContextMsg{content: msg.String()} }
+	}
+
+	name := args[0]
+	previous, previousProfile := model.config.LLM, model.config.Profile
+	if err := model.config.ApplyProfile(name); err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot switch profile: %v", err)) }
+	}
+	if err := model.reinitializeSession(); err != nil {
+		slog.Error("failed to switch profile", "profile", name, "error", err)
+		model.config.LLM, model.config.Profile = previous, previousProfile
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot switch to profile %q: %v", name, err)) }
+	}
+	slog.Info("switched profile", "profile", name, "provider", model.config.LLM.Provider, "model", model.config.LLM.Model)
+	llm := model.config.LLM
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Profile %q: %s/%s, new session started", name, llm.Provider, llm.Model))
+	}
+}
+
+func handleSandboxCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		info := getShellRunnerInfo()
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Usage: :sandbox on|off (current shell runner: %s)", info.Type))
+		}
+	}
+	if model.config == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No configuration loaded, cannot switch shell runner")
+		}
+	}
+
+	enabled := args[0] == "on"
+	config := model.config
+	return func() tea.Msg {
+		if err := setSandboxEnabled(context.Background(), config, enabled); err != nil {
+			slog.Warn("failed to switch shell runner", "sandbox", enabled, "error", err)
+			return showSystemMsg(fmt.Sprintf("Cannot enable the sandbox: %v. Run `just build-sandbox` or :init to build the image.", err))
+		}
+		if enabled {
+			return containerLaunchMsg{message: "Shell commands now run in the sandbox"}
+		}
+		return containerLaunchMsg{message: "Shell commands now run on the host"}
+	}
+}
+
+// handleContainerCommand reports and controls the sandbox container, for when it gets into a bad state
+func handleContainerCommand(model *TUIModel, args []string) tea.Cmd {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if !slices.Contains([]string{"status", "restart", "stop"}, action) {
+		return func() tea.Msg { return showSystemMsg("Usage: :container [status|restart|stop]") }
+	}
+
+	shellRunnerMu.RLock()
+	runner := currentShellRunner
+	shellRunnerMu.RUnlock()
+	if _, ok := runner.(containerRunner); !ok {
+		return func() tea.Msg {
+			return showSystemMsg("Shell commands run on the host, there's no sandbox container. Use :sandbox on to start one.")
+		}
+	}
+
+	switch action {
+	case "restart":
+		return func() tea.Msg {
+			if err := runner.Restart(context.Background()); err != nil {
+				return showSystemMsg(fmt.Sprintf("Cannot restart the sandbox container: %v", err))
+			}
+			return containerLaunchMsg{message: "Sandbox container restarted, the next command reattaches to it"}
+		}
+	case "stop":
+		return func() tea.Msg {
+			if err := runner.Close(context.Background()); err != nil {
+				return showSystemMsg(fmt.Sprintf("Cannot stop the sandbox container: %v", err))
+			}
+			return containerLaunchMsg{message: "Sandbox container stopped, the next command starts a new one"}
+		}
+	}
+
+	info := getShellRunnerInfo()
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLn("Sandbox container")
+	msg.WriteLnf("Container: %s", cmp.Or(info.ContainerID, "not started, the next command starts it"))
+	msg.WriteLnf("Image: %s", info.Image)
+	return func() tea.Msg { return showContextMsg{content: msg.String()} }
+}
+
+func handlePlanCommand(model *TUIModel, args []string) tea.Cmd {
+	return setPlanMode(model, true)
+}
+
+func handleActCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		return setPlanMode(model, false)
+	}
+	if len(args) != 1 || args[0] != "accept" {
+		return func() tea.Msg { return showSystemMsg("Usage: :act [accept]") }
+	}
+	return acceptPlan(model)
+}
+
+// acceptPlan switches to act mode and has the model carry out the plan of its last reply,
+// attached to the context of the prompt
+func acceptPlan(model *TUIModel) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	if model.promptWhileBusy() {
+		return nil
+	}
+	accepted, err := model.session.AcceptPlan()
+	if err != nil {
+		model.commandLine.AddToast(fmt.Sprintf("Plan not accepted: %v", err), "error", time.Second*4)
+		return nil
+	}
+	if !accepted {
+		return func() tea.Msg { return showSystemMsg("No plan to accept yet, ask for one in plan mode with :plan") }
+	}
+	slog.Info("accepted the plan, switched to act mode")
+	return func() tea.Msg {
+		return startConversationMsg{
+			prompt:          acceptPlanPrompt,
+			initialMessages: []string{systemPrefix + "Act mode: the plan is accepted and all tools are available again", "You: " + acceptPlanPrompt},
+		}
+	}
+}
+
+// handleBriefCommand asks the model to keep its replies under llm.brief_lines lines.
+// The instruction goes with each prompt, leaving the system prompt alone.
+func handleBriefCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	if len(args) == 0 {
+		state := "off"
+		if model.session.Brief() {
+			state = "on"
+		}
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Brief replies are %s, up to %d lines. Usage: :brief on|off", state, model.session.BriefLines()))
+		}
+	}
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return func() tea.Msg { return showSystemMsg("Usage: :brief on|off") }
+	}
+
+	on := args[0] == "on"
+	model.session.SetBrief(on)
+	slog.Info("switched brief replies", "on", on)
+	if on {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Brief replies: the model is asked to answer in %d lines or fewer", model.session.BriefLines()))
+		}
+	}
+	return func() tea.Msg { return showSystemMsg("Brief replies off") }
+}
+
+// handleThinkCommand sets the extended thinking budget of the session, shown in the status bar.
+// Providers without extended thinking ignore it.
+func handleThinkCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	if len(args) == 0 {
+		budget := model.session.ThinkingBudget()
+		state := "off"
+		if budget > 0 {
+			state = fmt.Sprintf("%d tokens", budget)
+		}
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Thinking budget: %s. Usage: :think <n>|off", state))
+		}
+	}
+
+	budget, err := strconv.Atoi(args[0])
+	if args[0] == "off" {
+		budget, err = 0, nil
+	}
+	if len(args) != 1 || err != nil || budget < 0 {
+		return func() tea.Msg { return showSystemMsg("Usage: :think <n>|off") }
+	}
+	model.session.SetThinkingBudget(budget)
+	slog.Info("set thinking budget", "tokens", budget)
+
+	msg := "Thinking off"
+	if budget > 0 {
+		msg = fmt.Sprintf("Thinking budget: %d tokens", max(budget, minThinkingBudget))
+		if !model.session.ThinkingSupported() {
+			msg += fmt.Sprintf(", used once you switch to a model with extended thinking, %s has none", model.session.Provider)
+		}
+	}
+	return func() tea.Msg { return showSystemMsg(msg) }
+}
+
+// handleGaugeCommand pins the context usage bar to the status bar, ui.context_gauge sets it at start
+func handleGaugeCommand(model *TUIModel, args []string) tea.Cmd {
+	on := !model.status.ContextGauge()
+	if len(args) > 0 {
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return func() tea.Msg { return showSystemMsg("Usage: :gauge on|off") }
+		}
+		on = args[0] == "on"
+	}
+	model.status.SetContextGauge(on)
+	if on {
+		return func() tea.Msg { return showSystemMsg("Context gauge on") }
+	}
+	return func() tea.Msg { return showSystemMsg("Context gauge off") }
+}
+
+// handleStreamCommand switches between streaming the replies and showing each whole once
+// complete, for terminals and pipelines that don't cope with streaming
+func handleStreamCommand(model *TUIModel, args []string) tea.Cmd {
+	on := !model.streaming
+	if len(args) > 0 {
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return func() tea.Msg { return showSystemMsg("Usage: :stream on|off") }
+		}
+		on = args[0] == "on"
+	}
+	model.streaming = on
+	if on {
+		return func() tea.Msg { return showSystemMsg("Streaming replies as they come") }
+	}
+	return func() tea.Msg { return showSystemMsg("Showing each reply whole once complete") }
+}
+
+// minWrapWidth is the narrowest :wrap accepts, leaving room for the message prefixes
+const minWrapWidth = 20
+
+// handleWrapCommand wraps the chat at a fixed width, for consistent exports and screenshots,
+// or at the terminal's width again with auto
+func handleWrapCommand(model *TUIModel, args []string) tea.Cmd {
+	chat := model.content.Chat
+	if len(args) == 0 {
+		return func() tea.Msg {
+			if chat.WrapWidth == 0 {
+				return showSystemMsg(fmt.Sprintf("Wrapping at the terminal width, %d columns", chat.Width))
+			}
+			return showSystemMsg(fmt.Sprintf("Wrapping at %d columns, :wrap auto follows the terminal", chat.WrapWidth))
+		}
+	}
+	if args[0] == "auto" {
+		chat.SetWrapWidth(0)
+		return func() tea.Msg { return showSystemMsg("Wrapping at the terminal width") }
+	}
+	cols, err := strconv.Atoi(args[0])
+	if err != nil || len(args) > 1 || cols < minWrapWidth {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Usage: :wrap <cols>|auto, with at least %d columns", minWrapWidth))
+		}
+	}
+	chat.SetWrapWidth(cols)
+	return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Wrapping at %d columns", cols)) }
+}
+
+// handleContinueCommand nudges the model to go on with a reply that stopped short, streaming the
+// continuation into the last AI message
+func handleContinueCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session to continue. Start a conversation first.")
+		}
+	}
+	if model.promptWhileBusy() {
+		return nil
+	}
+	return func() tea.Msg {
+		return startConversationMsg{prompt: continuePrompt, continueLast: true}
+	}
+}
+
+// handleCostCommand breaks the tokens and cost of the session down by turn
+func handleCostCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	turns := model.session.TurnUsages()
+	if len(turns) == 0 {
+		return func() tea.Msg { return showSystemMsg("No turns yet, nothing has been spent") }
+	}
+
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLn("Tokens and cost by turn:")
+	msg.WriteLnf("%3s  %8s  %8s  %9s  %s", "#", "input", "output", "cost", "prompt")
+	var total TokenUsage
+	for i, turn := range turns {
+		cost := formatCost(turn.Cost)
+		if turn.Unpriced {
+			cost = "?"
+		}
+		msg.WriteLnf("%3d  %8s  %8s  %9s  %s", i+1, formatTokenCount(turn.Input), formatTokenCount(turn.Output), cost, truncateSnippet(cleanSnippet(turn.Prompt), 40))
+		total.add(turn.TokenUsage)
+	}
+	totalCost := formatCost(total.Cost)
+	if total.Unpriced {
+		totalCost += "+"
+	}
+	msg.WriteLnf("%3s  %8s  %8s  %9s", "all", formatTokenCount(total.Input), formatTokenCount(total.Output), totalCost)
+	if _, priced := lookupModelPrice(model.session.getModelName()); priced {
+		msg.WriteLnf("At the list prices of %s, cached input counted at the input price", model.session.getModelName())
+	} else {
+		msg.WriteLnf("No prices known for %s, its turns show ? and count nothing toward the cost", model.session.getModelName())
+	}
+	return func() tea.Msg { return showContextMsg{content: msg.String()} }
+}
+
+// handleRestoreCommand lists the checkpoints of the working tree, or reverts it to checkpoint n
+func handleRestoreCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	// The model may be checkpointing or editing mid-turn
+	if model.promptWhileBusy() {
+		return nil
+	}
+	checkpoints := model.session.Checkpoints()
+	if len(checkpoints) == 0 {
+		return func() tea.Msg {
+			return showSystemMsg("No checkpoints yet, one is taken before each tool call that may change files when git.checkpoints is on")
+		}
+	}
+	if len(args) == 0 {
+		msg := NewChatMsgBuilder(systemPrefix)
+		msg.WriteLn("Checkpoints, revert the working tree to one with :restore <n>:")
+		for i, c := range checkpoints {
+			msg.WriteLnf("%3d  %s  before %-14s %s", i+1, c.Time.Format("15:04:05"), c.Tool, c.Commit[:7])
+		}
+		return func() tea.Msg { return showContextMsg{content: msg.String()} }
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || len(args) != 1 || n < 1 || n > len(checkpoints) {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Usage: :restore <n>, with n from 1 to %d", len(checkpoints)))
+		}
+	}
+	if err := model.session.RestoreCheckpoint(n); err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Restore failed: %v", err)) }
+	}
+	refreshGitInfo()
+	slog.Info("restored checkpoint", "n", n, "commit", checkpoints[n-1].Commit)
+	after := len(model.session.Checkpoints())
+	if after == len(checkpoints) {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("The working tree is already as it was at checkpoint %d", n))
+		}
+	}
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Restored the working tree to checkpoint %d, before %s. :restore %d undoes it", n, checkpoints[n-1].Tool, after))
+	}
+}
+
+// handleRedrawCommand clears and redraws a display garbled by terminal noise
+func handleRedrawCommand(model *TUIModel, args []string) tea.Cmd {
+	return model.redraw()
+}
+
+// setPlanMode switches the session between plan and act mode
+func setPlanMode(model *TUIModel, on bool) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	model.session.SetPlanMode(on)
+	slog.Info("switched session mode", "plan", on)
+	if on {
+		return func() tea.Msg {
+			return showSystemMsg("Plan mode: the model can read but not change anything. Use :act accept to have it carry out its plan, or :act to go on yourself.")
+		}
+	}
+	return func() tea.Msg {
+		return showSystemMsg("Act mode: all tools are available again")
+	}
+}
+
+// handleAutoSaveCommand toggles session auto-save for this run, or in the project config with "save"
+func handleAutoSaveCommand(model *TUIModel, args []string) tea.Cmd {
+	persist := len(args) == 2 && args[1] == "save"
+	if (len(args) != 1 && !persist) || (args[0] != "on" && args[0] != "off") {
+		return func() tea.Msg { return showSystemMsg("Usage: :autosave on|off [save]") }
+	}
+	if model.config == nil {
+		return func() tea.Msg { return showSystemMsg("No configuration loaded, cannot change auto-save") }
+	}
+
+	enabled := args[0] == "on"
+	model.config.Session.AutoSave = enabled
+	model.status.SetAutoSavePaused(model.config.Session.Enabled && !enabled)
+	slog.Info("switched session auto-save", "enabled", enabled, "persist", persist)
+
+	state := "paused"
+	if enabled {
+		state = "resumed"
+		model.saveSession()
+	}
+	return func() tea.Msg {
+		if persist {
+			if err := SetProjectConfig("session", "auto_save", strconv.FormatBool(enabled)); err != nil {
+				return showSystemMsg(fmt.Sprintf("Auto-save %s, but saving the setting failed: %v", state, err))
+			}
+			return showSystemMsg(fmt.Sprintf("Auto-save %s and saved to .agents/asimi.conf", state))
+		}
+		if !model.config.Session.Enabled {
+			return showSystemMsg(fmt.Sprintf("Auto-save %s, but sessions are disabled in the config", state))
+		}
+		return showSystemMsg(fmt.Sprintf("Auto-save %s for this run. Add `save` to keep the setting.", state))
+	}
+}
+
+// handleReplaceCommand previews a find/replace across the files matching a glob and asks to apply it.
+// With -r the old text is a regular expression. The arguments are split from the raw command
+// line, so quoted text can hold spaces and "" as the new text deletes the matches.
+func handleReplaceCommand(model *TUIModel, raw []string) tea.Cmd {
+	args, err := splitQuotedArgs(strings.Join(raw, " "))
+	if err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot replace: %v", err)) }
+	}
+	regex := len(args) > 0 && args[0] == "-r"
+	if regex {
+		args = args[1:]
+	}
+	if len(args) != 3 || args[1] == "" {
+		return func() tea.Msg { return showSystemMsg("Usage: :replace [-r] <glob> <old> <new>") }
+	}
+
+	plan, err := planReplace(args[0], args[1], args[2], regex)
+	if err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot replace: %v", err)) }
+	}
+	if len(plan.Files) == 0 {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("No matches for `%s` in %s", plan.Old, plan.Glob)) }
+	}
+	slog.Info("previewing replace", "glob", plan.Glob, "regex", regex, "files", len(plan.Files), "matches", plan.Matches())
+
+	model.content.Chat.AddMessage(renderReplacePreview(plan))
+	model.pendingReplace = plan
+	model.prompt.Blur()
+	return model.commandLine.EnterYesNoMode(fmt.Sprintf("Apply %d replacements in %d files?", plan.Matches(), len(plan.Files)))
+}
+
+// handleChangedCommand lists the files changed in the last N commits, or adds them to the context with "add"
+func handleChangedCommand(model *TUIModel, args []string) tea.Cmd {
+	commits, add := 1, false
+	for _, arg := range args {
+		if arg == "add" {
+			add = true
+			continue
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return func() tea.Msg { return showSystemMsg("Usage: :changed [N] [add]") }
+		}
+		commits = n
+	}
+
+	since := "the last commit"
+	if commits > 1 {
+		since = fmt.Sprintf("the last %d commits", commits)
+	}
+	paths, err := changedFiles(".", commits)
+	if err != nil {
+		return func() tea.Msg { return showSystemMsg(fmt.Sprintf("Cannot list changed files: %v", err)) }
+	}
+	if len(paths) == 0 {
+		return func() tea.Msg { return showSystemMsg("No files changed in " + since) }
+	}
+
+	msg := NewChatMsgBuilder(systemPrefix)
+	if !add {
+		msg.WriteLnf("Files changed in %s:", since)
+		for _, path := range paths {
+			msg.WriteLn(path)
+		}
+		msg.WriteLnf("Add them to the context with `:changed %d add`, or view one with `:open <path>`", commits)
+		return func() tea.Msg { return showContextMsg{content: msg.String()} }
+	}
+
+	if model.session == nil {
+		return func() tea.Msg {
+			return showSystemMsg("No active session. Use :models to configure a model and start chatting.")
+		}
+	}
+	var added, skipped []string
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err == nil && isBinaryContent(content) {
+			skipped = append(skipped, path+" (binary file)")
+			continue
+		}
+		if err == nil {
+			err = model.session.AddContextFile(path, string(content))
+		}
+		if err != nil {
+			slog.Warn("not adding changed file to the context", "path", path, "error", err)
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", path, err))
+			continue
+		}
+		added = append(added, path)
+	}
+	slog.Info("added changed files to the context", "commits", commits, "added", len(added), "skipped", len(skipped))
+
+	msg.WriteLnf("Added %d files changed in %s to the context for the next prompt", len(added), since)
+	for _, path := range added {
+		msg.WriteLn(path)
+	}
+	if len(skipped) > 0 {
+		msg.WriteLn("Skipped:")
+		for _, path := range skipped {
+			msg.WriteLn(path)
+		}
+	}
+	return func() tea.Msg { return showContextMsg{content: msg.String()} }
+}
+
+// handleLoopCommand shows the tool call loop detection state, or clears it with "reset"
+func handleLoopCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "reset") {
+		return func() tea.Msg { return showSystemMsg("Usage: :loop [reset]") }
+	}
+	if model.session == nil {
+		return func() tea.Msg { return showSystemMsg("No active session") }
+	}
+
+	if len(args) == 1 {
+		model.session.ResetToolLoop()
+		slog.Info("reset tool call loop detection")
+		return func() tea.Msg { return showSystemMsg("Tool call loop detection reset") }
+	}
+
+	key, count := model.session.ToolLoopState()
+	if key == "" {
+		return func() tea.Msg { return showSystemMsg("No repeated tool calls") }
+	}
+	if len(key) > 12 {
+		key = key[:12]
+	}
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Last tool call %s repeated %d/%d times", key, count, toolCallLoopThreshold))
+	}
+}
+
+// compareProviders lists the providers accepted as a `provider/model` prefix in :compare
+var compareProviders = []string{"anthropic", "openai", "googleai", "ollama", "fake"}
+
+// compareTarget is one side of a :compare run
+type compareTarget struct {
+	Label string
+	LLM   llms.Model
+}
+
+// compareResult holds the response of a single compareTarget
+type compareResult struct {
+	Label    string
+	Content  string
+	Err      error
+	Duration time.Duration
+}
+
+func handleCompareCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) < 3 {
+		return func() tea.Msg {
+			return showSystemMsg("Usage: :compare <modelA> <modelB> <prompt>")
+		}
+	}
+	prompt := strings.Join(args[2:], " ")
+
+	base := defaultConfig()
+	if model.config != nil {
+		base = *model.config
+	}
+	configs := []*Config{compareConfig(base, args[0]), compareConfig(base, args[1])}
+
+	return func() tea.Msg {
+		if program != nil {
+			program.Send(showSystemMsg(fmt.Sprintf("Comparing %s and %s...", args[0], args[1])))
+		}
+
+		targets := make([]compareTarget, 0, len(configs))
+		for _, cfg := range configs {
+			label := cfg.LLM.Provider + "/" + cfg.LLM.Model
+			llm, err := getModelClient(cfg)
+			if err != nil {
+				return showSystemMsg(fmt.Sprintf("Failed to create client for %s: %v", label, err))
+			}
+			targets = append(targets, compareTarget{Label: label, LLM: llm})
+		}
+
+		results := runComparison(context.Background(), prompt, targets)
+		return showContextMsg{content: renderComparison(prompt, results)}
+	}
+}
+
+// compareConfig returns a copy of base with the model overridden by spec.
+// spec is either a model name for the current provider or `provider/model`.
+func compareConfig(base Config, spec string) *Config {
+	cfg := base
+	if provider, modelName, ok := strings.Cut(spec, "/"); ok && slices.Contains(compareProviders, provider) {
+		if provider != cfg.LLM.Provider {
+			// Credentials and endpoints belong to the configured provider
+			cfg.LLM.APIKey = ""
+			cfg.LLM.AuthToken = ""
+			cfg.LLM.RefreshToken = ""
+			cfg.LLM.BaseURL = ""
+		}
+		cfg.LLM.Provider = provider
+		spec = modelName
+	}
+	cfg.LLM.Model = spec
+	return &cfg
+}
+
+// runComparison sends the prompt to every target concurrently, outside of the session history
+func runComparison(ctx context.Context, prompt string, targets []compareTarget) []compareResult {
+	results := make([]compareResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			content, err := llms.GenerateFromSinglePrompt(ctx, limitedModel{target.LLM}, prompt)
+			results[i] = compareResult{
+				Label:    target.Label,
+				Content:  content,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// renderComparison formats the results as sequential labeled blocks
+func renderComparison(prompt string, results []compareResult) string {
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLnf("Comparison for: %s", prompt)
+	for _, r := range results {
+		msg.WriteLn("")
+		msg.WriteLnf("── %s (%s) ──", r.Label, r.Duration.Round(time.Millisecond))
+		if r.Err != nil {
+			msg.WriteLnf("Error: %v", r.Err)
+			continue
+		}
+		content := strings.TrimSpace(r.Content)
+		if content == "" {
+			content = emptyResponsePlaceholder
+		}
+		for _, line := range strings.Split(content, "\n") {
+			msg.WriteLn(line)
+		}
+	}
+	return msg.String()
+}
+
+// benchTimeout bounds each :bench request, so a stalled provider doesn't hold up the table
+const benchTimeout = 60 * time.Second
+
+// benchResult holds the latencies of one :bench target
+type benchResult struct {
+	Label      string
+	FirstToken time.Duration
+	Total      time.Duration
+	Err        error
+}
+
+func handleBenchCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		return func() tea.Msg { return showSystemMsg("Usage: :bench <prompt>") }
+	}
+	prompt := strings.Join(args, " ")
+
+	base := defaultConfig()
+	if model.config != nil {
+		base = *model.config
+	}
+
+	return func() tea.Msg {
+		targets, failed := benchTargets(base)
+		if len(targets)+len(failed) == 0 {
+			return showSystemMsg("No provider has credentials to benchmark, use :login or set an API key")
+		}
+		if program != nil {
+			program.Send(showSystemMsg(fmt.Sprintf("Benchmarking %d providers...", len(targets))))
+		}
+		results := append(runBench(context.Background(), prompt, targets, benchTimeout), failed...)
+		return showContextMsg{content: renderBench(prompt, results)}
+	}
+}
+
+// benchTargets builds a client for the default model of every provider with credentials,
+// returning the ones that can't be created as failed results. It's replaced in tests.
+var benchTargets = func(base Config) ([]compareTarget, []benchResult) {
+	var targets []compareTarget
+	var failed []benchResult
+	for _, provider := range []string{"anthropic", "openai", "googleai"} {
+		auth := checkProviderAuth(provider)
+		configured := provider == base.LLM.Provider && (base.LLM.APIKey != "" || base.LLM.AuthToken != "")
+		if !auth.HasAPIKey && !auth.HasOAuth && apiKeyFromEnv(provider) == "" && !configured {
+			continue
+		}
+		cfg := compareConfig(base, provider+"/"+providerDefaultModels[provider])
+		if provider != base.LLM.Provider {
+			cfg.LLM.APIKey = apiKeyFromEnv(provider)
+		}
+		label := cfg.LLM.Provider + "/" + cfg.LLM.Model
+		llm, err := getModelClient(cfg)
+		if err != nil {
+			failed = append(failed, benchResult{Label: label, Err: err})
+			continue
+		}
+		targets = append(targets, compareTarget{Label: label, LLM: llm})
+	}
+	return targets, failed
+}
+
+// runBench streams the prompt from every target, outside of the session history, timing the
+// first chunk and the whole reply. llm.max_concurrent_requests bounds the requests in flight.
+func runBench(ctx context.Context, prompt string, targets []compareTarget, timeout time.Duration) []benchResult {
+	results := make([]benchResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			var start time.Time
+			var firstToken time.Duration
+			streaming := llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+				if firstToken == 0 && len(chunk) > 0 {
+					firstToken = time.Since(start)
+				}
+				return nil
+			})
+			messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}
+			start = time.Now()
+			_, err := generateContent(ctx, timedModel{target.LLM, &start}, messages, streaming)
+			total := time.Since(start)
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %s", timeout)
+			}
+			if firstToken == 0 {
+				// Without streaming the first token comes with the whole reply
+				firstToken = total
+			}
+			results[i] = benchResult{Label: target.Label, FirstToken: firstToken, Total: total, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// timedModel sets start when the request is sent, so the time spent waiting for one of
+// llm.max_concurrent_requests isn't counted as latency
+type timedModel struct {
+	llms.Model
+	start *time.Time
+}
+
+func (m timedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	*m.start = time.Now()
+	return m.Model.GenerateContent(ctx, messages, options...)
+}
+
+// renderBench formats the results as a table, the fastest first token first and the failures last
+func renderBench(prompt string, results []benchResult) string {
+	slices.SortStableFunc(results, func(a, b benchResult) int {
+		if (a.Err == nil) != (b.Err == nil) {
+			if a.Err == nil {
+				return -1
+			}
+			return 1
+		}
+		if a.Err != nil {
+			return 0
+		}
+		return cmp.Compare(a.FirstToken, b.FirstToken)
+	})
+
+	msg := NewChatMsgBuilder(systemPrefix)
+	msg.WriteLnf("Benchmark for: %s", prompt)
+	msg.WriteLn("")
+	msg.WriteLnf("%-36s %12s %10s", "Model", "First token", "Total")
+	for _, r := range results {
+		if r.Err != nil {
+			msg.WriteLnf("%-36s Error: %v", r.Label, r.Err)
+			continue
+		}
+		msg.WriteLnf("%-36s %12s %10s", r.Label, r.FirstToken.Round(time.Millisecond), r.Total.Round(time.Millisecond))
+	}
+	return msg.String()
+}
+
+func handleScrollTopCommand(model *TUIModel, args []string) tea.Cmd {
+	if model == nil || model.content.GetActiveView() != ViewChat {
+		return nil
+	}
+	model.content.Chat.ScrollToTop()
+	return nil
+}
+
+type updateCheckMsg struct {
+	hasUpdate bool
+	latest    string
+	err       error
+}
+
+type updateCompleteMsg struct {
+	success bool
+	err     error
+}
+
+func handleUpdateCommand(model *TUIModel, args []string) tea.Cmd {
+	return func() tea.Msg {
+		// Show checking message
+		if program != nil {
+			program.Send(showSystemMsg("Checking for updates..."))
+		}
+
+		// Check for updates
+		latest, hasUpdate, err := CheckForUpdates(version)
+		if err != nil {
+			return updateCheckMsg{hasUpdate: false, err: err}
+		}
+
+		if !hasUpdate {
+			return updateCheckMsg{hasUpdate: false, latest: version}
+		}
+
+		// Update available - show confirmation
+		return updateCheckMsg{hasUpdate: true, latest: latest.Version.String()}
+	}
+}
+
+func handleUpdateConfirm(model *TUIModel) tea.Cmd {
+	return func() tea.Msg {
+		// Show updating message
+		if program != nil {
+			msg := NewChatMsgBuilder(systemPrefix)
+			msg.WriteLn("Downloading and installing update...")
+			msg.WriteLn("This may take a moment.")
+			program.Send(showContextMsg{content: msg.String()})
+		}
+
+		// Perform update
+		err := SelfUpdate(version)
+		if err != nil {
+			return updateCompleteMsg{success: false, err: err}
+		}
+
+		return updateCompleteMsg{success: true}
+	}
+}
+
+// handleLogViewCommand switches between the chat and the agent log of tool calls
+func handleLogViewCommand(model *TUIModel, args []string) tea.Cmd {
+	model.logView = !model.logView
+	if model.logView {
+		model.rawMode = false
+	}
+	return nil
+}
+
+// handleRawCommand toggles the raw session view or, with filter, shows only the raw
+// entries of the given types. A type matches itself and its subtypes, so TOOL shows
+// TOOL_CALL and TOOL_SUCCESS. :raw filter alone shows all the entries again.
+func handleRawCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) == 0 {
+		model.rawMode = !model.rawMode
+		return nil
+	}
+	if args[0] != "filter" {
+		return func() tea.Msg { return showSystemMsg("Usage: :raw [filter [TYPE...]]") }
+	}
+
+	var filter []string
+	for _, arg := range args[1:] {
+		kind := strings.TrimRight(strings.ToUpper(arg), "*_")
+		if kind != "" && !slices.Contains(filter, kind) {
+			filter = append(filter, kind)
+		}
+	}
+	model.rawFilter = filter
+	model.rawXOffset = 0
+	model.rawMode = true
+	return nil
+}
+
+// normalizeTag lowercases a tag and drops a leading #, commas are not allowed in tags
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// handleTagCommand adds tags to the session, or removes those prefixed with -, and saves it.
+// Without arguments it lists the session's tags.
+func handleTagCommand(model *TUIModel, args []string) tea.Cmd {
+	if model.session == nil {
+		return func() tea.Msg { return showSystemMsg("No active session to tag. Start a conversation first.") }
+	}
+
+	tags := slices.Clone(model.session.Tags)
+	for _, arg := range args {
+		remove := strings.HasPrefix(arg, "-")
+		tag := normalizeTag(strings.TrimPrefix(arg, "-"))
+		if tag == "" || strings.Contains(tag, ",") {
+			return func() tea.Msg {
+				return showSystemMsg(fmt.Sprintf("Invalid tag %q, tags cannot be empty or contain commas", arg))
+			}
+		}
+		if remove {
+			tags = slices.DeleteFunc(tags, func(t string) bool { return t == tag })
+		} else if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+
+	if len(args) == 0 {
+		if len(tags) == 0 {
+			return func() tea.Msg { return showSystemMsg("The session has no tags. Usage: :tag <name...>") }
+		}
+		return func() tea.Msg { return showSystemMsg("Session tags: #" + strings.Join(tags, " #")) }
+	}
+
+	model.session.Tags = tags
+	listed := "none"
+	if len(tags) > 0 {
+		listed = "#" + strings.Join(tags, " #")
+	}
+	if model.sessionStore == nil || model.config == nil || !model.config.Session.Enabled {
+		return func() tea.Msg {
+			return showSystemMsg("Session tags: " + listed + " (sessions are disabled, the tags will not be saved)")
+		}
+	}
+	return func() tea.Msg {
+		if err := model.sessionStore.SaveSessionSync(model.session); err != nil {
+			return showSystemMsg(fmt.Sprintf("Session tags: %s, but saving the session failed: %v", listed, err))
+		}
+		return showSystemMsg("Session tags: " + listed)
+	}
+}
+
+// handleEncodingCommand shows the encoding assumed for files that are neither UTF-8 nor start
+// with a byte order mark, or sets it for this run
+func handleEncodingCommand(model *TUIModel, args []string) tea.Cmd {
+	if len(args) > 1 {
+		return func() tea.Msg { return showSystemMsg("Usage: :encoding [name]") }
+	}
+	if len(args) == 0 {
+		return func() tea.Msg {
+			return showSystemMsg(fmt.Sprintf("Files that aren't UTF-8 are read as %s. Available: %s",
+				currentFileEncoding(), strings.Join(fileEncodingNames(), ", ")))
+		}
+	}
+	if err := setFileEncoding(args[0]); err != nil {
+		return func() tea.Msg { return showSystemMsg(err.Error()) }
+	}
+	if model.config != nil {
+		model.config.Tools.FileEncoding = args[0]
+	}
+	return func() tea.Msg {
+		return showSystemMsg(fmt.Sprintf("Files that aren't UTF-8 are now read as %s for this run, set tools.file_encoding to keep it", currentFileEncoding()))
 	}
 }
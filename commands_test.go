@@ -1,10 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/fake"
+	gokeyring "github.com/zalando/go-keyring"
 )
 
 func TestFindCommand(t *testing.T) {
@@ -64,26 +87,47 @@ func TestFindCommand(t *testing.T) {
 			name:            "ambiguous match - c",
 			input:           ":c",
 			expectFound:     false,
-			expectMatches:   2, // compact and context
+			expectMatches:   9, // changed, compact, compare, config, container, context, continue, cost and count
 			expectAmbiguous: true,
 		},
 		{
 			name:            "ambiguous match - co",
 			input:           ":co",
 			expectFound:     false,
-			expectMatches:   2, // compact and context
+			expectMatches:   8, // compact, compare, config, container, context, continue, cost and count
 			expectAmbiguous: true,
 		},
 		{
-			name:          "partial disambiguated - com",
-			input:         ":com",
+			name:            "ambiguous match - com",
+			input:           ":com",
+			expectFound:     false,
+			expectMatches:   2, // compact and compare
+			expectAmbiguous: true,
+		},
+		{
+			name:          "partial disambiguated - compac",
+			input:         ":compac",
 			expectFound:   true,
 			expectCommand: "compact",
 			expectMatches: 1,
 		},
 		{
-			name:          "partial disambiguated - con",
-			input:         ":con",
+			name:          "partial disambiguated - compar",
+			input:         ":compar",
+			expectFound:   true,
+			expectCommand: "compare",
+			expectMatches: 1,
+		},
+		{
+			name:            "ambiguous match - con",
+			input:           ":con",
+			expectFound:     false,
+			expectMatches:   4, // config, container, context and continue
+			expectAmbiguous: true,
+		},
+		{
+			name:          "partial disambiguated - conte",
+			input:         ":conte",
 			expectFound:   true,
 			expectCommand: "context",
 			expectMatches: 1,
@@ -121,6 +165,18 @@ func TestFindCommand(t *testing.T) {
 	}
 }
 
+func TestSuggestCommand(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	require.Equal(t, "compact", registry.SuggestCommand(":comapct"))
+	require.Equal(t, "export", registry.SuggestCommand("exprot"))
+	require.Equal(t, "resume", registry.SuggestCommand("resme"))
+	require.Equal(t, "", registry.SuggestCommand("xyzzy"), "far misses get no suggestion")
+
+	require.Equal(t, "Unknown command ':comapct'. Did you mean ':compact'?", registry.UnknownCommandMessage(":comapct"))
+	require.Equal(t, "Unknown command: xyzzy", registry.UnknownCommandMessage("xyzzy"))
+}
+
 func TestNormalizeCommandName(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -142,6 +198,45 @@ func TestNormalizeCommandName(t *testing.T) {
 	}
 }
 
+func TestDetectProjectType(t *testing.T) {
+	tests := []struct {
+		manifest string
+		language string
+		test     string
+	}{
+		{"go.mod", "Go", "go test ./..."},
+		{"package.json", "JavaScript/TypeScript", "npm test"},
+		{"pyproject.toml", "Python", "pytest"},
+		{"Cargo.toml", "Rust", "cargo test"},
+		{"README.md", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.manifest, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, tt.manifest), []byte(""), 0o644))
+			t.Chdir(dir)
+
+			pt := detectProjectType()
+			require.Equal(t, tt.language, pt.Language)
+			require.Equal(t, tt.test, pt.Test)
+
+			tmpl, err := template.New("init").Parse(initializePrompt)
+			require.NoError(t, err)
+			var prompt bytes.Buffer
+			require.NoError(t, tmpl.Execute(&prompt, InitTemplateData{AgentsFile: "AGENTS.md", Project: pt}))
+			if tt.language == "" {
+				require.NotContains(t, prompt.String(), "Detected a")
+				return
+			}
+			require.Contains(t, prompt.String(), "Detected a "+tt.language+" project ("+tt.manifest+")")
+			require.Contains(t, prompt.String(), "`"+pt.Build+"`")
+			require.Contains(t, prompt.String(), "`"+pt.Test+"`")
+			require.Contains(t, prompt.String(), "`"+pt.Lint+"`")
+		})
+	}
+}
+
 func TestHandleInitCommand(t *testing.T) {
 	// Setup a temporary directory for the test
 	tmpDir := t.TempDir()
@@ -360,3 +455,951 @@ func TestRunInitGuardrails(t *testing.T) {
 		os.Remove("Justfile")
 	})
 }
+
+// containerMockRunner is a sandbox runner that records the lifecycle calls
+type containerMockRunner struct {
+	TestShellRunner
+	id       string
+	restarts int
+	closes   int
+}
+
+func (r *containerMockRunner) Restart(ctx context.Context) error {
+	r.restarts++
+	r.id = fmt.Sprintf("asimi-shell-%d", r.restarts)
+	return nil
+}
+
+func (r *containerMockRunner) Close(ctx context.Context) error {
+	r.closes++
+	r.id = ""
+	return nil
+}
+
+func (r *containerMockRunner) RunnerType() string  { return "podman" }
+func (r *containerMockRunner) ContainerID() string { return r.id }
+func (r *containerMockRunner) ImageName() string   { return "localhost/asimi-sandbox:latest" }
+
+func TestHandleContainerCommand(t *testing.T) {
+	restore := setShellRunnerForTesting(NewTestShellRunner())
+	defer restore()
+	model := newTestModel(t)
+	require.Contains(t, handleContainerCommand(model, nil)().(showContextMsg).content, "run on the host")
+
+	runner := &containerMockRunner{id: "asimi-shell-0"}
+	setShellRunnerForTesting(runner)
+	status := handleContainerCommand(model, []string{"status"})().(showContextMsg).content
+	require.Contains(t, status, "Container: asimi-shell-0")
+	require.Contains(t, status, "Image: localhost/asimi-sandbox:latest")
+	require.Contains(t, handleContainerCommand(model, []string{"rm"})().(showContextMsg).content, "Usage: :container")
+
+	updated, _ := model.Update(handleContainerCommand(model, []string{"restart"})())
+	m := updated.(TUIModel)
+	require.Equal(t, 1, runner.restarts)
+	require.Equal(t, "asimi-shell-1", m.status.shellRunnerInfo.ContainerID, "the status badge shows the container")
+	require.Contains(t, m.commandLine.toasts[len(m.commandLine.toasts)-1].Message, "restarted")
+
+	updated, _ = m.Update(handleContainerCommand(&m, []string{"stop"})())
+	m = updated.(TUIModel)
+	require.Equal(t, 1, runner.closes)
+	require.Empty(t, m.status.shellRunnerInfo.ContainerID)
+	require.Contains(t, handleContainerCommand(&m, nil)().(showContextMsg).content, "not started")
+}
+
+func TestHandleUseResultCommand(t *testing.T) {
+	model := newTestModel(t)
+	msg := handleUseResultCommand(model, []string{"1"})()
+	require.Contains(t, msg.(showContextMsg).content, "No tool results")
+
+	model.session.Messages = append(model.session.Messages,
+		llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{
+			llms.ToolCallResponse{ToolCallID: "1", Name: "read_file", Content: "package main"},
+		}},
+		llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{
+			llms.ToolCallResponse{ToolCallID: "2", Name: "run_in_shell", Content: "FAIL TestParse\nexpected 3, got 4"},
+		}},
+	)
+
+	list := handleUseResultCommand(model, nil)().(showContextMsg).content
+	require.Contains(t, list, "  1  read_file")
+	require.Contains(t, list, "  2  run_in_shell   FAIL TestParse")
+	require.Contains(t, handleUseResultCommand(model, []string{"3"})().(showContextMsg).content, "from 1 to 2")
+
+	msg = handleUseResultCommand(model, []string{"2"})()
+	require.Contains(t, msg.(showContextMsg).content, "Attached result 2 of run_in_shell")
+	model.session.prepareUserMessage("based on result 2, fix the parser")
+	sent := model.session.Messages[len(model.session.Messages)-1].Parts[0].(llms.TextContent).Text
+	require.Contains(t, sent, "result 2: run_in_shell")
+	require.Contains(t, sent, "expected 3, got 4")
+	require.Contains(t, sent, "based on result 2, fix the parser")
+}
+
+func TestHandleAttachLastCommand(t *testing.T) {
+	restore := setShellRunnerForTesting(NewTestShellRunner())
+	defer restore()
+
+	model := newTestModel(t)
+
+	// Nothing to attach before a shell command ran
+	msg := handleAttachLastCommand(model, nil)()
+	require.Contains(t, msg.(showContextMsg).content, "No shell command output")
+	require.False(t, model.session.HasContextFiles())
+
+	// Run a shell command and feed the result back through Update
+	_, cmd := model.handleShellCommand("!echo attached-output")
+	require.NotNil(t, cmd)
+	updated, _ := model.Update(cmd())
+	m := updated.(TUIModel)
+	require.NotNil(t, m.lastShellResult)
+
+	updated, cmd = m.Update(commandReadyMsg{command: "attach-last"})
+	m = updated.(TUIModel)
+	require.NotNil(t, cmd)
+	require.Contains(t, cmd().(showContextMsg).content, "echo attached-output")
+
+	files := m.session.GetContextFiles()
+	require.Contains(t, files, "shell:echo attached-output")
+	require.Contains(t, m.session.buildPromptWithContext("what happened?"), "attached-output")
+}
+
+func TestHandleSandboxCommand(t *testing.T) {
+	restore := setShellRunnerForTesting(failingPodmanRunner{})
+	defer restore()
+
+	model := newTestModel(t)
+	// An image that never exists keeps the test independent of a local podman setup
+	model.config.RunInShell.ImageName = "localhost/asimi-sandbox-missing-for-tests:latest"
+
+	msg := handleSandboxCommand(model, []string{"off"})()
+	require.IsType(t, containerLaunchMsg{}, msg)
+	require.Equal(t, "host", getShellRunnerInfo().Type)
+
+	updated, _ := model.Update(msg)
+	m := updated.(TUIModel)
+	require.NotNil(t, m.status.shellRunnerInfo)
+	require.Equal(t, "host", m.status.shellRunnerInfo.Type)
+
+	// Enabling the sandbox without the image fails and keeps the host runner
+	msg = handleSandboxCommand(&m, []string{"on"})()
+	require.Contains(t, msg.(showContextMsg).content, "Cannot enable the sandbox")
+	require.Equal(t, "host", getShellRunnerInfo().Type)
+
+	msg = handleSandboxCommand(&m, []string{"maybe"})()
+	require.Contains(t, msg.(showContextMsg).content, "Usage: :sandbox on|off")
+}
+
+func TestHandleDumpCommand(t *testing.T) {
+	model := newTestModel(t)
+	model.session.Messages = append(model.session.Messages,
+		llms.MessageContent{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{
+			llms.TextPart("--- Context from: main.go ---\npackage main\n--- End of Context from: main.go ---\nwhat does it do?"),
+		}},
+		llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{
+			llms.ToolCall{ID: "call_42", Type: "function", FunctionCall: &llms.FunctionCall{Name: "read_file", Arguments: `{"path":"main.go"}`}},
+		}},
+		llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{
+			llms.ToolCallResponse{ToolCallID: "call_42", Name: "read_file", Content: strings.Repeat("x", 500)},
+		}},
+		llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{llms.TextPart("It prints hello")}},
+	)
+
+	dump := handleDumpCommand(model, nil)().(showContextMsg).content
+	require.Contains(t, dump, "Messages sent to the model (5)")
+	require.Contains(t, dump, "#0 system [system prompt]")
+	require.Contains(t, dump, "#1 human [context-augmented]")
+	require.Contains(t, dump, "#2 ai\n  tool_call id=call_42 name=read_file")
+	require.Contains(t, dump, "#3 tool\n  tool_result id=call_42 name=read_file")
+	require.Contains(t, dump, `text: "It prints hello"`)
+	require.NotContains(t, dump, strings.Repeat("x", 300), "long parts are truncated")
+}
+
+func TestCompareConfig(t *testing.T) {
+	base := Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-20250514", APIKey: "secret", BaseURL: "http://proxy"}}
+
+	cfg := compareConfig(base, "claude-opus-4-20250514")
+	require.Equal(t, "anthropic", cfg.LLM.Provider)
+	require.Equal(t, "claude-opus-4-20250514", cfg.LLM.Model)
+	require.Equal(t, "secret", cfg.LLM.APIKey)
+
+	cfg = compareConfig(base, "openai/gpt-4o")
+	require.Equal(t, "openai", cfg.LLM.Provider)
+	require.Equal(t, "gpt-4o", cfg.LLM.Model)
+	require.Empty(t, cfg.LLM.APIKey, "credentials must not leak to another provider")
+	require.Empty(t, cfg.LLM.BaseURL)
+
+	// Unknown prefixes are part of the model name
+	cfg = compareConfig(base, "meta-llama/llama-3")
+	require.Equal(t, "anthropic", cfg.LLM.Provider)
+	require.Equal(t, "meta-llama/llama-3", cfg.LLM.Model)
+
+	// The base config is left untouched
+	require.Equal(t, "claude-sonnet-4-20250514", base.LLM.Model)
+}
+
+func TestRunComparison(t *testing.T) {
+	targets := []compareTarget{
+		{Label: "fake/model-a", LLM: fake.NewFakeLLM([]string{"Answer from A"})},
+		{Label: "fake/model-b", LLM: fake.NewFakeLLM([]string{"Answer from B"})},
+	}
+
+	results := runComparison(context.Background(), "What is 2+2?", targets)
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Err)
+	require.NoError(t, results[1].Err)
+
+	out := renderComparison("What is 2+2?", results)
+	require.Contains(t, out, "What is 2+2?")
+	require.Contains(t, out, "── fake/model-a")
+	require.Contains(t, out, "Answer from A")
+	require.Contains(t, out, "── fake/model-b")
+	require.Contains(t, out, "Answer from B")
+	require.Less(t, strings.Index(out, "Answer from A"), strings.Index(out, "fake/model-b"))
+}
+
+func TestHandleCompareCommandDoesNotTouchSession(t *testing.T) {
+	model := newTestModel(t)
+	before := len(model.session.Messages)
+
+	msg := handleCompareCommand(model, []string{"a"})()
+	require.Contains(t, msg.(showContextMsg).content, "Usage: :compare")
+
+	// Unsupported providers fail before any request is sent
+	model.config.LLM.Provider = "unknown"
+	model.config.LLM.APIKey = "test-key" // skip the keyring lookup
+	msg = handleCompareCommand(model, []string{"model-a", "model-b", "hello"})()
+	require.Contains(t, msg.(showContextMsg).content, "unsupported LLM provider")
+	require.Len(t, model.session.Messages, before)
+}
+
+// slowLLM streams a reply after firstToken and finishes it after total
+type slowLLM struct {
+	llms.Model
+	firstToken, total time.Duration
+}
+
+func (m *slowLLM) GenerateContent(ctx context.Context, _ []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	wait := func(d time.Duration) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := wait(m.firstToken); err != nil {
+		return nil, err
+	}
+	if opts.StreamingFunc != nil {
+		opts.StreamingFunc(ctx, []byte("4"))
+	}
+	if err := wait(m.total - m.firstToken); err != nil {
+		return nil, err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "4"}}}, nil
+}
+
+func TestHandleBenchCommand(t *testing.T) {
+	targets := benchTargets
+	benchTargets = func(base Config) ([]compareTarget, []benchResult) {
+		return []compareTarget{
+			{Label: "fake/slow", LLM: &slowLLM{firstToken: 150 * time.Millisecond, total: 250 * time.Millisecond}},
+			{Label: "fake/fast", LLM: &slowLLM{firstToken: 10 * time.Millisecond, total: 100 * time.Millisecond}},
+		}, []benchResult{{Label: "openai/gpt-4o", Err: errors.New("no credentials")}}
+	}
+	t.Cleanup(func() { benchTargets = targets })
+
+	model := newTestModel(t)
+	before := len(model.session.Messages)
+	require.Contains(t, handleBenchCommand(model, nil)().(showContextMsg).content, "Usage: :bench")
+
+	out := handleBenchCommand(model, []string{"What", "is", "2+2?"})().(showContextMsg).content
+	require.Contains(t, out, "Benchmark for: What is 2+2?")
+	require.Contains(t, out, "First token")
+	require.Contains(t, out, "openai/gpt-4o                        Error: no credentials")
+	fast, slow := strings.Index(out, "fake/fast"), strings.Index(out, "fake/slow")
+	require.True(t, fast >= 0 && slow >= 0, out)
+	require.Less(t, fast, slow, "the fastest first token comes first")
+	require.Less(t, slow, strings.Index(out, "openai/gpt-4o"))
+	require.Len(t, model.session.Messages, before, "the session is left alone")
+
+	benched := runBench(context.Background(), "hi", []compareTarget{
+		{Label: "fake/slow", LLM: &slowLLM{firstToken: 150 * time.Millisecond, total: 250 * time.Millisecond}},
+		{Label: "fake/fast", LLM: &slowLLM{firstToken: 10 * time.Millisecond, total: 100 * time.Millisecond}},
+		{Label: "fake/stalled", LLM: &slowLLM{firstToken: time.Minute, total: time.Minute}},
+	}, time.Second)
+	require.NoError(t, benched[0].Err)
+	require.GreaterOrEqual(t, benched[0].FirstToken, 150*time.Millisecond)
+	require.GreaterOrEqual(t, benched[0].Total, 250*time.Millisecond)
+	require.Less(t, benched[1].FirstToken, benched[0].FirstToken)
+	require.Less(t, benched[1].FirstToken, benched[1].Total)
+	require.EqualError(t, benched[2].Err, "timed out after 1s")
+	require.Less(t, benched[1].Total, 150*time.Millisecond, "waiting for a request slot isn't latency")
+}
+
+func TestHandleOpenCommand(t *testing.T) {
+	dir := t.TempDir()
+	var src strings.Builder
+	src.WriteString("package main\n\n")
+	for i := 3; i <= 12; i++ {
+		src.WriteString("// line\n")
+	}
+	path := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(path, []byte(src.String()), 0o644))
+
+	model := newTestModel(t)
+	model.content.SetSize(80, 40)
+	handleOpenCommand(model, []string{path})
+	require.Equal(t, ViewFile, model.content.GetActiveView())
+
+	view := ansi.Strip(model.content.View())
+	require.Contains(t, view, path)
+	require.Contains(t, view, " 1 package main")
+	require.Contains(t, view, "12 // line")
+	require.Empty(t, model.session.ContextFiles, "opening a file must not add it to the context")
+
+	handleQuitCommand(model, nil)
+	require.Equal(t, ViewChat, model.content.GetActiveView())
+
+	binary := filepath.Join(dir, "blob.bin")
+	require.NoError(t, os.WriteFile(binary, []byte{0x7f, 'E', 'L', 'F', 0x00, 0x01}, 0o644))
+	msg := handleOpenCommand(model, []string{binary})()
+	require.Contains(t, msg.(showContextMsg).content, "binary")
+	require.Equal(t, ViewChat, model.content.GetActiveView())
+}
+
+func TestHandleAgentsRegenerate(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := "# Agents\n\n- Run tests with `just test`\n- run tests with just test\n"
+	cleaned := "# Agents\n\n- Run tests with `just test`\n"
+	require.NoError(t, os.WriteFile("AGENTS.md", []byte(original), 0o644))
+
+	model := newTestModel(t)
+	sess, err := NewSession(fake.NewFakeLLM([]string{"```markdown\n" + cleaned + "```"}), &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+
+	msg := handleAgentsCommand(model, []string{"regenerate"})()
+	regenerated, ok := msg.(agentsRegeneratedMsg)
+	require.True(t, ok, "expected agentsRegeneratedMsg, got %T", msg)
+	require.NoError(t, regenerated.err)
+	require.Equal(t, cleaned, regenerated.cleaned)
+
+	// The diff is previewed and nothing is written before approval
+	updated, _ := model.Update(regenerated)
+	m := updated.(TUIModel)
+	require.NotNil(t, m.pendingAgentsRewrite)
+	require.True(t, m.commandLine.IsInYesNoMode())
+	preview := m.content.Chat.Messages[len(m.content.Chat.Messages)-1]
+	require.Contains(t, preview, "-- run tests with just test")
+	current, err := os.ReadFile("AGENTS.md")
+	require.NoError(t, err)
+	require.Equal(t, original, string(current))
+
+	updated, _ = m.Update(yesNoResponseMsg{answer: true})
+	m = updated.(TUIModel)
+	require.Nil(t, m.pendingAgentsRewrite)
+	current, err = os.ReadFile("AGENTS.md")
+	require.NoError(t, err)
+	require.Equal(t, cleaned, string(current))
+	backup, err := os.ReadFile("AGENTS.md.bak")
+	require.NoError(t, err)
+	require.Equal(t, original, string(backup))
+}
+
+func TestHandleBlameCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	repo, err := gogit.PlainInit(dir, false)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(author, content string) plumbing.Hash {
+		require.NoError(t, os.WriteFile("notes.txt", []byte(content), 0o644))
+		_, err := worktree.Add("notes.txt")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("update notes", &gogit.CommitOptions{
+			Author: &object.Signature{Name: author, Email: author + "@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+	first := commit("Alice", "one\ntwo\nthree\n")
+	second := commit("Bob", "one\n2\nthree\nfour\n")
+
+	file, err := loadBlameForView("notes.txt", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, file.blame, 4)
+	expected := []plumbing.Hash{first, second, first, second}
+	for i, line := range file.blame {
+		require.Equal(t, expected[i], line.Hash, "line %d", i+1)
+	}
+	require.Equal(t, "Alice", file.blame[0].AuthorName)
+	require.Equal(t, "Bob", file.blame[1].AuthorName)
+
+	model := newTestModel(t)
+	model.content.SetSize(100, 40)
+	handleBlameCommand(model, []string{"notes.txt", "2-3"})
+	require.Equal(t, ViewFile, model.content.GetActiveView())
+	view := ansi.Strip(model.content.View())
+	require.Contains(t, view, "notes.txt [blame]")
+	require.Contains(t, view, second.String()[:7]+" Bob")
+	require.Contains(t, view, "2 2")
+	require.Contains(t, view, first.String()[:7]+" Alice")
+	require.Contains(t, view, "3 three")
+	require.NotContains(t, view, "four", "only the range is shown")
+
+	msg := handleBlameCommand(model, []string{"notes.txt", "9"})()
+	require.Contains(t, msg.(showContextMsg).content, "has 4 lines")
+	msg = handleBlameCommand(model, []string{"missing.txt"})()
+	require.Contains(t, msg.(showContextMsg).content, "Cannot blame file")
+}
+
+func TestHandleSystemCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("AGENTS.md", []byte("Always run make lint-marker"), 0o644))
+
+	model := newTestModel(t)
+	model.content.SetSize(120, 400)
+	sess, err := NewSession(fake.NewFakeLLM(nil), &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+
+	handleSystemCommand(model, nil)
+	require.Equal(t, ViewFile, model.content.GetActiveView())
+	view := ansi.Strip(model.content.View())
+	require.Contains(t, view, systemPromptFile+" [RO]")
+	require.Contains(t, view, "**OS:**", "the environment block is rendered")
+	require.Contains(t, view, "Project specific directions from: AGENTS.md")
+	require.Contains(t, view, "Always run make lint-marker")
+
+	// :dump system is the same view
+	model.content.ShowChat()
+	handleDumpCommand(model, []string{"system"})
+	require.Equal(t, ViewFile, model.content.GetActiveView())
+}
+
+func TestHandleEditCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake editor is a shell script")
+	}
+	dir := t.TempDir()
+	editor := filepath.Join(dir, "editor.sh")
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\nsed 's/draft/final/' \"$1\" > \"$1.new\"\nprintf '\\nwith a second line\\n' >> \"$1.new\"\nmv \"$1.new\" \"$1\"\n"), 0o755))
+	t.Setenv("EDITOR", editor)
+
+	model := newTestModel(t)
+	model.prompt.SetValue("a draft prompt")
+	require.NotNil(t, handleEditCommand(model, nil))
+
+	// tea.ExecProcess needs a running program, so run the editor the way it would
+	path, err := writePromptDraft(model.prompt.Value())
+	require.NoError(t, err)
+	updated, _ := model.Update(promptEditedMsg{path: path, err: openInEditor(path).Run()})
+	m := updated.(TUIModel)
+	require.Equal(t, "a final prompt\nwith a second line", m.prompt.Value())
+	require.NoFileExists(t, path)
+
+	// A failing editor leaves the prompt alone
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\necho changed > \"$1\"\nexit 1\n"), 0o755))
+	path, err = writePromptDraft(m.prompt.Value())
+	require.NoError(t, err)
+	updated, _ = m.Update(promptEditedMsg{path: path, err: openInEditor(path).Run()})
+	m = updated.(TUIModel)
+	require.Equal(t, "a final prompt\nwith a second line", m.prompt.Value())
+	require.Contains(t, m.commandLine.toasts[len(m.commandLine.toasts)-1].Message, "Editor failed")
+	require.NoFileExists(t, path)
+}
+
+func TestHandleRedrawCommand(t *testing.T) {
+	model := newTestModel(t)
+
+	// Insert mode: the screen is cleared and the prompt cursor blinks again
+	model.prompt.EnterViInsertMode()
+	batch, ok := handleRedrawCommand(model, nil)().(tea.BatchMsg)
+	require.True(t, ok)
+	require.Len(t, batch, 2, "clear screen and the blinking cursor")
+	require.Equal(t, tea.ClearScreen(), batch[0]())
+	require.Equal(t, cursor.CursorBlink, model.prompt.TextArea.Cursor.Mode())
+
+	// Normal mode: a steady cursor needs no blink command
+	model.prompt.EnterViNormalMode()
+	require.Equal(t, tea.ClearScreen(), handleRedrawCommand(model, nil)())
+	require.Equal(t, cursor.CursorStatic, model.prompt.TextArea.Cursor.Mode())
+
+	// Ctrl+L does the same from any mode
+	_, cmd := model.handleKeyMsg(tea.KeyMsg{Type: tea.KeyCtrlL})
+	require.Equal(t, tea.ClearScreen(), cmd())
+}
+
+func TestHandleWhoamiCommand(t *testing.T) {
+	model := newTestModel(t)
+	cfg := &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-5", APIKey: "sk-ant-whoami-secret"}}
+	sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{Slug: "afittestide/asimi", Branch: "feature/whoami"}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+
+	out := handleWhoamiCommand(model, nil)().(showContextMsg).content
+	require.Contains(t, out, "Provider: anthropic")
+	require.Contains(t, out, "Model: claude-sonnet-4-5")
+	require.Contains(t, out, "Auth: API key")
+	require.Contains(t, out, "Project: afittestide/asimi")
+	require.Contains(t, out, "Branch: feature/whoami")
+	require.Contains(t, out, "Shell runner: ")
+	require.Contains(t, out, fmt.Sprintf("Tools: %d enabled", len(sess.activeToolDefs())))
+	require.NotContains(t, out, "whoami-secret")
+}
+
+func TestHandleCountCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	model := newTestModel(t)
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{LLM: LLMConfig{Provider: "openai", Model: "gpt-4o"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+
+	text := "The quick brown fox jumps over the lazy dog"
+	tokens := sess.countTokens(text)
+	require.Positive(t, tokens)
+	want := fmt.Sprintf("The text is %d tokens, ", tokens)
+	require.Contains(t, handleCountCommand(model, strings.Fields(text))().(showContextMsg).content, want)
+	require.Contains(t, handleCountCommand(model, strings.Fields(text))().(showContextMsg).content, want, "the count is stable")
+
+	require.NoError(t, os.WriteFile("fox.txt", []byte(text), 0o644))
+	out := handleCountCommand(model, []string{"@fox.txt"})().(showContextMsg).content
+	require.Contains(t, out, fmt.Sprintf("fox.txt is %d tokens", tokens))
+	require.Contains(t, out, "of gpt-4o's")
+
+	// The tokens are priced at the model's input price
+	big := strings.Repeat("lorem ipsum dolor sit amet ", 20_000)
+	tokens = sess.countTokens(big)
+	out = handleCountCommand(model, []string{big})().(showContextMsg).content
+	require.Contains(t, out, fmt.Sprintf("about %s as input", formatCost(float64(tokens)*2.5/1_000_000)))
+	sess.config.Model = "unpriced-model"
+	require.NotContains(t, handleCountCommand(model, strings.Fields(text))().(showContextMsg).content, "as input")
+
+	require.Contains(t, handleCountCommand(model, []string{"@missing.txt"})().(showContextMsg).content, "Cannot count missing.txt")
+	require.Contains(t, handleCountCommand(model, nil)().(showContextMsg).content, "Usage")
+}
+
+func TestHandleProfileCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	model := newTestModel(t)
+	model.config.Profiles = map[string]ProfileConfig{
+		"a": {Provider: "fake", Model: "model-a", APIKey: "key-a"},
+		"b": {Provider: "fake", Model: "model-b", APIKey: "key-b", Headers: map[string]string{"X-Team": "b"}},
+	}
+
+	out := handleProfileCommand(model, nil)().(showContextMsg).content
+	require.Contains(t, out, "Available profiles: a, b")
+
+	require.Contains(t, handleProfileCommand(model, []string{"a"})().(showContextMsg).content, `Profile "a": fake/model-a`)
+	first := model.session
+
+	require.Contains(t, handleProfileCommand(model, []string{"b"})().(showContextMsg).content, `Profile "b": fake/model-b`)
+	require.Equal(t, "fake", model.config.LLM.Provider)
+	require.Equal(t, "model-b", model.config.LLM.Model)
+	require.Equal(t, "key-b", model.config.LLM.APIKey)
+	require.Equal(t, "b", model.config.LLM.Headers["X-Team"])
+	require.Equal(t, "b", model.config.Profile)
+	require.NotSame(t, first, model.session, "the session is started again")
+	require.Contains(t, ansi.Strip(model.status.View()), "b:")
+	require.Contains(t, handleProfileCommand(model, nil)().(showContextMsg).content, "Current profile: b")
+
+	// An unknown profile leaves the config as it is
+	require.Contains(t, handleProfileCommand(model, []string{"c"})().(showContextMsg).content, `unknown profile "c"`)
+	require.Equal(t, "model-b", model.config.LLM.Model)
+}
+
+func TestHandleCompactCommand_ReportsToolHistory(t *testing.T) {
+	model := newTestModel(t)
+	sess, err := NewSession(&mockLLMNoTools{}, mockConfig(), RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+	call := func(id, name, args string) llms.ToolCall {
+		return llms.ToolCall{ID: id, Type: "function", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}
+	}
+	sess.Messages = append(sess.Messages,
+		llms.TextParts(llms.ChatMessageTypeHuman, "fix the build"),
+		llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{
+			call("1", "read_file", `{"path":"main.go"}`),
+			call("2", "write_file", `{"path":"main.go","content":"x"}`),
+			call("3", "replace_text", `{"path":"util.go","old_text":"a","new_text":"b"}`),
+			call("4", "replace_text", `{"path":"main.go","old_text":"x","new_text":"y"}`),
+		}},
+		llms.TextParts(llms.ChatMessageTypeAI, "done"),
+	)
+
+	toolCalls, files := sess.CompactionLoss()
+	require.Equal(t, 4, toolCalls)
+	require.Equal(t, 2, files)
+
+	// With llm.confirm_compact the count is shown before anything is dropped
+	model.config.LLM.ConfirmCompact = true
+	handleCompactCommand(model, nil)
+	require.True(t, model.pendingCompact)
+	require.True(t, model.commandLine.IsInYesNoMode())
+	require.Contains(t, model.commandLine.yesNoQuestion, "4 tool calls and the changes to 2 files are collapsed")
+
+	updated, cmd := model.Update(yesNoResponseMsg{answer: false})
+	*model = updated.(TUIModel)
+	require.Nil(t, cmd)
+	require.False(t, model.pendingCompact)
+	require.Len(t, sess.Messages, 4, "declining leaves the history alone")
+
+	model.commandLine.ExitYesNoMode()
+	model.pendingCompact = true
+	_, cmd = model.Update(yesNoResponseMsg{answer: true})
+	require.IsType(t, compactConversationMsg{}, cmd())
+}
+
+func TestHandleCompactCommand_Diff(t *testing.T) {
+	t.Chdir(t.TempDir())
+	model := newTestModel(t)
+	sess, err := NewSession(&compactingLLM{}, mockConfig(), RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+	sess.Messages = append(sess.Messages,
+		llms.TextParts(llms.ChatMessageTypeHuman, "add a flag"),
+		llms.TextParts(llms.ChatMessageTypeAI, strings.Repeat("added --verbose to the command line parser. ", 200)),
+		llms.TextParts(llms.ChatMessageTypeHuman, "now document it"),
+		llms.TextParts(llms.ChatMessageTypeAI, "documented it in the README"),
+		llms.TextParts(llms.ChatMessageTypeHuman, "and test it"),
+	)
+	sess.updateTokenCounts()
+	original := slices.Clone(sess.Messages)
+	before := sess.CountMessagesTokens()
+
+	require.Equal(t, compactConversationMsg{diff: true}, handleCompactCommand(model, []string{"--diff"})())
+	preview, err := sess.PreviewCompaction(context.Background(), compactPrompt)
+	require.NoError(t, err)
+	require.Equal(t, original, sess.Messages, "previewing leaves the history alone")
+	require.Equal(t, before, preview.BeforeTokens)
+	require.Equal(t, 5, preview.Messages)
+	require.Equal(t, 3, preview.Turns)
+	require.Less(t, preview.AfterTokens, preview.BeforeTokens)
+
+	updated, _ := model.Update(compactPreviewMsg{preview: preview})
+	*model = updated.(TUIModel)
+	require.True(t, model.commandLine.IsInYesNoMode())
+	view := model.content.Chat.Messages[len(model.content.Chat.Messages)-1]
+	require.Regexp(t, `Tokens\s+`+regexp.QuoteMeta(formatTokenCount(before))+`\s+`+regexp.QuoteMeta(formatTokenCount(preview.AfterTokens)), view)
+	require.Regexp(t, `Messages\s+5\s+2`, view)
+	require.Contains(t, view, "3 turns in 5 messages are discarded")
+
+	updated, cmd := model.Update(yesNoResponseMsg{answer: true})
+	*model = updated.(TUIModel)
+	require.IsType(t, compactCompleteMsg{}, cmd())
+	require.Len(t, sess.Messages, 3, "accepting applies the summary")
+	require.Nil(t, model.pendingCompactDiff)
+
+	require.Contains(t, handleCompactCommand(model, []string{"--bogus"})().(showContextMsg).content, "Usage: :compact [--diff]")
+}
+
+func TestHandleKeysCommand(t *testing.T) {
+	model := newTestModel(t)
+	model.prompt.viInsertKeyMap.Paste = key.NewBinding(key.WithKeys("ctrl+y"))
+
+	out := handleKeysCommand(model, nil)().(showContextMsg).content
+	insert := out[strings.Index(out, "## Insert"):strings.Index(out, "## Normal")]
+	normal := out[strings.Index(out, "## Normal"):strings.Index(out, "## Command line")]
+	require.Regexp(t, `ctrl\+y\s+Paste`, insert, "a changed binding shows its key")
+	require.NotContains(t, insert, "ctrl+v")
+	require.Regexp(t, `ctrl\+w\s+Delete word before`, insert)
+	require.Regexp(t, `p\s+Paste`, normal)
+	require.Regexp(t, `h, left\s+Move left`, normal)
+	require.NotContains(t, normal, "Uppercase word", "disabled bindings are left out")
+	require.Contains(t, out, "## Scroll")
+	require.Regexp(t, `ctrl\+l\s+Redraw the screen`, out)
+}
+
+func TestHandleEndpointCommand(t *testing.T) {
+	gokeyring.MockInit()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("OPENAI_API_KEY", "")
+	cfgPath := filepath.Join(home, ".config", "asimi", "asimi.conf")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer sk-local" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"qwen-coder"},{"id":"llama-3"}]}`)
+	}))
+	defer server.Close()
+
+	// submit types the inputs in the modal, following the commands it returns to the end
+	submit := func(model *TUIModel, inputs ...string) *TUIModel {
+		require.Nil(t, handleEndpointCommand(model, nil))
+		require.NotNil(t, model.endpointModal)
+		var cmd tea.Cmd
+		for _, input := range inputs {
+			var next tea.Model
+			next, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(input)})
+			updated := next.(TUIModel)
+			next, cmd = updated.Update(tea.KeyMsg{Type: tea.KeyEnter})
+			updated = next.(TUIModel)
+			model = &updated
+		}
+		for cmd != nil {
+			msg := cmd()
+			if _, ok := msg.(endpointEnteredMsg); !ok {
+				if _, ok := msg.(endpointCheckedMsg); !ok {
+					break
+				}
+			}
+			next, nextCmd := model.Update(msg)
+			updated := next.(TUIModel)
+			model, cmd = &updated, nextCmd
+		}
+		require.Nil(t, model.endpointModal)
+		return model
+	}
+	lastToast := func(model *TUIModel) string {
+		toasts := model.commandLine.toasts
+		require.NotEmpty(t, toasts)
+		return toasts[len(toasts)-1].Message
+	}
+
+	require.NoError(t, SaveAPIKeyToKeyring("openai", "sk-openai"))
+
+	t.Run("a rejected key isn't saved", func(t *testing.T) {
+		model := newTestModel(t)
+		model = submit(model, server.URL, "qwen-coder", "sk-wrong")
+		require.Contains(t, lastToast(model), "status 401")
+		require.NoFileExists(t, cfgPath)
+		require.Equal(t, "fake", model.session.Provider)
+	})
+
+	t.Run("a model the endpoint doesn't serve isn't saved", func(t *testing.T) {
+		model := newTestModel(t)
+		model = submit(model, server.URL, "qwen-coderr", "sk-local")
+		require.Contains(t, lastToast(model), `did you mean "qwen-coder"`)
+		require.NoFileExists(t, cfgPath)
+	})
+
+	t.Run("a working endpoint is saved and used", func(t *testing.T) {
+		model := newTestModel(t)
+		model = submit(model, server.URL+"/", "qwen-coder", "sk-local")
+		require.Contains(t, lastToast(model), "Using qwen-coder at "+server.URL+"/v1")
+
+		data, err := os.ReadFile(cfgPath)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `provider = "openai"`)
+		require.Contains(t, string(data), `model = "qwen-coder"`)
+		require.Contains(t, string(data), `base_url = "`+server.URL+`/v1"`)
+		require.NotContains(t, string(data), "sk-local")
+		key, err := GetAPIKeyFromKeyring(endpointKeyringName(server.URL + "/v1"))
+		require.NoError(t, err)
+		require.Equal(t, "sk-local", key)
+		key, err = GetAPIKeyFromKeyring("openai")
+		require.NoError(t, err)
+		require.Equal(t, "sk-openai", key, "the OpenAI key is left alone")
+
+		require.Equal(t, "openai", model.session.Provider)
+		require.Equal(t, "qwen-coder", model.session.Model)
+		require.Equal(t, server.URL+"/v1", model.config.LLM.BaseURL)
+	})
+}
+
+func TestGetModelClientEndpointKey(t *testing.T) {
+	gokeyring.MockInit()
+	t.Chdir(t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "sk-env")
+	require.NoError(t, SaveAPIKeyToKeyring("openai", "sk-openai"))
+
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"id":"1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	require.NoError(t, os.MkdirAll(".agents", 0o755))
+	require.NoError(t, os.WriteFile(".agents/asimi.conf", []byte(fmt.Sprintf("[llm]\nprovider = \"openai\"\nmodel = \"qwen-coder\"\nbase_url = %q\n", server.URL+"/v1")), 0o644))
+	call := func() string {
+		config, err := LoadConfig()
+		require.NoError(t, err)
+		require.Empty(t, config.LLM.APIKey, "OPENAI_API_KEY is not read for an endpoint")
+		llm, err := getModelClient(config)
+		require.NoError(t, err)
+		_, err = llm.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")})
+		require.NoError(t, err)
+		return auth
+	}
+
+	// Neither the OpenAI key of the keyring nor the environment goes to the endpoint
+	require.Equal(t, "Bearer none", call())
+	require.NoError(t, SaveAPIKeyToKeyring(endpointKeyringName(server.URL+"/v1"), "sk-endpoint"))
+	require.Equal(t, "Bearer sk-endpoint", call())
+}
+
+func TestNormalizeOpenAIBaseURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"http://localhost:8080", "http://localhost:8080/v1"},
+		{"localhost:1234/", "http://localhost:1234/v1"},
+		{"api.example.com/openai/v1/", "https://api.example.com/openai/v1"},
+		{"https://api.example.com/v1/chat/completions", "https://api.example.com/v1"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeOpenAIBaseURL(tt.input)
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.expected, got)
+	}
+	_, err := normalizeOpenAIBaseURL("ftp://example.com")
+	require.Error(t, err)
+}
+
+func TestHandleToolCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("remember the milk\n"), 0o644))
+	model := newTestModel(t)
+
+	msg := handleToolCommand(model, []string{`read_file {"path":"notes.txt"}`})()
+	content := msg.(showContextMsg).content
+	require.Contains(t, content, `read_file {"path":"notes.txt"}`)
+	require.Contains(t, content, "remember the milk")
+
+	msg = handleToolCommand(model, []string{`read_file {"offset":"1"}`})()
+	require.Contains(t, msg.(showContextMsg).content, `invalid arguments: missing required field "path"`)
+
+	msg = handleToolCommand(model, []string{"read_file notes.txt"})()
+	require.Contains(t, msg.(showContextMsg).content, "arguments must be a JSON object")
+
+	msg = handleToolCommand(model, []string{"no_such_tool {}"})()
+	require.Contains(t, msg.(showContextMsg).content, `unknown tool "no_such_tool"`)
+
+	// Spaces inside JSON strings reach the tool unchanged
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "two  spaces.txt"), []byte("found it\n"), 0o644))
+	cmd, _ := NewCommandRegistry().GetCommand("tool")
+	msg = cmd.Handler(model, cmd.Args(`:tool read_file {"path": "two  spaces.txt"}`))()
+	require.Contains(t, msg.(showContextMsg).content, "found it")
+
+	msg = handleToolCommand(model, []string{"read_file"})()
+	require.Contains(t, msg.(showContextMsg).content, "Usage: :tool")
+}
+
+func TestHandleErrorsCommand(t *testing.T) {
+	model := newTestModel(t)
+	model.content.SetSize(80, 10)
+	chat := model.content.Chat
+
+	msg := handleErrorsCommand(model, nil)()
+	require.Contains(t, msg.(showContextMsg).content, "No errors in this session")
+
+	for i := range 20 {
+		chat.AddMessage(fmt.Sprintf("message %d", i))
+	}
+	call := &ToolCall{ID: "call-1", Tool: &mockTool{name: "read_file"}, Input: `{"path":"missing.go"}`}
+	updated, _ := model.Update(ToolCallScheduledMsg{Call: call})
+	*model = updated.(TUIModel)
+	chat = model.content.Chat
+	toolMessage, ok := chat.GetToolCallMessageIndex(call.ID)
+	require.True(t, ok)
+	call.Error = errors.New("file not found")
+	updated, _ = model.Update(ToolCallErrorMsg{Call: call})
+	*model = updated.(TUIModel)
+	for i := 20; i < 40; i++ {
+		model.content.Chat.AddMessage(fmt.Sprintf("message %d", i))
+	}
+	updated, _ = model.Update(errMsg{err: errors.New("model unavailable")})
+	*model = updated.(TUIModel)
+	chat = model.content.Chat
+
+	list := handleErrorsCommand(model, nil)().(showContextMsg).content
+	require.Contains(t, list, "2 errors")
+	require.Regexp(t, `1\. \[\d\d:\d\d:\d\d\] TOOL_ERROR: read_file`, list)
+	require.Regexp(t, `2\. \[\d\d:\d\d:\d\d\] ERROR: model unavailable`, list)
+
+	require.True(t, chat.Viewport.AtBottom())
+	require.Nil(t, handleErrorsCommand(model, []string{"1"}))
+	require.Equal(t, chat.messageStarts[toolMessage], chat.Viewport.YOffset, "the chat scrolls to the failed tool call")
+	require.Contains(t, model.commandLine.toasts[len(model.commandLine.toasts)-1].Message, "Error 1 of 2")
+
+	require.Contains(t, handleErrorsCommand(model, []string{"3"})().(showContextMsg).content, "No error 3")
+	require.Contains(t, handleErrorsCommand(model, []string{"x"})().(showContextMsg).content, "Usage")
+}
+
+func TestHandleWrapCommand(t *testing.T) {
+	model := newTestModel(t)
+	model.content.SetSize(120, 60)
+	chat := model.content.Chat
+	long := strings.Repeat("wrapping keeps screenshots consistent across terminals ", 8)
+	chat.AddMessage(systemPrefix + long)
+	chat.AddMessage("Asimi: " + long)
+
+	// widest is the widest line of the chat, its padding trimmed
+	widest := func() int {
+		widest := 0
+		for _, line := range strings.Split(chat.Viewport.View(), "\n") {
+			widest = max(widest, ansi.StringWidth(strings.TrimRight(ansi.Strip(line), " ")))
+		}
+		return widest
+	}
+	require.Greater(t, widest(), 60)
+
+	handleWrapCommand(model, []string{"40"})
+	require.Equal(t, 40, chat.WrapWidth)
+	require.LessOrEqual(t, widest(), 42, "40 columns and the padding")
+	for _, width := range []int{200, 60} {
+		model.content.SetSize(width, 60)
+		require.LessOrEqual(t, widest(), 42, "the terminal width doesn't matter")
+		require.Greater(t, widest(), 30)
+	}
+
+	model.content.SetSize(120, 60)
+	handleWrapCommand(model, []string{"auto"})
+	require.Zero(t, chat.WrapWidth)
+	require.Greater(t, widest(), 60)
+
+	require.Contains(t, handleWrapCommand(model, []string{"5"})().(showContextMsg).content, "Usage")
+	require.Contains(t, handleWrapCommand(model, nil)().(showContextMsg).content, "terminal width")
+}
+
+func TestHandleContinueCommand(t *testing.T) {
+	model := NewTUIModel(mockConfig(), nil, nil, nil, nil, nil)
+	model.persistentPromptHistory = nil
+	model.initHistory()
+	llm := &gatedEchoLLM{gate: make(chan struct{})}
+	close(llm.gate)
+	notes := make(chan any, 100)
+	sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(msg any) { notes <- msg })
+	require.NoError(t, err)
+	model.SetSession(sess)
+	chat := model.content.Chat
+	chat.AddMessage("You: list the steps")
+	chat.AddMessage("Asimi: 1. build 2. ")
+	chat.FinalizeLastAIMessage()
+
+	startMsg := handleContinueCommand(model, nil)()
+	require.Equal(t, startConversationMsg{prompt: continuePrompt, continueLast: true}, startMsg)
+	updated, _ := model.Update(startMsg)
+	*model = updated.(TUIModel)
+	for done := false; !done; {
+		select {
+		case msg := <-notes:
+			updated, _ := model.Update(msg)
+			*model = updated.(TUIModel)
+			_, done = msg.(streamCompleteMsg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the continuation never completed")
+		}
+	}
+
+	unlock := sess.guard.lockMessages()
+	last := sess.Messages[len(sess.Messages)-2]
+	unlock()
+	require.Equal(t, llms.ChatMessageTypeHuman, last.Role)
+	require.Equal(t, continuePrompt, last.Parts[0].(llms.TextContent).Text)
+	require.Equal(t, []string{"You: list the steps", "Asimi:SUCCESS: 1. build 2. re: Continue."}, chat.Messages[len(chat.Messages)-2:])
+
+	require.Contains(t, handleContinueCommand(&TUIModel{}, nil)().(showContextMsg).content, "No active session")
+}
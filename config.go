@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cmp"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -30,14 +34,160 @@ type oauthProviderConfig struct {
 
 // Config represents the application configuration structure
 type Config struct {
-	Storage    StorageConfig    `koanf:"storage"`
-	Logging    LoggingConfig    `koanf:"logging"`
-	UI         UIConfig         `koanf:"ui"`
-	LLM        LLMConfig        `koanf:"llm"`
-	History    HistoryConfig    `koanf:"history"`
-	Session    SessionConfig    `koanf:"session"`
-	Container  ContainerConfig  `koanf:"container"`
-	RunInShell RunInShellConfig `koanf:"run_in_shell"`
+	Storage    StorageConfig            `koanf:"storage"`
+	Logging    LoggingConfig            `koanf:"logging"`
+	UI         UIConfig                 `koanf:"ui"`
+	LLM        LLMConfig                `koanf:"llm"`
+	History    HistoryConfig            `koanf:"history"`
+	Session    SessionConfig            `koanf:"session"`
+	Container  ContainerConfig          `koanf:"container"`
+	RunInShell RunInShellConfig         `koanf:"run_in_shell"`
+	Tools      ToolsConfig              `koanf:"tools"`
+	Security   SecurityConfig           `koanf:"security"`
+	Git        GitConfig                `koanf:"git"`
+	Personas   map[string]PersonaConfig `koanf:"personas"`
+	Profiles   map[string]ProfileConfig `koanf:"profiles"`
+	// Permissions is the policy of each tool the model calls, allow, ask or deny, see
+	// ToolPermission
+	Permissions map[string]string `koanf:"permissions"`
+	// Profile is the profile in use, set with :profile or --profile
+	Profile string `koanf:"-"`
+	// Sources is where the keys set since the defaults got their value, one of the
+	// ConfigSource values, shown by :config
+	Sources map[string]string `koanf:"-"`
+}
+
+// Sources of a config value, from the lowest precedence to the highest
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceUser    = "user"
+	ConfigSourceProject = "project"
+	ConfigSourceEnv     = "env"
+	ConfigSourceFlag    = "flag"
+	ConfigSourceProfile = "profile"
+)
+
+// Tool call policies of [permissions]
+const (
+	PermissionAllow = "allow"
+	PermissionAsk   = "ask"
+	PermissionDeny  = "deny"
+)
+
+// ToolPermission returns the [permissions] policy of a tool, allow when it has none. A policy
+// it doesn't know asks, to fail safe on a typo.
+func (c *Config) ToolPermission(tool string) string {
+	policy, ok := c.Permissions[tool]
+	if !ok {
+		return PermissionAllow
+	}
+	switch policy = strings.ToLower(strings.TrimSpace(policy)); policy {
+	case PermissionAllow, PermissionAsk, PermissionDeny:
+		return policy
+	}
+	slog.Warn("unknown tool permission, asking", "tool", tool, "permission", policy)
+	return PermissionAsk
+}
+
+// setSource records where the value of key came from, for the keys under it too
+func (c *Config) setSource(key, source string) {
+	if c.Sources == nil {
+		c.Sources = make(map[string]string)
+	}
+	for k := range c.Sources {
+		if strings.HasPrefix(k, key+".") {
+			delete(c.Sources, k)
+		}
+	}
+	c.Sources[key] = source
+}
+
+// Source returns where the value of key came from, set with it or with a table above it,
+// default when nothing set it
+func (c *Config) Source(key string) string {
+	for {
+		if source, ok := c.Sources[key]; ok {
+			return source
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return ConfigSourceDefault
+		}
+		key = key[:i]
+	}
+}
+
+// configSecretKeys are the keys :config masks, along with every LLM header
+var configSecretKeys = []string{"api_key", "auth_token", "refresh_token"}
+
+// renderConfig lists the effective config for :config, one key = value a line with where the
+// value came from, the keys under filter only when it's set
+func renderConfig(c *Config, filter string) string {
+	var b strings.Builder
+	visitConfig("", reflect.ValueOf(*c), func(key string, value any) {
+		if filter != "" && key != filter && !strings.HasPrefix(key, filter+".") {
+			return
+		}
+		fmt.Fprintf(&b, "%s = %s (%s)\n", key, formatConfigValue(key, value), c.Source(key))
+	})
+	return b.String()
+}
+
+// visitConfig calls visit with the koanf key and value of each leaf of v, in the order of the
+// struct fields and of the sorted map keys
+func visitConfig(prefix string, v reflect.Value, visit func(key string, value any)) {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+	switch {
+	case v.Kind() == reflect.Struct:
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			name := field.Tag.Get("koanf")
+			if name == "" || name == "-" || !field.IsExported() {
+				continue
+			}
+			visitConfig(join(name), v.Field(i), visit)
+		}
+	case v.Kind() == reflect.Map && v.Len() > 0:
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.String(), b.String()) })
+		for _, key := range keys {
+			visitConfig(join(key.String()), v.MapIndex(key), visit)
+		}
+	default:
+		visit(prefix, v.Interface())
+	}
+}
+
+// formatConfigValue formats a config value like TOML would, masking the credentials
+func formatConfigValue(key string, value any) string {
+	name := key[strings.LastIndex(key, ".")+1:]
+	secret := slices.Contains(configSecretKeys, name) || strings.HasPrefix(key, "llm.headers.") ||
+		(strings.HasPrefix(key, "profiles.") && strings.Contains(key, ".headers."))
+	if s, ok := value.(string); ok && secret && s != "" {
+		return "[REDACTED]"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// loadConfigLayer loads one config source into k, recording the keys it sets in sources
+func loadConfigLayer(k *koanf.Koanf, sources map[string]string, source string, provider koanf.Provider, parser koanf.Parser) error {
+	layer := koanf.New(".")
+	if err := layer.Load(provider, parser); err != nil {
+		return err
+	}
+	for _, key := range layer.Keys() {
+		sources[key] = source
+	}
+	return k.Merge(layer)
 }
 
 // StorageConfig holds storage configuration
@@ -63,21 +213,52 @@ type LLMConfig struct {
 	AuthToken                  string `koanf:"auth_token"`
 	RefreshToken               string `koanf:"refresh_token"`
 	ExperimentalModels         bool   `koanf:"experimental_models"`
+	// Headers are extra HTTP headers sent with every LLM request (e.g. X-Org-Id for a gateway)
+	Headers map[string]string `koanf:"headers"`
+	// ToolMode is "native" to use the provider's tools API or "prompted" for endpoints without one
+	ToolMode string `koanf:"tool_mode"`
+	// AutoContinueOnMaxTokens asks the model to continue a reply cut off by the output token limit
+	AutoContinueOnMaxTokens bool `koanf:"auto_continue_on_max_tokens"`
+	// FallbackModels are tried in order when the model is overloaded or unavailable,
+	// each a model name of the same provider or `provider/model`
+	FallbackModels []string `koanf:"fallback_models"`
+	// BriefLines is the reply length :brief asks the model to keep under, 10 lines when unset
+	BriefLines int `koanf:"brief_lines"`
+	// ThinkingBudget is the extended thinking token budget on models that support it, 0 turns
+	// thinking off. max_thinking_tokens is its older name, used when it's unset.
+	ThinkingBudget int `koanf:"thinking_budget"`
+	// MaxConcurrentRequests caps the model requests in flight at once, 2 when unset
+	MaxConcurrentRequests int `koanf:"max_concurrent_requests"`
+	// ConfirmCompact asks before :compact collapses tool calls into the summary
+	ConfirmCompact bool `koanf:"confirm_compact"`
+	// IncludeHistoryPartial carries the last compaction summary in the system prompt
+	IncludeHistoryPartial bool `koanf:"include_history_partial"`
 }
 
 // HistoryConfig holds persistent session history configuration
 type HistoryConfig struct {
-	Enabled      bool `koanf:"enabled"`
-	MaxSessions  int  `koanf:"max_sessions"`
-	MaxAgeDays   int  `koanf:"max_age_days"`
-	ListLimit    int  `koanf:"list_limit"`
-	AutoSave     bool `koanf:"auto_save"`
-	SaveInterval int  `koanf:"save_interval"`
+	Enabled      bool   `koanf:"enabled"`
+	MaxSessions  int    `koanf:"max_sessions"`
+	MaxAgeDays   int    `koanf:"max_age_days"`
+	ListLimit    int    `koanf:"list_limit"`
+	AutoSave     bool   `koanf:"auto_save"`
+	SaveInterval int    `koanf:"save_interval"`
+	Dedup        string `koanf:"dedup"` // repeated prompts and commands: none, consecutive or global
 }
 
 // UIConfig holds UI-specific configuration
 type UIConfig struct {
-	MarkdownEnabled bool `koanf:"markdown_enabled"`
+	MarkdownEnabled  bool   `koanf:"markdown_enabled"`
+	Notify           string `koanf:"notify"`             // off, bell or osc777
+	QuietHours       string `koanf:"quiet_hours"`        // e.g. "22:00-07:00"
+	QuietAutoCompact bool   `koanf:"quiet_auto_compact"` // status bar indicator instead of chat messages
+	FoldLinesOver    int    `koanf:"fold_lines_over"`    // fold longer chat messages, 0 disables
+	StartMode        string `koanf:"start_mode"`         // vi mode of the prompt at start: insert or normal
+	WrapMode         string `koanf:"wrap_mode"`          // long lines in the chat and raw views: wrap or scroll
+	ShowHidden       bool   `koanf:"show_hidden"`        // dotfiles in @ completion and the file tools, .git is always left out
+	ContextGauge     bool   `koanf:"context_gauge"`      // context usage as a colored bar in the status bar, also :gauge
+	EmptyEnterAction string `koanf:"empty_enter_action"` // Enter on an empty prompt: noop, repeat or continue
+	Streaming        bool   `koanf:"streaming"`          // stream replies as they come, off shows each whole once complete
 }
 
 // defaultConfig returns the configuration populated with sensible defaults.
@@ -96,9 +277,13 @@ func defaultConfig() Config {
 			ListLimit:    0,
 			AutoSave:     false,
 			SaveInterval: 300,
+			Dedup:        "consecutive",
 		},
 		UI: UIConfig{
 			MarkdownEnabled: true,
+			Notify:          "off",
+			ShowHidden:      true,
+			Streaming:       true,
 		},
 		Session: SessionConfig{
 			Enabled:      true,
@@ -124,6 +309,19 @@ type SessionConfig struct {
 	AutoSave     bool   `koanf:"auto_save"`
 	SaveInterval int    `koanf:"save_interval"`
 	AgentsFile   string `koanf:"agents_file"` // Project context file name (default: AGENTS.md, can be CLAUDE.md)
+	// LearningTarget is where # notes go: a file, "memory" for the project memory, the agents file when unset
+	LearningTarget string `koanf:"learning_target"`
+	// MaxStorageBytes caps the total size of the stored sessions, the oldest are removed first
+	MaxStorageBytes int64  `koanf:"max_storage_bytes"`
+	Persona         string `koanf:"persona"` // Persona applied to new sessions, see [personas.<name>]
+
+	AutoAttachReferences bool `koanf:"auto_attach_references"` // Attach files the agents file references as @path
+	// PromptPrefix and PromptSuffix frame each prompt sent to the model, e.g. "Follow our style
+	// guide" in a project's .agents/asimi.conf. The system prompt is left alone
+	PromptPrefix string `koanf:"prompt_prefix"`
+	PromptSuffix string `koanf:"prompt_suffix"`
+	// PreserveIncompleteToolCalls saves an interrupted tool call as a note rather than dropping it
+	PreserveIncompleteToolCalls bool `koanf:"preserve_incomplete_tool_calls"`
 }
 
 // ContainerMount represents a mount point for the container
@@ -152,6 +350,83 @@ type RunInShellConfig struct {
 	ImageName         string `koanf:"image_name"` // Container image name (default: asimi-sandbox-<project>:latest)
 }
 
+// ToolsConfig holds configuration for running tools
+type ToolsConfig struct {
+	// ConfirmShell asks for confirmation before running `:!command` from the command line
+	ConfirmShell bool `koanf:"confirm_shell"`
+	// MaxRetries is how many times a tool listed in RetryTools is retried on a transient error
+	MaxRetries int `koanf:"max_retries"`
+	// RetryTools lists the tools that opt into retries, e.g. ["run_in_shell"]
+	RetryTools []string `koanf:"retry_tools"`
+	// MaxContextFiles caps how many files can be loaded into the context, 0 for no limit
+	MaxContextFiles int `koanf:"max_context_files"`
+	// MaxContextBytes caps the total size of the context files, 0 for no limit
+	MaxContextBytes int `koanf:"max_context_bytes"`
+	// WriteAllowlist limits the files write_file and replace_text may modify to those matching
+	// one of these globs, relative to the project root. Empty allows all the project's files
+	WriteAllowlist []string `koanf:"write_allowlist"`
+	// StickyContext keeps the context files across turns until :context clear,
+	// instead of sending them with the next prompt only
+	StickyContext bool `koanf:"sticky_context"`
+	// FileEncoding is the encoding of files that are neither UTF-8 nor start with a byte order
+	// mark, e.g. latin-1. They are transcoded to UTF-8 for the model and back when written
+	FileEncoding string `koanf:"file_encoding"`
+	// OutputBufferBytes caps the output kept from each stream of a streaming tool call, like
+	// run_in_shell, dropping the middle with a marker past it. 0 for no limit
+	OutputBufferBytes int `koanf:"output_buffer_bytes"`
+	// WebFetchAllowlist limits the hosts web_fetch may download from, e.g. ["go.dev",
+	// "*.github.com"]. Empty allows all hosts
+	WebFetchAllowlist []string `koanf:"web_fetch_allowlist"`
+	// SubagentTools are the tools of the subagents dispatch_agent starts, read_file,
+	// read_many_files, list_files and web_fetch when empty
+	SubagentTools []string `koanf:"subagent_tools"`
+	// WebFetchMaxBytes caps the size of a page web_fetch downloads, 512KiB when 0
+	WebFetchMaxBytes int `koanf:"web_fetch_max_bytes"`
+}
+
+// PersonaConfig is a named conversation template selected with :persona or --persona
+type PersonaConfig struct {
+	// Instruction is appended to the system prompt
+	Instruction string `koanf:"instruction"`
+	// Tools limits the tools available to the model, empty means all tools
+	Tools []string `koanf:"tools"`
+}
+
+// ProfileConfig is a named provider and model selected with :profile or --profile
+type ProfileConfig struct {
+	Provider string `koanf:"provider"`
+	Model    string `koanf:"model"`
+	// APIKey is optional, by default the provider's key is read from the environment or keyring
+	APIKey  string            `koanf:"api_key"`
+	BaseURL string            `koanf:"base_url"`
+	Headers map[string]string `koanf:"headers"`
+}
+
+// SecurityConfig holds configuration for protecting sensitive data
+type SecurityConfig struct {
+	// RedactPatterns is a list of extra regex patterns masked in tool results and logs,
+	// on top of the built-in API key and bearer token patterns
+	RedactPatterns []string `koanf:"redact_patterns"`
+	// RedactPaths replaces the working and home directories with . and ~ in exports, so
+	// shared sessions don't give away the user name and directory layout
+	RedactPaths bool `koanf:"redact_paths"`
+	// HashPaths also hashes each segment of the other absolute paths in exports, with RedactPaths
+	HashPaths bool `koanf:"hash_paths"`
+}
+
+// GitConfig holds configuration for how asimi works with the project's repository
+type GitConfig struct {
+	// AutoCommit commits the files edited by the model at the end of each turn.
+	// Skipped on main and master, where commits aren't squashed like on a worktree.
+	AutoCommit bool `koanf:"auto_commit"`
+	// ManageGitignore lists the files asimi writes into the project in its .gitignore
+	ManageGitignore bool `koanf:"manage_gitignore"`
+	// Checkpoints snapshots the working tree before each tool call that may change files,
+	// for :restore to revert to. Off by default as each snapshot hashes the changed and
+	// untracked files before the call runs
+	Checkpoints bool `koanf:"checkpoints"`
+}
+
 // TODO: find a better way and remove this global
 // ConfigCreated tracks whether the config file was created on this run
 var ConfigCreated bool
@@ -200,20 +475,21 @@ func EnsureUserConfigExists() (bool, error) {
 func LoadConfig() (*Config, error) {
 	// Create a new koanf instance
 	k := koanf.New(".")
+	sources := make(map[string]string)
 
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		slog.Error("Failed to get user home directory", "error", err)
 	} else {
 		userConfigPath := filepath.Join(homeDir, ".config", "asimi", "asimi.conf")
-		if err := k.Load(file.Provider(userConfigPath), koanftoml.Parser()); err != nil {
+		if err := loadConfigLayer(k, sources, ConfigSourceUser, file.Provider(userConfigPath), koanftoml.Parser()); err != nil {
 			log.Printf("Failed to load user config from %s: %v", userConfigPath, err)
 		}
 	}
 
 	projectConfigPath := filepath.Join(".agents", "asimi.conf")
 	if _, err := os.Stat(projectConfigPath); err == nil {
-		if err := k.Load(file.Provider(projectConfigPath), koanftoml.Parser()); err != nil {
+		if err := loadConfigLayer(k, sources, ConfigSourceProject, file.Provider(projectConfigPath), koanftoml.Parser()); err != nil {
 			log.Printf("Failed to load project config from %s: %v", projectConfigPath, err)
 		}
 	} else if !os.IsNotExist(err) {
@@ -223,7 +499,7 @@ func LoadConfig() (*Config, error) {
 	// 3. Load environment variables
 	// Environment variables with prefix "ASIMI_" will override config values
 	// e.g., ASIMI_SERVER_PORT=8080 will override the server port
-	if err := k.Load(koanfenv.Provider(".", koanfenv.Opt{
+	if err := loadConfigLayer(k, sources, ConfigSourceEnv, koanfenv.Provider(".", koanfenv.Opt{
 		Prefix: "ASIMI_",
 		TransformFunc: func(key, value string) (string, any) {
 			// Transform environment variable names to match config keys
@@ -236,12 +512,13 @@ func LoadConfig() (*Config, error) {
 	}
 
 	// Special handling for API keys from standard environment variables
-	// Check for OPENAI_API_KEY if using OpenAI
-	if k.String("llm.provider") == "openai" && k.String("llm.api_key") == "" {
+	// Check for OPENAI_API_KEY if using OpenAI, but not for an endpoint at llm.base_url
+	if k.String("llm.provider") == "openai" && k.String("llm.api_key") == "" && k.String("llm.base_url") == "" {
 		if openaiKey := os.Getenv("OPENAI_API_KEY"); openaiKey != "" {
 			if err := k.Set("llm.api_key", openaiKey); err != nil {
 				log.Printf("Failed to set OpenAI API key from environment: %v", err)
 			}
+			sources["llm.api_key"] = ConfigSourceEnv
 		}
 	}
 
@@ -251,6 +528,7 @@ func LoadConfig() (*Config, error) {
 			if err := k.Set("llm.api_key", anthropicKey); err != nil {
 				log.Printf("Failed to set Anthropic API key from environment: %v", err)
 			}
+			sources["llm.api_key"] = ConfigSourceEnv
 		}
 	}
 
@@ -259,6 +537,7 @@ func LoadConfig() (*Config, error) {
 	if err := k.Unmarshal("", &config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	config.Sources = sources
 
 	// Set default values for session config if not explicitly configured
 	// Check if session.enabled was explicitly set in config or environment
@@ -271,50 +550,98 @@ func LoadConfig() (*Config, error) {
 	if config.LLM.Provider == "" {
 		if anthropicKey := os.Getenv("ANTHROPIC_API_KEY"); anthropicKey != "" {
 			config.LLM.Provider = "anthropic"
-			config.LLM.Model = "claude-sonnet-4-20250514"
+			config.LLM.Model = providerDefaultModels["anthropic"]
 			config.LLM.APIKey = anthropicKey
 			log.Printf("Auto-configured provider: anthropic (from ANTHROPIC_API_KEY)")
 		} else if openaiKey := os.Getenv("OPENAI_API_KEY"); openaiKey != "" {
 			config.LLM.Provider = "openai"
-			config.LLM.Model = "gpt-4o"
+			config.LLM.Model = providerDefaultModels["openai"]
 			config.LLM.APIKey = openaiKey
 			log.Printf("Auto-configured provider: openai (from OPENAI_API_KEY)")
 		} else if geminiKey := os.Getenv("GEMINI_API_KEY"); geminiKey != "" {
 			config.LLM.Provider = "googleai"
-			config.LLM.Model = "gemini-2.5-flash"
+			config.LLM.Model = providerDefaultModels["googleai"]
 			config.LLM.APIKey = geminiKey
 			log.Printf("Auto-configured provider: googleai (from GEMINI_API_KEY)")
 		} else if googleKey := os.Getenv("GOOGLE_API_KEY"); googleKey != "" {
 			config.LLM.Provider = "googleai"
-			config.LLM.Model = "gemini-2.5-flash"
+			config.LLM.Model = providerDefaultModels["googleai"]
 			config.LLM.APIKey = googleKey
 			log.Printf("Auto-configured provider: googleai (from GOOGLE_API_KEY)")
 		}
+		if config.LLM.Provider != "" {
+			for _, key := range []string{"llm.provider", "llm.model", "llm.api_key"} {
+				config.setSource(key, ConfigSourceEnv)
+			}
+		}
 	}
 
-	// If provider is set but API key is not, try to load from environment
-	if config.LLM.Provider != "" && config.LLM.APIKey == "" {
-		switch config.LLM.Provider {
-		case "anthropic":
-			if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
-				config.LLM.APIKey = key
-			}
-		case "openai":
-			if key := os.Getenv("OPENAI_API_KEY"); key != "" {
-				config.LLM.APIKey = key
-			}
-		case "googleai":
-			if key := os.Getenv("GEMINI_API_KEY"); key != "" {
-				config.LLM.APIKey = key
-			} else if key := os.Getenv("GOOGLE_API_KEY"); key != "" {
-				config.LLM.APIKey = key
-			}
+	// If provider is set but API key is not, try to load from environment. OPENAI_API_KEY is
+	// kept from the endpoints set with llm.base_url
+	if config.LLM.Provider != "" && config.LLM.APIKey == "" && (config.LLM.Provider != "openai" || config.LLM.BaseURL == "") {
+		if config.LLM.APIKey = apiKeyFromEnv(config.LLM.Provider); config.LLM.APIKey != "" {
+			config.setSource("llm.api_key", ConfigSourceEnv)
 		}
 	}
+	if config.LLM.ThinkingBudget == 0 && config.LLM.MaxThinkingTokens != 0 {
+		config.LLM.ThinkingBudget = config.LLM.MaxThinkingTokens
+		config.setSource("llm.thinking_budget", config.Source("llm.max_thinking_tokens"))
+	}
 
 	return &config, nil
 }
 
+// providerDefaultModels are the models used for a provider configured from its API key alone
+var providerDefaultModels = map[string]string{
+	"anthropic": "claude-sonnet-4-20250514",
+	"openai":    "gpt-4o",
+	"googleai":  "gemini-2.5-flash",
+}
+
+// apiKeyFromEnv returns the provider's API key from its environment variable, if set
+func apiKeyFromEnv(provider string) string {
+	switch provider {
+	case "anthropic":
+		return os.Getenv("ANTHROPIC_API_KEY")
+	case "openai":
+		return os.Getenv("OPENAI_API_KEY")
+	case "googleai":
+		if key := os.Getenv("GEMINI_API_KEY"); key != "" {
+			return key
+		}
+		return os.Getenv("GOOGLE_API_KEY")
+	}
+	return ""
+}
+
+// ApplyProfile switches the LLM settings to the provider, model and credentials of a
+// profile from [profiles.<name>]. Without an api_key the credentials are looked up like
+// for the configured provider: the environment, then the keyring.
+func (c *Config) ApplyProfile(name string) error {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, add it under [profiles.%s]", name, name)
+	}
+	if profile.Provider == "" || profile.Model == "" {
+		return fmt.Errorf("profile %q needs a provider and a model", name)
+	}
+	c.LLM.Provider = profile.Provider
+	c.LLM.Model = profile.Model
+	c.LLM.BaseURL = profile.BaseURL
+	c.LLM.Headers = profile.Headers
+	c.LLM.AuthToken = ""
+	c.LLM.RefreshToken = ""
+	c.LLM.APIKey = profile.APIKey
+	if c.LLM.APIKey == "" {
+		c.LLM.APIKey = apiKeyFromEnv(profile.Provider)
+	}
+	for _, key := range []string{"llm.provider", "llm.model", "llm.base_url", "llm.headers", "llm.auth_token", "llm.refresh_token", "llm.api_key"} {
+		c.setSource(key, ConfigSourceProfile)
+	}
+	c.Profile = name
+	return nil
+}
+
 // ReloadProjectConf reloads the project's configuration file
 func (c *Config) ReloadProjectConf() error {
 	projectConfigPath := filepath.Join(".agents", "asimi.conf")
@@ -334,6 +661,9 @@ func (c *Config) ReloadProjectConf() error {
 	if err := k.Unmarshal("", c); err != nil {
 		return fmt.Errorf("failed to unmarshal project config: %w", err)
 	}
+	for _, key := range k.Keys() {
+		c.setSource(key, ConfigSourceProject)
+	}
 
 	return nil
 }
@@ -354,6 +684,10 @@ func SaveConfig(config *Config) error {
 	// Update provider and model using comment-preserving helpers
 	content = updateOrInsertTOMLValue(content, "llm", "provider", config.LLM.Provider)
 	content = updateOrInsertTOMLValue(content, "llm", "model", config.LLM.Model)
+	// A base URL left from another provider's endpoint would point this one at it
+	if config.LLM.BaseURL == "" {
+		content = removeTOMLKey(content, "llm", "base_url")
+	}
 
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(userConfigPath), 0o755); err != nil {
@@ -463,6 +797,45 @@ func updateAPIKeyInFile(provider, apiKey, model string) error {
 	return os.WriteFile(cfgPath, []byte(content), 0o600)
 }
 
+// SaveEndpointConfig points ~/.config/asimi/asimi.conf at an OpenAI-compatible endpoint.
+// The API key goes to the keyring under the endpoint's own name, or to the file without one.
+func SaveEndpointConfig(baseURL, model, apiKey string) error {
+	inFile := false
+	if apiKey != "" {
+		if err := SaveAPIKeyToKeyring(endpointKeyringName(baseURL), apiKey); err != nil {
+			log.Printf("Warning: Failed to save API key to keyring, falling back to file storage: %v", err)
+			inFile = true
+		}
+	}
+
+	cfgDir, cfgPath, err := userConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfgDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+
+	// Read existing content or start with empty
+	var content string
+	if data, err := os.ReadFile(cfgPath); err == nil {
+		content = string(data)
+	}
+
+	content = updateOrInsertTOMLValue(content, "llm", "provider", "openai")
+	content = updateOrInsertTOMLValue(content, "llm", "model", model)
+	content = updateOrInsertTOMLValue(content, "llm", "base_url", baseURL)
+	if inFile {
+		content = updateOrInsertTOMLValue(content, "llm", "api_key", apiKey)
+		content = updateOrInsertTOMLValue(content, "llm", "auth_method", "apikey_file")
+	} else {
+		// A key left from another provider must not be sent to the endpoint
+		content = removeTOMLKey(content, "llm", "api_key")
+	}
+
+	return os.WriteFile(cfgPath, []byte(content), 0o600)
+}
+
 func escapeTOMLString(s string) string {
 	// Basic escaping for quotes and backslashes
 	s = strings.ReplaceAll(s, "\\", "\\\\")
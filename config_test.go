@@ -229,6 +229,8 @@ func TestLoadConfig(t *testing.T) {
 		assert.NotNil(t, config)
 		// History should be enabled by default
 		assert.True(t, config.History.Enabled)
+		// Checkpoints cost a snapshot per tool call, they are opt-in
+		assert.False(t, config.Git.Checkpoints)
 	})
 
 	t.Run("load with project config", func(t *testing.T) {
@@ -244,6 +246,10 @@ api_key = "test-key"
 [history]
 enabled = false
 max_sessions = 100
+
+[personas.reviewer]
+instruction = "Review only"
+tools = ["read_file"]
 `
 		err = os.WriteFile(".agents/asimi.conf", []byte(configContent), 0644)
 		require.NoError(t, err)
@@ -255,6 +261,7 @@ max_sessions = 100
 		assert.Equal(t, "gpt-4", config.LLM.Model)
 		assert.False(t, config.History.Enabled)
 		assert.Equal(t, 100, config.History.MaxSessions)
+		assert.Equal(t, PersonaConfig{Instruction: "Review only", Tools: []string{"read_file"}}, config.Personas["reviewer"])
 	})
 
 	t.Run("environment variables override config", func(t *testing.T) {
@@ -329,6 +336,37 @@ model = "claude-3-opus"
 	// See TestGetOauthTokenFormats in keyring_test.go for tests
 }
 
+func TestLoadConfigSources(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("ASIMI_LLM_MODEL", "claude-3-opus")
+	require.NoError(t, os.MkdirAll(".agents", 0755))
+	require.NoError(t, os.WriteFile(".agents/asimi.conf", []byte(`[llm]
+provider = "anthropic"
+model = "claude-3-haiku"
+api_key = "sk-ant-secret"
+`), 0644))
+
+	config, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "claude-3-opus", config.LLM.Model)
+	assert.Equal(t, ConfigSourceEnv, config.Source("llm.model"))
+	assert.Equal(t, ConfigSourceProject, config.Source("llm.provider"))
+	assert.Equal(t, ConfigSourceDefault, config.Source("history.enabled"))
+
+	view := renderConfig(config, "llm")
+	assert.Contains(t, view, "llm.model = \"claude-3-opus\" (env)\n")
+	assert.Contains(t, view, "llm.provider = \"anthropic\" (project)\n")
+	assert.Contains(t, view, "llm.api_key = [REDACTED] (project)\n")
+	assert.NotContains(t, view, "sk-ant-secret")
+	assert.NotContains(t, view, "history.")
+	assert.Contains(t, renderConfig(config, ""), "history.enabled = true (default)\n")
+
+	config.Profiles = map[string]ProfileConfig{"work": {Provider: "openai", Model: "gpt-4o"}}
+	require.NoError(t, config.ApplyProfile("work"))
+	assert.Contains(t, renderConfig(config, "llm.model"), "llm.model = \"gpt-4o\" (profile)")
+}
+
 func TestSaveConfig(t *testing.T) {
 	// Create a temporary directory for test
 	tempDir := t.TempDir()
@@ -16,13 +16,15 @@ const (
 	ViewHelp
 	ViewModels
 	ViewResume
+	ViewHelpIndex
+	ViewFile
 )
 
 // NavigationMode represents how navigation works in the current view
 type NavigationMode int
 
 const (
-	NavText NavigationMode = iota // Text scrolling (chat, help)
+	NavText NavigationMode = iota // Text scrolling (chat, help, file)
 	NavList                       // List selection (models, resume)
 )
 
@@ -37,6 +39,7 @@ type ContentComponent struct {
 	help   HelpWindow
 	models ModelsWindow
 	resume ResumeWindow
+	file   FileViewer
 
 	// Unified navigation state
 	navMode      NavigationMode
@@ -104,6 +107,31 @@ func (c *ContentComponent) ShowHelp(topic string) tea.Cmd {
 	}
 }
 
+// ShowHelpIndex switches to the searchable help topic index
+func (c *ContentComponent) ShowHelpIndex() tea.Cmd {
+	c.activeView = ViewHelpIndex
+	c.navMode = NavList
+	c.help.OpenIndex()
+
+	return func() tea.Msg {
+		return ChangeModeMsg{NewMode: "help"}
+	}
+}
+
+// ShowFile switches to the read-only file view
+func (c *ContentComponent) ShowFile(file FileViewer) tea.Cmd {
+	c.activeView = ViewFile
+	c.navMode = NavText
+	c.file = file
+
+	c.viewport.SetContent(file.Render())
+	c.viewport.GotoTop()
+
+	return func() tea.Msg {
+		return ChangeModeMsg{NewMode: "help"}
+	}
+}
+
 // ShowModels switches to models view (legacy - for backward compatibility)
 func (c *ContentComponent) ShowModels(models []AnthropicModel, currentModel string) tea.Cmd {
 	// Convert AnthropicModel to unified Model type
@@ -200,6 +228,11 @@ func (c *ContentComponent) Update(msg tea.Msg) (ContentComponent, tea.Cmd) {
 			}
 		}
 
+		// The help index consumes typed characters as its filter
+		if c.activeView == ViewHelpIndex {
+			return *c, c.handleHelpIndexKeys(msg)
+		}
+
 		// Navigation
 		switch c.navMode {
 		case NavText:
@@ -252,8 +285,12 @@ func (c *ContentComponent) handleTextNavigation(msg tea.KeyMsg) tea.Cmd {
 		return nil
 	}
 
-	// Help view navigation
+	// Help and file view navigation
 	switch msg.String() {
+	case "/":
+		if c.activeView == ViewHelp {
+			return c.ShowHelpIndex()
+		}
 	case "j", "down":
 		c.viewport.LineDown(1)
 	case "k", "up":
@@ -277,6 +314,27 @@ func (c *ContentComponent) handleTextNavigation(msg tea.KeyMsg) tea.Cmd {
 	return nil
 }
 
+// handleHelpIndexKeys filters the help index as the user types and opens the selected topic
+func (c *ContentComponent) handleHelpIndexKeys(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyDown, tea.KeyCtrlN, tea.KeyTab:
+		c.help.list.SelectNext()
+	case tea.KeyUp, tea.KeyCtrlP, tea.KeyShiftTab:
+		c.help.list.SelectPrev()
+	case tea.KeyBackspace:
+		if filter := []rune(c.help.GetFilter()); len(filter) > 0 {
+			c.help.SetFilter(string(filter[:len(filter)-1]))
+		}
+	case tea.KeyEnter:
+		if topic := c.help.SelectedTopic(); topic != "" {
+			return c.ShowHelp(topic)
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		c.help.SetFilter(c.help.GetFilter() + string(msg.Runes))
+	}
+	return nil
+}
+
 // handleListNavigation handles navigation for list views (models, resume)
 func (c *ContentComponent) handleListNavigation(msg tea.KeyMsg) tea.Cmd {
 	var itemCount int
@@ -462,6 +520,10 @@ func (c *ContentComponent) View() string {
 		return c.Chat.View()
 	case ViewHelp:
 		return c.renderHelpView()
+	case ViewHelpIndex:
+		return c.renderHelpIndexView()
+	case ViewFile:
+		return c.renderFileView()
 	case ViewModels:
 		return c.renderModelsView()
 	case ViewResume:
@@ -493,6 +555,35 @@ func (c *ContentComponent) renderHelpView() string {
 		Render(content)
 }
 
+// renderHelpIndexView renders the searchable help topic index
+func (c *ContentComponent) renderHelpIndexView() string {
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#F952F9")).
+		Background(lipgloss.Color("#000000")).
+		Padding(0, 1)
+
+	title := titleStyle.Render(fmt.Sprintf(" Help index /%s ", c.help.GetFilter()))
+
+	return lipgloss.NewStyle().
+		Height(c.height - 1).
+		MaxHeight(c.height - 1).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, c.help.RenderIndex()))
+}
+
+// renderFileView renders the read-only file view
+func (c *ContentComponent) renderFileView() string {
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#F952F9")).
+		Background(lipgloss.Color("#000000")).
+		Padding(0, 1)
+
+	title := titleStyle.Render(c.file.Title())
+
+	return lipgloss.JoinVertical(lipgloss.Left, title, c.viewport.View())
+}
+
 // renderModelsView renders the models selection view
 func (c *ContentComponent) renderModelsView() string {
 	content := c.models.RenderList(c.selectedItem, c.scrollOffset, c.models.GetVisibleSlots())
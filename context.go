@@ -7,6 +7,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"math"
 	"strings"
 
@@ -42,6 +43,39 @@ var extendedModelContextSizes = map[string]int{
 	"gemini-2.0-flash":        1_000_000,
 }
 
+// modelMaxOutputTokens is the most output tokens a request may ask of a model. Dated
+// versions match by their longest known prefix, unknown models aren't limited.
+var modelMaxOutputTokens = map[string]int{
+	// OpenAI
+	"gpt-3.5-turbo": 4_096,
+	"gpt-4":         8_192,
+	"gpt-4-turbo":   4_096,
+	"gpt-4o":        16_384,
+	"gpt-4o-mini":   16_384,
+	"gpt-4.1":       32_768,
+	"o1":            100_000,
+	"o3":            100_000,
+	"o4-mini":       100_000,
+
+	// Anthropic
+	"claude-3-opus":     4_096,
+	"claude-3-sonnet":   4_096,
+	"claude-3-haiku":    4_096,
+	"claude-3-5-sonnet": 8_192,
+	"claude-3-5-haiku":  8_192,
+	"claude-3-7-sonnet": 64_000,
+	"claude-sonnet-4":   64_000,
+	"claude-opus-4":     32_000,
+
+	// Google
+	"gemini-pro":       2_048,
+	"gemini-1.5-flash": 8_192,
+	"gemini-1.5-pro":   8_192,
+	"gemini-2.0-flash": 8_192,
+	"gemini-2.5-flash": 65_536,
+	"gemini-2.5-pro":   65_536,
+}
+
 // ContextInfo holds information about context usage.
 type ContextInfo struct {
 	Model              string
@@ -127,6 +161,29 @@ func (s *Session) getModelContextSize() int {
 	return defaultUnknownContextRef
 }
 
+// getModelMaxOutputTokens returns the output token limit of a model, or 0 when unknown
+func getModelMaxOutputTokens(model string) int {
+	modelName := strings.ToLower(model)
+	best, limit := "", 0
+	for prefix, max := range modelMaxOutputTokens {
+		if strings.HasPrefix(modelName, prefix) && len(prefix) > len(best) {
+			best, limit = prefix, max
+		}
+	}
+	return limit
+}
+
+// maxTokensForRequest clamps the requested output tokens to what model accepts,
+// since asking for more gets a 400 from the provider
+func maxTokensForRequest(model string, requested int) int {
+	limit := getModelMaxOutputTokens(model)
+	if limit == 0 || requested <= limit {
+		return requested
+	}
+	slog.Info("clamping max output tokens", "model", model, "requested", requested, "limit", limit)
+	return limit
+}
+
 // CountSystemPromptTokens counts tokens in the system prompt.
 // This includes the base system prompt template and AGENTS.md content if it exists.
 func (s *Session) CountSystemPromptTokens() int {
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
 )
 
@@ -259,3 +261,46 @@ func TestAGENTSmdInSystemPrompt(t *testing.T) {
 		t.Log("AGENTS.md not found in project directory")
 	}
 }
+
+// maxTokensLLM records the max tokens of each request
+type maxTokensLLM struct {
+	llms.Model
+	maxTokens []int
+}
+
+func (m *maxTokensLLM) GenerateContent(_ context.Context, _ []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	m.maxTokens = append(m.maxTokens, opts.MaxTokens)
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "done"}}}, nil
+}
+
+func TestMaxTokensClampedToModelLimit(t *testing.T) {
+	tests := []struct {
+		provider string
+		model    string
+		expected int
+	}{
+		{"openai", "gpt-4-turbo", 4_096},
+		{"openai", "gpt-4o-2024-08-06", 16_384},
+		{"anthropic", "claude-3-haiku-20240307", 4_096},
+		{"anthropic", "claude-sonnet-4-5-20250929", defaultMaxOutputTokens},
+		{"openai", "o3-mini", defaultMaxOutputTokens},
+		{"ollama", "qwen3:8b", defaultMaxOutputTokens},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			llm := &maxTokensLLM{}
+			sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: tt.provider, Model: tt.model}}, RepoInfo{}, func(any) {})
+			require.NoError(t, err)
+
+			_, err = sess.generateLLMResponse(context.Background(), nil)
+			require.NoError(t, err)
+			require.Equal(t, []int{tt.expected}, llm.maxTokens)
+		})
+	}
+}
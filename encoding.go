@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Encodings detected from a file's byte order mark
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF8BOM = "utf-8-bom"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+)
+
+// textEncodings maps the names accepted by tools.file_encoding, and the detected ones,
+// to their encoding. UTF-8 needs no transcoding and isn't listed.
+var textEncodings = map[string]encoding.Encoding{
+	encodingUTF8BOM: unicode.UTF8BOM,
+	encodingUTF16LE: unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM),
+	encodingUTF16BE: unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM),
+	"latin-1":       charmap.ISO8859_1,
+	"iso-8859-1":    charmap.ISO8859_1,
+	"iso-8859-15":   charmap.ISO8859_15,
+	"windows-1252":  charmap.Windows1252,
+	"cp1252":        charmap.Windows1252,
+}
+
+var (
+	fileEncodingMu sync.RWMutex
+	fileEncoding   string
+)
+
+// fileEncodingNames lists the encodings tools.file_encoding accepts
+func fileEncodingNames() []string {
+	names := []string{encodingUTF8}
+	for name, enc := range textEncodings {
+		if _, isCharmap := enc.(*charmap.Charmap); isCharmap {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// setFileEncoding sets the encoding assumed for files that are neither UTF-8 nor start with
+// a byte order mark. Empty or utf-8 leaves such files as they are.
+func setFileEncoding(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" && name != encodingUTF8 && !slices.Contains(fileEncodingNames(), name) {
+		return fmt.Errorf("unknown encoding %q, use one of %s", name, strings.Join(fileEncodingNames(), ", "))
+	}
+	if name == encodingUTF8 {
+		name = ""
+	}
+	fileEncodingMu.Lock()
+	defer fileEncodingMu.Unlock()
+	fileEncoding = name
+	return nil
+}
+
+// currentFileEncoding returns the fallback encoding set with setFileEncoding, utf-8 when unset
+func currentFileEncoding() string {
+	fileEncodingMu.RLock()
+	defer fileEncodingMu.RUnlock()
+	if fileEncoding == "" {
+		return encodingUTF8
+	}
+	return fileEncoding
+}
+
+// initFileEncoding sets the fallback file encoding from the tools config
+func initFileEncoding(config *Config) {
+	var name string
+	if config != nil {
+		name = config.Tools.FileEncoding
+	}
+	if err := setFileEncoding(name); err != nil {
+		slog.Warn("ignoring tools.file_encoding", "error", err)
+		_ = setFileEncoding("")
+	}
+}
+
+// detectEncoding names the encoding of a file's content: the one its byte order mark
+// announces, utf-8 when it is valid UTF-8, or else tools.file_encoding
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return encodingUTF8BOM
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return encodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return encodingUTF16BE
+	case utf8.Valid(data):
+		return encodingUTF8
+	}
+	return currentFileEncoding()
+}
+
+// decodeText transcodes a file's content to UTF-8 for the model, returning its encoding
+// so the file can be written back the same way
+func decodeText(data []byte) (string, string, error) {
+	name := detectEncoding(data)
+	enc, ok := textEncodings[name]
+	if !ok {
+		return string(data), name, nil
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", name, fmt.Errorf("cannot decode the file as %s: %w", name, err)
+	}
+	return string(decoded), name, nil
+}
+
+// encodeText transcodes UTF-8 text to the named encoding
+func encodeText(text, name string) ([]byte, error) {
+	enc, ok := textEncodings[name]
+	if !ok {
+		return []byte(text), nil
+	}
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("the text cannot be written as %s, the file's encoding: %w", name, err)
+	}
+	return encoded, nil
+}
+
+// readTextFile reads a file as UTF-8 text, returning the encoding it was transcoded from
+func readTextFile(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	return decodeText(data)
+}
+
+// writeTextFile writes UTF-8 text to a file in the named encoding
+func writeTextFile(path, text, name string) error {
+	data, err := encodeText(text, name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// existingFileEncoding returns the encoding of a file about to be overwritten,
+// utf-8 for a new file
+func existingFileEncoding(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return encodingUTF8
+	}
+	return detectEncoding(data)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// endpointCheckTimeout bounds the /models call validating a custom endpoint
+const endpointCheckTimeout = 10 * time.Second
+
+// endpointFields are the inputs of the :endpoint modal, in order
+var endpointFields = []string{"Base URL", "Model", "API key (empty if none)"}
+
+// endpointEnteredMsg carries the inputs of the :endpoint modal
+type endpointEnteredMsg struct {
+	baseURL string
+	model   string
+	apiKey  string
+}
+
+// endpointCheckedMsg reports whether the endpoint entered in the :endpoint modal answered
+type endpointCheckedMsg struct {
+	endpointEnteredMsg
+	err error
+}
+
+// EndpointModal asks for the base URL, model and API key of an OpenAI-compatible endpoint
+type EndpointModal struct {
+	*BaseModal
+	inputs []string
+	focus  int
+}
+
+// NewEndpointModal creates the endpoint modal, filled in with the current endpoint if any
+func NewEndpointModal(baseURL, model string) *EndpointModal {
+	return &EndpointModal{
+		BaseModal: NewBaseModal("OpenAI-compatible Endpoint", "", 80, 16),
+		inputs:    []string{baseURL, model, ""},
+	}
+}
+
+// Render renders the endpoint modal
+func (m *EndpointModal) Render() string {
+	var b strings.Builder
+	inputStyle := lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(0, 1).Width(60)
+	focusedStyle := inputStyle.BorderForeground(lipgloss.Color("62"))
+	for i, label := range endpointFields {
+		value := m.inputs[i]
+		if i == 2 {
+			value = strings.Repeat("*", len(value))
+		}
+		style := inputStyle
+		if i == m.focus {
+			value += "│"
+			style = focusedStyle
+		}
+		b.WriteString(label + "\n" + style.Render(value) + "\n")
+	}
+	b.WriteString("\nTab to move, Enter on the last field to connect, Esc to cancel")
+	m.BaseModal.Content = b.String()
+	return m.BaseModal.Render()
+}
+
+// Update handles key events for the endpoint modal
+func (m *EndpointModal) Update(msg tea.Msg) (*EndpointModal, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+	switch keyMsg.String() {
+	case "esc", "ctrl+c":
+		return m, func() tea.Msg { return modalCancelledMsg{} }
+	case "tab", "down":
+		m.focus = (m.focus + 1) % len(m.inputs)
+	case "shift+tab", "up":
+		m.focus = (m.focus + len(m.inputs) - 1) % len(m.inputs)
+	case "backspace", "ctrl+h":
+		if input := []rune(m.inputs[m.focus]); len(input) > 0 {
+			m.inputs[m.focus] = string(input[:len(input)-1])
+		}
+	case "ctrl+u":
+		m.inputs[m.focus] = ""
+	case "enter", "ctrl+m":
+		if m.focus < len(m.inputs)-1 {
+			m.focus++
+			return m, nil
+		}
+		entered := endpointEnteredMsg{
+			baseURL: strings.TrimSpace(m.inputs[0]),
+			model:   strings.TrimSpace(m.inputs[1]),
+			apiKey:  strings.TrimSpace(m.inputs[2]),
+		}
+		if entered.baseURL == "" || entered.model == "" {
+			m.focus = 0
+			if entered.baseURL != "" {
+				m.focus = 1
+			}
+			return m, nil
+		}
+		return m, func() tea.Msg { return entered }
+	default:
+		// Typed and pasted text
+		if keyMsg.Type == tea.KeyRunes {
+			m.inputs[m.focus] += string(keyMsg.Runes)
+		}
+	}
+	return m, nil
+}
+
+// normalizeOpenAIBaseURL turns what a user pastes as an OpenAI-compatible endpoint into the base
+// URL the client expects: with a scheme, without a trailing slash or API route, and ending in
+// /v1 when no path is given
+func normalizeOpenAIBaseURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		scheme := "https://"
+		if host := strings.Split(raw, "/")[0]; strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+			scheme = "http://"
+		}
+		raw = scheme + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid base URL %q: the scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q: host is empty", raw)
+	}
+	path := strings.TrimRight(u.Path, "/")
+	for _, route := range []string{"/chat/completions", "/completions", "/models"} {
+		path = strings.TrimSuffix(path, route)
+	}
+	if path == "" {
+		path = "/v1"
+	}
+	u.Path = path
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// checkOpenAIEndpoint lists the models of an OpenAI-compatible endpoint, failing when it doesn't
+// answer or doesn't serve model
+func checkOpenAIEndpoint(ctx context.Context, baseURL, apiKey, model string) error {
+	ctx, cancel := context.WithTimeout(ctx, endpointCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", baseURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s/models returned status %d", baseURL, resp.StatusCode)
+	}
+
+	var models OpenAIModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return fmt.Errorf("failed to parse the model list: %w", err)
+	}
+	// Some servers answer with an empty list, leaving the model unchecked
+	if len(models.Data) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(models.Data))
+	for _, m := range models.Data {
+		if m.ID == model {
+			return nil
+		}
+		ids = append(ids, m.ID)
+	}
+	if suggestion := suggestModel(model, ids); suggestion != "" {
+		return fmt.Errorf("model %q is not served by %s, did you mean %q?", model, baseURL, suggestion)
+	}
+	return fmt.Errorf("model %q is not served by %s, it serves: %s", model, baseURL, strings.Join(ids, ", "))
+}
+
+// checkEndpointCmd validates the endpoint entered in the :endpoint modal
+func checkEndpointCmd(entered endpointEnteredMsg) tea.Cmd {
+	return func() tea.Msg {
+		baseURL, err := normalizeOpenAIBaseURL(entered.baseURL)
+		if err == nil {
+			entered.baseURL = baseURL
+			err = checkOpenAIEndpoint(context.Background(), entered.baseURL, entered.apiKey, entered.model)
+		}
+		return endpointCheckedMsg{endpointEnteredMsg: entered, err: err}
+	}
+}
+
+// applyEndpoint saves a checked endpoint to the user config and reinitializes the session with
+// it, keeping the current model when either fails
+func (m *TUIModel) applyEndpoint(endpoint endpointEnteredMsg) error {
+	old := m.config.LLM
+	m.config.LLM.Provider = "openai"
+	m.config.LLM.Model = endpoint.model
+	m.config.LLM.BaseURL = endpoint.baseURL
+	m.config.LLM.APIKey = endpoint.apiKey
+	m.config.LLM.AuthToken = ""
+	m.config.LLM.RefreshToken = ""
+	if err := m.reinitializeSession(); err != nil {
+		m.config.LLM = old
+		return err
+	}
+	if err := SaveEndpointConfig(endpoint.baseURL, endpoint.model, endpoint.apiKey); err != nil {
+		m.config.LLM = old
+		if reinitErr := m.reinitializeSession(); reinitErr != nil {
+			return fmt.Errorf("failed to save config: %w (and to restore the previous model: %v)", err, reinitErr)
+		}
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
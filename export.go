@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -18,36 +22,163 @@ type ExportType string
 const (
 	ExportTypeFull         ExportType = "full"
 	ExportTypeConversation ExportType = "conversation"
+	ExportTypeJSON         ExportType = "json" // the full transcript as JSON, for scripts
 )
 
-// exportSession exports the current session to a markdown file and returns the filepath
+// exportSession exports the current session to a file in the temp dir and returns the filepath
 func exportSession(session *Session, exportType ExportType) (string, error) {
+	return writeExport(session, exportType, "")
+}
+
+// writeExport writes the export of a session to path, or to a file in the temp dir named
+// after the session and export type when path is empty, and returns the path
+func writeExport(session *Session, exportType ExportType, path string) (string, error) {
 	if session == nil {
 		return "", fmt.Errorf("no session to export")
 	}
 
 	// Generate export content based on type
 	var content string
+	ext := ".md"
 	switch exportType {
 	case ExportTypeFull:
 		content = generateFullExportContent(session)
 	case ExportTypeConversation:
 		content = generateConversationExportContent(session)
+	case ExportTypeJSON:
+		var err error
+		if content, err = generateJSONExportContent(session); err != nil {
+			return "", err
+		}
+		ext = ".json"
 	default:
 		return "", fmt.Errorf("unknown export type: %s", exportType)
 	}
 
-	// Create temporary file
-	timestamp := time.Now().Format("20060102-150405")
-	filename := fmt.Sprintf("asimi-export-%s-%s-%s.md", string(exportType), session.ID, timestamp)
-	filepath := filepath.Join(os.TempDir(), filename)
+	if path == "" {
+		timestamp := time.Now().Format("20060102-150405")
+		filename := fmt.Sprintf("asimi-export-%s-%s-%s%s", string(exportType), session.ID, timestamp, ext)
+		path = filepath.Join(os.TempDir(), filename)
+	}
 
 	// Write content to file
-	if err := os.WriteFile(filepath, []byte(content), 0644); err != nil {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 		return "", fmt.Errorf("failed to write export file: %w", err)
 	}
 
-	return filepath, nil
+	return path, nil
+}
+
+// exportTranscript is the JSON export of a session
+type exportTranscript struct {
+	AsimiVersion string          `json:"asimi_version"`
+	SessionID    string          `json:"session_id"`
+	WorkingDir   string          `json:"working_dir"`
+	Project      string          `json:"project,omitempty"`
+	Provider     string          `json:"provider"`
+	Model        string          `json:"model"`
+	CreatedAt    time.Time       `json:"created_at"`
+	LastUpdated  time.Time       `json:"last_updated"`
+	ExportedAt   time.Time       `json:"exported_at"`
+	Messages     []exportMessage `json:"messages"`
+}
+
+// exportMessage is a message of the JSON export, its role one of system, human, ai or tool
+type exportMessage struct {
+	Role        string             `json:"role"`
+	Text        string             `json:"text,omitempty"`
+	Reasoning   string             `json:"reasoning,omitempty"`
+	ToolCalls   []exportToolCall   `json:"tool_calls,omitempty"`
+	ToolResults []exportToolResult `json:"tool_results,omitempty"`
+}
+
+type exportToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type exportToolResult struct {
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+}
+
+// thinkingBlockPattern matches the reasoning a reply carries inline, as Ask records it
+var thinkingBlockPattern = regexp.MustCompile(`(?s)<thinking>\n?(.*?)\n?</thinking>\s*`)
+
+// generateJSONExportContent generates the full transcript of a session as JSON, with the
+// reasoning blocks of the replies apart from their text
+func generateJSONExportContent(session *Session) (string, error) {
+	transcript := exportTranscript{
+		AsimiVersion: version,
+		SessionID:    session.ID,
+		WorkingDir:   session.exportWorkingDir(),
+		Project:      session.ProjectSlug,
+		Provider:     session.Provider,
+		Model:        session.Model,
+		CreatedAt:    session.CreatedAt,
+		LastUpdated:  session.LastUpdated,
+		ExportedAt:   time.Now(),
+		Messages:     make([]exportMessage, 0, len(session.Messages)),
+	}
+	for _, msg := range session.Messages {
+		exported := exportMessage{Role: string(msg.Role)}
+		var texts, reasoning []string
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				for _, block := range thinkingBlockPattern.FindAllStringSubmatch(p.Text, -1) {
+					reasoning = append(reasoning, block[1])
+				}
+				if text := strings.TrimSpace(thinkingBlockPattern.ReplaceAllString(p.Text, "")); text != "" {
+					texts = append(texts, text)
+				}
+			case llms.ToolCall:
+				if p.FunctionCall != nil {
+					exported.ToolCalls = append(exported.ToolCalls, exportToolCall{ID: p.ID, Name: p.FunctionCall.Name, Arguments: p.FunctionCall.Arguments})
+				}
+			case llms.ToolCallResponse:
+				exported.ToolResults = append(exported.ToolResults, exportToolResult{ToolCallID: p.ToolCallID, Name: p.Name, Content: p.Content})
+			}
+		}
+		exported.Text = strings.Join(texts, "\n\n")
+		exported.Reasoning = strings.Join(reasoning, "\n\n")
+		transcript.Messages = append(transcript.Messages, exported)
+	}
+
+	data, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the transcript: %w", err)
+	}
+	return session.pathRedactor().Redact(string(data)), nil
+}
+
+// dumpLastTurn writes the raw model requests and responses of the last turn to a
+// JSON file for provider bug reports, with secrets redacted
+func dumpLastTurn(session *Session) (string, error) {
+	if session == nil {
+		return "", fmt.Errorf("no session to dump")
+	}
+	exchanges := session.LastTurnExchanges()
+	if len(exchanges) == 0 {
+		return "", fmt.Errorf("no model requests in the last turn")
+	}
+
+	data, err := json.MarshalIndent(struct {
+		SessionID string        `json:"session_id"`
+		Exchanges []LLMExchange `json:"exchanges"`
+	}{session.ID, exchanges}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the last turn: %w", err)
+	}
+
+	timestamp := time.Now().Format("20060102-150405")
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("asimi-dump-%s-%s.json", session.ID, timestamp))
+	if err := os.WriteFile(path, []byte(redactSecrets(string(data))), 0600); err != nil {
+		return "", fmt.Errorf("failed to write dump file: %w", err)
+	}
+	return path, nil
 }
 
 // generateFullExportContent generates the full markdown content for the export
@@ -95,7 +226,7 @@ func generateFullExportContent(session *Session) string {
 
 	formatMessages(&b, session.Messages[startIdx:], true, true) // true = full mode, true = include message numbers
 
-	return b.String()
+	return session.pathRedactor().Redact(b.String())
 }
 
 // generateConversationExportContent generates a slimmer export with just the conversation
@@ -116,7 +247,69 @@ func generateConversationExportContent(session *Session) string {
 
 	formatMessages(&b, session.Messages[startIdx:], false, false) // false = conversation mode, false = no message numbers
 
-	return b.String()
+	return session.pathRedactor().Redact(b.String())
+}
+
+// pathRedactor rewrites the paths of an export that give away the user name and directory
+// layout, with security.redact_paths
+type pathRedactor struct {
+	home    string
+	workDir string
+	hash    bool // hash each segment of the other absolute paths
+}
+
+// absolutePathPattern matches the absolute paths of a text, not the tail of a relative path or URL
+var absolutePathPattern = regexp.MustCompile(`(?m)(^|[\s"'` + "`" + `(\[=:,])((?:/[\w.-]+)+)`)
+
+// pathRedactor returns the export path redactor of the session, nil when security.redact_paths is off
+func (s *Session) pathRedactor() *pathRedactor {
+	if s.systemConfig == nil || !s.systemConfig.Security.RedactPaths {
+		return nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		slog.Warn("cannot redact the home directory in exports", "error", err)
+	}
+	return &pathRedactor{home: home, workDir: s.WorkingDir, hash: s.systemConfig.Security.HashPaths}
+}
+
+// Redact replaces the working and home directories in text with . and ~, the working directory
+// first as it's usually under home, and hashes the segments of the other absolute paths
+func (r *pathRedactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	text = redactDir(text, r.workDir, ".")
+	text = redactDir(text, r.home, "~")
+	if r.hash {
+		text = absolutePathPattern.ReplaceAllStringFunc(text, func(match string) string {
+			start := strings.Index(match, "/")
+			return match[:start] + hashPathSegments(match[start:])
+		})
+	}
+	return text
+}
+
+// redactDir replaces dir and the paths under it in text with short, leaving the paths that
+// merely start with the same name, like dir-old, alone
+func redactDir(text, dir, short string) string {
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		return text
+	}
+	pattern := regexp.MustCompile(regexp.QuoteMeta(dir) + `([^\w.-]|$)`)
+	return pattern.ReplaceAllString(text, short+"${1}")
+}
+
+// hashPathSegments replaces each segment of an absolute path with the start of its SHA-256,
+// so the same path still reads the same across an export
+func hashPathSegments(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, segment := range segments {
+		sum := sha256.Sum256([]byte(segment))
+		segments[i] = hex.EncodeToString(sum[:4])
+	}
+	return "/" + strings.Join(segments, "/")
 }
 
 // formatMessages formats a slice of messages, pairing tool calls with their results
@@ -173,6 +366,58 @@ func formatMessages(b *strings.Builder, messages []llms.MessageContent, fullMode
 	}
 }
 
+// dumpPartLimit caps how many characters of each message part :dump shows
+const dumpPartLimit = 200
+
+// renderMessageDump renders the raw messages array as sent to the model, one entry per
+// message with its role, truncated parts and tool call IDs
+func renderMessageDump(messages []llms.MessageContent, pendingContextFiles int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Messages sent to the model (%d):\n", len(messages))
+	for i, msg := range messages {
+		label := ""
+		switch {
+		case msg.Role == llms.ChatMessageTypeSystem:
+			label = " [system prompt]"
+		case msg.Role == llms.ChatMessageTypeHuman && hasContextAugmentation(msg):
+			label = " [context-augmented]"
+		}
+		fmt.Fprintf(&b, "\n#%d %s%s\n", i, msg.Role, label)
+
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				fmt.Fprintf(&b, "  text: %q\n", truncateSnippet(p.Text, dumpPartLimit))
+			case llms.ToolCall:
+				name, args := "", ""
+				if p.FunctionCall != nil {
+					name, args = p.FunctionCall.Name, p.FunctionCall.Arguments
+				}
+				fmt.Fprintf(&b, "  tool_call id=%s name=%s args=%s\n", p.ID, name, truncateSnippet(args, dumpPartLimit))
+			case llms.ToolCallResponse:
+				fmt.Fprintf(&b, "  tool_result id=%s name=%s: %q\n", p.ToolCallID, p.Name, truncateSnippet(p.Content, dumpPartLimit))
+			default:
+				fmt.Fprintf(&b, "  %T\n", part)
+			}
+		}
+	}
+	if pendingContextFiles > 0 {
+		fmt.Fprintf(&b, "\n%d context file(s) will be prepended to the next prompt\n", pendingContextFiles)
+	}
+	return b.String()
+}
+
+// hasContextAugmentation reports whether a human message carries context files
+// prepended by buildPromptWithContext
+func hasContextAugmentation(msg llms.MessageContent) bool {
+	for _, part := range msg.Parts {
+		if text, ok := part.(llms.TextContent); ok && strings.HasPrefix(text.Text, "--- Context from: ") {
+			return true
+		}
+	}
+	return false
+}
+
 // formatToolCallWithResult formats a tool call and its result together
 func formatToolCallWithResult(b *strings.Builder, toolCall llms.ToolCall, toolResults map[string]llms.ToolCallResponse, fullMode bool) {
 	if toolCall.FunctionCall == nil {
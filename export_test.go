@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/fake"
 )
 
 func TestExportShowsToolCalls(t *testing.T) {
@@ -541,3 +547,123 @@ func TestExportMetadata(t *testing.T) {
 		}
 	})
 }
+
+func TestExportRedactsPaths(t *testing.T) {
+	t.Setenv("HOME", "/home/alice")
+	session := &Session{
+		ID:           "test-123",
+		WorkingDir:   "/home/alice/work/project",
+		systemConfig: &Config{Security: SecurityConfig{RedactPaths: true}},
+		Messages: []llms.MessageContent{
+			{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextPart(
+				"Compare /home/alice/work/project/main.go with /home/alice/notes.txt, /home/alice/work/project-old/main.go and /etc/hosts")}},
+		},
+		ContextFiles: make(map[string]string),
+	}
+
+	for _, content := range []string{generateFullExportContent(session), generateConversationExportContent(session)} {
+		require.NotContains(t, content, "/home/alice")
+		require.Contains(t, content, "**Working Directory:** ~/work/project\n")
+		require.Contains(t, content, "Compare ./main.go with ~/notes.txt, ~/work/project-old/main.go and /etc/hosts")
+	}
+
+	session.systemConfig.Security.HashPaths = true
+	content := generateConversationExportContent(session)
+	require.Contains(t, content, "./main.go with ~/notes.txt")
+	require.NotContains(t, content, "/etc/hosts")
+	require.Contains(t, content, hashPathSegments("/etc/hosts"))
+	require.Regexp(t, `^/[0-9a-f]{8}/[0-9a-f]{8}$`, hashPathSegments("/etc/hosts"))
+
+	session.systemConfig.Security.RedactPaths = false
+	require.Contains(t, generateConversationExportContent(session), "/home/alice/notes.txt")
+}
+
+func TestExportJSON(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	session := &Session{
+		ID:         "test-123",
+		WorkingDir: "/work/project",
+		Provider:   "anthropic",
+		Model:      "claude-3",
+		Messages: []llms.MessageContent{
+			{Role: llms.ChatMessageTypeSystem, Parts: []llms.ContentPart{llms.TextPart("You are Asimi")}},
+			{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextPart("Read main.go")}},
+			{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{
+				llms.TextPart("<thinking>\nThe user wants main.go\n</thinking>\n\nReading it"),
+				llms.ToolCall{ID: "call_1", Type: "function", FunctionCall: &llms.FunctionCall{Name: "read_file", Arguments: `{"path":"main.go"}`}},
+			}},
+			{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{
+				llms.ToolCallResponse{ToolCallID: "call_1", Name: "read_file", Content: "package main"},
+			}},
+			{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{llms.TextPart("It is the main package")}},
+		},
+		ContextFiles: make(map[string]string),
+	}
+
+	path, err := exportSession(session, ExportTypeJSON)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(path, ".json"), path)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var transcript exportTranscript
+	require.NoError(t, json.Unmarshal(data, &transcript))
+	require.Equal(t, "test-123", transcript.SessionID)
+	require.Equal(t, "/work/project", transcript.WorkingDir)
+	require.Equal(t, "claude-3", transcript.Model)
+	require.Equal(t, []exportMessage{
+		{Role: "system", Text: "You are Asimi"},
+		{Role: "human", Text: "Read main.go"},
+		{Role: "ai", Text: "Reading it", Reasoning: "The user wants main.go",
+			ToolCalls: []exportToolCall{{ID: "call_1", Name: "read_file", Arguments: `{"path":"main.go"}`}}},
+		{Role: "tool", ToolResults: []exportToolResult{{ToolCallID: "call_1", Name: "read_file", Content: "package main"}}},
+		{Role: "ai", Text: "It is the main package"},
+	}, transcript.Messages)
+
+	// The output path is used as given
+	out := filepath.Join(t.TempDir(), "session.json")
+	path, err = writeExport(session, ExportTypeJSON, out)
+	require.NoError(t, err)
+	require.Equal(t, out, path)
+	require.FileExists(t, out)
+}
+
+func TestDumpLastTurn(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("TMPDIR", t.TempDir())
+
+	cfg := &Config{LLM: LLMConfig{Provider: "fake", Model: "fake-model"}}
+	sess, err := NewSession(fake.NewFakeLLM([]string{"Use the --verbose flag"}), cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	_, err = dumpLastTurn(sess)
+	require.Error(t, err, "nothing to dump before the first turn")
+
+	_, err = sess.Ask(context.Background(), "why does my key sk-ant-api03-abcdef123456 fail?")
+	require.NoError(t, err)
+
+	path, err := dumpLastTurn(sess)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(path, os.TempDir()))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "sk-ant-api03-abcdef123456")
+
+	var dump struct {
+		SessionID string        `json:"session_id"`
+		Exchanges []LLMExchange `json:"exchanges"`
+	}
+	require.NoError(t, json.Unmarshal(data, &dump))
+	require.Equal(t, sess.ID, dump.SessionID)
+	require.NotEmpty(t, dump.Exchanges)
+
+	first := dump.Exchanges[0]
+	require.Equal(t, "fake-model", first.Model)
+	require.Contains(t, first.Tools, "read_file")
+	require.Equal(t, llms.ChatMessageTypeSystem, first.Messages[0].Role)
+	prompt := first.Messages[len(first.Messages)-1]
+	require.Equal(t, llms.ChatMessageTypeHuman, prompt.Role)
+	require.Contains(t, prompt.Parts[0].(llms.TextContent).Text, "why does my key [REDACTED] fail?")
+	require.NotNil(t, first.Response)
+	require.Equal(t, "Use the --verbose flag", first.Response.Choices[0].Content)
+}
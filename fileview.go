@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/charmbracelet/lipgloss"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// fileViewSniffLen is how much of a file is inspected to decide whether it is binary
+const fileViewSniffLen = 8000
+
+// FileViewer holds a file opened read-only with :open or :blame
+// Navigation is handled by ContentComponent
+type FileViewer struct {
+	path    string
+	content string
+	blame   []*gogit.Line // commit and author of each line, for :blame
+	first   int           // line number of the first line shown, for a blamed range
+}
+
+// loadFileForView reads a text file for the file viewer, rejecting binaries
+func loadFileForView(path string) (FileViewer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileViewer{}, fmt.Errorf("cannot open %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return FileViewer{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FileViewer{}, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	if isBinaryContent(data) {
+		return FileViewer{}, fmt.Errorf("%s looks like a binary file", path)
+	}
+
+	return FileViewer{path: path, content: string(data)}, nil
+}
+
+// loadBlameForView blames lines first to last of a file for the file viewer.
+// Lines are numbered from 1, and last 0 means the end of the file.
+func loadBlameForView(path string, first, last int) (FileViewer, error) {
+	result, err := blameFile(path)
+	if err != nil {
+		return FileViewer{}, err
+	}
+	if last == 0 || last > len(result.Lines) {
+		last = len(result.Lines)
+	}
+	if first < 1 || first > last {
+		return FileViewer{}, fmt.Errorf("%s has %d lines, cannot show %d-%d", path, len(result.Lines), first, last)
+	}
+
+	lines := result.Lines[first-1 : last]
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return FileViewer{path: path, content: strings.Join(texts, "\n") + "\n", blame: lines, first: first}, nil
+}
+
+// systemPromptFile names the system prompt in the file viewer, markdown for the highlighting
+const systemPromptFile = "system-prompt.md"
+
+// systemPromptView shows the system prompt of the messages, its parts concatenated, read-only
+func systemPromptView(messages []llms.MessageContent) FileViewer {
+	if len(messages) == 0 || messages[0].Role != llms.ChatMessageTypeSystem {
+		return FileViewer{path: systemPromptFile, content: "The session has no system prompt\n"}
+	}
+	var b strings.Builder
+	for _, part := range messages[0].Parts {
+		if text, ok := part.(llms.TextContent); ok {
+			b.WriteString(text.Text)
+		}
+	}
+	return FileViewer{path: systemPromptFile, content: b.String()}
+}
+
+// isBinaryContent reports whether data looks binary: a NUL byte or invalid UTF-8 near the start
+func isBinaryContent(data []byte) bool {
+	sample := data
+	if len(sample) > fileViewSniffLen {
+		sample = sample[:fileViewSniffLen]
+		// Don't mistake a multi-byte rune cut at the boundary for invalid UTF-8
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+	return bytes.IndexByte(sample, 0) >= 0 || !utf8.Valid(sample)
+}
+
+// Render returns the file content with syntax highlighting and line numbers
+func (f FileViewer) Render() string {
+	lines := strings.Split(strings.TrimSuffix(highlightSource(f.path, f.content), "\n"), "\n")
+
+	first := max(f.first, 1)
+	width := len(fmt.Sprint(first + len(lines) - 1))
+	numberStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+
+	var b strings.Builder
+	for i, line := range lines {
+		if i < len(f.blame) {
+			b.WriteString(numberStyle.Render(blameGutter(f.blame[i])))
+		}
+		b.WriteString(numberStyle.Render(fmt.Sprintf("%*d ", width, first+i)))
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// blameGutter is the short commit, author and date shown before a blamed line
+func blameGutter(line *gogit.Line) string {
+	author := []rune(line.AuthorName)
+	if len(author) > 16 {
+		author = author[:16]
+	}
+	return fmt.Sprintf("%s %-16s %s ", line.Hash.String()[:7], string(author), line.Date.Format("2006-01-02"))
+}
+
+// Title is shown above the file view
+func (f FileViewer) Title() string {
+	if f.blame != nil {
+		return fmt.Sprintf(" %s [blame] ", f.path)
+	}
+	return fmt.Sprintf(" %s [RO] ", f.path)
+}
+
+// highlightSource colours source using the same chroma lexers glamour uses for
+// code blocks in chat, falling back to plain text when no lexer matches
+func highlightSource(path, source string) string {
+	lexer := lexers.Match(path)
+	if lexer == nil {
+		lexer = lexers.Analyse(source)
+	}
+	if lexer == nil {
+		return source
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, source)
+	if err != nil {
+		return source
+	}
+	var buf bytes.Buffer
+	if err := formatters.TTY256.Format(&buf, styles.Get("monokai"), iterator); err != nil {
+		return source
+	}
+	return buf.String()
+}
@@ -13,14 +13,31 @@ type HelpWindow struct {
 	width  int
 	height int
 	topic  string
+
+	// Searchable index state
+	filter  string
+	matches []helpTopic
+	list    CompletionDialog
+}
+
+// helpTopic is an entry in the searchable help index
+type helpTopic struct {
+	Name  string
+	Title string
 }
 
+// helpTopicNames lists the topics shown in the help index, in display order
+var helpTopicNames = []string{"index", "modes", "commands", "navigation", "editing", "files", "sessions", "context", "models", "config", "quickref"}
+
 // NewHelpWindow creates a new help window
 func NewHelpWindow() HelpWindow {
+	list := NewCompletionDialog()
+	list.MaxHeight = 20
 	return HelpWindow{
 		width:  80,
 		height: 20,
 		topic:  "index",
+		list:   list,
 	}
 }
 
@@ -43,6 +60,53 @@ func (h *HelpWindow) GetTopic() string {
 	return h.topic
 }
 
+// OpenIndex resets the search filter and lists all topics
+func (h *HelpWindow) OpenIndex() {
+	h.list.Selected = 0
+	h.SetFilter("")
+	h.list.Show()
+}
+
+// SetFilter narrows the index to topics whose name or title contains filter
+func (h *HelpWindow) SetFilter(filter string) {
+	h.filter = filter
+	needle := strings.ToLower(filter)
+	h.matches = h.matches[:0]
+	var options []string
+	for _, name := range helpTopicNames {
+		content := h.getHelpTopic(name)
+		title, _, _ := strings.Cut(content, "\n")
+		t := helpTopic{Name: name, Title: strings.TrimPrefix(title, "# ")}
+		if needle != "" && !strings.Contains(strings.ToLower(t.Name+" "+t.Title), needle) {
+			continue
+		}
+		h.matches = append(h.matches, t)
+		options = append(options, fmt.Sprintf("%-12s %s", t.Name, t.Title))
+	}
+	h.list.SetOptions(options)
+}
+
+// GetFilter returns the current index search filter
+func (h *HelpWindow) GetFilter() string {
+	return h.filter
+}
+
+// SelectedTopic returns the topic highlighted in the index, or "" if nothing matches
+func (h *HelpWindow) SelectedTopic() string {
+	if h.list.Selected < 0 || h.list.Selected >= len(h.matches) {
+		return ""
+	}
+	return h.matches[h.list.Selected].Name
+}
+
+// RenderIndex renders the filtered topic list
+func (h *HelpWindow) RenderIndex() string {
+	if len(h.matches) == 0 {
+		return "  No matching help topics"
+	}
+	return h.list.View()
+}
+
 // RenderContent generates the styled help content for the current topic
 func (h *HelpWindow) RenderContent() string {
 	return h.renderHelpContent(h.topic)
@@ -194,6 +258,7 @@ Asimi uses ':' for sending commmands and starts in INSERT mode.
   Ctrl+d/Ctrl+u    - Scroll half page
   Ctrl+f/Ctrl+b    - Scroll full page
   g/G              - Go to top/bottom
+  /                - Search help topics, Enter opens the selected one
   q or ESC         - Close help
 
 ## Getting Help
@@ -274,6 +339,9 @@ Special mode for adding notes to AGENTS.md.
   In LEARNING mode:
     Type your note and press Enter to append to AGENTS.md
     ESC  - Cancel and return to NORMAL mode
+
+  When the notes pile up, :agents regenerate asks the model to merge and
+  deduplicate them.
 `
 
 const helpCommands = `# Commands
@@ -282,14 +350,59 @@ Commands are executed from COMMAND-LINE mode. Press : in NORMAL mode to enter
 COMMAND-LINE mode, then type the command and press Enter.
 
   :new              - Start a new conversation
-  :resume           - Resume a previous session
+  :resume           - Resume a previous session, --tag <name> lists the tagged ones
+                      and --all-branches those of the other branches too
+  :tag <name...>    - Tag the session to find it later, -name removes a tag
+  :sessions du      - Show the space the stored sessions take
   :quit             - Quit Asimi (also saves session)
+  :redraw           - Clear the screen and draw it again (also Ctrl+L)
   :update           - Check for and install updates
 
 ## Information
 
   :help [topic]     - Show help (optionally for a specific topic)
+  :keys             - List the key bindings of each mode
+  :whoami           - Show the provider, model, auth method, project and shell runner in use
+  :config [key]     - Show the effective config, or the keys under key, with where each value came from
   :context          - Show context usage and token information
+  :context limit    - Show context files loaded vs the configured limits
+  :context git      - Add the git status and changed files to the next prompt ({{git_status}} inline)
+  :context clear    - Remove the context files, kept across prompts with tools.sticky_context
+  :count @FILE|TEXT - Count the tokens of a file or some text
+  :use-result [N]   - List the tool results, or add result N to the next prompt
+  :encoding [name]  - Show or set the encoding of files that aren't UTF-8, e.g. latin-1
+  :attach-last      - Add the output of the last :!command to the context
+  :dump             - Show the exact messages sent to the model
+  :system           - View the system prompt with the environment and AGENTS.md filled in
+  :dump-last        - Write the raw requests and responses of the last turn to a JSON file
+  :open <path>      - View a file read-only, without adding it to the context
+  :blame <path>     - View who last changed each line of a file, add N-M for a line range
+  :perf             - Show prompt build, first token and total time of the last turn
+  :cost             - Show the tokens and cost of each turn of the session
+  :metrics          - Show the calls, errors and time of each tool over the session
+  :errors [N]       - List the session's tool and model errors, or jump to error N in the chat
+  :tool             - Call a tool directly with JSON arguments, bypassing the model
+                      (usage: :tool <name> <json-args>, e.g. :tool read_file {"path":"go.mod"})
+  :replace          - Replace text in the files matching a glob after previewing the diff
+                      (usage: :replace [-r] <glob> <old> <new>, -r for a regular expression)
+  :restore          - List the checkpoints taken before the model's edits
+  :restore <n>      - Revert the working tree to checkpoint n, undone by the checkpoint it adds
+  :changed [N]      - List the files changed in the last N commits (default 1)
+  :changed [N] add  - Add the files changed in the last N commits to the context
+  :raw              - Toggle the raw session view, like Ctrl+O
+  :raw filter TYPE  - Show only the raw entries of TYPE, e.g. TOOL or STREAM, none for all
+  :log-view         - Follow the tool calls and turns in a condensed log, :q to return
+  :loop [reset]     - Show the tool call loop counter, or reset it
+  :autosave on|off  - Pause or resume saving the session after each turn, add save to keep it
+  :plan             - Plan mode: the model outlines steps using read-only tools
+  :brief on|off     - Ask the model for replies of up to llm.brief_lines lines (default 10)
+  :think <n>|off    - Set the extended thinking token budget of Anthropic models, off turns it off
+  :gauge on|off     - Show the context usage as a bar colored by how full it is
+  :stream on|off    - Stream replies as they come, off shows each reply whole once complete
+  :wrap <cols>|auto - Wrap the chat at a fixed width for exports and screenshots, or the terminal's
+  :continue         - Ask the model to keep going, its reply extends the last one
+  :act              - Act mode: the model gets all its tools back
+  :act accept       - Act mode, the model carries out the plan of its last reply
 
 ## History
 
@@ -297,15 +410,28 @@ COMMAND-LINE mode, then type the command and press Enter.
 
 ## Export
 
+  :edit             - Edit the prompt in $EDITOR, the saved text replaces it
   :export [type]    - Export conversation to file and open in $EDITOR
-                      Types: conversation (default), full
+                      Types: conversation (default), full, json
+                      --pick chooses a stored session to export
 
 ## Configuration
 
   :models           - Select AI model
+  :endpoint         - Set up a custom OpenAI-compatible endpoint, checked before it's saved
+  :compare <a> <b> <prompt>
+                    - Run a prompt against two models, e.g. openai/gpt-4o
+  :bench <prompt>   - Time the first token and the reply of each provider you're logged in to
+  :sandbox on|off   - Run shell commands in the sandbox or on the host
+  :container [CMD]  - Show the sandbox container and its image, restart or stop it
+  :persona [name]   - Apply a persona from [personas.<name>] or list them
+  :profile [name]   - Switch to a provider and model from [profiles.<name>] or list them
 
   :init [clean]     - Initialize project with infrastructure files
                       Creates: AGENTS.md, Justfile, .agents/Sandbox
+  :agents regenerate
+                    - Tidy AGENTS.md with the model, preview the diff and
+                      write it on approval, keeping AGENTS.md.bak
 
 ## Examples
 
@@ -355,6 +481,9 @@ Navigation commands work in NORMAL mode.
 
   Mouse wheel      - Scroll chat history
   Touch gestures   - Scroll on touch devices
+  z or Enter       - Expand or fold a long message (SCROLL mode, see ui.fold_lines_over)
+  ]t / [t          - Jump to the next / previous tool call (SCROLL mode)
+  h/l or ←/→       - Scroll long lines sideways (SCROLL mode, see ui.wrap_mode)
 
 ## Help Navigation
 
@@ -422,8 +551,13 @@ Press # in NORMAL mode to enter LEARNING mode. Type a note and press Enter
 to append it to AGENTS.md. This is useful for teaching Asimi about your
 project conventions and preferences.
 
+Notes starting with todo: or bug: go under the TODO or Bugs section. Set
+session.learning_target to send notes to another file, or to "memory" for
+the project memory.
+
 Example:
   # We use snake_case for function names in this project
+  #todo: cover the parser's error paths
 `
 
 const helpFiles = `# File Operations
@@ -457,12 +591,31 @@ Type to filter:
   @mai             - Shows files matching "mai" (e.g., main.go)
   @src/            - Shows files in src/ directory
 
+## Viewing Files
+
+Use :open to read a file without loading it into the conversation context:
+
+  :open main.go    - Show main.go with line numbers and highlighting
+  j/k, CTRL-D/U    - Scroll
+  :q or ESC        - Close the file
+
+Binary files are rejected.
+
 ## Context Management
 
 Files you reference are added to the conversation context. Use :context to
 see what's currently in context:
 
   :context         - Show context usage and loaded files
+  :context limit   - Show files and bytes loaded vs tools.max_context_files
+                     and tools.max_context_bytes
+
+Files that would exceed these limits are refused.
+
+Context files are sent with your next prompt and then dropped. With
+tools.sticky_context they are sent with every prompt until you clear them:
+
+  :context clear   - Remove all the context files
 
 ## File Tools
 
@@ -495,11 +648,19 @@ conversation history and context.
 
   :resume          - Show list of recent sessions
                      Select one to resume
+  :resume --tag x  - Show only the sessions tagged with x
+  :resume --all-branches
+                   - Show the sessions of all branches, with their branch.
+                     One from another branch can be resumed by checking
+                     out its branch or imported into the current one
+  :tag <name...>   - Tag the current session and save it
 
 The session list shows:
   - First prompt from each session
   - Time since last update
   - Project/directory
+  - Tags, as #name
+  - The branch, with --all-branches
 
 Navigation in session list:
   ↓/↑              - Navigate sessions
@@ -522,6 +683,7 @@ Configure session behavior in ~/.config/asimi/asimi.conf:
   auto_save = true         # Auto-save after each message
   max_sessions = 50        # Maximum sessions to keep
   max_age_days = 30        # Delete sessions older than this
+  max_storage_bytes = 0    # Trim the oldest sessions to fit this size (0 = no limit)
   list_limit = 20          # Number of sessions to show in :resume
 
 ## Session Storage
@@ -540,8 +702,12 @@ Each session includes:
   :export              - Export conversation to file
   :export conversation - Export just the conversation
   :export full         - Export with full context
+  :export json         - Export the transcript as JSON, tool calls and reasoning included
+  :export --pick       - Choose a stored session to export, a type may follow
 
-The exported file opens in your $EDITOR for review or sharing.
+The exported file opens in your $EDITOR for review or sharing. From the shell,
+asimi export <session-id> [--format md|json] [-o file] writes a stored session
+and prints the path of the file.
 `
 
 const helpContext = `# Context and Token Usage
@@ -645,6 +811,12 @@ Authentication options:
 Custom endpoint:
   ANTHROPIC_BASE_URL=https://custom-endpoint.com
 
+### OpenAI-compatible endpoints
+Self-hosted servers such as vLLM, llama.cpp or LM Studio:
+
+  :endpoint        - Asks for the base URL, model and API key, lists the
+                     endpoint's models to check it and saves it on success
+
 ### Ollama (Local Models)
 Models: Any model you've pulled locally (llama2, codellama, etc.)
 
@@ -801,7 +973,7 @@ Examples:
   ASIMI_UI_MARKDOWN_ENABLED=true
 
 ### System
-  EDITOR                    - Text editor for :export
+  EDITOR                    - Text editor for :export and :edit
   SHELL                     - Shell for container sessions
 
 ### API Keys & Authentication
@@ -918,6 +1090,7 @@ const helpQuickRef = `# Quick Reference
   #note            - Add note to AGENTS.md
   Ctrl+C (2x)      - Quit (press twice quickly)
   Ctrl+Z           - Background Asimi
+  Ctrl+L           - Redraw the screen
   Ctrl+O           - Toggle raw session view
   ?                - Quick help (in NORMAL mode)
 
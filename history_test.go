@@ -282,15 +282,63 @@ func TestHistoryStore_EmptyPrompt(t *testing.T) {
 	store, err := NewPromptHistoryStore(db, repoInfo)
 	require.NoError(t, err)
 
-	// Try to append empty prompt (should still work - storage layer handles validation)
-	err = store.Append("")
-	require.NoError(t, err)
+	// Empty and whitespace-only prompts are skipped without an error
+	require.NoError(t, store.Append(""))
+	require.NoError(t, store.Append("  \n\t"))
 
-	// Verify it was stored
 	entries, err := store.Load()
 	require.NoError(t, err)
-	require.Len(t, entries, 1)
-	assert.Equal(t, "", entries[0].Content)
+	assert.Empty(t, entries)
+}
+
+// TestHistoryStore_Dedup tests each deduplication policy over prompts and commands with repeats
+func TestHistoryStore_Dedup(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sequence := []string{"build", "build", "test", "build", "test", "test", "lint"}
+	tests := []struct {
+		policy   string
+		expected []string
+	}{
+		{"none", sequence},
+		{"", sequence},
+		{"consecutive", []string{"build", "test", "build", "test", "lint"}},
+		{"global", []string{"build", "test", "lint"}},
+	}
+	contents := func(entries []storage.HistoryEntry) []string {
+		var out []string
+		for _, entry := range entries {
+			out = append(out, entry.Content)
+		}
+		return out
+	}
+
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			repoInfo := RepoInfo{ProjectRoot: "/test/project", Branch: "dedup-" + tt.policy}
+			prompts, err := NewPromptHistoryStore(db, repoInfo)
+			require.NoError(t, err)
+			commands, err := NewCommandHistoryStore(db, repoInfo)
+			require.NoError(t, err)
+			prompts.SetDedup(tt.policy)
+			commands.SetDedup(tt.policy)
+
+			for _, entry := range sequence {
+				require.NoError(t, prompts.Append(entry))
+				require.NoError(t, commands.Append(entry))
+			}
+
+			entries, err := prompts.Load()
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, contents(entries))
+			entries, err = commands.Load()
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, contents(entries))
+		})
+	}
 }
 
 // TestHistoryStore_LongPrompt tests handling of very long prompts
@@ -131,6 +131,12 @@ func SaveAPIKeyToKeyring(provider, apiKey string) error {
 	return nil
 }
 
+// endpointKeyringName is the keyring name of the API key of an OpenAI-compatible endpoint, kept
+// apart from the OpenAI key so that one is never overwritten or sent to another server
+func endpointKeyringName(baseURL string) string {
+	return "endpoint_" + strings.TrimRight(baseURL, "/")
+}
+
 // GetAPIKeyFromKeyring retrieves API keys from the OS keyring
 func GetAPIKeyFromKeyring(provider string) (string, error) {
 	key := "apikey_" + provider
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// keyAction is a binding listed by :keys
+type keyAction struct {
+	keys   []string
+	action string
+}
+
+// promptKeyActions lists the prompt editing bindings of a mode's keymap, leaving out the disabled ones
+func promptKeyActions(km textarea.KeyMap) []keyAction {
+	named := []struct {
+		binding key.Binding
+		action  string
+	}{
+		{km.CharacterBackward, "Move left"},
+		{km.CharacterForward, "Move right"},
+		{km.WordBackward, "Word back"},
+		{km.WordForward, "Word forward"},
+		{km.LineStart, "Start of line"},
+		{km.LineEnd, "End of line"},
+		{km.LinePrevious, "Line up"},
+		{km.LineNext, "Line down"},
+		{km.InputBegin, "Start of prompt"},
+		{km.InputEnd, "End of prompt"},
+		{km.InsertNewline, "New line"},
+		{km.DeleteCharacterBackward, "Delete character before"},
+		{km.DeleteCharacterForward, "Delete character"},
+		{km.DeleteWordBackward, "Delete word before"},
+		{km.DeleteWordForward, "Delete word"},
+		{km.DeleteBeforeCursor, "Delete to start of line"},
+		{km.DeleteAfterCursor, "Delete to end of line"},
+		{km.Paste, "Paste"},
+		{km.UppercaseWordForward, "Uppercase word"},
+		{km.LowercaseWordForward, "Lowercase word"},
+		{km.CapitalizeWordForward, "Capitalize word"},
+		{km.TransposeCharacterBackward, "Swap characters"},
+	}
+	var actions []keyAction
+	for _, n := range named {
+		if len(n.binding.Keys()) == 0 {
+			continue
+		}
+		actions = append(actions, keyAction{keys: n.binding.Keys(), action: n.action})
+	}
+	return actions
+}
+
+// Bindings handled by the TUI itself rather than the prompt's keymap
+var (
+	globalKeyActions = []keyAction{
+		{[]string{"ctrl+c"}, "Stop the reply, twice to quit"},
+		{[]string{"ctrl+z"}, "Background Asimi"},
+		{[]string{"ctrl+l"}, "Redraw the screen"},
+		{[]string{"ctrl+b"}, "Scroll mode"},
+	}
+	insertKeyActions = []keyAction{
+		{[]string{"esc"}, "Normal mode"},
+		{[]string{"ctrl+o"}, "Toggle raw session view"},
+		{[]string{":"}, "Command line, on an empty prompt"},
+		{[]string{"@"}, "Reference a file"},
+	}
+	normalKeyActions = []keyAction{
+		{[]string{"i", "a", "I", "A"}, "Insert mode"},
+		{[]string{"o", "O"}, "Insert on a new line"},
+		{[]string{"enter"}, "Send the prompt"},
+		{[]string{":"}, "Command line"},
+		{[]string{"?"}, "Quick help"},
+		{[]string{"#"}, "Add a note to the agents file"},
+	}
+	commandKeyActions = []keyAction{
+		{[]string{"enter"}, "Run the command"},
+		{[]string{"esc"}, "Cancel"},
+		{[]string{"tab", "ctrl+n"}, "Next completion"},
+		{[]string{"shift+tab", "ctrl+p"}, "Previous completion"},
+		{[]string{"up", "down"}, "Command history"},
+		{[]string{"home", "ctrl+a"}, "Start of line"},
+		{[]string{"end", "ctrl+e"}, "End of line"},
+	}
+	scrollKeyActions = []keyAction{
+		{[]string{"j", "down"}, "Line down"},
+		{[]string{"k", "up"}, "Line up"},
+		{[]string{"ctrl+d"}, "Half page down"},
+		{[]string{"ctrl+u"}, "Half page up"},
+		{[]string{"ctrl+f"}, "Page down"},
+		{[]string{"ctrl+b"}, "Page up"},
+		{[]string{"G"}, "Bottom"},
+		{[]string{"h", "left"}, "Scroll left"},
+		{[]string{"l", "right"}, "Scroll right"},
+		{[]string{"z", "enter"}, "Fold or unfold"},
+		{[]string{"]t", "[t"}, "Next or previous tool call"},
+		{[]string{"i", "esc"}, "Insert mode"},
+	}
+)
+
+// renderKeys lists the active bindings of every mode for :keys, the prompt's taken from its keymaps
+func renderKeys(prompt PromptComponent) string {
+	msg := NewChatMsgBuilder(systemPrefix)
+	section := func(title string, groups ...[]keyAction) {
+		msg.WriteLn("")
+		msg.WriteLn(title)
+		for _, group := range groups {
+			for _, a := range group {
+				msg.WriteLnf("  %-22s %s", strings.Join(a.keys, ", "), a.action)
+			}
+		}
+	}
+	msg.WriteLn("Key bindings")
+	section("## Everywhere", globalKeyActions)
+	section("## Insert", insertKeyActions, promptKeyActions(prompt.viInsertKeyMap))
+	section("## Normal", normalKeyActions, promptKeyActions(prompt.viNormalKeyMap))
+	section("## Command line", commandKeyActions)
+	section("## Scroll", scrollKeyActions)
+	return msg.String()
+}
+
+func handleKeysCommand(model *TUIModel, args []string) tea.Cmd {
+	content := renderKeys(model.prompt)
+	return func() tea.Msg { return showContextMsg{content: content} }
+}
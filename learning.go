@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// learningTargetMemory sends # notes to the project memory instead of a file
+const learningTargetMemory = "memory"
+
+// learningSections maps the categories of a "#todo: ..." style note to the section it goes under
+var learningSections = map[string]string{
+	"todo": "## TODO",
+	"bug":  "## Bugs",
+}
+
+// learningTarget names where # notes go: session.learning_target, or else the agents file
+func learningTarget(cfg *Config) string {
+	if cfg != nil && cfg.Session.LearningTarget != "" {
+		return cfg.Session.LearningTarget
+	}
+	return agentsFileName(cfg)
+}
+
+// parseLearningNote splits the category off a note like "todo: add tests", returning an empty
+// category for notes that have none
+func parseLearningNote(note string) (category, text string) {
+	if prefix, rest, ok := strings.Cut(note, ":"); ok {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if _, known := learningSections[prefix]; known {
+			return prefix, strings.TrimSpace(rest)
+		}
+	}
+	return "", note
+}
+
+// saveLearningNote stores a # note in the learning target, a categorized note under its
+// section, and returns where it went
+func saveLearningNote(cfg *Config, note string) (string, error) {
+	category, text := parseLearningNote(note)
+	target := learningTarget(cfg)
+	if target == learningTargetMemory {
+		return saveLearningMemory(category, text)
+	}
+
+	if category == "" {
+		f, err := os.OpenFile(target, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		_, err = f.WriteString("\n" + text + "\n")
+		return target, err
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	section := learningSections[category]
+	content := addToSection(string(data), section, "- "+text)
+	return fmt.Sprintf("%s, %s", target, strings.TrimPrefix(section, "## ")), os.WriteFile(target, []byte(content), 0644)
+}
+
+// addToSection adds line at the end of the markdown section headed by heading, adding the
+// section at the end of the content when it's missing
+func addToSection(content, heading, line string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	start := slices.Index(lines, heading)
+	if start < 0 {
+		if strings.TrimSpace(content) == "" {
+			return heading + "\n\n" + line + "\n"
+		}
+		return strings.TrimRight(content, "\n") + "\n\n" + heading + "\n\n" + line + "\n"
+	}
+	end := len(lines)
+	for i := start + 1; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "#") {
+			end = i
+			break
+		}
+	}
+	// After the section's last non-blank line
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	lines = slices.Insert(lines, end, line)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// saveLearningMemory keeps a note in the project memory under its category and the time it was taken
+func saveLearningMemory(category, text string) (string, error) {
+	memory := currentProjectMemory
+	if memory == nil {
+		return "", fmt.Errorf("project memory is not available")
+	}
+	base := fmt.Sprintf("%s-%s", cmp.Or(category, "note"), time.Now().Format("20060102-150405"))
+	key := base
+	for n := 2; ; n++ {
+		_, taken, err := memory.Get(key)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			break
+		}
+		key = fmt.Sprintf("%s-%d", base, n)
+	}
+	if err := memory.Set(key, text); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("project memory as %s", key), nil
+}
@@ -608,19 +608,19 @@ func (m *TUIModel) performOAuthLogin(provider string) tea.Cmd {
 		m.config.LLM.RefreshToken = refresh
 		slog.Debug("In performaOAuthLogin", "auth token", token, "refresh", refresh)
 		if err := UpdateUserOAuthTokens(provider, token, refresh, expiry); err != nil {
-			m.commandLine.AddToast("Authorized, but failed to persist token", "error", 4000)
+			m.commandLine.AddToast("Authorized, but failed to persist token", "error", time.Second*4)
 		}
 
 		// Reinitialize LLM and session with new credentials
 		if err := m.reinitializeSession(); err != nil {
-			m.commandLine.AddToast("Failed to initialize AI session: "+err.Error(), "error", 5000)
+			m.commandLine.AddToast("Failed to initialize AI session: "+err.Error(), "error", time.Second*5)
 			return showOauthFailed{err.Error()}
 		}
 
 		// Update status line
 		m.status.SetAgent(provider + " (" + m.config.LLM.Model + ")")
 		m.content.Chat.AddMessage("Authenticated with " + provider + ", model: " + m.config.LLM.Model)
-		m.commandLine.AddToast("Authentication saved", "info", 2500)
+		m.commandLine.AddToast("Authentication saved", "info", 2500*time.Millisecond)
 		m.sessionActive = true
 		return nil
 	}
@@ -632,7 +632,7 @@ func (m *TUIModel) completeAnthropicOAuth(authCode, verifier string) tea.Cmd {
 		auth := &AuthAnthropic{}
 
 		// Exchange code for tokens
-		m.commandLine.AddToast("Exchanging authorization code for tokens...", "success", 3000)
+		m.commandLine.AddToast("Exchanging authorization code for tokens...", "success", time.Second*3)
 		m.content.Chat.AddMessage("")
 		tokens, err := auth.exchange(authCode, verifier)
 		if err != nil {
@@ -658,13 +658,13 @@ func (m *TUIModel) completeAnthropicOAuth(authCode, verifier string) tea.Cmd {
 
 		// Reinitialize LLM and session with new credentials
 		if err := m.reinitializeSession(); err != nil {
-			m.commandLine.AddToast("Failed to initialize AI session: "+err.Error(), "error", 5000)
+			m.commandLine.AddToast("Failed to initialize AI session: "+err.Error(), "error", time.Second*5)
 			return showOauthFailed{err.Error()}
 		}
 
 		// Update status and UI
 		m.status.SetAgent("anthropic (" + m.config.LLM.Model + ")")
-		m.commandLine.AddToast("✅ Anthropic Authenticated using Oauth", "info", 2500)
+		m.commandLine.AddToast("✅ Anthropic Authenticated using Oauth", "info", 2500*time.Millisecond)
 		m.sessionActive = true
 
 		// Show model selection modal after successful authentication
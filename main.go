@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -17,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/afittestide/asimi/storage"
 	"github.com/alecthomas/kong"
 	tea "github.com/charmbracelet/bubbletea"
 	isatty "github.com/mattn/go-isatty"
@@ -44,11 +46,39 @@ var cli struct {
 	MemProfile    string `help:"Write memory profile to file"`
 	Trace         string `help:"Write execution trace to file"`
 	ProfileExitMs int    `help:"Exit after N milliseconds (for profiling startup)"`
+	Persona       string `help:"Start the session with a persona from the config"`
+	Profile       string `help:"Start with a provider and model profile from the config"`
+	Yes           bool   `short:"y" help:"With -p, run the tools the permissions config asks about instead of denying them"`
+
+	Chat   chatCmd   `cmd:"" default:"withargs" help:"Start the chat, or run the prompt of -p (the default)"`
+	Export exportCmd `cmd:"" help:"Export a stored session to a file"`
+}
+
+// chatCmd is asimi without a command, the chat or with -p a headless run
+type chatCmd struct {
+	Output string `enum:"text,json" default:"text" help:"With -p, print the reply as text or as JSON lines of events for scripts (text|json)"`
+}
+
+// exportCmd is asimi export, writing a stored session to a file as :export does
+type exportCmd struct {
+	SessionID string `arg:"" help:"ID of the session to export, as :resume lists them"`
+	Format    string `enum:"md,json" default:"md" help:"Markdown with the full context, or the JSON transcript (md|json)"`
+	Output    string `short:"o" help:"File to write, by default one in the temp dir"`
+}
+
+// exportFormats maps the formats of asimi export to their export types
+var exportFormats = map[string]ExportType{
+	"md":   ExportTypeFull,
+	"json": ExportTypeJSON,
 }
 
+// logFilePath is where initLogger writes the log, shown in crash messages
+var logFilePath string
+
 func initLogger() {
 	var logDir string
 	var logPath string
+	defer func() { logFilePath = logPath }()
 
 	// Determine log directory and path
 	if cli.Debug {
@@ -59,7 +89,7 @@ func initLogger() {
 		if err != nil {
 			panic(fmt.Errorf("failed to open log file %s: %w", logPath, err))
 		}
-		slog.SetDefault(slog.New(slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		slog.SetDefault(slog.New(slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactLogAttr})))
 	} else {
 		// In production mode, log to user's data directory
 		homeDir, err := os.UserHomeDir()
@@ -78,7 +108,7 @@ func initLogger() {
 			MaxAge:     28, // days
 			Compress:   true,
 		}
-		slog.SetDefault(slog.New(slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: slog.LevelInfo})))
+		slog.SetDefault(slog.New(slog.NewTextHandler(logFile, &slog.HandlerOptions{Level: slog.LevelInfo, ReplaceAttr: redactLogAttr})))
 	}
 }
 
@@ -88,7 +118,7 @@ func runInteractiveMode() error {
 	// Check if we are running in a terminal (skip check if profiling with auto-exit)
 	if cli.ProfileExitMs == 0 && !isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsTerminal(os.Stdin.Fd()) {
 		fmt.Println("This program requires a terminal to run.")
-		fmt.Println("Please run it in a terminal emulator.")
+		fmt.Println("Please run it in a terminal emulator, or pipe a prompt to run it headless: echo \"fix lint\" | asimi")
 		return nil
 	}
 
@@ -118,13 +148,14 @@ func runInteractiveMode() error {
 			ProvidePromptHistory,
 			ProvideCommandHistory,
 			ProvideSessionHistory,
+			ProvideProjectMemory,
 			ProvideTUIModel,
 			StartTUI,
 		),
 		fx.Invoke(
 			ProvideModelClient,
 		),
-		fx.Populate(&currentShellRunner, &tuiProgram),
+		fx.Populate(&currentShellRunner, &currentProjectMemory, &tuiProgram),
 	)
 
 	// Create fx app with all providers
@@ -159,6 +190,9 @@ func runInteractiveMode() error {
 
 	_, runErr := tuiProgram.Run()
 
+	if tuiCrash != nil {
+		return crashError(logFilePath)
+	}
 	if runErr != nil {
 		return fmt.Errorf("alas, there's been an error: %w", runErr)
 	}
@@ -185,17 +219,27 @@ type compactCompleteMsg struct {
 	summary string
 }
 
+// compactPreviewMsg is sent when the summary of :compact --diff is ready to review
+type compactPreviewMsg struct {
+	preview CompactPreview
+}
+
 // compactErrorMsg is sent when conversation compaction fails
 type compactErrorMsg struct {
 	err error
 }
 
+// compactProgressMsg reports how much of the summary a running compaction has generated
+type compactProgressMsg struct {
+	chars int
+}
+
 // updateAvailableMsg is sent when a newer version is available
 type updateAvailableMsg struct{}
 
 func main() {
 	startTime := time.Now()
-	kong.Parse(&cli)
+	ctx := kong.Parse(&cli)
 
 	// Handle --version flag
 	if cli.Version {
@@ -203,6 +247,21 @@ func main() {
 		os.Exit(0)
 	}
 
+	if ctx.Command() == "export <session-id>" {
+		config, err := LoadConfig()
+		if err != nil {
+			fmt.Printf("Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+		path, err := runExport(config, cli.Export)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(path)
+		os.Exit(0)
+	}
+
 	// Start profiling if requested
 	if cli.CPUProfile != "" {
 		f, err := os.Create(cli.CPUProfile)
@@ -243,24 +302,16 @@ func main() {
 	// Non-interactive mode is triggered by:
 	// 1. Explicit -p flag: asimi -p "prompt here"
 	// 2. Non-interactive stdin (pipe/redirect): echo "prompt" | asimi
-	isStdinTerminal := isatty.IsTerminal(os.Stdin.Fd())
 	hasPromptArg := cli.Prompt != ""
 
 	// If no -p flag but stdin is not a terminal, read from stdin
-	if !hasPromptArg && !isStdinTerminal {
-		// Read prompt from stdin
-		var builder strings.Builder
-		buf := make([]byte, 4096)
-		for {
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				builder.Write(buf[:n])
-			}
-			if err != nil {
-				break
-			}
+	if !hasPromptArg {
+		prompt, err := readPipedPrompt(os.Stdin)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		cli.Prompt = strings.TrimSpace(builder.String())
+		cli.Prompt = prompt
 		hasPromptArg = cli.Prompt != ""
 	}
 
@@ -281,8 +332,24 @@ func main() {
 			os.Exit(1)
 		}
 
+		if cli.Persona != "" {
+			config.Session.Persona = cli.Persona
+			config.setSource("session.persona", ConfigSourceFlag)
+		}
+		if cli.Profile != "" {
+			if err := config.ApplyProfile(cli.Profile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 		// Initialize shell runner with config
 		initShellRunner(config)
+		initRedactor(config)
+		initWriteAllowlist(config)
+		initFileEncoding(config)
+		initShowHidden(config)
+		initRequestLimit(config)
 
 		llm, err := getModelClient(config)
 		if err != nil {
@@ -290,24 +357,10 @@ func main() {
 			fmt.Printf("Please authenticate by running the program in interactive mode and ':models'\n")
 			os.Exit(1)
 		}
-		// Set up streaming for non-interactive mode
-		done := make(chan struct{})
-		var finalResponse strings.Builder
-		var mu sync.Mutex
-
-		repoInfo := GetRepoInfo()
-		sess, err := NewSession(llm, config, repoInfo, consoleStreamingNotify(done, &finalResponse, &mu))
-		if err != nil {
-			fmt.Printf("Error creating session: %v\n", err)
+		if err := runHeadless(llm, config, GetRepoInfo(), cli.Prompt, cli.Yes, cli.Chat.Output == "json"); err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
-
-		// Start streaming
-		sess.AskStream(context.Background(), cli.Prompt)
-
-		// Wait for streaming to complete
-		<-done
-
 		os.Exit(0)
 	}
 
@@ -336,6 +389,74 @@ func main() {
 	slog.Debug("[TIMING] Total execution time", "duration", time.Since(startTime))
 }
 
+// readPipedPrompt reads the prompt piped into stdin, e.g. `echo "fix lint" | asimi`.
+// It returns "" when stdin is a terminal.
+func readPipedPrompt(stdin *os.File) (string, error) {
+	if isatty.IsTerminal(stdin.Fd()) {
+		return "", nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading the prompt from stdin: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// runHeadless sends a single prompt to a new session and streams the reply to
+// stdout, returning once the turn is over. With no one to ask, the tools with the ask
+// permission are denied unless approveTools.
+func runHeadless(llm llms.Model, config *Config, repoInfo RepoInfo, prompt string, approveTools, jsonOutput bool) error {
+	done := make(chan struct{})
+	var finalResponse strings.Builder
+	var mu sync.Mutex
+
+	notify := consoleStreamingNotify(done, &finalResponse, &mu)
+	if jsonOutput {
+		notify = jsonStreamingNotify(done, os.Stdout)
+	}
+	sess, err := NewSession(llm, config, repoInfo, notify)
+	if err != nil {
+		return fmt.Errorf("creating session: %w", err)
+	}
+	sess.SetApproveTools(approveTools)
+	sess.AskStream(context.Background(), prompt)
+	<-done
+	return nil
+}
+
+// runExport writes the stored session of cmd to a file and returns its path
+func runExport(config *Config, cmd exportCmd) (string, error) {
+	exportType, ok := exportFormats[cmd.Format]
+	if !ok {
+		return "", fmt.Errorf("unknown export format: %s", cmd.Format)
+	}
+
+	db, err := storage.InitDB(config.Storage.DatabasePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer db.Close()
+
+	// No limits, so that an export only reads and never cleans up the sessions
+	store, err := NewSessionStore(db, GetRepoInfo(), 0, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session store: %w", err)
+	}
+	defer store.Close()
+
+	session, err := store.LoadSession(cmd.SessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load session %s: %w", cmd.SessionID, err)
+	}
+	if session == nil {
+		return "", fmt.Errorf("session %s not found", cmd.SessionID)
+	}
+	// The security settings of the config apply to the export
+	session.systemConfig = config
+
+	return writeExport(session, exportType, cmd.Output)
+}
+
 // formatToolCall formats a tool call according to the spec: two lines with ⏺ and ⎿ symbols
 func formatToolCall(toolName, icon string, input, result string, err error) string {
 	// Parse input JSON to extract key parameters for the first line
@@ -406,6 +527,12 @@ func consoleStreamingNotify(done chan struct{}, finalResponse *strings.Builder,
 			mu.Lock()
 			finalResponse.WriteString(chunk)
 			mu.Unlock()
+		case streamEmptyResponseMsg:
+			slog.Debug("console streaming empty response")
+			fmt.Print(emptyResponsePlaceholder)
+			mu.Lock()
+			finalResponse.WriteString(emptyResponsePlaceholder)
+			mu.Unlock()
 		case streamCompleteMsg:
 			fmt.Println() // Add newline after streaming
 			slog.Debug("console streaming completed")
@@ -429,6 +556,74 @@ func consoleStreamingNotify(done chan struct{}, finalResponse *strings.Builder,
 	}
 }
 
+// headlessEvent is a line of --output json: a tool_call, tool_result, assistant_chunk, or the
+// final event with the whole reply and how the turn ended
+type headlessEvent struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id,omitempty"`
+	Tool   string          `json:"tool,omitempty"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Output *string         `json:"output,omitempty"`
+	Text   string          `json:"text,omitempty"`
+	Status string          `json:"status,omitempty"` // of final: complete, interrupted, truncated, max_turns or error
+	Error  string          `json:"error,omitempty"`
+}
+
+// jsonStreamingNotify writes the events of a headless run to w as JSON lines, for scripts
+func jsonStreamingNotify(done chan struct{}, w io.Writer) func(any) {
+	var mu sync.Mutex
+	var reply strings.Builder
+	encoder := json.NewEncoder(w)
+	emit := func(event headlessEvent) {
+		if err := encoder.Encode(event); err != nil {
+			slog.Error("cannot write event", "type", event.Type, "error", err)
+		}
+	}
+	finish := func(event headlessEvent) {
+		event.Type = "final"
+		event.Text = reply.String()
+		emit(event)
+		close(done)
+	}
+
+	return func(m any) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch v := m.(type) {
+		case ToolCallScheduledMsg:
+			args := json.RawMessage(v.Call.Input)
+			if !json.Valid(args) {
+				args, _ = json.Marshal(v.Call.Input)
+			}
+			emit(headlessEvent{Type: "tool_call", ID: v.Call.ID, Tool: v.Call.Tool.Name(), Args: args})
+		case ToolCallSuccessMsg:
+			emit(headlessEvent{Type: "tool_result", ID: v.Call.ID, Tool: v.Call.Tool.Name(), Output: &v.Call.Result})
+		case ToolCallErrorMsg:
+			event := headlessEvent{Type: "tool_result", ID: v.Call.ID, Tool: v.Call.Tool.Name()}
+			if v.Call.Error != nil {
+				event.Error = v.Call.Error.Error()
+			}
+			emit(event)
+		case streamChunkMsg:
+			reply.WriteString(string(v))
+			emit(headlessEvent{Type: "assistant_chunk", Text: string(v)})
+		case streamEmptyResponseMsg:
+			reply.WriteString(emptyResponsePlaceholder)
+			emit(headlessEvent{Type: "assistant_chunk", Text: emptyResponsePlaceholder})
+		case streamCompleteMsg:
+			finish(headlessEvent{Status: "complete"})
+		case streamInterruptedMsg:
+			finish(headlessEvent{Status: "interrupted"})
+		case streamErrorMsg:
+			finish(headlessEvent{Status: "error", Error: v.err.Error()})
+		case streamMaxTokensReachedMsg:
+			finish(headlessEvent{Status: "truncated", Error: "response truncated due to length limit"})
+		case streamMaxTurnsExceededMsg:
+			finish(headlessEvent{Status: "max_turns", Error: fmt.Sprintf("stopped after %d turns", v.maxTurns)})
+		}
+	}
+}
+
 // toolCallDisplay manages the display of a tool call with dynamic status updates
 type toolCallDisplay struct {
 	toolName string
@@ -527,6 +722,12 @@ func (d *toolCallDisplay) formatWithStatus() string {
 
 // getModelClient creates and returns an LLM client based on the configuration
 func getModelClient(config *Config) (llms.Model, error) {
+	keyringName := config.LLM.Provider
+	if config.LLM.Provider == "openai" && config.LLM.BaseURL != "" {
+		// An endpoint has its own key, the OpenAI one must not go to an arbitrary server
+		keyringName = endpointKeyringName(config.LLM.BaseURL)
+	}
+
 	// First try to load tokens from keyring if not already in config
 	if config.LLM.AuthToken == "" && config.LLM.APIKey == "" {
 		// Try OAuth tokens first
@@ -544,7 +745,7 @@ func getModelClient(config *Config) (llms.Model, error) {
 				if !refreshOAuthToken(config) {
 					// Refresh failed - fall back to API key
 					slog.Warn("Token refresh failed, falling back to API key", "provider", config.LLM.Provider)
-					apiKey, err := GetAPIKeyFromKeyring(config.LLM.Provider)
+					apiKey, err := GetAPIKeyFromKeyring(keyringName)
 					if err == nil && apiKey != "" {
 						config.LLM.APIKey = apiKey
 					}
@@ -554,7 +755,7 @@ func getModelClient(config *Config) (llms.Model, error) {
 			}
 		} else {
 			// No token data found - try API key from keyring
-			apiKey, err := GetAPIKeyFromKeyring(config.LLM.Provider)
+			apiKey, err := GetAPIKeyFromKeyring(keyringName)
 			if err == nil && apiKey != "" {
 				config.LLM.APIKey = apiKey
 			}
@@ -577,6 +778,9 @@ func getModelClient(config *Config) (llms.Model, error) {
 		if config.LLM.BaseURL != "" {
 			opts = append(opts, ollama.WithServerURL(config.LLM.BaseURL))
 		}
+		if len(config.LLM.Headers) > 0 {
+			opts = append(opts, ollama.WithHTTPClient(llmHTTPClient(config, http.DefaultTransport)))
+		}
 
 		return ollama.New(opts...)
 	case "openai":
@@ -587,11 +791,18 @@ func getModelClient(config *Config) (llms.Model, error) {
 
 		if config.LLM.APIKey != "" {
 			opts = append(opts, openai.WithToken(config.LLM.APIKey))
+		} else if config.LLM.BaseURL != "" {
+			// Self-hosted endpoints often take no key, but the client requires one and would
+			// otherwise send OPENAI_API_KEY to the endpoint
+			opts = append(opts, openai.WithToken("none"))
 		}
 
 		if config.LLM.BaseURL != "" {
 			opts = append(opts, openai.WithBaseURL(config.LLM.BaseURL))
 		}
+		if len(config.LLM.Headers) > 0 {
+			opts = append(opts, openai.WithHTTPClient(llmHTTPClient(config, http.DefaultTransport)))
+		}
 
 		return openai.New(opts...)
 	case "anthropic":
@@ -611,16 +822,17 @@ func getModelClient(config *Config) (llms.Model, error) {
 			opts = append(opts, anthropic.WithToken("oauth-placeholder"))
 
 			// Create custom HTTP client with OAuth transport
-			httpClient := &http.Client{
-				Transport: &anthropicOAuthTransport{
-					token:  accessToken,
-					config: config,
-					base:   http.DefaultTransport,
-				},
-			}
+			httpClient := llmHTTPClient(config, &anthropicOAuthTransport{
+				token:  accessToken,
+				config: config,
+				base:   http.DefaultTransport,
+			})
 			opts = append(opts, anthropic.WithHTTPClient(httpClient))
 		} else if config.LLM.APIKey != "" {
 			opts = append(opts, anthropic.WithToken(config.LLM.APIKey))
+			if len(config.LLM.Headers) > 0 {
+				opts = append(opts, anthropic.WithHTTPClient(llmHTTPClient(config, http.DefaultTransport)))
+			}
 		}
 
 		if config.LLM.BaseURL != "" {
@@ -642,6 +854,14 @@ func getModelClient(config *Config) (llms.Model, error) {
 			googleai.WithDefaultModel(config.LLM.Model),
 			googleai.WithAPIKey(apiKey),
 		}
+		if len(config.LLM.Headers) > 0 {
+			// A custom HTTP client replaces the API key option, so send the key as a header
+			httpClient := llmHTTPClient(config, &headersTransport{
+				headers: map[string]string{"x-goog-api-key": apiKey},
+				base:    http.DefaultTransport,
+			})
+			opts = append(opts, googleai.WithHTTPClient(httpClient))
+		}
 
 		return googleai.New(context.Background(), opts...)
 	default:
@@ -744,6 +964,39 @@ func (t *anthropicOAuthTransport) RoundTrip(req *http.Request) (*http.Response,
 	return t.base.RoundTrip(r)
 }
 
+// headersTransport adds the configured extra headers to every request
+type headersTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Clone request to avoid mutating caller's request
+	r := req.Clone(req.Context())
+	for name, value := range t.headers {
+		r.Header.Set(name, value)
+	}
+
+	if t.base == nil {
+		t.base = http.DefaultTransport
+	}
+	return t.base.RoundTrip(r)
+}
+
+// llmHTTPClient returns an HTTP client sending config.LLM.Headers on top of the base transport.
+// Headers set by the base transport (e.g. OAuth Authorization) take precedence.
+func llmHTTPClient(config *Config, base http.RoundTripper) *http.Client {
+	if len(config.LLM.Headers) == 0 {
+		return &http.Client{Transport: base}
+	}
+	return &http.Client{
+		Transport: &headersTransport{
+			headers: config.LLM.Headers,
+			base:    base,
+		},
+	}
+}
+
 // anthropicAPIKeyTransport adds beta headers for API key authentication
 type anthropicAPIKeyTransport struct {
 	base http.RoundTripper
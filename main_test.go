@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/afittestide/asimi/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestGetModelClientSendsConfiguredHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		baseURL  func(server string) string
+		response string
+	}{
+		{
+			name:     "openai",
+			provider: "openai",
+			baseURL:  func(server string) string { return server + "/v1" },
+			response: `{"id":"1","object":"chat.completion","created":0,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`,
+		},
+		{
+			name:     "anthropic",
+			provider: "anthropic",
+			baseURL:  func(server string) string { return server + "/v1" },
+			response: `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			config := &Config{LLM: LLMConfig{
+				Provider: tt.provider,
+				Model:    "test-model",
+				APIKey:   "test-key",
+				BaseURL:  tt.baseURL(server.URL),
+				Headers: map[string]string{
+					"X-Org-Id":            "acme",
+					"Proxy-Authorization": "Basic dXNlcjpwYXNz",
+				},
+			}}
+
+			llm, err := getModelClient(config)
+			require.NoError(t, err)
+
+			_, err = llms.GenerateFromSinglePrompt(context.Background(), llm, "hello")
+			require.NoError(t, err)
+			require.NotNil(t, got, "request should reach the server")
+			require.Equal(t, "acme", got.Get("X-Org-Id"))
+			require.Equal(t, "Basic dXNlcjpwYXNz", got.Get("Proxy-Authorization"))
+		})
+	}
+}
+
+func TestLLMHTTPClientKeepsBaseTransportHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	config := &Config{LLM: LLMConfig{Headers: map[string]string{
+		"X-Org-Id":       "acme",
+		"anthropic-beta": "overridden",
+	}}}
+	client := llmHTTPClient(config, &anthropicAPIKeyTransport{base: http.DefaultTransport})
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, "acme", got.Get("X-Org-Id"))
+	require.Contains(t, got.Get("anthropic-beta"), "claude-code-20250219", "base transport headers take precedence")
+}
+
+// promptRecordingLLM records the human messages it is asked to answer
+type promptRecordingLLM struct {
+	sessionMockLLM
+	prompts []string
+}
+
+func (m *promptRecordingLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	for _, msg := range messages {
+		if msg.Role != llms.ChatMessageTypeHuman {
+			continue
+		}
+		for _, part := range msg.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				m.prompts = append(m.prompts, text.Text)
+			}
+		}
+	}
+	return m.sessionMockLLM.GenerateContent(ctx, messages, options...)
+}
+
+func TestHeadlessPipedPrompt(t *testing.T) {
+	t.Chdir(t.TempDir())
+	stdin, pipe, err := os.Pipe()
+	require.NoError(t, err)
+	_, err = pipe.WriteString("  fix lint\n")
+	require.NoError(t, err)
+	require.NoError(t, pipe.Close())
+
+	prompt, err := readPipedPrompt(stdin)
+	require.NoError(t, err)
+	require.Equal(t, "fix lint", prompt)
+
+	out, stdout, err := os.Pipe()
+	require.NoError(t, err)
+	realStdout := os.Stdout
+	os.Stdout = stdout
+	t.Cleanup(func() { os.Stdout = realStdout })
+
+	llm := &promptRecordingLLM{sessionMockLLM: sessionMockLLM{response: "Lint fixed"}}
+	require.NoError(t, runHeadless(llm, &Config{}, RepoInfo{}, prompt, false, false))
+	os.Stdout = realStdout
+	require.NoError(t, stdout.Close())
+	printed, err := io.ReadAll(out)
+	require.NoError(t, err)
+
+	require.Contains(t, strings.Join(llm.prompts, "\n"), "fix lint", "the piped prompt is sent to the model")
+	require.Contains(t, string(printed), "Lint fixed", "the reply is streamed to stdout")
+}
+
+func TestHeadlessJSONOutput(t *testing.T) {
+	run := func(llm llms.Model) []headlessEvent {
+		out, stdout, err := os.Pipe()
+		require.NoError(t, err)
+		realStdout := os.Stdout
+		os.Stdout = stdout
+		defer func() { os.Stdout = realStdout }()
+
+		require.NoError(t, runHeadless(llm, &Config{}, repoInfoWithProjectRoot(t), "read the test file", false, true))
+		os.Stdout = realStdout
+		require.NoError(t, stdout.Close())
+		printed, err := io.ReadAll(out)
+		require.NoError(t, err)
+		require.NotContains(t, string(printed), "\033[", "no ANSI decoration")
+
+		var events []headlessEvent
+		for _, line := range strings.Split(strings.TrimSpace(string(printed)), "\n") {
+			var event headlessEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event), "every line is a JSON event: %q", line)
+			events = append(events, event)
+		}
+		require.Equal(t, "final", events[len(events)-1].Type)
+		return events
+	}
+
+	// Reads testdata/test.txt with read_file
+	events := run(&sessionMockLLM{})
+	require.Equal(t, "tool_call", events[0].Type)
+	require.Equal(t, "read_file", events[0].Tool)
+	require.JSONEq(t, `{"path":"testdata/test.txt"}`, string(events[0].Args))
+	require.Equal(t, "tool_result", events[1].Type)
+	require.Equal(t, events[0].ID, events[1].ID)
+	require.NotNil(t, events[1].Output)
+	require.Contains(t, *events[1].Output, "This is a test file.")
+
+	// The streamed reply comes in chunks, whole in the final event
+	events = run(&sessionMockLLM{response: "Lint fixed"})
+	var chunks strings.Builder
+	for _, event := range events[:len(events)-1] {
+		require.Equal(t, "assistant_chunk", event.Type)
+		chunks.WriteString(event.Text)
+	}
+	final := events[len(events)-1]
+	require.Equal(t, "complete", final.Status)
+	require.Equal(t, chunks.String(), final.Text)
+	require.Contains(t, final.Text, "Lint fixed")
+}
+
+func TestRunExport(t *testing.T) {
+	tempDir := t.TempDir()
+	config := &Config{Storage: StorageConfig{DatabasePath: filepath.Join(tempDir, "asimi.sqlite")}}
+	db, err := storage.InitDB(config.Storage.DatabasePath)
+	require.NoError(t, err)
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir}, 50, 30)
+	require.NoError(t, err)
+	sess := &Session{
+		ID:           "export-me",
+		WorkingDir:   tempDir,
+		Messages:     []llms.MessageContent{{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextPart("hello there")}}},
+		ContextFiles: make(map[string]string),
+	}
+	require.NoError(t, store.SaveSessionSync(sess))
+	store.Close()
+	require.NoError(t, db.Close())
+
+	out := filepath.Join(tempDir, "export.md")
+	path, err := runExport(config, exportCmd{SessionID: "export-me", Format: "md", Output: out})
+	require.NoError(t, err)
+	require.Equal(t, out, path)
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "hello there")
+	require.Contains(t, string(data), "**Export Type:** full")
+
+	out = filepath.Join(tempDir, "export.json")
+	_, err = runExport(config, exportCmd{SessionID: "export-me", Format: "json", Output: out})
+	require.NoError(t, err)
+	data, err = os.ReadFile(out)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"session_id": "export-me"`)
+
+	_, err = runExport(config, exportCmd{SessionID: "missing", Format: "md", Output: out})
+	require.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -266,7 +267,7 @@ func fetchAllModels(config *Config) []Model {
 
 	// Fetch Ollama models (local, no auth required)
 	if ollamaAvailable {
-		ollamaModels, err := fetchOllamaModels(config)
+		ollamaModels, err := fetchOllamaModels(context.Background(), config)
 		if err == nil && len(ollamaModels) > 0 {
 			for _, m := range ollamaModels {
 				status := "ready"
@@ -308,6 +309,51 @@ func fetchAllModels(config *Config) []Model {
 	return allModels
 }
 
+// providerModelIDs lists the models the provider offers, for suggesting one when the configured
+// model isn't found. It's replaced in tests.
+var providerModelIDs = func(config *Config) ([]string, error) {
+	var ids []string
+	switch config.LLM.Provider {
+	case "anthropic":
+		models, err := fetchAnthropicModels(config)
+		for _, m := range models {
+			ids = append(ids, m.ID)
+		}
+		return ids, err
+	case "openai":
+		models, err := fetchOpenAIModels(config)
+		for _, m := range models {
+			ids = append(ids, m.ID)
+		}
+		return ids, err
+	case "googleai":
+		models, err := fetchGoogleModels(config)
+		for _, m := range models {
+			ids = append(ids, strings.TrimPrefix(m.Name, "models/"))
+		}
+		return ids, err
+	case "ollama":
+		models, err := fetchOllamaModels(context.Background(), config)
+		for _, m := range models {
+			ids = append(ids, m.Name)
+		}
+		return ids, err
+	}
+	return nil, fmt.Errorf("cannot list the models of %s", config.LLM.Provider)
+}
+
+// suggestModel returns the model in ids closest to name, or "" when none is close enough to be a typo
+func suggestModel(name string, ids []string) string {
+	name = strings.ToLower(name)
+	best, bestDistance := "", max(maxSuggestionDistance, len(name)/4)+1
+	for _, id := range ids {
+		if distance := editDistance(name, strings.ToLower(id)); distance < bestDistance {
+			best, bestDistance = id, distance
+		}
+	}
+	return best
+}
+
 // fetchAnthropicModels fetches available models from the Anthropic API
 func fetchAnthropicModels(config *Config) ([]AnthropicModel, error) {
 	// Don't use config.LLM.AuthToken or config.LLM.APIKey as they might be for a different provider
@@ -579,11 +625,11 @@ func checkOllamaAvailable() bool {
 }
 
 // fetchOllamaModels fetches available models from the local Ollama instance
-func fetchOllamaModels(config *Config) ([]OllamaModel, error) {
+func fetchOllamaModels(ctx context.Context, config *Config) ([]OllamaModel, error) {
 	baseURL := getOllamaBaseURL()
 
 	// Create request
-	req, err := http.NewRequest("GET", baseURL+"/api/tags", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/tags", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -614,6 +660,34 @@ func fetchOllamaModels(config *Config) ([]OllamaModel, error) {
 	return modelsResponse.Models, nil
 }
 
+// ollamaCheckTimeout bounds the /api/tags call checking that a selected Ollama model is pulled
+const ollamaCheckTimeout = 5 * time.Second
+
+// ensureOllamaModelPulled checks /api/tags for model, so switching to a model that
+// hasn't been pulled fails up front with a hint instead of on the first prompt
+func ensureOllamaModelPulled(ctx context.Context, model string) error {
+	models, err := fetchOllamaModels(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot list ollama models: %w", err)
+	}
+	for _, m := range models {
+		if m.Name == model || m.Name == model+":latest" {
+			return nil
+		}
+	}
+	return fmt.Errorf("ollama model %q is not pulled. Run `ollama pull %s` and try again", model, model)
+}
+
+// checkOllamaModel runs ensureOllamaModelPulled for a selected model off the update loop, as
+// the Ollama server may be slow or gone
+func checkOllamaModel(model *Model) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), ollamaCheckTimeout)
+		defer cancel()
+		return ollamaModelCheckedMsg{model: model, err: ensureOllamaModelPulled(ctx, model.ID)}
+	}
+}
+
 // ModelsWindow is a component for displaying unified model selection across all providers
 // Navigation is handled by ContentComponent
 type ModelsWindow struct {
@@ -801,6 +875,13 @@ func (m *ModelsWindow) RenderList(selectedIndex, scrollOffset, visibleSlots int)
 type modelSelectedMsg struct {
 	model    *Model
 	onSelect tea.Cmd
+	pulled   bool // an Ollama model checked by checkOllamaModel
+}
+
+// ollamaModelCheckedMsg reports whether the selected Ollama model is pulled
+type ollamaModelCheckedMsg struct {
+	model *Model
+	err   error
 }
 
 // Message types for model loading
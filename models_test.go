@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -612,3 +613,45 @@ func TestModelsWindowRenderList_ScrollingAndGrouping(t *testing.T) {
 	assert.Contains(t, lines[3], "▶ 🤖 ● GPT-4")          // Selected item at index 2
 	assert.Contains(t, lines[4], "  🤖 ● GPT-3.5")        // Item at index 3
 }
+
+func TestSwitchingToUnpulledOllamaModelIsRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/tags", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[{"name":"llama3:latest"}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("OLLAMA_HOST", server.URL)
+
+	require.NoError(t, ensureOllamaModelPulled(context.Background(), "llama3"))
+	require.NoError(t, ensureOllamaModelPulled(context.Background(), "llama3:latest"))
+
+	model := newTestModel(t)
+	model.config.LLM.Provider = "anthropic"
+	model.config.LLM.Model = "claude-sonnet-4"
+	session := model.session
+
+	// The check runs in a command, the selection waits for it
+	updated, cmd := model.Update(modelSelectedMsg{model: &Model{ID: "qwen3", Provider: "ollama"}})
+	m := updated.(TUIModel)
+	assert.Equal(t, "claude-sonnet-4", m.config.LLM.Model)
+	require.NotNil(t, cmd)
+	updated, _ = m.Update(cmd())
+	m = updated.(TUIModel)
+
+	assert.Equal(t, "anthropic", m.config.LLM.Provider)
+	assert.Equal(t, "claude-sonnet-4", m.config.LLM.Model)
+	assert.Same(t, session, m.session, "the session must not be reinitialized")
+	require.NotEmpty(t, m.commandLine.toasts)
+	toast := m.commandLine.toasts[len(m.commandLine.toasts)-1]
+	assert.Equal(t, "error", toast.Type)
+	assert.Contains(t, toast.Message, "ollama pull qwen3")
+
+	// A pulled model is selected again once checked
+	checked := checkOllamaModel(&Model{ID: "llama3", Provider: "ollama"})().(ollamaModelCheckedMsg)
+	require.NoError(t, checked.err)
+	_, cmd = m.Update(checked)
+	selected := cmd().(modelSelectedMsg)
+	assert.True(t, selected.pulled)
+	assert.Equal(t, "llama3", selected.model.ID)
+}
@@ -45,6 +45,8 @@ type commandOutput struct {
 	exitCode   string
 	ready      chan struct{} // closed when both stdout and stderr are complete
 	outputDone bool
+	onLine     func(line string) // live output for the UI, may be nil
+	limit      int               // bytes of output kept, see outputBuffer
 }
 
 func newPodmanShellRunner(allowFallback bool, config *Config, repoInfo RepoInfo) *PodmanShellRunner {
@@ -268,7 +270,7 @@ func (r *PodmanShellRunner) readStream(reader io.Reader) {
 	scanner.Buffer(buf, 1024*1024)
 
 	var currentID int
-	var output strings.Builder
+	output := newOutputBuffer(0)
 	inCommand := false
 
 	scanner.Split(bufio.ScanLines)
@@ -284,7 +286,13 @@ func (r *PodmanShellRunner) readStream(reader io.Reader) {
 			if len(parts) >= 2 {
 				if _, err := fmt.Sscanf(parts[1], "%d", &currentID); err == nil {
 					inCommand = true
-					output.Reset()
+					r.outputsMu.Lock()
+					limit := 0
+					if cmd := r.outputs[currentID]; cmd != nil {
+						limit = cmd.limit
+					}
+					r.outputsMu.Unlock()
+					output = newOutputBuffer(limit)
 					slog.Debug("found start marker", "id", currentID)
 					continue
 				}
@@ -325,6 +333,13 @@ func (r *PodmanShellRunner) readStream(reader io.Reader) {
 				output.WriteString("\n")
 			}
 			output.WriteString(line)
+
+			r.outputsMu.Lock()
+			cmd := r.outputs[currentID]
+			r.outputsMu.Unlock()
+			if cmd != nil && cmd.onLine != nil {
+				cmd.onLine(line)
+			}
 		}
 	}
 
@@ -448,7 +463,9 @@ func (r *PodmanShellRunner) Run(ctx context.Context, params RunInShellInput) (Ru
 
 	// Register command in outputs map
 	cmd := &commandOutput{
-		ready: make(chan struct{}),
+		ready:  make(chan struct{}),
+		onLine: toolOutputFunc(ctx),
+		limit:  toolOutputLimit(ctx),
 	}
 	r.outputsMu.Lock()
 	r.outputs[id] = cmd
@@ -621,6 +638,11 @@ func (r *PodmanShellRunner) RunnerType() string {
 	return "podman"
 }
 
+// ImageName returns the image the container is created from
+func (r *PodmanShellRunner) ImageName() string {
+	return r.imageName
+}
+
 // ContainerID returns the container name if the container has been started
 func (r *PodmanShellRunner) ContainerID() string {
 	r.mu.Lock()
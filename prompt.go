@@ -3,6 +3,7 @@ package main
 import (
 	"strings"
 
+	"github.com/charmbracelet/bubbles/cursor"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
@@ -299,6 +300,14 @@ func (p *PromptComponent) updateViModeStyle() {
 	}
 }
 
+// cursorCmd sets the prompt cursor for the current vi mode: blinking while inserting, steady otherwise
+func (p *PromptComponent) cursorCmd() tea.Cmd {
+	if p.ViCurrentMode == ViModeInsert {
+		return p.TextArea.Cursor.SetMode(cursor.CursorBlink)
+	}
+	return p.TextArea.Cursor.SetMode(cursor.CursorStatic)
+}
+
 // handleViCommand processes vi commands like dd, dw, cc, cw, etc.
 func (p *PromptComponent) handleViCommand(key string) (bool, tea.Cmd) {
 	// Handle pending operations
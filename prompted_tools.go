@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tmc/langchaingo/llms"
+	lctools "github.com/tmc/langchaingo/tools"
+)
+
+// toolModePrompted describes the tools in the system prompt and parses tool calls
+// out of the model's text, for OpenAI-compatible endpoints without a tools API
+const toolModePrompted = "prompted"
+
+// promptedToolBlock matches a fenced ```tool_call block. Other fences, ```json included, are
+// examples the model shows and never run.
+var promptedToolBlock = regexp.MustCompile("(?s)```tool_call[ \\t]*\\n(.*?)```")
+
+// promptedToolCall is the JSON the model emits to call a tool in prompted mode
+type promptedToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// promptedToolInstructions explains to the model how to call the tools without a tools API
+func promptedToolInstructions(defs []llms.Tool) string {
+	var b strings.Builder
+	b.WriteString("\n--- Tools ---\n")
+	b.WriteString("You can call the tools below. To call one, reply with a fenced block like this and then stop:\n\n")
+	b.WriteString("```tool_call\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"main.go\"}}\n```\n\n")
+	b.WriteString("Use one block per call. The results come back in the next message.\n\n")
+	for _, def := range defs {
+		if def.Function == nil {
+			continue
+		}
+		schema, err := json.Marshal(def.Function.Parameters)
+		if err != nil {
+			schema = []byte("{}")
+		}
+		fmt.Fprintf(&b, "- %s: %s\n  parameters: %s\n", def.Function.Name, def.Function.Description, schema)
+	}
+	b.WriteString("--- End of Tools ---")
+	return b.String()
+}
+
+// promptedMessages rewrites the history for an endpoint without a tools API: the tool
+// instructions are added to the system message, tool call parts are dropped since the
+// assistant text already holds the blocks, and tool results become user messages
+func promptedMessages(messages []llms.MessageContent, defs []llms.Tool) []llms.MessageContent {
+	out := make([]llms.MessageContent, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case llms.ChatMessageTypeSystem:
+			parts := append(append([]llms.ContentPart{}, msg.Parts...), llms.TextPart(promptedToolInstructions(defs)))
+			out = append(out, llms.MessageContent{Role: msg.Role, Parts: parts})
+		case llms.ChatMessageTypeAI:
+			var parts []llms.ContentPart
+			for _, part := range msg.Parts {
+				if _, isCall := part.(llms.ToolCall); !isCall {
+					parts = append(parts, part)
+				}
+			}
+			if len(parts) > 0 {
+				out = append(out, llms.MessageContent{Role: msg.Role, Parts: parts})
+			}
+		case llms.ChatMessageTypeTool:
+			for _, part := range msg.Parts {
+				if resp, ok := part.(llms.ToolCallResponse); ok {
+					out = append(out, llms.TextParts(llms.ChatMessageTypeHuman,
+						fmt.Sprintf("Result of tool %s:\n%s", resp.Name, resp.Content)))
+				}
+			}
+		default:
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+// parsePromptedToolCalls extracts the tool call blocks from a prompted-mode reply. Blocks
+// that aren't JSON or name a tool missing from catalog are left alone.
+func parsePromptedToolCalls(content string, catalog map[string]lctools.Tool) []llms.ToolCall {
+	var calls []llms.ToolCall
+	for _, match := range promptedToolBlock.FindAllStringSubmatch(content, -1) {
+		var call promptedToolCall
+		if err := json.Unmarshal([]byte(strings.TrimSpace(match[1])), &call); err != nil || catalog[call.Name] == nil {
+			continue
+		}
+
+		args := "{}"
+		if len(call.Arguments) > 0 {
+			args = string(call.Arguments)
+			// Some models encode the arguments object as a string
+			var encoded string
+			if json.Unmarshal(call.Arguments, &encoded) == nil {
+				args = encoded
+			}
+		}
+
+		calls = append(calls, llms.ToolCall{
+			ID:   "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+			Type: "function",
+			FunctionCall: &llms.FunctionCall{
+				Name:      call.Name,
+				Arguments: args,
+			},
+		})
+	}
+	return calls
+}
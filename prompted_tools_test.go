@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	lctools "github.com/tmc/langchaingo/tools"
+)
+
+func TestParsePromptedToolCalls(t *testing.T) {
+	content := "Let me look at the file.\n\n" +
+		"```tool_call\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"main.go\"}}\n```\n" +
+		"```tool_call\n{\"name\": \"run_in_shell\", \"arguments\": \"{\\\"command\\\":\\\"go test ./...\\\"}\"}\n```\n" +
+		"For example, to write a file:\n```json\n{\"name\": \"write_file\", \"arguments\": {\"path\": \"x\"}}\n```\n" +
+		"```tool_call\n{\"name\": \"delete_everything\", \"arguments\": {}}\n```\n" +
+		"```tool_call\nnot json\n```"
+	catalog := map[string]lctools.Tool{"read_file": ReadFileTool{}, "run_in_shell": RunInShell{}, "write_file": WriteFileTool{}}
+
+	calls := parsePromptedToolCalls(content, catalog)
+	require.Len(t, calls, 2, "json examples and unknown tools are not called")
+
+	assert.Equal(t, "read_file", calls[0].FunctionCall.Name)
+	assert.JSONEq(t, `{"path": "main.go"}`, calls[0].FunctionCall.Arguments)
+	assert.Equal(t, "run_in_shell", calls[1].FunctionCall.Name)
+	assert.JSONEq(t, `{"command":"go test ./..."}`, calls[1].FunctionCall.Arguments, "string-encoded arguments are unwrapped")
+	assert.NotEmpty(t, calls[0].ID)
+	assert.NotEqual(t, calls[0].ID, calls[1].ID)
+
+	assert.Empty(t, parsePromptedToolCalls("No tools needed, the answer is 42.", catalog))
+}
+
+// promptedLLM replays scripted replies and records what it was sent
+type promptedLLM struct {
+	replies  []string
+	requests [][]llms.MessageContent
+	options  []llms.CallOptions
+}
+
+func (p *promptedLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	p.requests = append(p.requests, messages)
+	p.options = append(p.options, opts)
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: reply}}}, nil
+}
+
+func (p *promptedLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, p, prompt, options...)
+}
+
+func TestSession_PromptedToolMode(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("notes.txt", []byte("the secret word is tangerine"), 0o644))
+
+	llm := &promptedLLM{replies: []string{
+		"```tool_call\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"notes.txt\"}}\n```",
+		"The secret word is tangerine.",
+	}}
+	cfg := &Config{LLM: LLMConfig{Provider: "openai", ToolMode: toolModePrompted}}
+	sess, err := NewSession(llm, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	out, err := sess.Ask(context.Background(), "what is the secret word?")
+	require.NoError(t, err)
+	assert.Equal(t, "The secret word is tangerine.", out)
+
+	require.Len(t, llm.requests, 2)
+	for _, opts := range llm.options {
+		assert.Empty(t, opts.Tools, "prompted mode must not use the tools API")
+		assert.Positive(t, opts.MaxTokens)
+	}
+
+	// The tools are described in the system prompt
+	system := llm.requests[0][0]
+	require.Equal(t, llms.ChatMessageTypeSystem, system.Role)
+	lastPart := system.Parts[len(system.Parts)-1].(llms.TextContent).Text
+	assert.Contains(t, lastPart, "```tool_call")
+	assert.Contains(t, lastPart, "- read_file:")
+
+	// The tool result comes back as plain text, without tool call parts or tool roles
+	second := llm.requests[1]
+	for _, msg := range second {
+		assert.NotEqual(t, llms.ChatMessageTypeTool, msg.Role)
+		for _, part := range msg.Parts {
+			_, isCall := part.(llms.ToolCall)
+			assert.False(t, isCall)
+		}
+	}
+	result := second[len(second)-1]
+	assert.Equal(t, llms.ChatMessageTypeHuman, result.Role)
+	assert.True(t, strings.HasPrefix(result.Parts[0].(llms.TextContent).Text, "Result of tool read_file:"))
+	assert.Contains(t, result.Parts[0].(llms.TextContent).Text, "tangerine")
+
+	// The session history keeps the native representation
+	var sawToolCall bool
+	for _, msg := range sess.Messages {
+		for _, part := range msg.Parts {
+			if tc, ok := part.(llms.ToolCall); ok && tc.FunctionCall.Name == "read_file" {
+				sawToolCall = true
+			}
+		}
+	}
+	assert.True(t, sawToolCall)
+}
@@ -58,6 +58,28 @@ func ProvideConfig(logger *slog.Logger) (*Config, error) {
 	// Override from CLI flag
 	if cli.NoCleanup {
 		config.RunInShell.NoCleanup = true
+		config.setSource("run_in_shell.no_cleanup", ConfigSourceFlag)
+	}
+	if cli.Persona != "" {
+		config.Session.Persona = cli.Persona
+		config.setSource("session.persona", ConfigSourceFlag)
+	}
+	if cli.Profile != "" {
+		if err := config.ApplyProfile(cli.Profile); err != nil {
+			return nil, err
+		}
+	}
+	initRedactor(config)
+	initWriteAllowlist(config)
+	initFileEncoding(config)
+	initShowHidden(config)
+	initRequestLimit(config)
+	if config.Git.ManageGitignore {
+		if added, err := ensureGitignore(".", asimiArtifacts(config)); err != nil {
+			logger.Warn("failed to update .gitignore", "error", err)
+		} else if len(added) > 0 {
+			logger.Info("added asimi's files to .gitignore", "entries", added)
+		}
 	}
 	logger.Info("configuration loaded")
 	return config, nil
@@ -175,11 +197,7 @@ func ProvideModelClient(params ModelClientParams) {
 				} else {
 					params.Logger.Info("LLM client connected")
 					params.Logger.Info("creating session")
-					sess, sessErr := NewSession(llm, params.Config, params.RepoInfo, func(m any) {
-						if program != nil {
-							program.Send(m)
-						}
-					})
+					sess, sessErr := NewSession(llm, params.Config, params.RepoInfo, programNotify.Notify)
 					if cli.Debug {
 						params.Logger.Debug("[TIMING] NewSession() completed")
 					}
@@ -214,24 +232,26 @@ type CommandHistoryResult struct {
 }
 
 // ProvidePromptHistory creates and returns the prompt history store
-func ProvidePromptHistory(db *storage.DB, repoInfo RepoInfo, logger *slog.Logger) (PromptHistoryResult, error) {
+func ProvidePromptHistory(db *storage.DB, config *Config, repoInfo RepoInfo, logger *slog.Logger) (PromptHistoryResult, error) {
 	logger.Info("loading prompt history")
 	historyStore, err := NewPromptHistoryStore(db, repoInfo)
 	if err != nil {
 		logger.Warn("failed to initialize prompt history store", "error", err)
 		return PromptHistoryResult{History: nil}, nil // Don't fail, just return nil
 	}
+	historyStore.SetDedup(config.History.Dedup)
 	return PromptHistoryResult{History: historyStore}, nil
 }
 
 // ProvideCommandHistory creates and returns the command history store
-func ProvideCommandHistory(db *storage.DB, repoInfo RepoInfo, logger *slog.Logger) (CommandHistoryResult, error) {
+func ProvideCommandHistory(db *storage.DB, config *Config, repoInfo RepoInfo, logger *slog.Logger) (CommandHistoryResult, error) {
 	logger.Info("loading command history")
 	historyStore, err := NewCommandHistoryStore(db, repoInfo)
 	if err != nil {
 		logger.Warn("failed to initialize command history store", "error", err)
 		return CommandHistoryResult{History: nil}, nil // Don't fail, just return nil
 	}
+	historyStore.SetDedup(config.History.Dedup)
 	return CommandHistoryResult{History: historyStore}, nil
 }
 
@@ -256,9 +276,24 @@ func ProvideSessionHistory(db *storage.DB, config *Config, repoInfo RepoInfo, lo
 		logger.Error("failed to create session store", "error", err)
 		return nil, nil // Don't fail startup
 	}
+	store.SetMaxStorageBytes(config.Session.MaxStorageBytes)
+	if _, err := store.TrimStorage(); err != nil {
+		logger.Warn("failed to trim sessions to max_storage_bytes", "error", err)
+	}
+	store.SetPreserveIncompleteToolCalls(config.Session.PreserveIncompleteToolCalls)
 	return store, nil
 }
 
+// ProvideProjectMemory creates the per-project memory store used by the memory tool
+func ProvideProjectMemory(db *storage.DB, repoInfo RepoInfo, logger *slog.Logger) *ProjectMemory {
+	memory, err := NewProjectMemory(db, repoInfo)
+	if err != nil {
+		logger.Warn("failed to initialize project memory", "error", err)
+		return nil // Don't fail, the memory tool reports it's unavailable
+	}
+	return memory
+}
+
 // TUIModelParams holds parameters for TUI model creation
 type TUIModelParams struct {
 	fx.In
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aymanbagabas/go-udiff"
+	"github.com/yargevad/filepathx"
+)
+
+// replacePreviewLines caps the diff :replace shows before asking to apply it
+const replacePreviewLines = 200
+
+// replacePlan is a project-wide find/replace previewed by :replace and applied once confirmed
+type replacePlan struct {
+	Glob  string
+	Old   string
+	New   string
+	Regex bool
+	Files []replaceFile
+}
+
+// replaceFile is a file with matches in a replacePlan
+type replaceFile struct {
+	Path    string
+	Matches int
+	Current string
+	Updated string
+}
+
+// Matches returns the number of matches in all the files of the plan
+func (p *replacePlan) Matches() int {
+	total := 0
+	for _, file := range p.Files {
+		total += file.Matches
+	}
+	return total
+}
+
+// splitQuotedArgs splits s at whitespace like a shell would for quoting: text in single quotes
+// is taken as is, in double quotes \" and \\ are escapes, and empty quotes make an empty argument.
+// Backslashes elsewhere are kept, as regular expressions need them.
+func splitQuotedArgs(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				current.WriteRune(runes[i])
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// planReplace finds the files matching glob and computes their content after replacing
+// old with new. In regex mode old is a regular expression and new can use $1 style groups.
+func planReplace(glob, old, new string, regex bool) (*replacePlan, error) {
+	var re *regexp.Regexp
+	if regex {
+		var err error
+		if re, err = regexp.Compile(old); err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+	}
+
+	pattern := glob
+	if strings.HasPrefix(pattern, "**") {
+		// filepathx expands ** from the pattern's directory, which must not be empty
+		pattern = "./" + pattern
+	}
+	paths, err := filepathx.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob: %w", err)
+	}
+
+	plan := &replacePlan{Glob: glob, Old: old, New: new, Regex: regex}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if seen[path] || isHiddenPath(path) {
+			continue
+		}
+		seen[path] = true
+		if validatePathWithinProject(path) != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// Read as replace_text does, so the preview is the text it compares against
+		current, _, err := readTextFile(path)
+		if err != nil || isBinaryContent([]byte(current)) {
+			continue
+		}
+
+		file := replaceFile{Path: path, Current: current}
+		if regex {
+			file.Matches = len(re.FindAllStringIndex(current, -1))
+			file.Updated = re.ReplaceAllString(current, new)
+		} else {
+			file.Matches = strings.Count(current, old)
+			file.Updated = strings.ReplaceAll(current, old, new)
+		}
+		if file.Matches > 0 && file.Updated != current {
+			plan.Files = append(plan.Files, file)
+		}
+	}
+	slices.SortFunc(plan.Files, func(a, b replaceFile) int { return strings.Compare(a.Path, b.Path) })
+	return plan, nil
+}
+
+// renderReplacePreview shows the changes of a replace plan as a unified diff
+func renderReplacePreview(plan *replacePlan) string {
+	msg := NewChatMsgBuilder(systemPrefix)
+	mode := "text"
+	if plan.Regex {
+		mode = "regex"
+	}
+	msg.WriteLnf("Replacing %s `%s` with `%s` in %s: %d matches in %d files", mode, plan.Old, plan.New, plan.Glob, plan.Matches(), len(plan.Files))
+
+	var lines []string
+	for _, file := range plan.Files {
+		diff := strings.TrimSuffix(udiff.Unified(file.Path, file.Path, file.Current, file.Updated), "\n")
+		lines = append(lines, strings.Split(diff, "\n")...)
+	}
+	more := 0
+	if len(lines) > replacePreviewLines {
+		more = len(lines) - replacePreviewLines
+		lines = lines[:replacePreviewLines]
+	}
+	msg.WriteLn("```diff")
+	for _, line := range lines {
+		msg.WriteLn(line)
+	}
+	msg.WriteLn("```")
+	if more > 0 {
+		msg.WriteLnf("... %d more diff lines", more)
+	}
+	return msg.String()
+}
+
+// applyReplace writes the planned changes through the replace_text tool. Each file's
+// previewed content is the text replaced, so a file changed since the preview is skipped
+// rather than overwritten.
+func applyReplace(ctx context.Context, plan *replacePlan) (files, matches int, skipped []string) {
+	tool := ReplaceTextTool{}
+	for _, file := range plan.Files {
+		input, err := json.Marshal(ReplaceTextInput{Path: file.Path, OldText: file.Current, NewText: file.Updated})
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", file.Path, err))
+			continue
+		}
+		result, err := tool.Call(ctx, string(input))
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", file.Path, err))
+			continue
+		}
+		if !strings.HasPrefix(result, "Successfully") {
+			skipped = append(skipped, file.Path+" (changed since the preview)")
+			continue
+		}
+		files++
+		matches += file.Matches
+	}
+	return files, matches, skipped
+}
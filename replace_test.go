@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceCommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := map[string]string{
+		"main.go":          "package main\n\nfunc OldName() {}\n\nvar _ = OldName\n",
+		"pkg/util.go":      "package pkg\n\n// OldName is old\n",
+		"pkg/util_test.go": "package pkg\n",
+		"README.md":        "OldName in docs\n",
+		".git/HEAD.go":     "OldName\n",
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	plan, err := planReplace("**/*.go", "OldName", "NewName", false)
+	require.NoError(t, err)
+	require.Equal(t, 3, plan.Matches())
+	require.Len(t, plan.Files, 2)
+	require.Equal(t, "main.go", plan.Files[0].Path)
+	require.Equal(t, 2, plan.Files[0].Matches)
+	require.Equal(t, filepath.Join("pkg", "util.go"), plan.Files[1].Path)
+
+	// :replace previews the diff and waits for confirmation
+	model := newTestModel(t)
+	cmd := handleReplaceCommand(model, []string{"**/*.go OldName NewName"})
+	require.NotNil(t, cmd)
+	require.NotNil(t, model.pendingReplace)
+	preview := model.content.Chat.Messages[len(model.content.Chat.Messages)-1]
+	require.Contains(t, preview, "3 matches in 2 files")
+	require.Contains(t, preview, "-func OldName() {}")
+	require.Contains(t, preview, "+func NewName() {}")
+	require.Equal(t, files["main.go"], read("main.go"), "nothing changes before confirming")
+
+	updated, _ := model.Update(yesNoResponseMsg{answer: true})
+	result := updated.(TUIModel)
+	require.Nil(t, result.pendingReplace)
+	require.Contains(t, result.content.Chat.Messages[len(result.content.Chat.Messages)-1], "Replaced 3 matches in 2 files")
+	require.Equal(t, "package main\n\nfunc NewName() {}\n\nvar _ = NewName\n", read("main.go"))
+	require.Equal(t, "package pkg\n\n// NewName is old\n", read(filepath.Join("pkg", "util.go")))
+	require.Equal(t, files["README.md"], read("README.md"), "files outside the glob are left alone")
+	require.Equal(t, files[".git/HEAD.go"], read(filepath.Join(".git", "HEAD.go")))
+
+	// Regex mode with groups, declined
+	plan, err = planReplace("*.md", `(\w+)Name`, "${1}Title", true)
+	require.NoError(t, err)
+	require.Equal(t, 1, plan.Matches())
+	require.Equal(t, "OldTitle in docs\n", plan.Files[0].Updated)
+	handleReplaceCommand(model, []string{`-r *.md (\w+)Name ${1}Title`})
+	require.NotNil(t, model.pendingReplace)
+	updated, _ = model.Update(yesNoResponseMsg{answer: false})
+	require.Nil(t, updated.(TUIModel).pendingReplace)
+	require.Equal(t, files["README.md"], read("README.md"))
+
+	// A file changed after the preview is skipped
+	plan, err = planReplace("*.md", "docs", "the docs", false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile("README.md", []byte("edited docs\n"), 0o644))
+	changed, matches, skipped := applyReplace(t.Context(), plan)
+	require.Zero(t, changed)
+	require.Zero(t, matches)
+	require.Len(t, skipped, 1)
+	require.Equal(t, "edited docs\n", read("README.md"))
+
+	// Files in other encodings preview as text and keep their encoding
+	bom := append([]byte{0xEF, 0xBB, 0xBF}, "OldName\n"...)
+	require.NoError(t, os.WriteFile("bom.txt", bom, 0o644))
+	utf16, err := encodeText("OldName\n", encodingUTF16LE)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile("wide.txt", utf16, 0o644))
+	plan, err = planReplace("*.txt", "OldName", "NewName", false)
+	require.NoError(t, err)
+	require.Len(t, plan.Files, 2)
+	require.Equal(t, "OldName\n", plan.Files[0].Current)
+	changed, _, skipped = applyReplace(t.Context(), plan)
+	require.Equal(t, 2, changed, skipped)
+	require.Equal(t, string(append([]byte{0xEF, 0xBB, 0xBF}, "NewName\n"...)), read("bom.txt"))
+	text, name, err := readTextFile("wide.txt")
+	require.NoError(t, err)
+	require.Equal(t, "NewName\n", text)
+	require.Equal(t, encodingUTF16LE, name)
+
+	_, err = planReplace("*.go", "(", "x", true)
+	require.ErrorContains(t, err, "invalid regular expression")
+
+	// Quoted text holds spaces, and an empty replacement deletes
+	require.NoError(t, os.WriteFile("notes.txt", []byte("keep this, drop that\n"), 0o644))
+	handleReplaceCommand(model, []string{`notes.txt ", drop that" ""`})
+	require.NotNil(t, model.pendingReplace)
+	require.Equal(t, "", model.pendingReplace.New)
+	updated, _ = model.Update(yesNoResponseMsg{answer: true})
+	require.Equal(t, "keep this\n", read("notes.txt"))
+}
+
+func TestSplitQuotedArgs(t *testing.T) {
+	args, err := splitQuotedArgs(`-r  *.go 'a b' "c \"d\" \\" "" (\w+)`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-r", "*.go", "a b", `c "d" \`, "", `(\w+)`}, args)
+
+	_, err = splitQuotedArgs(`*.go "open`)
+	require.ErrorContains(t, err, "unterminated \" quote")
+}
+
+func TestRawCommandArgs(t *testing.T) {
+	registry := NewCommandRegistry()
+	replace, ok := registry.GetCommand("replace")
+	require.True(t, ok)
+	require.Equal(t, []string{`*.go "a  b" c`}, replace.Args(`:replace  *.go "a  b" c `))
+	require.Nil(t, replace.Args(":replace"))
+
+	help, ok := registry.GetCommand("help")
+	require.True(t, ok)
+	require.Equal(t, []string{"a", "b"}, help.Args(":help a  b"))
+}
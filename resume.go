@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -17,6 +18,8 @@ type sessionsLoadedMsg struct {
 
 type sessionSelectedMsg struct {
 	session *Session
+	// exportType, when set, exports the session instead of resuming it
+	exportType ExportType
 }
 
 type sessionResumeErrorMsg struct {
@@ -28,6 +31,10 @@ type sessionResumeErrorMsg struct {
 type ResumeWindow struct {
 	SelectWindow[Session]
 	loadingSession bool
+	// exportType turns the picker into the one of :export --pick
+	exportType ExportType
+	// allBranches lists the sessions of every branch, for :resume --all-branches
+	allBranches bool
 }
 
 func NewResumeWindow() ResumeWindow {
@@ -154,7 +161,14 @@ func (r *ResumeWindow) RenderList(selectedIndex, scrollOffset, visibleSlots int)
 
 	config := RenderConfig[Session]{
 		ConstructTitle: func(selectedIndex, totalItems int) string {
-			return titleStyle.Render(fmt.Sprintf("Choose a session to resume [%3d/%3d]:", selectedIndex+1, totalItems))
+			action := "resume"
+			if r.exportType != "" {
+				action = "export"
+			}
+			if r.allBranches {
+				action += " from any branch"
+			}
+			return titleStyle.Render(fmt.Sprintf("Choose a session to %s [%3d/%3d]:", action, selectedIndex+1, totalItems))
 		},
 		OnLoading: func(sb *strings.Builder) {
 			sb.WriteString("Loading sessions...\n")
@@ -189,7 +203,14 @@ func (r *ResumeWindow) RenderList(selectedIndex, scrollOffset, visibleSlots int)
 
 			var line strings.Builder
 			line.WriteString(prefix)
-			line.WriteString(fmt.Sprintf("[%s] %4d %s", timeStr, session.MessageCount, sessionTitle))
+			line.WriteString(fmt.Sprintf("[%s] %4d ", timeStr, session.MessageCount))
+			if r.allBranches {
+				line.WriteString(fmt.Sprintf("(%s) ", truncateSnippet(session.Branch, 20)))
+			}
+			line.WriteString(sessionTitle)
+			for _, tag := range session.Tags {
+				line.WriteString(" #" + tag)
+			}
 
 			lineStyle := lipgloss.NewStyle()
 			if isSelected {
@@ -206,6 +227,7 @@ func (r *ResumeWindow) RenderList(selectedIndex, scrollOffset, visibleSlots int)
 // LoadSession loads a session by ID
 func (r *ResumeWindow) LoadSession(sessionID string) tea.Cmd {
 	r.loadingSession = true
+	exportType := r.exportType
 
 	return func() tea.Msg {
 		config, err := LoadConfig()
@@ -246,8 +268,139 @@ func (r *ResumeWindow) LoadSession(sessionID string) tea.Cmd {
 			return sessionResumeErrorMsg{err: fmt.Errorf("session %s not found", sessionID)}
 		}
 
-		return sessionSelectedMsg{session: mainSession}
+		return sessionSelectedMsg{session: mainSession, exportType: exportType}
+	}
+}
+
+// resumeSession replaces the conversation with a stored session and rebuilds the chat from it
+func (m *TUIModel) resumeSession(session *Session) {
+	if m.session != nil {
+		// Copy all persisted fields from loaded session to existing session
+		m.session.ID = session.ID
+		m.session.CreatedAt = session.CreatedAt
+		m.session.LastUpdated = session.LastUpdated
+		m.session.FirstPrompt = session.FirstPrompt
+		m.session.Provider = session.Provider
+		m.session.Model = session.Model
+		m.session.WorkingDir = session.WorkingDir
+		m.session.ProjectSlug = session.ProjectSlug
+		m.session.Branch = session.Branch
+		m.session.ContextFiles = session.ContextFiles
+		m.session.Tags = session.Tags
+		m.session.setToolMetrics(session.ToolMetrics())
+
+		// Copy messages - need to make a proper copy
+		m.session.Messages = make([]llms.MessageContent, len(session.Messages))
+		copy(m.session.Messages, session.Messages)
+	} else {
+		// No active session - set the loaded session directly
+		m.session = session
+		slog.Warn("Resumed session without active LLM - some features may be limited")
+	}
+
+	// Clear and rebuild chat UI from messages (reuses existing markdown renderer)
+	m.content.Chat.Clear()
+	for _, msgContent := range m.session.Messages {
+		// Skip system messages
+		if msgContent.Role == llms.ChatMessageTypeSystem {
+			continue
+		}
+
+		if msgContent.Role == llms.ChatMessageTypeHuman || msgContent.Role == llms.ChatMessageTypeAI {
+			for _, part := range msgContent.Parts {
+				if textPart, ok := part.(llms.TextContent); ok {
+					prefix := "You: "
+					if msgContent.Role == llms.ChatMessageTypeAI {
+						prefix = "Asimi: "
+					}
+					m.content.Chat.AddMessage(prefix + textPart.Text)
+				}
+			}
+		}
+	}
+	if m.session != nil {
+		m.session.updateTokenCounts()
+	}
+	m.sessionActive = true
+
+	// Reset in-session prompt history state to prevent rollback issues
+	// when the user enters a new prompt after resuming.
+	// We keep the persistent history (loaded from disk) but clear the
+	// session-specific rollback state.
+	m.sessionPromptHistory = make([]promptHistoryEntry, 0)
+	m.historyCursor = 0
+	m.historySaved = false
+	m.historyPendingPrompt = ""
+	m.historyPresentSessionSnapshot = 0
+	m.historyPresentChatSnapshot = 0
+}
+
+// branchResume is a session picked with :resume --all-branches from another branch, waiting
+// for the user to check out its branch or import it into the current one
+type branchResume struct {
+	session *Session
+	branch  string // the local branch the session's slug matches
+	current string // the slug of the current branch
+}
+
+// offerBranchResume asks whether to check out the branch of a session stored under another
+// branch or to import its transcript into the current branch. A session whose branch is gone
+// is imported.
+func (m *TUIModel) offerBranchResume(session *Session, current string) tea.Cmd {
+	branch := localBranchForSlug(session.Branch)
+	if branch == "" {
+		m.importSession(session, current)
+		m.commandLine.AddToast(fmt.Sprintf("Branch %s is gone, imported the session into %s", session.Branch, current), "success", time.Second*3)
+		return nil
+	}
+	m.pendingBranchResume = &branchResume{session: session, branch: branch, current: current}
+	m.prompt.Blur()
+	return m.commandLine.EnterYesNoMode(fmt.Sprintf("The session is from %s. Check it out? No imports the session into %s", branch, current))
+}
+
+// finishBranchResume resumes the session of pending on its branch, checked out, when checkout
+// is true or else a copy of it on the current branch
+func (m *TUIModel) finishBranchResume(pending branchResume, checkout bool) {
+	if !checkout {
+		m.importSession(pending.session, pending.current)
+		m.commandLine.AddToast(fmt.Sprintf("Imported the session from %s into %s", pending.branch, pending.current), "success", time.Second*3)
+		return
+	}
+	if out, err := runGitCommand("", "checkout", pending.branch); err != nil {
+		slog.Warn("checking out the branch of a resumed session failed", "branch", pending.branch, "error", err)
+		m.content.Chat.AddMessage(fmt.Sprintf("%s❌ Couldn't check out %s, the session wasn't resumed: %s", systemPrefix, pending.branch, strings.TrimSpace(string(out))))
+		return
+	}
+	refreshGitInfo()
+	// Further saves go to the checked out branch, where the session is stored
+	if m.sessionStore != nil {
+		m.sessionStore.Branch = pending.session.Branch
+	}
+	m.resumeSession(pending.session)
+	m.commandLine.AddToast(fmt.Sprintf("Checked out %s and resumed the session", pending.branch), "success", time.Second*3)
+}
+
+// importSession resumes a copy of a session from another branch, with an ID of its own so it
+// is saved under the current branch and the original stays on its branch
+func (m *TUIModel) importSession(session *Session, current string) {
+	session.ID = generateSessionID()
+	session.Branch = current
+	m.resumeSession(session)
+}
+
+// localBranchForSlug returns the local branch whose slug, as sessions are stored under, is
+// slug, "" when there is none
+func localBranchForSlug(slug string) string {
+	out, err := runGitCommand("", "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	if err != nil {
+		return ""
+	}
+	for _, branch := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if branch != "" && branchSlugOrDefault(branch) == slug {
+			return branch
+		}
 	}
+	return ""
 }
 
 func formatRelativeTime(t time.Time) string {
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
 )
 
@@ -135,3 +136,77 @@ func testSession(id, prompt string, updated time.Time, messageTexts ...string) S
 		Model:        "test",
 	}
 }
+
+func TestResumeFromAnotherBranch(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	git := func(args ...string) string {
+		out, err := runGitPlumbing(dir, checkpointEnv, args...)
+		require.NoError(t, err)
+		return out
+	}
+	git("init", "-q")
+	git("checkout", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("branch", "feature/login")
+
+	model := newTestModel(t)
+	stored := func(branch string) *Session {
+		return &Session{
+			ID:       "stored-id",
+			Branch:   branch,
+			Messages: []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "fix the login")},
+		}
+	}
+	pick := func(session *Session) TUIModel {
+		updated, _ := model.handleCustomMessages(sessionSelectedMsg{session: session})
+		return updated.(TUIModel)
+	}
+	answer := func(m TUIModel, yes bool) TUIModel {
+		updated, _ := m.handleCustomMessages(yesNoResponseMsg{answer: yes})
+		return updated.(TUIModel)
+	}
+
+	// No imports a copy into the current branch
+	m := pick(stored("feature-login"))
+	require.NotNil(t, m.pendingBranchResume)
+	require.Equal(t, "feature/login", m.pendingBranchResume.branch)
+	m = answer(m, false)
+	require.Nil(t, m.pendingBranchResume)
+	require.NotEqual(t, "stored-id", m.session.ID)
+	require.Equal(t, "main", m.session.Branch)
+	require.Equal(t, "main", git("branch", "--show-current"))
+	require.True(t, containsMessage(m.content.Chat.Messages, "You: fix the login"))
+
+	// A session whose branch is gone is imported right away
+	m = pick(stored("deleted-branch"))
+	require.Nil(t, m.pendingBranchResume)
+	require.NotEqual(t, "stored-id", m.session.ID)
+
+	// Yes checks the branch out and resumes the session as it is
+	m = answer(pick(stored("feature-login")), true)
+	require.Equal(t, "feature/login", git("branch", "--show-current"))
+	require.Equal(t, "stored-id", m.session.ID)
+	require.Equal(t, "feature-login", m.session.Branch)
+}
+
+func TestResumePickerModes(t *testing.T) {
+	model := newTestModel(t)
+	sessions := []Session{{ID: "a", Branch: "feature-login", FirstPrompt: "fix the login", LastUpdated: time.Now()}}
+
+	// The export picker stays one once the sessions are loaded
+	handleExportCommand(model, []string{"--pick", "json"})
+	updated, _ := model.handleCustomMessages(sessionsLoadedMsg{sessions: sessions})
+	require.Equal(t, ExportTypeJSON, updated.(TUIModel).content.resume.exportType)
+
+	handleResumeCommand(model, []string{"--all-branches", "--tag", "auth"})
+	require.Empty(t, model.content.resume.exportType)
+	require.True(t, model.content.resume.allBranches)
+	model.content.resume.SetSessions(sessions)
+	rendered := model.content.resume.RenderList(0, 0, 5)
+	require.Contains(t, rendered, "from any branch")
+	require.Contains(t, rendered, "(feature-login) fix the login")
+
+	msg := handleResumeCommand(model, []string{"--tag"})()
+	require.Contains(t, msg.(showContextMsg).content, "Usage: :resume [--all-branches]")
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
@@ -46,6 +49,7 @@ type CoreToolScheduler struct {
 	isBusy      bool
 	resultChans map[string]chan ToolCallResult
 	notify      func(any)
+	outputLimit int // bytes of output a streaming tool call keeps, 0 for no limit
 }
 
 // NewCoreToolScheduler creates a new CoreToolScheduler
@@ -58,6 +62,14 @@ func NewCoreToolScheduler(toolNotify func(any)) *CoreToolScheduler {
 	}
 }
 
+// SetOutputBufferBytes caps the output a streaming tool call keeps in memory, dropping the
+// middle past it. 0 keeps all of it
+func (s *CoreToolScheduler) SetOutputBufferBytes(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputLimit = limit
+}
+
 // Schedule adds a new tool call to the scheduler and returns a channel for the result
 func (s *CoreToolScheduler) Schedule(tool tools.Tool, input string) <-chan ToolCallResult {
 	slog.Debug("scheduler.enqueue", "tool", tool.Name())
@@ -100,12 +112,20 @@ func (s *CoreToolScheduler) processQueue() {
 		s.notify(ToolCallExecutingMsg{Call: call})
 	}
 
+	limit := s.outputLimit
 	go func() {
 		// NOTE: We are calling the tool's Call method directly here.
 		// The toolWrapper's Call method is what schedules the tool.
 		// This means the tool passed to Schedule should be the unwrapped tool.
 		slog.Debug("scheduler.exec", "tool", call.Tool.Name())
-		output, err := call.Tool.Call(context.Background(), call.Input)
+		ctx := withToolOutputLimit(context.Background(), limit)
+		if s.notify != nil {
+			ctx = withToolOutput(ctx, func(line string) {
+				s.notify(ToolCallOutputChunkMsg{Call: call, Chunk: redactSecrets(line)})
+			})
+		}
+		output, err := call.Tool.Call(ctx, call.Input)
+		output = redactSecrets(output)
 
 		s.mu.Lock()
 		defer s.mu.Unlock()
@@ -147,3 +167,121 @@ type ToolCallExecutingMsg struct{ Call *ToolCall }
 type ToolCallWaitingForApprovalMsg struct{ Call *ToolCall }
 type ToolCallSuccessMsg struct{ Call *ToolCall }
 type ToolCallErrorMsg struct{ Call *ToolCall }
+
+// ToolCallOutputChunkMsg carries a line of output from a running tool, for live display.
+// The tool's result still holds the whole output.
+type ToolCallOutputChunkMsg struct {
+	Call  *ToolCall
+	Chunk string
+}
+
+type toolOutputKey struct{}
+
+// withToolOutput returns a context through which a tool reports output lines as they are produced
+func withToolOutput(ctx context.Context, fn func(line string)) context.Context {
+	return context.WithValue(ctx, toolOutputKey{}, fn)
+}
+
+// toolOutputFunc returns the output callback set with withToolOutput, or nil
+func toolOutputFunc(ctx context.Context) func(line string) {
+	fn, _ := ctx.Value(toolOutputKey{}).(func(line string))
+	return fn
+}
+
+type toolOutputLimitKey struct{}
+
+// withToolOutputLimit returns a context through which a tool learns how much output to keep
+func withToolOutputLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, toolOutputLimitKey{}, limit)
+}
+
+// toolOutputLimit returns the limit set with withToolOutputLimit, 0 for no limit
+func toolOutputLimit(ctx context.Context) int {
+	limit, _ := ctx.Value(toolOutputLimitKey{}).(int)
+	return limit
+}
+
+// outputDroppedMarker stands in for the middle of an output over its buffer's limit
+const outputDroppedMarker = "\n[... %d bytes of output dropped ...]\n"
+
+// outputBuffer collects a tool's output keeping at most limit bytes: past it the middle is
+// dropped, keeping the first and last half. A zero limit keeps everything
+type outputBuffer struct {
+	limit   int
+	head    []byte
+	tail    []byte
+	dropped int
+}
+
+func newOutputBuffer(limit int) *outputBuffer {
+	return &outputBuffer{limit: limit}
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+	headLimit := b.limit / 2
+	if room := headLimit - len(b.head); room > 0 {
+		take := min(room, len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+	b.tail = append(b.tail, p...)
+	if over := len(b.tail) - (b.limit - headLimit); over > 0 {
+		b.dropped += over
+		b.tail = b.tail[over:]
+	}
+	return n, nil
+}
+
+func (b *outputBuffer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// Len is the number of bytes kept
+func (b *outputBuffer) Len() int {
+	return len(b.head) + len(b.tail)
+}
+
+func (b *outputBuffer) Reset() {
+	b.head, b.tail, b.dropped = nil, nil, 0
+}
+
+// String returns the output kept, with a marker where the middle was dropped
+func (b *outputBuffer) String() string {
+	if b.dropped == 0 {
+		return string(b.head) + string(b.tail)
+	}
+	// The cuts can split a multi-byte character
+	return strings.ToValidUTF8(string(b.head), "") + fmt.Sprintf(outputDroppedMarker, b.dropped) + strings.ToValidUTF8(string(b.tail), "")
+}
+
+// lineWriter passes each complete line written to it to emit
+type lineWriter struct {
+	emit func(line string)
+	buf  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits the last line when it has no trailing newline
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockTool struct {
@@ -96,3 +100,54 @@ func TestCoreToolScheduler(t *testing.T) {
 	_, ok = model.messages[2].(ToolCallSuccessMsg)
 	assert.True(t, ok)
 }
+
+func TestSchedulerStreamsShellOutput(t *testing.T) {
+	restore := setShellRunnerForTesting(newHostShellRunner(nil))
+	defer restore()
+
+	var mu sync.Mutex
+	var events []string
+	scheduler := NewCoreToolScheduler(func(msg any) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch m := msg.(type) {
+		case ToolCallOutputChunkMsg:
+			events = append(events, "chunk:"+m.Chunk)
+		case ToolCallSuccessMsg:
+			events = append(events, "success")
+		}
+	})
+
+	result := <-scheduler.Schedule(RunInShell{}, `{"command":"echo one; echo two >&2; sleep 0.1; printf three"}`)
+	require.NoError(t, result.Error)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 4)
+	require.ElementsMatch(t, []string{"chunk:one", "chunk:two", "chunk:three"}, events[:3], "output arrives before completion")
+	require.Equal(t, "chunk:three", events[2])
+	require.Equal(t, "success", events[3])
+
+	// The result still holds the whole output
+	require.Contains(t, result.Output, "one")
+	require.Contains(t, result.Output, "three")
+}
+
+func TestSchedulerBoundsStreamingOutput(t *testing.T) {
+	restore := setShellRunnerForTesting(newHostShellRunner(nil))
+	defer restore()
+
+	scheduler := NewCoreToolScheduler(nil)
+	scheduler.SetOutputBufferBytes(1000)
+
+	result := <-scheduler.Schedule(RunInShell{}, `{"command":"seq 1 100000"}`)
+	require.NoError(t, result.Error)
+
+	var out RunInShellOutput
+	require.NoError(t, json.Unmarshal([]byte(result.Output), &out))
+	require.Less(t, len(out.Output), 1100, "the output is bounded by the buffer")
+	require.True(t, strings.HasPrefix(out.Output, "1\n2\n3\n"), "the head is kept")
+	require.Contains(t, out.Output, "99999\n100000\n", "the tail is kept")
+	require.Regexp(t, `\[\.\.\. \d+ bytes of output dropped \.\.\.\]`, out.Output)
+	require.NotContains(t, out.Output, "\n50000\n", "the middle is dropped")
+}
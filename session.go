@@ -6,15 +6,26 @@ import (
 	"crypto/sha256"
 	_ "embed"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/afittestide/asimi/storage"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
 	lctools "github.com/tmc/langchaingo/tools"
@@ -37,10 +48,12 @@ type Session struct {
 	Model       string    `json:"model"`
 	WorkingDir  string    `json:"working_dir"`
 	ProjectSlug string    `json:"project_slug,omitempty"`
+	Branch      string    `json:"branch,omitempty"` // The branch slug the session is stored under, set when loaded
 
 	Messages     []llms.MessageContent `json:"messages"`
 	ContextFiles map[string]string     `json:"context_files"`
 	MessageCount int                   `json:"message_count,omitempty"` // For list views, avoids loading full messages
+	Tags         []string              `json:"tags,omitempty"`          // Labels set with :tag, to filter :resume
 
 	llm                     llms.Model              `json:"-"`
 	toolCatalog             map[string]lctools.Tool `json:"-"`
@@ -51,8 +64,37 @@ type Session struct {
 	notify                  NotifyFunc              `json:"-"`
 	accumulatedContent      strings.Builder         `json:"-"`
 	config                  *LLMConfig              `json:"-"`
+	toolsConfig             ToolsConfig             `json:"-"`
+	gitConfig               GitConfig               `json:"-"`
+	turnEdits               []string                `json:"-"` // files edited this turn, for git.auto_commit
+	checkpoints             []Checkpoint            `json:"-"` // snapshots of the working tree for :restore
+	planMode                bool                    // :plan limits the model to read-only tools until :act
+	approveTools            bool                    // --yes, tools with the ask permission run without asking
+	alwaysApprovedTools     map[string]bool         // tools with the ask permission the user approved for the session
+	brief                   bool                    // :brief asks for concise replies in each prompt
+	repoInfo                RepoInfo                `json:"-"`
+	persona                 string                  `json:"-"`
+	historySummary          string                  `json:"-"` // the last compaction summary, for the history partial
+	commandPrompts          []string                `json:"-"` // prompts sent by commands such as :init, never used as FirstPrompt
+	systemConfig            *Config                 `json:"-"` // what the system message was built from, to rebuild it
+	systemPersona           *PersonaConfig          `json:"-"`
 	startTime               time.Time               `json:"-"`
 
+	// Timing of the last turn, shown by :perf
+	timing *turnTimer `json:"-"`
+
+	// Per tool usage over the session, shown by :metrics and saved with it
+	metrics *toolMetrics `json:"-"`
+
+	// Raw model requests and responses of the current turn, for :dump-last
+	exchanges *exchangeLog `json:"-"`
+
+	// Tokens and cost of each turn, shown by :cost and in the status bar
+	usage *usageLog `json:"-"`
+
+	// Serializes turns and changes to Messages between the UI and the streaming goroutine
+	guard *turnGuard `json:"-"`
+
 	// Token counts - updated when messages/context changes
 	systemPromptTokens int `json:"-"`
 	systemToolsTokens  int `json:"-"`
@@ -60,6 +102,15 @@ type Session struct {
 	messagesTokens     int `json:"-"`
 }
 
+// exportWorkingDir is the working directory exports show, with security.redact_paths where it
+// is under home, as the whole of it redacts to .
+func (s *Session) exportWorkingDir() string {
+	if r := s.pathRedactor(); r != nil {
+		return (&pathRedactor{home: r.home}).Redact(s.WorkingDir)
+	}
+	return s.WorkingDir
+}
+
 // formatMetadata returns the metadata header used by export helpers.
 func (s *Session) formatMetadata(exportType ExportType, exportedAt time.Time) string {
 	var b strings.Builder
@@ -67,7 +118,7 @@ func (s *Session) formatMetadata(exportType ExportType, exportedAt time.Time) st
 
 	b.WriteString(fmt.Sprintf("**Asimi Version:** %s \n", version))
 	b.WriteString(fmt.Sprintf("**Export Type:** %s\n", exportType))
-	b.WriteString(fmt.Sprintf("**Session ID:** %s | **Working Directory:** %s\n", s.ID, s.WorkingDir))
+	b.WriteString(fmt.Sprintf("**Session ID:** %s | **Working Directory:** %s\n", s.ID, s.exportWorkingDir()))
 	b.WriteString(fmt.Sprintf("**Provider:** %s | **Model:** %s\n", s.Provider, s.Model))
 	b.WriteString(fmt.Sprintf("**Created:** %s | **Last Updated:** %s | **Exported:** %s\n",
 		s.CreatedAt.Format("2006-01-02 15:04:05"),
@@ -99,12 +150,14 @@ func (s *Session) getStreamBuffer(reset bool) string {
 // notification messages
 type streamChunkMsg string
 type streamReasoningChunkMsg string
+type streamDiscardedMsg string // chunks streamed by a model that failed before a fallback answers
 type streamStartMsg struct{}
 type streamCompleteMsg struct{}
 type streamInterruptedMsg struct{ partialContent string }
 type streamErrorMsg struct{ err error }
 type streamMaxTurnsExceededMsg struct{ maxTurns int }
 type streamMaxTokensReachedMsg struct{ content string }
+type streamEmptyResponseMsg struct{}
 type containerLaunchMsg struct{ message string }
 
 // Local copies of prompt partials and template used by the session, to decouple from agent.go.
@@ -124,6 +177,9 @@ var sessPromptPartials = map[string]any{
 	"history":       "",
 }
 
+// emptyResponsePlaceholder is shown when the model ends its turn without any content
+const emptyResponsePlaceholder = "[model returned no content]"
+
 //go:embed prompts/system_prompt.tmpl
 var sessSystemPromptTemplate string
 
@@ -143,9 +199,24 @@ func NewSession(llm llms.Model, cfg *Config, repoInfo RepoInfo, toolNotify Notif
 		llm:         llm,
 		toolCatalog: map[string]lctools.Tool{},
 		notify:      toolNotify,
+		timing:      &turnTimer{},
+		metrics:     &toolMetrics{},
+		exchanges:   &exchangeLog{},
+		usage:       &usageLog{},
+		guard:       &turnGuard{},
+	}
+	if toolNotify != nil {
+		// The turns StopTurn waits for are muted, so they don't reach the UI after the history changed
+		s.notify = func(msg any) {
+			if !s.guard.muted.Load() {
+				toolNotify(msg)
+			}
+		}
 	}
 	if cfg != nil {
 		s.config = &cfg.LLM
+		s.toolsConfig = cfg.Tools
+		s.gitConfig = cfg.Git
 		s.Provider = cfg.LLM.Provider
 		s.Model = cfg.LLM.Model
 		// Set default maxTurns if not configured
@@ -157,12 +228,55 @@ func NewSession(llm llms.Model, cfg *Config, repoInfo RepoInfo, toolNotify Notif
 		s.config.MaxTurns = 999
 	}
 
-	// Build system prompt from the existing template and partials, same as the agent.
+	var persona *PersonaConfig
+	if cfg != nil && cfg.Session.Persona != "" {
+		p, ok := cfg.Personas[cfg.Session.Persona]
+		if !ok {
+			return nil, fmt.Errorf("unknown persona %q", cfg.Session.Persona)
+		}
+		persona = &p
+		s.persona = cfg.Session.Persona
+	}
+	s.repoInfo = repoInfo
+
+	sysMsg, err := s.buildSystemMessage(cfg, persona)
+	if err != nil {
+		return nil, err
+	}
+	s.Messages = append(s.Messages, sysMsg)
+
+	// Build tool schema for the model and execution catalog for the scheduler.
+	s.toolDefs, s.toolCatalog = s.buildTools(cfg)
+	if persona != nil {
+		if s.toolDefs, s.toolCatalog, err = restrictTools(s.toolDefs, s.toolCatalog, persona.Tools); err != nil {
+			return nil, fmt.Errorf("persona %s: %w", s.persona, err)
+		}
+	}
+	s.scheduler = NewCoreToolScheduler(s.notify)
+	if cfg != nil {
+		s.scheduler.SetOutputBufferBytes(cfg.Tools.OutputBufferBytes)
+	}
+	s.ContextFiles = make(map[string]string)
+	if cfg != nil && cfg.Session.AutoAttachReferences {
+		s.attachAgentsReferences(readProjectContext(agentsFileName(cfg)))
+	}
+	s.startTime = time.Now()
+	s.updateTokenCounts()
+	return s, nil
+}
+
+// buildSystemMessage renders the system prompt from the template and partials, the
+// project's agents file and, when set, the persona's instruction
+func (s *Session) buildSystemMessage(cfg *Config, persona *PersonaConfig) (llms.MessageContent, error) {
 	partials := make(map[string]any, len(sessPromptPartials))
 	for k, v := range sessPromptPartials {
 		partials[k] = v
 	}
-	partials["Env"] = sessBuildEnvBlock(repoInfo)
+	partials["Env"] = sessBuildEnvBlock(s.repoInfo)
+	partials["Memory"] = currentProjectMemory.Prompt()
+	if s.config != nil && s.config.IncludeHistoryPartial {
+		partials["history"] = s.historySummary
+	}
 
 	pt := prompts.PromptTemplate{
 		Template:         sessSystemPromptTemplate,
@@ -174,24 +288,30 @@ func NewSession(llm llms.Model, cfg *Config, repoInfo RepoInfo, toolNotify Notif
 	// Render with empty input/scratchpad since this is a system message.
 	sys, err := pt.Format(map[string]any{"input": "", "agent_scratchpad": ""})
 	if err != nil {
-		return nil, fmt.Errorf("formatting system prompt: %w", err)
+		return llms.MessageContent{}, fmt.Errorf("formatting system prompt: %w", err)
 	}
-	var parts []llms.ContentPart
-	if s.config != nil && s.config.Provider == "anthropic" {
-		parts = append(parts, llms.TextPart("You are Claude Code, Anthropic's official CLI for Claude."))
-	}
-	parts = append(parts, llms.TextPart(sys))
+	parts := []llms.ContentPart{llms.TextPart(sys)}
 
 	// Add agents file (AGENTS.md or CLAUDE.md) to system message if it exists
-	agentsFile := "AGENTS.md"
-	if cfg != nil && cfg.Session.AgentsFile != "" {
-		agentsFile = cfg.Session.AgentsFile
-	}
+	agentsFile := agentsFileName(cfg)
 	projectContext := readProjectContext(agentsFile)
 	if projectContext != "" {
 		parts = append(parts, llms.TextPart(fmt.Sprintf("\n--- Project specific directions from: %s ---\n%s\n--- End of Directions from: %s ---", agentsFile, projectContext, agentsFile)))
 	}
 
+	if persona != nil && persona.Instruction != "" {
+		parts = append(parts, llms.TextPart(fmt.Sprintf("\n--- Persona: %s ---\n%s\n--- End of Persona ---", s.persona, persona.Instruction)))
+	}
+
+	s.systemConfig, s.systemPersona = cfg, persona
+	return s.systemMessage(parts), nil
+}
+
+// systemMessage makes the system message of parts the way the provider takes it
+func (s *Session) systemMessage(parts []llms.ContentPart) llms.MessageContent {
+	if s.config != nil && s.config.Provider == "anthropic" {
+		parts = append([]llms.ContentPart{llms.TextPart("You are Claude Code, Anthropic's official CLI for Claude.")}, parts...)
+	}
 	if s.config != nil && s.config.Provider == "ollama" {
 		var builder strings.Builder
 		for _, part := range parts {
@@ -204,26 +324,115 @@ func NewSession(llm llms.Model, cfg *Config, repoInfo RepoInfo, toolNotify Notif
 		}
 		parts = []llms.ContentPart{llms.TextPart(builder.String())}
 	}
-
-	s.Messages = append(s.Messages, llms.MessageContent{
+	return llms.MessageContent{
 		Role:  llms.ChatMessageTypeSystem,
 		Parts: parts,
-	})
+	}
+}
 
-	// Build tool schema for the model and execution catalog for the scheduler.
-	s.toolDefs, s.toolCatalog = buildLLMTools(cfg)
-	s.scheduler = NewCoreToolScheduler(s.notify)
-	s.ContextFiles = make(map[string]string)
-	s.startTime = time.Now()
+// ApplyPersona switches the session to a persona from the config, rebuilding the
+// system message and limiting the tools to the persona's tool set
+func (s *Session) ApplyPersona(cfg *Config, name string) error {
+	if cfg == nil {
+		return fmt.Errorf("no configuration loaded")
+	}
+	persona, ok := cfg.Personas[name]
+	if !ok {
+		return fmt.Errorf("unknown persona %q", name)
+	}
+
+	defs, catalog := s.buildTools(cfg)
+	defs, catalog, err := restrictTools(defs, catalog, persona.Tools)
+	if err != nil {
+		return fmt.Errorf("persona %s: %w", name, err)
+	}
+
+	prev := s.persona
+	s.persona = name
+	sysMsg, err := s.buildSystemMessage(cfg, &persona)
+	if err != nil {
+		s.persona = prev
+		return err
+	}
+	if len(s.Messages) > 0 && s.Messages[0].Role == llms.ChatMessageTypeSystem {
+		s.Messages[0] = sysMsg
+	} else {
+		s.Messages = append([]llms.MessageContent{sysMsg}, s.Messages...)
+	}
+	s.toolDefs, s.toolCatalog = defs, catalog
 	s.updateTokenCounts()
-	return s, nil
+	return nil
+}
+
+// Persona returns the name of the active persona, or "" if none is applied
+func (s *Session) Persona() string {
+	return s.persona
+}
+
+// restrictTools keeps only the allowed tools; an empty allow list keeps them all
+func restrictTools(defs []llms.Tool, catalog map[string]lctools.Tool, allowed []string) ([]llms.Tool, map[string]lctools.Tool, error) {
+	if len(allowed) == 0 {
+		return defs, catalog, nil
+	}
+	for _, name := range allowed {
+		if _, ok := catalog[name]; !ok {
+			return nil, nil, fmt.Errorf("unknown tool %q", name)
+		}
+	}
+	restrictedDefs := make([]llms.Tool, 0, len(allowed))
+	for _, def := range defs {
+		if slices.Contains(allowed, def.Function.Name) {
+			restrictedDefs = append(restrictedDefs, def)
+		}
+	}
+	restrictedCatalog := make(map[string]lctools.Tool, len(allowed))
+	for _, name := range allowed {
+		restrictedCatalog[name] = catalog[name]
+	}
+	return restrictedDefs, restrictedCatalog, nil
 }
 
-// AddContextFile adds file content to the context for the next prompt
-func (s *Session) AddContextFile(path, content string) {
+// ErrContextLimit is returned by AddContextFile when tools.max_context_files or
+// tools.max_context_bytes would be exceeded
+var ErrContextLimit = errors.New("context limit reached")
+
+// AddContextFile adds file content to the context for the next prompt.
+// It refuses files that would take the context over the configured limits.
+func (s *Session) AddContextFile(path, content string) error {
+	files, size := s.contextUsageWithout(path)
+	if limit := s.toolsConfig.MaxContextFiles; limit > 0 && files+1 > limit {
+		return fmt.Errorf("%w: %s would make %d files, the limit is %d", ErrContextLimit, path, files+1, limit)
+	}
+	if limit := s.toolsConfig.MaxContextBytes; limit > 0 && size+len(content) > limit {
+		return fmt.Errorf("%w: %s would make %d bytes, the limit is %d", ErrContextLimit, path, size+len(content), limit)
+	}
+
 	s.ContextFiles[path] = content
 	// Invalidate context cache since context files changed
 	s.updateTokenCounts()
+	return nil
+}
+
+// ContextUsage returns the number of context files and their total size in bytes
+func (s *Session) ContextUsage() (files, bytes int) {
+	return s.contextUsageWithout("")
+}
+
+// ContextLimits returns the configured file and byte caps for context files, 0 meaning unlimited
+func (s *Session) ContextLimits() (files, bytes int) {
+	return s.toolsConfig.MaxContextFiles, s.toolsConfig.MaxContextBytes
+}
+
+// contextUsageWithout measures the context files, skipping exclude so re-adding a file replaces it
+func (s *Session) contextUsageWithout(exclude string) (files, bytes int) {
+	for path, content := range s.ContextFiles {
+		if path == exclude {
+			continue
+		}
+		files++
+		bytes += len(content)
+	}
+	return files, bytes
 }
 
 // ClearContext removes all dynamically added file content from the context
@@ -233,6 +442,14 @@ func (s *Session) ClearContext() {
 	s.updateTokenCounts()
 }
 
+// endTurnContext clears the context files at the end of a turn, unless tools.sticky_context
+// keeps them for the next prompts
+func (s *Session) endTurnContext() {
+	if !s.toolsConfig.StickyContext {
+		s.ClearContext()
+	}
+}
+
 // ClearHistory clears the conversation history but keeps the system message
 // TODO: rename to ClearMessages
 func (s *Session) ClearHistory() {
@@ -246,6 +463,10 @@ func (s *Session) ClearHistory() {
 	// Reset tool call tracking
 	s.lastToolCallKey = ""
 	s.toolCallRepetitionCount = 0
+	s.setHistorySummary("")
+	s.setToolMetrics(nil)
+	s.FirstPrompt = ""
+	s.commandPrompts = nil
 
 	// Invalidate context cache since messages changed
 	s.updateTokenCounts()
@@ -256,6 +477,82 @@ func (s *Session) ClearHistory() {
 	s.ClearContext()
 }
 
+// firstPromptLimit is how much of the first prompt is kept to label the session
+const firstPromptLimit = 100
+
+// compactSummaryPrefix starts the human message that carries the summary of a compacted conversation
+const compactSummaryPrefix = "Previous conversation summary:\n\n"
+
+// isCommandInput reports whether the user typed a command or a shell invocation rather than a prompt
+func isCommandInput(text string) bool {
+	text = strings.TrimSpace(text)
+	return strings.HasPrefix(text, ":") || strings.HasPrefix(text, "!")
+}
+
+// SetFirstPrompt labels the session with the first prompt the user typed, skipping commands
+// and shell invocations
+func (s *Session) SetFirstPrompt(prompt string) {
+	prompt = strings.TrimSpace(prompt)
+	if s.FirstPrompt != "" || prompt == "" || isCommandInput(prompt) {
+		return
+	}
+	if len(prompt) > firstPromptLimit {
+		prompt = prompt[:firstPromptLimit] + "..."
+	}
+	s.FirstPrompt = prompt
+}
+
+// markCommandPrompt records a prompt sent on behalf of a command, so it never labels the session
+func (s *Session) markCommandPrompt(prompt string) {
+	s.commandPrompts = append(s.commandPrompts, prompt)
+}
+
+// backfillFirstPrompt labels a session that has none from its first human message the user
+// wrote, skipping command prompts, compaction summaries and auto-continue requests
+func (s *Session) backfillFirstPrompt() {
+	if s.FirstPrompt != "" {
+		return
+	}
+	for _, msg := range s.Messages {
+		if msg.Role != llms.ChatMessageTypeHuman {
+			continue
+		}
+		for _, part := range msg.Parts {
+			text, ok := part.(llms.TextContent)
+			if !ok {
+				continue
+			}
+			if slices.ContainsFunc(s.commandPrompts, func(prompt string) bool { return strings.Contains(text.Text, prompt) }) {
+				break
+			}
+			prompt := stripPromptContext(text.Text)
+			if strings.HasPrefix(prompt, compactSummaryPrefix) || prompt == autoContinuePrompt {
+				break
+			}
+			s.SetFirstPrompt(prompt)
+			if s.FirstPrompt != "" {
+				return
+			}
+		}
+	}
+}
+
+// stripPromptContext removes the context files buildPromptWithContext prepends to a prompt
+func stripPromptContext(text string) string {
+	if !strings.HasPrefix(text, "--- Context from: ") {
+		return text
+	}
+	end := strings.LastIndex(text, "--- End of Context from: ")
+	if end < 0 {
+		// Cut off or pasted, there is no prompt after the context to return
+		return text
+	}
+	if newline := strings.Index(text[end:], "---\n"); newline >= 0 {
+		return text[end+newline+len("---\n"):]
+	}
+	return text
+}
+
 // HasContextFiles returns true if there are files in the context
 func (s *Session) HasContextFiles() bool {
 	return len(s.ContextFiles) > 0
@@ -291,10 +588,22 @@ func (s *Session) getToolCallKey(name, argsJSON string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// toolCallLoopThreshold is how many identical consecutive tool calls count as a loop.
+const toolCallLoopThreshold = 3 // More conservative than gemini-cli's 5
+
+// ToolLoopState returns the key of the last tool call and how many times in a row it was repeated
+func (s *Session) ToolLoopState() (string, int) {
+	return s.lastToolCallKey, s.toolCallRepetitionCount
+}
+
+// ResetToolLoop clears the tool call loop detection state
+func (s *Session) ResetToolLoop() {
+	s.lastToolCallKey = ""
+	s.toolCallRepetitionCount = 0
+}
+
 // checkToolCallLoop detects if the same tool call is being repeated
 func (s *Session) checkToolCallLoop(name, argsJSON string) bool {
-	const toolCallLoopThreshold = 3 // More conservative than gemini-cli's 5
-
 	key := s.getToolCallKey(name, argsJSON)
 	if s.lastToolCallKey == key {
 		s.toolCallRepetitionCount++
@@ -311,6 +620,45 @@ func (s *Session) checkToolCallLoop(name, argsJSON string) bool {
 	return false
 }
 
+// SanitizedMessages returns a copy of the messages as they will be sent to the model
+func (s *Session) SanitizedMessages() []llms.MessageContent {
+	c := &Session{Messages: slices.Clone(s.Messages), config: s.config}
+	c.sanitizeMessages()
+	return c.Messages
+}
+
+// incompleteToolCallNote stands in for a tool call interrupted before its result
+const incompleteToolCallNote = "[incomplete tool call: %s was interrupted]"
+
+// noteIncompleteToolCalls replaces the tool calls of a trailing assistant message, which never
+// got their results, with notes naming the tools, so a resumed session explains the gap
+func (s *Session) noteIncompleteToolCalls() {
+	if s.config != nil && s.config.DisableContextSanitization {
+		return
+	}
+	if len(s.Messages) == 0 {
+		return
+	}
+	last := &s.Messages[len(s.Messages)-1]
+	if last.Role != llms.ChatMessageTypeAI {
+		return
+	}
+	parts := make([]llms.ContentPart, 0, len(last.Parts))
+	for _, part := range last.Parts {
+		tc, ok := part.(llms.ToolCall)
+		if !ok {
+			parts = append(parts, part)
+			continue
+		}
+		name := "a tool"
+		if tc.FunctionCall != nil && tc.FunctionCall.Name != "" {
+			name = tc.FunctionCall.Name
+		}
+		parts = append(parts, llms.TextPart(fmt.Sprintf(incompleteToolCallNote, name)))
+	}
+	last.Parts = parts
+}
+
 // sanitizeMessages removes any trailing assistant messages with tool calls
 // that don't have corresponding tool responses. This prevents errors when the agent
 // is interrupted mid-execution. Can be disabled via config.
@@ -400,16 +748,482 @@ func (s *Session) sanitizeMessages() {
 
 // prepareUserMessage builds the prompt with context and adds it to the message history
 func (s *Session) prepareUserMessage(prompt string) {
+	start := s.beginTurnTiming()
+	s.beginTurnUsage(prompt)
+	s.turnEdits = nil
+	s.exchanges.reset()
+
 	// Before adding a new user message, check for and remove any unmatched tool calls
 	s.sanitizeMessages()
 
-	fullPrompt := s.buildPromptWithContext(prompt)
-	s.Messages = append(s.Messages, llms.MessageContent{
+	fullPrompt := s.buildPromptWithContext(s.framePrompt(expandGitStatus(prompt)))
+	if s.brief {
+		fullPrompt = fmt.Sprintf(briefNote, s.BriefLines()) + fullPrompt
+	}
+	if s.planMode {
+		fullPrompt += planModeNote
+	}
+	s.addMessages(llms.MessageContent{
 		Role:  llms.ChatMessageTypeHuman,
 		Parts: []llms.ContentPart{llms.TextPart(fullPrompt)},
 	})
 	// Invalidate context cache since messages changed
 	s.updateTokenCounts()
+
+	if s.timing != nil {
+		s.timing.mu.Lock()
+		s.timing.last.PromptBuild = time.Since(start)
+		s.timing.mu.Unlock()
+	}
+}
+
+// framePrompt wraps a prompt in session.prompt_prefix and session.prompt_suffix
+func (s *Session) framePrompt(prompt string) string {
+	if s.systemConfig == nil {
+		return prompt
+	}
+	if prefix := strings.TrimSpace(s.systemConfig.Session.PromptPrefix); prefix != "" {
+		prompt = prefix + "\n\n" + prompt
+	}
+	if suffix := strings.TrimSpace(s.systemConfig.Session.PromptSuffix); suffix != "" {
+		prompt += "\n\n" + suffix
+	}
+	return prompt
+}
+
+// planModeTools are the read-only tools the model keeps in plan mode
+var planModeTools = []string{"read_file", "read_many_files", "list_files"}
+
+// planModeNote is appended to prompts sent in plan mode
+const planModeNote = "\n\n[Plan mode: only read-only tools are available. Explore as needed, then reply with a numbered plan of the changes. Do not try to make them, the user will switch to act mode when ready.]"
+
+// acceptedPlanName names the plan in the context once :act accept attaches it
+const acceptedPlanName = "accepted plan"
+
+// acceptPlanPrompt asks the model to carry out the plan :act accept attached
+const acceptPlanPrompt = "Carry out the accepted plan, step by step."
+
+// SetPlanMode switches between plan mode, where the model only gets read-only tools, and act mode
+func (s *Session) SetPlanMode(on bool) {
+	s.planMode = on
+}
+
+// AcceptPlan switches to act mode with the model's last reply, the plan, attached to the
+// context of the next prompt without its thinking. It reports false, leaving the mode alone,
+// when there is no reply, and fails outside plan mode.
+func (s *Session) AcceptPlan() (bool, error) {
+	if !s.planMode {
+		return false, fmt.Errorf("not in plan mode, ask for a plan with :plan first")
+	}
+	plan := strings.TrimSpace(thinkingBlockPattern.ReplaceAllString(s.lastReply(), ""))
+	if plan == "" {
+		return false, nil
+	}
+	if err := s.AddContextFile(acceptedPlanName, plan); err != nil {
+		return false, err
+	}
+	s.planMode = false
+	return true, nil
+}
+
+// PlanMode reports whether the session is in plan mode
+func (s *Session) PlanMode() bool {
+	return s.planMode
+}
+
+// defaultBriefLines is the reply length :brief asks for when llm.brief_lines is unset
+const defaultBriefLines = 10
+
+// briefNote is prepended to prompts sent with :brief on, the system prompt is left unchanged
+const briefNote = "[Respond concisely, in %d lines or fewer.]\n\n"
+
+// SetBrief turns on or off asking the model for concise replies
+func (s *Session) SetBrief(on bool) {
+	s.brief = on
+}
+
+// Brief reports whether the model is asked for concise replies
+func (s *Session) Brief() bool {
+	return s.brief
+}
+
+// minThinkingBudget is the smallest extended thinking budget Anthropic accepts
+const minThinkingBudget = 1024
+
+// SetThinkingBudget sets the extended thinking token budget, 0 turns thinking off
+func (s *Session) SetThinkingBudget(tokens int) {
+	s.config.ThinkingBudget = tokens
+}
+
+// ThinkingBudget is the extended thinking token budget, 0 when thinking is off
+func (s *Session) ThinkingBudget() int {
+	if s.config == nil {
+		return 0
+	}
+	return s.config.ThinkingBudget
+}
+
+// ThinkingSupported reports whether the provider takes a thinking budget
+func (s *Session) ThinkingSupported() bool {
+	return s.config != nil && s.config.Provider == "anthropic"
+}
+
+// thinkingCallOptions asks the model of cfg for extended thinking within the budget, on providers
+// that support it. The reply's max tokens are raised to leave it room besides the thinking, and
+// the temperature is 1 as Anthropic refuses thinking with any other.
+func thinkingCallOptions(cfg *LLMConfig) []llms.CallOption {
+	if cfg == nil || cfg.ThinkingBudget <= 0 || cfg.Provider != "anthropic" {
+		return nil
+	}
+	budget := max(cfg.ThinkingBudget, minThinkingBudget)
+	maxTokens := maxTokensForRequest(cfg.Model, budget+defaultMaxOutputTokens)
+	if budget >= maxTokens {
+		budget = max(maxTokens/2, minThinkingBudget)
+	}
+	return []llms.CallOption{
+		llms.WithMaxTokens(maxTokens),
+		llms.WithTemperature(1),
+		llms.WithThinking(&llms.ThinkingConfig{BudgetTokens: budget, ReturnThinking: true, StreamThinking: true}),
+	}
+}
+
+// BriefLines is the reply length asked for with :brief on
+func (s *Session) BriefLines() int {
+	if s.config != nil && s.config.BriefLines > 0 {
+		return s.config.BriefLines
+	}
+	return defaultBriefLines
+}
+
+// refusalTools maps the ways a model says it lacks a capability to the tool that gives it
+var refusalTools = []struct {
+	pattern *regexp.Regexp
+	tool    string
+}{
+	{regexp.MustCompile(`(?i)\b(can(no|')t|unable to|not able to|don't have (the )?(ability|access) to)\s+(run|execute)\s+(shell\s+)?(commands?|scripts?|tests?|code)`), "run_in_shell"},
+	{regexp.MustCompile(`(?i)\b(can(no|')t|unable to|not able to|don't have (the )?(ability|access) to)\s+(edit|modify|write( to)?|change|create)\s+(the\s+|your\s+|any\s+)?files?`), "write_file"},
+	{regexp.MustCompile(`(?i)\b(can(no|')t|unable to|not able to|don't have (the )?(ability|access) to)\s+(read|access|open|see)\s+(the\s+|your\s+|any\s+)?(files?|code\s*base|repository)`), "read_file"},
+	{regexp.MustCompile(`(?i)\b(can(no|')t|unable to|not able to|don't have (the )?(ability|access) to)\s+(browse|access|open|fetch|visit|read|search)\s+(the\s+|that\s+|this\s+|any\s+)?(web|internet|online|urls?|links?|websites?|web\s*pages?)`), "web_fetch"},
+}
+
+// lastReply returns the text of the model's last reply
+func (s *Session) lastReply() string {
+	var reply string
+	for i := len(s.Messages) - 1; i >= 0; i-- {
+		if s.Messages[i].Role != llms.ChatMessageTypeAI {
+			continue
+		}
+		for _, part := range s.Messages[i].Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				reply += text.Text
+			}
+		}
+		break
+	}
+	return reply
+}
+
+// RefusalHint suggests how to give the model a tool when its last reply says it can't do
+// what that tool does. It is advisory, the session is left as it is.
+func (s *Session) RefusalHint() string {
+	reply := s.lastReply()
+	if reply == "" {
+		return ""
+	}
+	offered := map[string]bool{}
+	for _, def := range s.activeToolDefs() {
+		offered[def.Function.Name] = true
+	}
+	for _, refusal := range refusalTools {
+		if offered[refusal.tool] || !refusal.pattern.MatchString(reply) {
+			continue
+		}
+		if s.planMode && !slices.Contains(planModeTools, refusal.tool) {
+			return fmt.Sprintf("Tip: %s is off in plan mode, switch to act mode with :act", refusal.tool)
+		}
+		if s.persona == "" {
+			return fmt.Sprintf("Tip: %s isn't among the tools of this session", refusal.tool)
+		}
+		return fmt.Sprintf("Tip: the %s persona leaves out %s, switch with :persona", s.persona, refusal.tool)
+	}
+	return ""
+}
+
+// activeToolDefs returns the tool definitions offered to the model in the current mode
+func (s *Session) activeToolDefs() []llms.Tool {
+	if !s.planMode {
+		return s.toolDefs
+	}
+	defs := make([]llms.Tool, 0, len(planModeTools))
+	for _, def := range s.toolDefs {
+		if slices.Contains(planModeTools, def.Function.Name) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// autoCommitTools are the tools whose edits git.auto_commit commits
+var autoCommitTools = []string{"write_file", "replace_text"}
+
+// recordEdit remembers the file a successful editing tool call changed
+func (s *Session) recordEdit(toolName, argsJSON string, response llms.ToolCallResponse) {
+	if !slices.Contains(autoCommitTools, toolName) || strings.HasPrefix(strings.ToLower(response.Content), "error:") {
+		return
+	}
+	var args struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal([]byte(argsJSON), &args) != nil || args.Path == "" {
+		return
+	}
+	if !slices.Contains(s.turnEdits, args.Path) {
+		s.turnEdits = append(s.turnEdits, args.Path)
+	}
+}
+
+// autoCommitTurn commits the files edited during the turn when git.auto_commit is on.
+// Branches other than main and master are fair game: on a worktree they get squashed.
+func (s *Session) autoCommitTurn(summary string) {
+	edits := s.turnEdits
+	s.turnEdits = nil
+	if !s.gitConfig.AutoCommit || len(edits) == 0 {
+		return
+	}
+	if s.repoInfo.IsMain {
+		slog.Info("skipping auto-commit on main branch", "branch", s.repoInfo.Branch)
+		s.notifyAutoCommit(fmt.Sprintf("Auto-commit skipped on %s, review and commit the changes yourself", s.repoInfo.Branch))
+		return
+	}
+
+	message := autoCommitMessage(summary, edits)
+	hash, err := commitPaths(s.WorkingDir, edits, message)
+	if err != nil {
+		slog.Warn("auto-commit failed", "error", err)
+		s.notifyAutoCommit(fmt.Sprintf("Auto-commit failed: %v", err))
+		return
+	}
+	if hash == "" {
+		return // the edits left the files unchanged
+	}
+	slog.Info("auto-committed turn edits", "commit", hash, "files", edits)
+	s.notifyAutoCommit(fmt.Sprintf("Committed %s: %s", hash[:7], strings.SplitN(message, "\n", 2)[0]))
+}
+
+func (s *Session) notifyAutoCommit(text string) {
+	if s.notify != nil {
+		s.notify(showSystemMsg(text))
+	}
+}
+
+// autoCommitMessage uses the first line of the model's reply as the subject and lists the edited files
+func autoCommitMessage(summary string, paths []string) string {
+	subject := ""
+	for _, line := range strings.Split(summary, "\n") {
+		line = strings.TrimRight(strings.TrimLeft(strings.TrimSpace(line), "#*->` "), "*` ")
+		if line != "" && line != "<thinking>" {
+			subject = line
+			break
+		}
+	}
+	if subject == "" {
+		subject = "Update " + strings.Join(paths, ", ")
+	}
+	if runes := []rune(subject); len(runes) > 72 {
+		subject = string(runes[:71]) + "…"
+	}
+
+	var b strings.Builder
+	b.WriteString(subject)
+	b.WriteString("\n\nEdited by asimi:\n")
+	for _, path := range paths {
+		fmt.Fprintf(&b, "- %s\n", path)
+	}
+	return b.String()
+}
+
+// ToolMetric is the usage of a tool over a session: its calls, the failed ones and the time
+// spent in them, retries included
+type ToolMetric = storage.ToolMetric
+
+// toolMetrics guards the per tool usage, written by the streaming goroutine
+type toolMetrics struct {
+	mu     sync.Mutex
+	byTool map[string]ToolMetric
+}
+
+// recordToolCall adds a call of the named tool to the session's metrics
+func (s *Session) recordToolCall(name string, elapsed time.Duration, failed bool) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	if s.metrics.byTool == nil {
+		s.metrics.byTool = make(map[string]ToolMetric)
+	}
+	metric := s.metrics.byTool[name]
+	metric.Calls++
+	metric.Total += elapsed
+	if failed {
+		metric.Errors++
+	}
+	s.metrics.byTool[name] = metric
+}
+
+// ToolMetrics returns a copy of the per tool usage of the session
+func (s *Session) ToolMetrics() map[string]ToolMetric {
+	if s.metrics == nil {
+		return nil
+	}
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	return maps.Clone(s.metrics.byTool)
+}
+
+// setToolMetrics replaces the per tool usage, e.g. with the one of a resumed session
+func (s *Session) setToolMetrics(metrics map[string]ToolMetric) {
+	if s.metrics == nil {
+		s.metrics = &toolMetrics{}
+	}
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	s.metrics.byTool = maps.Clone(metrics)
+}
+
+// TurnTiming breaks down where the time of a turn went
+type TurnTiming struct {
+	Started     time.Time
+	PromptBuild time.Duration // building the prompt with its context files
+	FirstToken  time.Duration // from the first model request to its first streamed chunk
+	Generation  time.Duration // waiting on the model, over all requests of the turn
+	Total       time.Duration // from the prompt to the final response, tools included
+	LLMCalls    int
+}
+
+// turnTimer guards the timing of the current turn, written by the streaming goroutine
+type turnTimer struct {
+	mu   sync.Mutex
+	last TurnTiming
+}
+
+// beginTurnTiming resets the timing for a new turn and returns its start time
+func (s *Session) beginTurnTiming() time.Time {
+	now := time.Now()
+	if s.timing == nil {
+		return now
+	}
+	s.timing.mu.Lock()
+	defer s.timing.mu.Unlock()
+	s.timing.last = TurnTiming{Started: now}
+	return now
+}
+
+// recordLLMCall adds a model request to the current turn's timing.
+// firstChunk is zero when the response wasn't streamed.
+func (s *Session) recordLLMCall(requestStart, firstChunk, end time.Time) {
+	if s.timing == nil {
+		return
+	}
+	s.timing.mu.Lock()
+	defer s.timing.mu.Unlock()
+	turn := &s.timing.last
+	if turn.Started.IsZero() || turn.Total != 0 {
+		return // not part of a turn, e.g. :compact
+	}
+	if firstChunk.IsZero() {
+		firstChunk = end
+	}
+	if turn.LLMCalls == 0 {
+		turn.FirstToken = firstChunk.Sub(requestStart)
+	}
+	turn.LLMCalls++
+	turn.Generation += end.Sub(requestStart)
+}
+
+// finishTurnTiming records the total time of the current turn, once
+func (s *Session) finishTurnTiming() {
+	if s.timing == nil {
+		return
+	}
+	s.timing.mu.Lock()
+	defer s.timing.mu.Unlock()
+	if turn := &s.timing.last; !turn.Started.IsZero() && turn.Total == 0 {
+		turn.Total = time.Since(turn.Started)
+	}
+}
+
+// LastTurnTiming returns the timing of the last, or current, turn
+func (s *Session) LastTurnTiming() TurnTiming {
+	if s.timing == nil {
+		return TurnTiming{}
+	}
+	s.timing.mu.Lock()
+	defer s.timing.mu.Unlock()
+	return s.timing.last
+}
+
+// defaultMaxOutputTokens is the output budget asked of the model, clamped to the model's limit
+const defaultMaxOutputTokens = 64000
+
+// LLMExchange is one request sent to the model and the raw response it got
+type LLMExchange struct {
+	Time     time.Time             `json:"time"`
+	Provider string                `json:"provider,omitempty"`
+	Model    string                `json:"model,omitempty"`
+	Tools    []string              `json:"tools,omitempty"`
+	Messages []llms.MessageContent `json:"messages"`
+	Response *llms.ContentResponse `json:"response,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// exchangeLog guards the exchanges of the current turn, written by the streaming goroutine
+type exchangeLog struct {
+	mu    sync.Mutex
+	calls []LLMExchange
+}
+
+func (l *exchangeLog) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = nil
+}
+
+// recordExchange keeps the exact messages sent to the model and what came back
+func (s *Session) recordExchange(messages []llms.MessageContent, defs []llms.Tool, resp *llms.ContentResponse, err error) {
+	if s.exchanges == nil {
+		return
+	}
+	exchange := LLMExchange{
+		Time:     time.Now(),
+		Messages: slices.Clone(messages),
+		Response: resp,
+	}
+	if s.config != nil {
+		exchange.Provider, exchange.Model = s.config.Provider, s.config.Model
+	}
+	for _, def := range defs {
+		exchange.Tools = append(exchange.Tools, def.Function.Name)
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	s.exchanges.mu.Lock()
+	defer s.exchanges.mu.Unlock()
+	s.exchanges.calls = append(s.exchanges.calls, exchange)
+}
+
+// LastTurnExchanges returns the model requests and responses of the last turn
+func (s *Session) LastTurnExchanges() []LLMExchange {
+	if s.exchanges == nil {
+		return nil
+	}
+	s.exchanges.mu.Lock()
+	defer s.exchanges.mu.Unlock()
+	return slices.Clone(s.exchanges.calls)
 }
 
 // isOAuthTokenExpiredError checks if an error is due to an expired OAuth token
@@ -423,19 +1237,76 @@ func isOAuthTokenExpiredError(err error) bool {
 		strings.Contains(errStr, "expire")
 }
 
+// isModelNotFoundError reports whether the provider rejected the request because it doesn't know the model
+func isModelNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := strings.ToLower(err.Error())
+	if strings.Contains(errStr, "model_not_found") || strings.Contains(errStr, "model not found") {
+		return true
+	}
+	return strings.Contains(errStr, "model") &&
+		(strings.Contains(errStr, "not_found_error") || strings.Contains(errStr, "404") || strings.Contains(errStr, "does not exist"))
+}
+
+// explainModelError turns a model-not-found error into one naming the closest model the provider
+// offers, since a typo in llm.model otherwise only shows an opaque 404
+func (s *Session) explainModelError(err error) error {
+	if s.config == nil || !isModelNotFoundError(err) {
+		return err
+	}
+	ids, listErr := providerModelIDs(&Config{LLM: *s.config})
+	if listErr != nil {
+		slog.Debug("cannot list models for a suggestion", "error", listErr)
+	}
+	if slices.Contains(ids, s.config.Model) {
+		return err
+	}
+	if suggestion := suggestModel(s.config.Model, ids); suggestion != "" {
+		return fmt.Errorf("model %q not found, did you mean %q? %w", s.config.Model, suggestion, err)
+	}
+	return fmt.Errorf("model %q not found, use :models to pick one: %w", s.config.Model, err)
+}
+
+// modelCallOptions returns the tool, max tokens and thinking options of a request to the model
+// of cfg. They depend on the model's limits, so a fallback model gets its own. Thinking is left
+// off when messages continue a tool call: Anthropic then wants the signed thinking blocks of the
+// call back, which the client doesn't keep.
+func modelCallOptions(cfg *LLMConfig, toolDefs []llms.Tool, messages []llms.MessageContent) []llms.CallOption {
+	if cfg == nil {
+		cfg = &LLMConfig{}
+	}
+	var opts []llms.CallOption
+	if len(toolDefs) > 0 {
+		// Prompted tools are described in the system prompt instead
+		if cfg.ToolMode != toolModePrompted {
+			opts = append(opts, llms.WithTools(toolDefs), llms.WithToolChoice("auto"))
+		}
+		opts = append(opts, llms.WithMaxTokens(maxTokensForRequest(cfg.Model, defaultMaxOutputTokens)))
+	}
+	if len(messages) > 0 && messages[len(messages)-1].Role == llms.ChatMessageTypeTool {
+		return opts
+	}
+	return append(opts, thinkingCallOptions(cfg)...)
+}
+
 func (s *Session) generateLLMResponse(ctx context.Context, streamingFunc func(ctx context.Context, chunk []byte) error) (*llms.ContentChoice, error) {
-	// Build call options; try with explicit tool choice first, then without, then no tools.
-	var callOptsWithChoice []llms.CallOption
-	var callOptsNoChoice []llms.CallOption
-	if len(s.toolDefs) > 0 {
-		callOptsNoChoice = []llms.CallOption{llms.WithTools(s.toolDefs), llms.WithMaxTokens(64000)}
-		callOptsWithChoice = append([]llms.CallOption{}, callOptsNoChoice...)
-		callOptsWithChoice = append(callOptsWithChoice, llms.WithToolChoice("auto"))
-	}
+	prompted := s.config != nil && s.config.ToolMode == toolModePrompted
+	toolDefs := s.activeToolDefs()
 
-	// Add streaming option if requested
+	// Add streaming option if requested, the same whichever model answers
+	var streamOpts []llms.CallOption
+	requestStart := time.Now()
+	var firstChunk time.Time
 	if streamingFunc != nil {
-		callOptsWithChoice = append(callOptsWithChoice, llms.WithStreamingFunc(streamingFunc))
+		streamChunk := streamingFunc
+		streamOpts = append(streamOpts, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			if firstChunk.IsZero() {
+				firstChunk = time.Now()
+			}
+			return streamChunk(ctx, chunk)
+		}))
 
 		// Add reasoning callback for models that support it (#38)
 		reasoningFunc := func(ctx context.Context, reasoningChunk, chunk []byte) error {
@@ -452,14 +1323,21 @@ func (s *Session) generateLLMResponse(ctx context.Context, streamingFunc func(ct
 			}
 			return nil
 		}
-		callOptsWithChoice = append(callOptsWithChoice, llms.WithStreamingReasoningFunc(reasoningFunc))
+		streamOpts = append(streamOpts, llms.WithStreamingReasoningFunc(reasoningFunc))
 	}
-
 	// Remove any unmatched tool calls from context before sending to API
 	s.sanitizeMessages()
 
+	messages := s.Messages
+	if prompted {
+		messages = promptedMessages(s.Messages, toolDefs)
+	}
+	callOpts := append(modelCallOptions(s.config, toolDefs, messages), streamOpts...)
+
 	// Attempt with explicit tool choice first
-	resp, err := s.llm.GenerateContent(ctx, s.Messages, callOptsWithChoice...)
+	model := s.getModelName()
+	resp, err := generateContent(ctx, s.llm, messages, callOpts...)
+	s.recordExchange(messages, toolDefs, resp, err)
 	if err != nil {
 		// Check if this is an OAuth token expiration error
 		if isOAuthTokenExpiredError(err) {
@@ -486,22 +1364,141 @@ func (s *Session) generateLLMResponse(ctx context.Context, streamingFunc func(ct
 
 			// Retry the request with the new client
 			slog.Info("Retrying request with refreshed OAuth token")
-			resp, err = s.llm.GenerateContent(ctx, s.Messages, callOptsWithChoice...)
+			resp, err = generateContent(ctx, s.llm, messages, callOpts...)
+			s.recordExchange(messages, toolDefs, resp, err)
 			if err != nil {
 				return nil, fmt.Errorf("request failed after OAuth token refresh: %w", err)
 			}
-		} else {
-			// Not an OAuth error, return as-is
-			return nil, err
+		} else if resp, model, err = s.generateWithFallback(ctx, messages, toolDefs, streamOpts, err); err != nil {
+			return nil, s.explainModelError(err)
 		}
 	}
 
+	s.recordLLMCall(requestStart, firstChunk, time.Now())
+	s.recordUsage(model, resp)
+
 	if len(resp.Choices) == 0 {
 		return nil, fmt.Errorf("empty response choices")
 	}
-	return resp.Choices[0], nil
+	choice := resp.Choices[0]
+	if prompted && len(choice.ToolCalls) == 0 {
+		choice.ToolCalls = parsePromptedToolCalls(choice.Content, s.toolCatalog)
+	}
+	return choice, nil
+}
+
+// fallbackClient builds the client of a fallback model, replaced in tests
+var fallbackClient = getModelClient
+
+// defaultMaxConcurrentRequests caps the model requests in flight when llm.max_concurrent_requests is unset
+const defaultMaxConcurrentRequests = 2
+
+var (
+	requestSlotsMu sync.RWMutex
+	requestSlots   = make(chan struct{}, defaultMaxConcurrentRequests)
+)
+
+// initRequestLimit sizes the model requests allowed in flight at once from the llm config
+func initRequestLimit(config *Config) {
+	limit := defaultMaxConcurrentRequests
+	if config != nil && config.LLM.MaxConcurrentRequests > 0 {
+		limit = config.LLM.MaxConcurrentRequests
+	}
+	requestSlotsMu.Lock()
+	defer requestSlotsMu.Unlock()
+	requestSlots = make(chan struct{}, limit)
+}
+
+// generateContent sends a request to the model once fewer than llm.max_concurrent_requests
+// are in flight, so :compare and parallel turns don't run into the provider's rate limits
+func generateContent(ctx context.Context, llm llms.Model, messages []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	requestSlotsMu.RLock()
+	slots := requestSlots
+	requestSlotsMu.RUnlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-slots }()
+	return llm.GenerateContent(ctx, messages, opts...)
+}
+
+// limitedModel counts the requests of a model outside the session toward llm.max_concurrent_requests
+type limitedModel struct {
+	llms.Model
+}
+
+func (m limitedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	return generateContent(ctx, m.Model, messages, opts...)
+}
+
+// generateWithFallback retries a request that failed with a retryable provider error on the
+// llm.fallback_models in order, telling the user which model answered. The session keeps its
+// primary model for the next request as overloads are usually short lived. Each fallback gets the
+// options of its own model, followed by streamOpts. It returns the model that answered, whose
+// prices the response is charged at.
+func (s *Session) generateWithFallback(ctx context.Context, messages []llms.MessageContent, toolDefs []llms.Tool, streamOpts []llms.CallOption, err error) (*llms.ContentResponse, string, error) {
+	if s.config == nil || len(s.config.FallbackModels) == 0 || !isRetryableLLMError(err) {
+		return nil, "", err
+	}
+	primary := s.config.Provider + "/" + s.config.Model
+	for _, spec := range s.config.FallbackModels {
+		cfg := compareConfig(Config{LLM: *s.config}, spec)
+		label := cfg.LLM.Provider + "/" + cfg.LLM.Model
+		if label == primary {
+			continue
+		}
+		llm, clientErr := fallbackClient(cfg)
+		if clientErr != nil {
+			slog.Warn("cannot create fallback model client", "model", label, "error", clientErr)
+			continue
+		}
+		slog.Warn("model failed, trying fallback", "model", primary, "fallback", label, "error", err)
+		// The fallback answers from scratch, without what the failed model streamed
+		if partial := s.getStreamBuffer(true); partial != "" && s.notify != nil {
+			s.notify(streamDiscardedMsg(partial))
+		}
+		opts := append(modelCallOptions(&cfg.LLM, toolDefs, messages), streamOpts...)
+		resp, fallbackErr := generateContent(ctx, llm, messages, opts...)
+		s.recordExchange(messages, toolDefs, resp, fallbackErr)
+		if fallbackErr == nil {
+			if s.notify != nil {
+				s.notify(showSystemMsg(fmt.Sprintf("%s is unavailable, %s answered", primary, label)))
+			}
+			return resp, cfg.LLM.Model, nil
+		}
+		if !isRetryableLLMError(fallbackErr) {
+			return nil, "", fmt.Errorf("fallback %s failed: %w", label, fallbackErr)
+		}
+		err = fallbackErr
+	}
+	return nil, "", err
+}
+
+// isRetryableLLMError reports whether a provider error is worth retrying on another model:
+// overloaded or unavailable servers, rate limits and transient network errors
+func isRetryableLLMError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if isTransientToolError(err) {
+		return true
+	}
+	errStr := strings.ToLower(err.Error())
+	for _, marker := range []string{"overloaded", "unavailable", "rate limit", "too many requests"} {
+		if strings.Contains(errStr, marker) {
+			return true
+		}
+	}
+	return retryableStatusPattern.MatchString(errStr)
 }
 
+// retryableStatusPattern matches the rate limit and server error statuses in the errors of
+// the provider clients, such as "API returned unexpected status code: 529"
+var retryableStatusPattern = regexp.MustCompile(`\b(status code|status|http(/[\d.]+)?):? (429|5\d\d)\b`)
+
 // appendMessages adds LLM response content and tool calls to the message history
 func (s *Session) appendMessages(content string, toolCalls []llms.ToolCall) {
 	// Build the assistant message parts
@@ -523,7 +1520,7 @@ func (s *Session) appendMessages(content string, toolCalls []llms.ToolCall) {
 
 	// Only add the assistant message if we have content or tool calls
 	if len(parts) > 0 {
-		s.Messages = append(s.Messages, llms.MessageContent{
+		s.addMessages(llms.MessageContent{
 			Role:  llms.ChatMessageTypeAI,
 			Parts: parts,
 		})
@@ -537,31 +1534,182 @@ func (s *Session) executeToolCall(ctx context.Context, tool lctools.Tool, tc llm
 	var out string
 	var callErr error
 
-	if s.scheduler != nil {
-		ch := s.scheduler.Schedule(tool, argsJSON)
-		res := <-ch
-		out, callErr = res.Output, res.Error
-	} else {
-		out, callErr = tool.Call(ctx, argsJSON)
+	retries := 0
+	if slices.Contains(s.toolsConfig.RetryTools, tool.Name()) {
+		retries = s.toolsConfig.MaxRetries
+	}
+
+	start := time.Now()
+	defer func() { s.recordToolCall(tool.Name(), time.Since(start), callErr != nil) }()
+
+	for attempt := 0; ; attempt++ {
+		out, callErr = s.callTool(ctx, tool, argsJSON)
+		if callErr == nil || attempt >= retries || !isTransientToolError(callErr) {
+			break
+		}
+		delay := toolRetryBaseDelay << attempt
+		slog.Warn("retrying tool after transient error", "tool", tool.Name(), "attempt", attempt+1, "delay", delay, "error", callErr)
+		select {
+		case <-ctx.Done():
+			callErr = ctx.Err()
+		case <-time.After(delay):
+			continue
+		}
+		break
 	}
 
 	if callErr != nil {
 		return llms.ToolCallResponse{
 			ToolCallID: tc.ID,
 			Name:       tc.FunctionCall.Name,
-			Content:    fmt.Sprintf("Error: %v", callErr),
+			Content:    redactSecrets(fmt.Sprintf("Error: %v", callErr)),
 		}
 	}
 
 	return llms.ToolCallResponse{
 		ToolCallID: tc.ID,
 		Name:       tc.FunctionCall.Name,
-		Content:    out,
+		Content:    redactSecrets(out),
+	}
+}
+
+// callTool runs a tool once, through the scheduler when there is one
+func (s *Session) callTool(ctx context.Context, tool lctools.Tool, argsJSON string) (string, error) {
+	if s.scheduler != nil {
+		res := <-s.scheduler.Schedule(tool, argsJSON)
+		return res.Output, res.Error
+	}
+	return tool.Call(ctx, argsJSON)
+}
+
+// RunTool calls a tool with argsJSON through the scheduler, bypassing the model, after checking
+// the arguments against its schema. Plan mode limits the tools as it does for the model
+func (s *Session) RunTool(ctx context.Context, name, argsJSON string) (string, error) {
+	argsJSON = normalizeToolArguments(argsJSON)
+	tool, ok := s.toolCatalog[name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+	if s.planMode && !slices.Contains(planModeTools, name) {
+		return "", fmt.Errorf("%s is not available in plan mode", name)
+	}
+	if schemaTool, ok := tool.(Tool); ok {
+		if err := validateToolArguments(schemaTool.ParameterSchema(), argsJSON); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	out, err := s.callTool(ctx, tool, argsJSON)
+	return redactSecrets(out), err
+}
+
+// toolRetryBaseDelay is the backoff before the first tool retry, doubled on each attempt
+var toolRetryBaseDelay = 500 * time.Millisecond
+
+// isTransientToolError reports whether a tool error is worth retrying:
+// timeouts, dropped or refused connections and unexpected EOFs
+func isTransientToolError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// turnGuard runs the turns of a session one after the other and guards changes to its
+// message history. The UI only takes the messages lock, which is never held while
+// notifying the UI as that may block until the UI reads.
+type turnGuard struct {
+	mu       sync.Mutex
+	last     chan struct{} // closed when the last queued turn ends
+	messages sync.Mutex    // held while Messages is read for a snapshot or changed
+	pending  atomic.Int32
+	muted    atomic.Bool // drops the notifications of the turns being stopped
+}
+
+// queue reserves the next turn, so turns run in the order they were queued. It returns
+// the func waiting for the turns queued before and the func ending this one.
+func (g *turnGuard) queue() (wait func(), done func()) {
+	if g == nil {
+		return func() {}, func() {}
+	}
+	g.pending.Add(1)
+	g.mu.Lock()
+	previous, current := g.last, make(chan struct{})
+	g.last = current
+	g.mu.Unlock()
+	wait = func() {
+		if previous != nil {
+			<-previous
+		}
+	}
+	done = func() {
+		close(current)
+		g.pending.Add(-1)
+	}
+	return wait, done
+}
+
+// idle returns a channel closed once the turns queued so far have ended
+func (g *turnGuard) idle() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.last == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return g.last
+}
+
+// lockMessages locks the message history and returns the unlock func
+func (g *turnGuard) lockMessages() func() {
+	if g == nil {
+		return func() {}
+	}
+	g.messages.Lock()
+	return g.messages.Unlock
+}
+
+// Busy reports whether a turn is queued or running
+func (s *Session) Busy() bool {
+	return s.guard != nil && s.guard.pending.Load() > 0
+}
+
+// StopTurn cancels the running turn and waits for the queued turns to end, so the history
+// can be rolled back without a turn still writing to it. Their notifications are dropped
+// meanwhile. It reports false when they don't end within timeout.
+func (s *Session) StopTurn(cancel context.CancelFunc, timeout time.Duration) bool {
+	if s.guard == nil {
+		return true
+	}
+	s.guard.muted.Store(true)
+	defer s.guard.muted.Store(false)
+	if cancel != nil {
+		cancel()
+	}
+	select {
+	case <-s.guard.idle():
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
 
+// addMessages appends to the message history under the messages lock
+func (s *Session) addMessages(msgs ...llms.MessageContent) {
+	unlock := s.guard.lockMessages()
+	s.Messages = append(s.Messages, msgs...)
+	unlock()
+}
+
 // GetMessageSnapshot returns the current size of the message history for rollback purposes
 func (s *Session) GetMessageSnapshot() int {
+	defer s.guard.lockMessages()()
 	return len(s.Messages)
 }
 
@@ -570,11 +1718,13 @@ func (s *Session) RollbackTo(snapshot int) {
 	if snapshot < 1 {
 		snapshot = 1 // always preserve the system prompt
 	}
-	if snapshot > len(s.Messages) {
-		snapshot = len(s.Messages)
-	}
-	if snapshot < len(s.Messages) {
+	unlock := s.guard.lockMessages()
+	truncated := snapshot < len(s.Messages)
+	if truncated {
 		s.Messages = s.Messages[:snapshot]
+	}
+	unlock()
+	if truncated {
 		// Invalidate context cache since messages changed
 		s.updateTokenCounts()
 	}
@@ -609,7 +1759,7 @@ func (s *Session) processToolCalls(ctx context.Context, toolCalls []llms.ToolCal
 			continue
 		}
 		name := tc.FunctionCall.Name
-		argsJSON := tc.FunctionCall.Arguments
+		argsJSON := normalizeToolArguments(tc.FunctionCall.Arguments)
 
 		// Check for context cancellation before processing each tool call
 		select {
@@ -653,6 +1803,17 @@ func (s *Session) processToolCalls(ctx context.Context, toolCalls []llms.ToolCal
 		}
 
 		tool, ok := s.toolCatalog[name]
+		if ok && s.planMode && !slices.Contains(planModeTools, name) {
+			toolMessages = append(toolMessages, llms.MessageContent{
+				Role: llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{
+					ToolCallID: tc.ID,
+					Name:       name,
+					Content:    fmt.Sprintf("error: %s is not available in plan mode, outline the change instead", name),
+				}},
+			})
+			continue
+		}
 		if !ok {
 			// If the model requested an unknown tool, feed an error response back.
 			toolMessages = append(toolMessages, llms.MessageContent{
@@ -666,8 +1827,38 @@ func (s *Session) processToolCalls(ctx context.Context, toolCalls []llms.ToolCal
 			continue
 		}
 
+		// Reject malformed arguments with a response the model can correct from
+		if schemaTool, ok := tool.(Tool); ok {
+			if err := validateToolArguments(schemaTool.ParameterSchema(), argsJSON); err != nil {
+				slog.Debug("invalid tool arguments", "tool", name, "args", argsJSON, "error", err)
+				toolMessages = append(toolMessages, llms.MessageContent{
+					Role: llms.ChatMessageTypeTool,
+					Parts: []llms.ContentPart{llms.ToolCallResponse{
+						ToolCallID: tc.ID,
+						Name:       name,
+						Content:    fmt.Sprintf("error: invalid arguments: %v", err),
+					}},
+				})
+				continue
+			}
+		}
+
+		if denial := s.toolPermissionDenial(ctx, name, argsJSON); denial != "" {
+			toolMessages = append(toolMessages, llms.MessageContent{
+				Role: llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{
+					ToolCallID: tc.ID,
+					Name:       name,
+					Content:    denial,
+				}},
+			})
+			continue
+		}
+
 		// Execute tool and add response
+		s.checkpoint(name)
 		response := s.executeToolCall(ctx, tool, tc, argsJSON)
+		s.recordEdit(name, argsJSON, response)
 		slog.Debug("Called a tool", "tool", name, "args", argsJSON)
 		toolMessages = append(toolMessages, llms.MessageContent{
 			Role:  llms.ChatMessageTypeTool,
@@ -678,18 +1869,134 @@ func (s *Session) processToolCalls(ctx context.Context, toolCalls []llms.ToolCal
 	return toolMessages, false // shouldReturn = false
 }
 
+// SetApproveTools makes the tools with the ask permission run without asking, for --yes
+func (s *Session) SetApproveTools(on bool) {
+	s.approveTools = on
+}
+
+// toolPermissionDenial applies the [permissions] policy of a tool call, asking the user when
+// it's ask, and returns the response telling the model why the call may not run, "" when it may
+func (s *Session) toolPermissionDenial(ctx context.Context, name, argsJSON string) string {
+	if s.systemConfig == nil {
+		return ""
+	}
+	switch s.systemConfig.ToolPermission(name) {
+	case PermissionDeny:
+		return fmt.Sprintf("error: %s is denied by the permissions config, do without it", name)
+	case PermissionAsk:
+		if s.approveTools || s.alwaysApprovedTools[name] {
+			return ""
+		}
+		approval, err := requestToolApproval(ctx, name, argsJSON)
+		if err != nil {
+			return fmt.Sprintf("error: %s needs the user's approval, which couldn't be asked: %v", name, err)
+		}
+		switch approval {
+		case ToolApprovedAlways:
+			if s.alwaysApprovedTools == nil {
+				s.alwaysApprovedTools = make(map[string]bool)
+			}
+			s.alwaysApprovedTools[name] = true
+		case ToolDenied:
+			return fmt.Sprintf("error: the user denied this %s call", name)
+		}
+	}
+	return ""
+}
+
+// maxAutoContinuations caps the continue turns llm.auto_continue_on_max_tokens issues for one reply
+const maxAutoContinuations = 3
+
+// autoContinuePrompt asks the model to finish a reply cut off by the output token limit
+const autoContinuePrompt = "Your previous reply was cut off by the output token limit. Continue exactly where it stopped, without repeating anything or adding a preamble."
+
+// autoContinue asks the model to finish a reply cut off by the output token limit, when
+// llm.auto_continue_on_max_tokens is on. Replies with tool calls aren't continued as their
+// arguments may be incomplete. It reports whether a continue turn was queued.
+func (s *Session) autoContinue(reply string, toolCalls []llms.ToolCall, continuations *int) bool {
+	if !s.config.AutoContinueOnMaxTokens || len(toolCalls) > 0 || strings.TrimSpace(reply) == "" {
+		return false
+	}
+	if *continuations >= maxAutoContinuations {
+		slog.Warn("reply still cut off after auto-continuing", "continuations", *continuations)
+		return false
+	}
+	*continuations++
+	slog.Info("auto-continuing a reply cut off by max tokens", "continuation", *continuations, "chars", len(reply))
+	s.appendMessages(reply, nil)
+	s.addMessages(llms.TextParts(llms.ChatMessageTypeHuman, autoContinuePrompt))
+	s.updateTokenCounts()
+	return true
+}
+
+// mergeContinuation drops the cut off reply and the continue prompt autoContinue added,
+// returning the whole reply so the history keeps it as a single message
+func (s *Session) mergeContinuation(truncated, continuation string) string {
+	unlock := s.guard.lockMessages()
+	s.Messages = s.Messages[:len(s.Messages)-2]
+	unlock()
+	s.updateTokenCounts()
+	return truncated + continuation
+}
+
 // Ask sends a user prompt through the native loop. It returns the final assistant text.
 // It handles provider-native tool calls by executing them and feeding results back.
 func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
+	wait, done := s.guard.queue()
+	wait()
+	defer done()
+	return s.ask(ctx, prompt)
+}
+
+// AskWhole sends a user prompt through the native loop in the background like AskStream, for
+// ui.streaming = false, but notifies the reply in one chunk once it's complete
+func (s *Session) AskWhole(ctx context.Context, prompt string) {
+	wait, done := s.guard.queue()
+	go func() {
+		wait()
+		defer done()
+
+		notify := s.notify
+		if notify == nil {
+			notify = func(any) {}
+		}
+		notify(streamStartMsg{})
+		reply, err := s.ask(ctx, prompt)
+		switch {
+		case ctx.Err() != nil:
+			notify(streamInterruptedMsg{})
+		case err != nil:
+			notify(streamErrorMsg{err: err})
+		default:
+			if reply == emptyResponsePlaceholder {
+				notify(streamEmptyResponseMsg{})
+			} else {
+				notify(streamChunkMsg(reply))
+			}
+			notify(streamCompleteMsg{})
+		}
+	}()
+}
+
+// ask is Ask once the previous turns ended
+func (s *Session) ask(ctx context.Context, prompt string) (reply string, err error) {
 	// Build prompt with context if available and add to messages
 	s.prepareUserMessage(prompt)
+	defer s.finishTurnTiming()
+	defer func() {
+		if err == nil {
+			s.autoCommitTurn(reply)
+		}
+	}()
 	// Clear context after building the prompt
-	defer s.ClearContext()
+	defer s.endTurnContext()
 
 	// A simple loop: generate -> maybe tool calls -> tool responses -> generate.
 	var finalText string
 	var lastAssistant string
 	var hadAnyToolCall bool
+	var truncated string
+	var continuations int
 	var i int
 	maxTurns := s.config.MaxTurns
 	for i = 0; i < maxTurns; i++ {
@@ -697,9 +2004,17 @@ func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		if truncated != "" {
+			choice.Content = s.mergeContinuation(truncated, choice.Content)
+			truncated = ""
+		}
 
 		// Check if response was truncated due to max tokens
 		if choice.StopReason == "max_tokens" {
+			if s.autoContinue(choice.Content, choice.ToolCalls, &continuations) {
+				truncated = choice.Content
+				continue
+			}
 			return choice.Content + "\n\n[Response truncated due to length limit]", nil
 		}
 
@@ -717,6 +2032,9 @@ func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
 
 		// Handle tool calls, if any.
 		if len(choice.ToolCalls) == 0 {
+			if strings.TrimSpace(responseText) == "" {
+				break
+			}
 			// Give the model another turn to issue tool calls if it only planned.
 			// Stop if it repeats the same assistant content.
 			if hadAnyToolCall || strings.TrimSpace(choice.Content) == strings.TrimSpace(lastAssistant) {
@@ -730,7 +2048,7 @@ func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
 		// Process tool calls and add responses
 		toolMessages, shouldReturn := s.processToolCalls(ctx, choice.ToolCalls)
 		if len(toolMessages) > 0 {
-			s.Messages = append(s.Messages, toolMessages...)
+			s.addMessages(toolMessages...)
 			// Invalidate context cache since messages changed
 			s.updateTokenCounts()
 		}
@@ -747,6 +2065,9 @@ func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
 		// No tool responses to send; break.
 		break
 	}
+	if strings.TrimSpace(finalText) == "" {
+		finalText = emptyResponsePlaceholder
+	}
 	if i < maxTurns {
 		return finalText, nil
 	}
@@ -756,13 +2077,20 @@ func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
 // AskStream sends a user prompt through the native loop with streaming support.
 // It launches the streaming process in a goroutine and returns immediately.
 // Uses the notify callback to send streaming chunks as they arrive.
-// Supports cancellation via the provided context.
+// Supports cancellation via the provided context. A turn still running when AskStream is
+// called again finishes before the new one starts, so their messages never interleave.
 func (s *Session) AskStream(ctx context.Context, prompt string) {
+	// Queued before the goroutine starts to keep the order and make Busy true as soon as AskStream returns
+	wait, done := s.guard.queue()
 	// Launch streaming in a goroutine to avoid blocking the UI
 	go func() {
+		wait()
+		defer done()
+
 		// Ensure cleanup on exit
 		defer func() {
-			s.ClearContext()
+			s.endTurnContext()
+			s.finishTurnTiming()
 		}()
 
 		// Build prompt with context if available and add to messages
@@ -776,6 +2104,9 @@ func (s *Session) AskStream(ctx context.Context, prompt string) {
 		// A simple loop: generate -> maybe tool calls -> tool responses -> generate.
 		// Cap at a few iterations to avoid infinite loops.
 		var i int
+		var lastResponse string
+		var truncated string
+		var continuations int
 		maxTurns := s.config.MaxTurns
 		for i = 0; i < maxTurns; i++ {
 			s.resetStreamBuffer()
@@ -836,9 +2167,20 @@ func (s *Session) AskStream(ctx context.Context, prompt string) {
 
 			// Use accumulated content as the response
 			responseContent := s.getStreamBuffer(false)
+			if truncated != "" {
+				responseContent = s.mergeContinuation(truncated, responseContent)
+				truncated = ""
+			}
+			if strings.TrimSpace(responseContent) != "" {
+				lastResponse = responseContent
+			}
 
 			// Check if response was truncated due to max tokens
 			if choice.StopReason == "max_tokens" {
+				if s.autoContinue(responseContent, choice.ToolCalls, &continuations) {
+					truncated = responseContent
+					continue
+				}
 				if s.notify != nil {
 					s.notify(streamMaxTokensReachedMsg{content: responseContent})
 				}
@@ -857,13 +2199,16 @@ func (s *Session) AskStream(ctx context.Context, prompt string) {
 			// Handle tool calls, if any.
 			if len(choice.ToolCalls) == 0 {
 				// No tool calls - streaming is complete
+				if strings.TrimSpace(responseContent) == "" && choice.ReasoningContent == "" && s.notify != nil {
+					s.notify(streamEmptyResponseMsg{})
+				}
 				break
 			}
 
 			// Process tool calls and add responses
 			toolMessages, shouldReturn := s.processToolCalls(ctx, choice.ToolCalls)
 			if len(toolMessages) > 0 {
-				s.Messages = append(s.Messages, toolMessages...)
+				s.addMessages(toolMessages...)
 				// Invalidate context cache since messages changed
 				s.updateTokenCounts()
 			}
@@ -882,6 +2227,8 @@ func (s *Session) AskStream(ctx context.Context, prompt string) {
 		}
 
 		// Check if we exceeded max turns and send appropriate notification
+		s.autoCommitTurn(lastResponse)
+		s.finishTurnTiming()
 		if s.notify != nil {
 			if i >= maxTurns {
 				s.notify(streamMaxTurnsExceededMsg{maxTurns: maxTurns})
@@ -927,6 +2274,52 @@ func normalizeBuildVersion(v string) string {
 	return strings.TrimPrefix(v, "v")
 }
 
+// agentsFileName returns the configured project context file, AGENTS.md by default
+func agentsFileName(cfg *Config) string {
+	if cfg != nil && cfg.Session.AgentsFile != "" {
+		return cfg.Session.AgentsFile
+	}
+	return "AGENTS.md"
+}
+
+// agentsReference matches an @path reference in the agents file, but not an email address
+var agentsReference = regexp.MustCompile("(?:^|[\\s(`'\"])@([A-Za-z0-9_./-]+)")
+
+// agentsReferences returns the relative paths the agents file references as @path, in order
+func agentsReferences(agents string) []string {
+	var paths []string
+	for _, match := range agentsReference.FindAllStringSubmatch(agents, -1) {
+		path := filepath.Clean(strings.TrimRight(match[1], ".,:;"))
+		if path == "." || filepath.IsAbs(path) || strings.HasPrefix(path, "..") || slices.Contains(paths, path) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// attachAgentsReferences adds the files referenced by the agents file to the context,
+// skipping missing and binary files and those over the context limits
+func (s *Session) attachAgentsReferences(agents string) {
+	for _, path := range agentsReferences(agents) {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			slog.Debug("skipping agents file reference", "path", path, "error", err)
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || isBinaryContent(content) {
+			slog.Debug("skipping agents file reference", "path", path, "error", err)
+			continue
+		}
+		if err := s.AddContextFile(path, string(content)); err != nil {
+			slog.Warn("not attaching agents file reference", "path", path, "error", err)
+			continue
+		}
+		slog.Info("attached agents file reference", "path", path)
+	}
+}
+
 // readProjectContext reads the contents of the agents file (AGENTS.md or CLAUDE.md) from the current working directory.
 func readProjectContext(agentsFile string) string {
 	wd, err := os.Getwd()
@@ -941,6 +2334,13 @@ func readProjectContext(agentsFile string) string {
 	return string(b)
 }
 
+// buildTools returns the tools of buildLLMTools, with the ones acting for the session bound to it
+func (s *Session) buildTools(cfg *Config) ([]llms.Tool, map[string]lctools.Tool) {
+	defs, catalog := buildLLMTools(cfg)
+	catalog[dispatchAgentToolName] = DispatchAgentTool{parent: s}
+	return defs, catalog
+}
+
 // buildLLMTools returns the LLM tool/function definitions and a catalog by name for execution.
 func buildLLMTools(cfg *Config) ([]llms.Tool, map[string]lctools.Tool) {
 	// Get tools with config
@@ -991,6 +2391,45 @@ func (s *Session) GetContextUsagePercent() float64 {
 	return (float64(info.UsedTokens) / float64(info.TotalTokens)) * 100
 }
 
+// CompactionLoss counts the tool calls and the files they edited, whose details compaction
+// collapses into the summary
+func (s *Session) CompactionLoss() (toolCalls, files int) {
+	edited := map[string]bool{}
+	for _, msg := range s.Messages {
+		for _, part := range msg.Parts {
+			call, ok := part.(llms.ToolCall)
+			if !ok {
+				continue
+			}
+			toolCalls++
+			if call.FunctionCall == nil || !slices.Contains(autoCommitTools, call.FunctionCall.Name) {
+				continue
+			}
+			var args struct {
+				Path string `json:"path"`
+			}
+			if json.Unmarshal([]byte(call.FunctionCall.Arguments), &args) == nil && args.Path != "" {
+				edited[args.Path] = true
+			}
+		}
+	}
+	return toolCalls, len(edited)
+}
+
+// ToolResults returns the tool results of the conversation in the order they came in,
+// numbered from 1 by :use-result
+func (s *Session) ToolResults() []llms.ToolCallResponse {
+	var results []llms.ToolCallResponse
+	for _, msg := range s.Messages {
+		for _, part := range msg.Parts {
+			if resp, ok := part.(llms.ToolCallResponse); ok {
+				results = append(results, resp)
+			}
+		}
+	}
+	return results
+}
+
 // CompactHistory summarizes the conversation history to reduce context usage
 // It uses the high-end model to create a comprehensive summary that includes:
 // - All diffs/changes made to files
@@ -998,9 +2437,55 @@ func (s *Session) GetContextUsagePercent() float64 {
 // - Important technical details
 // The summary replaces the conversation history while preserving the system message
 func (s *Session) CompactHistory(ctx context.Context, compactPrompt string) (string, error) {
+	summary, err := s.generateCompactSummary(ctx, compactPrompt)
+	if err != nil {
+		return "", err
+	}
+	s.ApplyCompactSummary(summary)
+	return summary, nil
+}
+
+// CompactPreview is what :compact --diff shows of a compaction before applying it
+type CompactPreview struct {
+	Summary      string
+	BeforeTokens int // tokens of the conversation messages
+	AfterTokens  int // tokens of the messages replacing them
+	Messages     int // conversation messages discarded
+	Turns        int // user prompts among them
+}
+
+// PreviewCompaction generates the compaction summary without applying it, for
+// ApplyCompactSummary once the user accepts it
+func (s *Session) PreviewCompaction(ctx context.Context, compactPrompt string) (CompactPreview, error) {
+	summary, err := s.generateCompactSummary(ctx, compactPrompt)
+	if err != nil {
+		return CompactPreview{}, err
+	}
+	preview := CompactPreview{
+		Summary:      summary,
+		BeforeTokens: s.CountMessagesTokens(),
+		AfterTokens:  s.countTokens(compactSummaryPrefix+summary) + s.countTokens(compactAcknowledgement),
+		Messages:     len(s.Messages) - 1,
+	}
+	for _, msg := range s.Messages[1:] {
+		if msg.Role == llms.ChatMessageTypeHuman {
+			preview.Turns++
+		}
+	}
+	return preview, nil
+}
+
+// compactAcknowledgement is the assistant's reply to the summary in a compacted history
+const compactAcknowledgement = "I understand. I have the context from the previous conversation and am ready to continue."
+
+// generateCompactSummary has the model summarize the conversation, leaving the history as it was
+func (s *Session) generateCompactSummary(ctx context.Context, compactPrompt string) (string, error) {
 	if len(s.Messages) <= 2 {
 		return "", fmt.Errorf("not enough conversation history to compact")
 	}
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("compaction cancelled: %w", err)
+	}
 
 	// Build the content to summarize
 	var contentBuilder strings.Builder
@@ -1063,12 +2548,29 @@ func (s *Session) CompactHistory(ctx context.Context, compactPrompt string) (str
 		},
 	}
 
-	// Generate the summary using the LLM
-	choice, err := s.generateLLMResponse(ctx, nil)
+	// Generate the summary using the LLM, reporting progress as it streams
+	s.notifyCompactProgress(0)
+	generated, reported := 0, 0
+	progress := func(ctx context.Context, chunk []byte) error {
+		generated += len(chunk)
+		if generated-reported >= compactProgressEvery {
+			reported = generated
+			s.notifyCompactProgress(generated)
+		}
+		return ctx.Err()
+	}
+	choice, err := s.generateLLMResponse(ctx, progress)
+	if err == nil {
+		// A model that ignores cancellation must not get its summary applied
+		err = ctx.Err()
+	}
+	// Restore original messages, the summary replaces them in ApplyCompactSummary
+	s.Messages = originalMessages
+	s.updateTokenCounts()
 	if err != nil {
-		// Restore original messages on error
-		s.Messages = originalMessages
-		s.updateTokenCounts()
+		if errors.Is(err, context.Canceled) {
+			return "", fmt.Errorf("compaction cancelled: %w", err)
+		}
 		return "", fmt.Errorf("failed to generate summary: %w", err)
 	}
 
@@ -1076,28 +2578,58 @@ func (s *Session) CompactHistory(ctx context.Context, compactPrompt string) (str
 	if choice.ReasoningContent != "" {
 		summary = choice.ReasoningContent + "\n\n" + choice.Content
 	}
+	return summary, nil
+}
 
-	// Replace the conversation history with the summary
+// ApplyCompactSummary replaces the conversation history with a compaction summary
+func (s *Session) ApplyCompactSummary(summary string) {
+	if len(s.Messages) == 0 {
+		return
+	}
+	systemMessage := s.Messages[0]
 	s.Messages = []llms.MessageContent{
 		systemMessage,
 		{
 			Role:  llms.ChatMessageTypeHuman,
-			Parts: []llms.ContentPart{llms.TextPart("Previous conversation summary:\n\n" + summary)},
+			Parts: []llms.ContentPart{llms.TextPart(compactSummaryPrefix + summary)},
 		},
 		{
 			Role:  llms.ChatMessageTypeAI,
-			Parts: []llms.ContentPart{llms.TextPart("I understand. I have the context from the previous conversation and am ready to continue.")},
+			Parts: []llms.ContentPart{llms.TextPart(compactAcknowledgement)},
 		},
 	}
 
 	// Reset tool call tracking
 	s.lastToolCallKey = ""
 	s.toolCallRepetitionCount = 0
+	s.setHistorySummary(summary)
 
 	// Invalidate context cache since messages changed
 	s.updateTokenCounts()
+}
 
-	return summary, nil
+// setHistorySummary keeps the last compaction summary and, with llm.include_history_partial,
+// rebuilds the system message so its history partial carries it
+func (s *Session) setHistorySummary(summary string) {
+	s.historySummary = summary
+	if !s.config.IncludeHistoryPartial || len(s.Messages) == 0 || s.Messages[0].Role != llms.ChatMessageTypeSystem {
+		return
+	}
+	sysMsg, err := s.buildSystemMessage(s.systemConfig, s.systemPersona)
+	if err != nil {
+		slog.Warn("cannot rebuild the system prompt with the history partial", "error", err)
+		return
+	}
+	s.Messages[0] = sysMsg
+}
+
+// compactProgressEvery is how many summary bytes are generated between progress notifications
+const compactProgressEvery = 512
+
+func (s *Session) notifyCompactProgress(chars int) {
+	if s.notify != nil {
+		s.notify(compactProgressMsg{chars: chars})
+	}
 }
 
 // extractFileChanges extracts all file changes from tool call responses
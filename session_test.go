@@ -2,17 +2,29 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/afittestide/asimi/storage"
+	"github.com/charmbracelet/x/ansi"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/fake"
 	lctools "github.com/tmc/langchaingo/tools"
 )
 
@@ -129,6 +141,50 @@ func TestSession_ToolRoundTrip(t *testing.T) {
 	assert.Contains(t, out, "This is a test file.")
 }
 
+func TestSession_ToolPermissions(t *testing.T) {
+	ask := func(t *testing.T, sess *Session) string {
+		out, err := sess.Ask(context.Background(), "please read the file")
+		require.NoError(t, err)
+		return out
+	}
+	newSession := func(t *testing.T, permission string) *Session {
+		sess, err := NewSession(&sessionMockLLM{}, &Config{Permissions: map[string]string{"read_file": permission}}, RepoInfo{}, func(any) {})
+		require.NoError(t, err)
+		return sess
+	}
+
+	require.Contains(t, ask(t, newSession(t, PermissionAllow)), "This is a test file.")
+	require.Contains(t, ask(t, newSession(t, "Deny")), "read_file is denied by the permissions config")
+	require.Contains(t, ask(t, newSession(t, PermissionAsk)), "couldn't be asked", "no one to ask without the TUI")
+	sess := newSession(t, PermissionAsk)
+	sess.SetApproveTools(true)
+	require.Contains(t, ask(t, sess), "This is a test file.", "--yes approves")
+
+	requests := make(chan ToolApprovalRequest)
+	SetToolApprovalChannel(requests)
+	t.Cleanup(func() { SetToolApprovalChannel(nil) })
+	answers := make(chan ToolApproval, 3)
+	go func() {
+		for request := range requests {
+			assert.Equal(t, "read_file", request.Tool)
+			assert.JSONEq(t, `{"path":"testdata/test.txt"}`, request.Args)
+			request.ResponseChan <- <-answers
+		}
+	}()
+	defer close(requests)
+
+	sess = newSession(t, PermissionAsk)
+	answers <- ToolDenied
+	require.Contains(t, ask(t, sess), "the user denied this read_file call")
+	answers <- ToolApprovedOnce
+	require.Contains(t, ask(t, sess), "This is a test file.")
+	// A new session keeps the repeated read under the tool call loop threshold
+	sess = newSession(t, PermissionAsk)
+	answers <- ToolApprovedAlways
+	require.Contains(t, ask(t, sess), "This is a test file.")
+	require.Contains(t, ask(t, sess), "This is a test file.", "approved for the session, no answer left to take")
+}
+
 // mockLLMNoTools returns a direct assistant message without any tool calls.
 type mockLLMNoTools struct{ llms.Model }
 
@@ -139,6 +195,199 @@ func (m *mockLLMNoTools) GenerateContent(ctx context.Context, messages []llms.Me
 	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "Hello world"}}}, nil
 }
 
+func TestSession_ToolResultRedactsSecrets(t *testing.T) {
+	t.Parallel()
+
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	tool := &mockTool{
+		name: "run_in_shell",
+		callFunc: func(ctx context.Context, input string) (string, error) {
+			return "HOME=/root\nOPENAI_API_KEY=sk-proj1234567890abcdefXYZ\n", nil
+		},
+	}
+	tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: "run_in_shell", Arguments: `{"command":"env"}`}}
+
+	resp := sess.executeToolCall(context.Background(), tool, tc, tc.FunctionCall.Arguments)
+	require.NotContains(t, resp.Content, "sk-proj1234567890abcdefXYZ")
+	require.Contains(t, resp.Content, "OPENAI_API_KEY=[REDACTED]")
+}
+
+func TestSession_ToolRetryOnTransientError(t *testing.T) {
+	orig := toolRetryBaseDelay
+	toolRetryBaseDelay = time.Millisecond
+	defer func() { toolRetryBaseDelay = orig }()
+
+	cfg := &Config{Tools: ToolsConfig{MaxRetries: 2, RetryTools: []string{"run_in_shell"}}}
+	sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	flaky := func(failErr error, calls *int) *mockTool {
+		return &mockTool{
+			name: "run_in_shell",
+			callFunc: func(ctx context.Context, input string) (string, error) {
+				*calls++
+				if *calls == 1 {
+					return "", failErr
+				}
+				return "fetched", nil
+			},
+		}
+	}
+	tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: "run_in_shell", Arguments: `{"command":"curl example.com"}`}}
+
+	// A transient failure is retried and the success is fed back
+	calls := 0
+	resp := sess.executeToolCall(context.Background(), flaky(fmt.Errorf("dial: %w", syscall.ECONNRESET), &calls), tc, tc.FunctionCall.Arguments)
+	require.Equal(t, "fetched", resp.Content)
+	require.Equal(t, 2, calls)
+
+	// Non-retryable errors pass through immediately
+	calls = 0
+	resp = sess.executeToolCall(context.Background(), flaky(errors.New("command not found"), &calls), tc, tc.FunctionCall.Arguments)
+	require.Equal(t, "Error: command not found", resp.Content)
+	require.Equal(t, 1, calls)
+
+	// Tools that didn't opt in are not retried
+	calls = 0
+	other := flaky(syscall.ECONNRESET, &calls)
+	other.name = "read_file"
+	resp = sess.executeToolCall(context.Background(), other, tc, tc.FunctionCall.Arguments)
+	require.Contains(t, resp.Content, "Error:")
+	require.Equal(t, 1, calls)
+}
+
+func TestSession_ToolMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	reader := &mockTool{name: "read_file", callFunc: func(ctx context.Context, input string) (string, error) {
+		return "contents", nil
+	}}
+	shell := &mockTool{name: "run_in_shell", callFunc: func(ctx context.Context, input string) (string, error) {
+		return "", errors.New("command not found")
+	}}
+	tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: "read_file", Arguments: `{"path":"a.go"}`}}
+	sess.executeToolCall(context.Background(), reader, tc, tc.FunctionCall.Arguments)
+	sess.executeToolCall(context.Background(), reader, tc, tc.FunctionCall.Arguments)
+	sess.executeToolCall(context.Background(), shell, tc, `{"command":"nope"}`)
+
+	metrics := sess.ToolMetrics()
+	require.Len(t, metrics, 2)
+	require.Equal(t, 2, metrics["read_file"].Calls)
+	require.Zero(t, metrics["read_file"].Errors)
+	require.Equal(t, 1, metrics["run_in_shell"].Calls)
+	require.Equal(t, 1, metrics["run_in_shell"].Errors)
+
+	model := newTestModel(t)
+	model.session = sess
+	content := handleMetricsCommand(model, nil)().(showContextMsg).content
+	require.Regexp(t, `read_file\s+2\s+0 `, content)
+	require.Regexp(t, `run_in_shell\s+1\s+1 `, content)
+	require.Regexp(t, `All\s+3\s+1 `, content)
+	require.Less(t, strings.Index(content, "read_file"), strings.Index(content, "run_in_shell"))
+
+	// The metrics are saved and resumed with the session
+	sess.Messages = append(sess.Messages, llms.MessageContent{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextContent{Text: "read a.go"}}})
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir}, 50, 30)
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.SaveSessionSync(sess))
+	loaded, err := store.LoadSession(sess.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, loaded.ToolMetrics()["read_file"].Calls)
+	require.Equal(t, 1, loaded.ToolMetrics()["run_in_shell"].Errors)
+
+	sess.ClearHistory()
+	require.Empty(t, sess.ToolMetrics())
+}
+
+func TestSession_InvalidToolArgumentsAreRejected(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	call := func(name, args string) string {
+		tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}
+		msgs, _ := sess.processToolCalls(context.Background(), []llms.ToolCall{tc})
+		require.Len(t, msgs, 1)
+		return msgs[0].Parts[0].(llms.ToolCallResponse).Content
+	}
+
+	// A missing required field never reaches the tool
+	resp := call("write_file", `{"path":"out.txt"}`)
+	require.Equal(t, `error: invalid arguments: missing required field "content"`, resp)
+	require.NoFileExists(t, "out.txt")
+
+	resp = call("write_file", `{"path":"out.txt","content":42}`)
+	require.Equal(t, `error: invalid arguments: field "content": expected a string, got a number`, resp)
+
+	resp = call("read_many_files", `{"paths":"*.go"}`)
+	require.Contains(t, resp, `field "paths": expected an array, got a string`)
+
+	resp = call("memory", `{"action":"forget"}`)
+	require.Contains(t, resp, `"forget" is not one of set, get, list, delete`)
+
+	resp = call("write_file", `not json`)
+	require.Contains(t, resp, "invalid arguments: arguments must be a JSON object")
+
+	// Empty arguments are an empty object, checked for the required fields
+	resp = call("write_file", ``)
+	require.Equal(t, `error: invalid arguments: missing required field "path"`, resp)
+	resp = call("list_files", ` `)
+	require.NotContains(t, resp, "invalid arguments")
+
+	// Valid arguments still run the tool
+	call("write_file", `{"path":"out.txt","content":"hello"}`)
+	require.FileExists(t, "out.txt")
+}
+
+func TestSession_ApplyPersona(t *testing.T) {
+	cfg := &Config{Personas: map[string]PersonaConfig{
+		"reviewer": {
+			Instruction: "Review the changes and never modify files.",
+			Tools:       []string{"read_file", "list_files"},
+		},
+	}}
+	sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.Contains(t, sess.toolCatalog, "write_file")
+
+	require.NoError(t, sess.ApplyPersona(cfg, "reviewer"))
+	require.Equal(t, "reviewer", sess.Persona())
+
+	var system strings.Builder
+	for _, part := range sess.Messages[0].Parts {
+		system.WriteString(part.(llms.TextContent).Text)
+	}
+	require.Contains(t, system.String(), "--- Persona: reviewer ---")
+	require.Contains(t, system.String(), "never modify files")
+
+	var names []string
+	for _, def := range sess.toolDefs {
+		names = append(names, def.Function.Name)
+	}
+	require.ElementsMatch(t, []string{"read_file", "list_files"}, names)
+	require.NotContains(t, sess.toolCatalog, "write_file")
+
+	require.ErrorContains(t, sess.ApplyPersona(cfg, "hacker"), "unknown persona")
+
+	// The configured default persona is applied to new sessions
+	cfg.Session.Persona = "reviewer"
+	sess, err = NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.Len(t, sess.toolDefs, 2)
+
+	cfg.Session.Persona = "missing"
+	_, err = NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.ErrorContains(t, err, "unknown persona")
+}
+
 func TestSession_NoTools(t *testing.T) {
 	t.Parallel()
 
@@ -961,6 +1210,65 @@ func TestSessionStore_RemovesDanglingToolCallsOnSave(t *testing.T) {
 	}
 }
 
+func TestSessionStore_PreservesIncompleteToolCallsOnSave(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	if err != nil {
+		t.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer db.Close()
+
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir}, 50, 30)
+	if err != nil {
+		t.Fatalf("Failed to create session store: %v", err)
+	}
+	defer store.Close()
+	store.SetPreserveIncompleteToolCalls(true)
+
+	session := &Session{
+		Messages: []llms.MessageContent{
+			{Role: llms.ChatMessageTypeSystem, Parts: []llms.ContentPart{llms.TextPart("System prompt")}},
+			{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextPart("Need help writing a file")}},
+			{
+				Role: llms.ChatMessageTypeAI,
+				Parts: []llms.ContentPart{
+					llms.TextPart("Writing it now."),
+					llms.ToolCall{
+						ID:           "toolu_123",
+						Type:         "function",
+						FunctionCall: &llms.FunctionCall{Name: "write_file", Arguments: `{"path":"main.go"}`},
+					},
+				},
+			},
+		},
+	}
+	if err := store.SaveSessionSync(session); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	loaded, err := store.LoadSession(session.ID)
+	if err != nil {
+		t.Fatalf("Failed to load session: %v", err)
+	}
+	last := loaded.Messages[len(loaded.Messages)-1]
+	if last.Role != llms.ChatMessageTypeAI {
+		t.Fatalf("Expected the interrupted AI message to be kept, got last role %q", last.Role)
+	}
+	var texts []string
+	for _, part := range last.Parts {
+		switch p := part.(type) {
+		case llms.ToolCall:
+			t.Fatalf("Expected the tool call to be replaced by a note")
+		case llms.TextContent:
+			texts = append(texts, p.Text)
+		}
+	}
+	want := []string{"Writing it now.", "[incomplete tool call: write_file was interrupted]"}
+	if !slices.Equal(texts, want) {
+		t.Fatalf("Expected parts %q, got %q", want, texts)
+	}
+}
+
 // mockLLMValidateNoUnmatchedCalls verifies that messages have no unmatched tool calls
 type mockLLMValidateNoUnmatchedCalls struct {
 	llms.Model
@@ -1223,6 +1531,169 @@ func TestSessionStore_ListSessionsLimit(t *testing.T) {
 	}
 }
 
+func TestSessionStore_MaxStorageBytes(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir}, 3, 30)
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := range 4 {
+		require.NoError(t, store.SaveSessionSync(&Session{
+			Messages: []llms.MessageContent{
+				llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf("prompt %d %s", i, strings.Repeat("x", 1000))),
+			},
+			ContextFiles: map[string]string{},
+		}))
+	}
+
+	// The count limit still applies
+	require.NoError(t, store.CleanupOldSessions())
+	usage, err := store.Usage()
+	require.NoError(t, err)
+	require.Equal(t, 3, usage.Sessions)
+	require.Greater(t, usage.Bytes, int64(3000))
+
+	// Setting a budget for two and a half sessions deletes nothing by itself
+	perSession := usage.Bytes / 3
+	store.SetMaxStorageBytes(2*perSession + perSession/2)
+	require.NoError(t, store.CleanupOldSessions())
+	sessions, err := store.ListSessions(10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+
+	// Trimming keeps the two newest
+	deleted, err := store.TrimStorage()
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	sessions, err = store.ListSessions(10)
+	require.NoError(t, err)
+	var kept []string
+	for _, sess := range sessions {
+		kept = append(kept, sess.FirstPrompt[:len("prompt 0")])
+	}
+	require.ElementsMatch(t, []string{"prompt 2", "prompt 3"}, kept)
+	usage, err = store.Usage()
+	require.NoError(t, err)
+	require.LessOrEqual(t, usage.Bytes, 2*perSession+perSession/2)
+
+	// The newest session is kept even when it alone is over budget
+	store.SetMaxStorageBytes(10)
+	deleted, err = store.TrimStorage()
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	sessions, err = store.ListSessions(10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.True(t, strings.HasPrefix(sessions[0].FirstPrompt, "prompt 3"))
+
+	// :sessions du reports the usage and the limit
+	model := newTestModel(t)
+	model.sessionStore = store
+	model.config.Session.MaxStorageBytes = 10 * 1024
+	out := handleSessionsCommand(model, []string{"du"})().(showContextMsg).content
+	require.Contains(t, out, "Sessions: 1 stored, ")
+	require.Contains(t, out, "Limit: 10.0 KB")
+}
+
+func TestSessionStore_AllBranches(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	save := func(branch, prompt string) {
+		store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir, Branch: branch}, 50, 30)
+		require.NoError(t, err)
+		defer store.Close()
+		require.NoError(t, store.SaveSessionSync(&Session{
+			Messages:     []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)},
+			ContextFiles: map[string]string{},
+		}))
+	}
+	save("main", "on main")
+	save("feature/login", "on the login branch")
+
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir, Branch: "main"}, 50, 30)
+	require.NoError(t, err)
+	defer store.Close()
+	sessions, err := store.ListSessions(10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, "main", sessions[0].Branch)
+
+	sessions, err = store.ListAllBranchesSessions("", 10)
+	require.NoError(t, err)
+	branches := map[string]string{}
+	for _, session := range sessions {
+		branches[session.FirstPrompt] = session.Branch
+	}
+	require.Equal(t, map[string]string{"on main": "main", "on the login branch": "feature-login"}, branches)
+
+	loaded, err := store.LoadSession(sessions[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, sessions[0].Branch, loaded.Branch)
+}
+
+func TestSessionStore_Tags(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir}, 50, 30)
+	require.NoError(t, err)
+	defer store.Close()
+
+	newSession := func(prompt string) *Session {
+		return &Session{
+			Messages: []llms.MessageContent{
+				{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.TextContent{Text: prompt}}},
+			},
+			ContextFiles: map[string]string{},
+		}
+	}
+	require.NoError(t, store.SaveSessionSync(newSession("untagged")))
+
+	// :tag saves the session with its tags
+	model := newTestModel(t)
+	model.config.Session.Enabled = true
+	model.sessionStore = store
+	model.session = newSession("fix the login bug")
+	msg := handleTagCommand(model, []string{"#Bugfix", "auth", "auth"})()
+	require.Contains(t, msg.(showContextMsg).content, "Session tags: #auth #bugfix")
+
+	sessions, err := store.ListSessions(10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	tagged := map[string][]string{}
+	for _, session := range sessions {
+		tagged[session.FirstPrompt] = session.Tags
+	}
+	require.Equal(t, []string{"auth", "bugfix"}, tagged["fix the login bug"])
+	require.Empty(t, tagged["untagged"])
+
+	sessions, err = store.ListTaggedSessions("bugfix", 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, model.session.ID, sessions[0].ID)
+
+	loaded, err := store.LoadSession(model.session.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"auth", "bugfix"}, loaded.Tags)
+
+	// -name removes a tag
+	handleTagCommand(model, []string{"-bugfix"})()
+	sessions, err = store.ListTaggedSessions("bugfix", 10)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	resume := NewResumeWindow()
+	resume.SetSessions([]Session{*loaded})
+	require.Contains(t, resume.RenderList(0, 0, 5), "#auth #bugfix")
+}
+
 func TestFormatRelativeTime(t *testing.T) {
 	now := time.Now()
 
@@ -1315,6 +1786,88 @@ func TestSession_AskStream(t *testing.T) {
 	assert.Equal(t, 1, completeCount, "Should have received exactly one complete notification")
 }
 
+func TestSession_SystemPromptIncludesProjectMemory(t *testing.T) {
+	memory := useTestProjectMemory(t)
+
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.NotContains(t, sess.Messages[0].Parts[0].(llms.TextContent).Text, "## Memory")
+
+	_, err = MemoryTool{}.Call(context.Background(), `{"action": "set", "key": "test-command", "value": "just test"}`)
+	require.NoError(t, err)
+	require.Equal(t, "- test-command: just test\n", memory.Prompt())
+
+	sess, err = NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	system := sess.Messages[0].Parts[0].(llms.TextContent).Text
+	require.Contains(t, system, "## Memory")
+	require.Contains(t, system, "- test-command: just test")
+}
+
+func TestSession_AskEmptyResponse(t *testing.T) {
+	t.Parallel()
+
+	sess, err := NewSession(&sessionMockLLM{response: "  \n\t "}, nil, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	out, err := sess.Ask(context.Background(), "Hello")
+	require.NoError(t, err)
+	assert.Equal(t, emptyResponsePlaceholder, out)
+
+	// Only the system prompt and the user message are stored
+	require.Len(t, sess.Messages, 2)
+	assert.Equal(t, llms.ChatMessageTypeHuman, sess.Messages[1].Role)
+}
+
+func TestSession_AskStreamEmptyResponse(t *testing.T) {
+	var mu sync.Mutex
+	var notifications []any
+	done := make(chan struct{})
+	notify := func(msg any) {
+		mu.Lock()
+		notifications = append(notifications, msg)
+		mu.Unlock()
+		if _, ok := msg.(streamCompleteMsg); ok {
+			close(done)
+		}
+	}
+
+	sess, err := NewSession(&sessionMockLLM{response: "  \n "}, nil, RepoInfo{}, notify)
+	require.NoError(t, err)
+
+	sess.AskStream(context.Background(), "Hello")
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not complete")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var emptyCount int
+	for _, n := range notifications {
+		if _, ok := n.(streamEmptyResponseMsg); ok {
+			emptyCount++
+		}
+	}
+	assert.Equal(t, 1, emptyCount, "Should notify about the empty response")
+	for _, msg := range sess.Messages {
+		assert.NotEqual(t, llms.ChatMessageTypeAI, msg.Role, "Empty assistant message should not be stored")
+	}
+}
+
+func TestChatComponent_EmptyResponsePlaceholder(t *testing.T) {
+	model := newTestModel(t)
+
+	updated, _ := model.Update(streamChunkMsg(" \n"))
+	updated, _ = updated.(TUIModel).Update(streamEmptyResponseMsg{})
+	m := updated.(TUIModel)
+
+	messages := m.content.Chat.Messages
+	require.Equal(t, "Asimi: "+emptyResponsePlaceholder, messages[len(messages)-1])
+	require.False(t, containsMessage(messages[:len(messages)-1], "Asimi:"), "Blank bubble should be replaced")
+}
+
 func TestChatComponent_AppendToLastMessage(t *testing.T) {
 	chat := NewChatComponent(80, 20, false)
 
@@ -1550,3 +2103,865 @@ func TestSession_OAuthTokenRefreshOnError(t *testing.T) {
 		assert.Equal(t, "network timeout", err.Error())
 	})
 }
+
+func TestSession_AddContextFileLimits(t *testing.T) {
+	cfg := &Config{
+		LLM:   LLMConfig{Provider: "fake"},
+		Tools: ToolsConfig{MaxContextFiles: 2, MaxContextBytes: 10},
+	}
+	sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	require.NoError(t, sess.AddContextFile("a.txt", "1234"))
+	require.NoError(t, sess.AddContextFile("b.txt", "1234"))
+
+	err = sess.AddContextFile("c.txt", "1")
+	require.ErrorIs(t, err, ErrContextLimit, "third file is over the file cap")
+	assert.NotContains(t, sess.ContextFiles, "c.txt")
+
+	// Replacing a loaded file doesn't count it twice
+	require.NoError(t, sess.AddContextFile("b.txt", "123456"))
+	err = sess.AddContextFile("b.txt", "1234567")
+	require.ErrorIs(t, err, ErrContextLimit, "over the byte cap")
+	assert.Equal(t, "123456", sess.ContextFiles["b.txt"])
+
+	files, bytes := sess.ContextUsage()
+	assert.Equal(t, 2, files)
+	assert.Equal(t, 10, bytes)
+
+	msg := renderContextLimits(sess)
+	assert.Contains(t, msg, "Files: 2 / 2")
+	assert.Contains(t, msg, "Bytes: 10 / 10")
+}
+
+func TestSession_StickyContext(t *testing.T) {
+	// promptsWithContext lists which human messages carried the context file
+	promptsWithContext := func(sess *Session) []bool {
+		var with []bool
+		for _, msg := range sess.Messages {
+			if msg.Role == llms.ChatMessageTypeHuman {
+				with = append(with, strings.Contains(msg.Parts[0].(llms.TextContent).Text, "--- Context from: notes.txt ---"))
+			}
+		}
+		return with
+	}
+	twoTurns := func(sticky bool) *Session {
+		cfg := &Config{LLM: LLMConfig{Provider: "fake"}, Tools: ToolsConfig{StickyContext: sticky}}
+		sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+		require.NoError(t, err)
+		require.NoError(t, sess.AddContextFile("notes.txt", "remember me"))
+		for _, prompt := range []string{"first", "second"} {
+			_, err = sess.Ask(context.Background(), prompt)
+			require.NoError(t, err)
+		}
+		return sess
+	}
+
+	sess := twoTurns(false)
+	assert.Equal(t, []bool{true, false}, promptsWithContext(sess), "context goes with the next prompt only")
+	assert.Empty(t, sess.ContextFiles)
+
+	sess = twoTurns(true)
+	assert.Equal(t, []bool{true, true}, promptsWithContext(sess), "sticky context goes with every prompt")
+	assert.Contains(t, sess.ContextFiles, "notes.txt")
+
+	model := newTestModel(t)
+	model.SetSession(sess)
+	msg := handleContextCommand(model, []string{"clear"})()
+	assert.Contains(t, msg.(showContextMsg).content, "Cleared 1 context files")
+	assert.Empty(t, sess.ContextFiles)
+}
+
+func TestSession_TurnTiming(t *testing.T) {
+	sess, err := NewSession(fake.NewFakeLLM([]string{"hello"}), &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	assert.True(t, sess.LastTurnTiming().Started.IsZero())
+
+	_, err = sess.Ask(context.Background(), "hi")
+	require.NoError(t, err)
+
+	timing := sess.LastTurnTiming()
+	assert.False(t, timing.Started.IsZero())
+	assert.Positive(t, timing.PromptBuild)
+	assert.Positive(t, timing.FirstToken)
+	assert.Positive(t, timing.LLMCalls)
+	assert.GreaterOrEqual(t, timing.Total, timing.PromptBuild+timing.Generation)
+
+	model := newTestModel(t)
+	model.SetSession(sess)
+	out := handlePerfCommand(model, nil)().(showContextMsg).content
+	assert.Contains(t, out, "Prompt build:")
+	assert.Contains(t, out, "First token:")
+	assert.Contains(t, out, "Model time:")
+	assert.Contains(t, out, fmt.Sprintf("over %d request(s)", timing.LLMCalls))
+	assert.NotContains(t, out, "still running")
+}
+
+func TestSession_AutoCommitTurn(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	repo, err := gogit.PlainInit(dir, false)
+	require.NoError(t, err)
+	repoCfg, err := repo.Config()
+	require.NoError(t, err)
+	repoCfg.User.Name = "Test"
+	repoCfg.User.Email = "test@example.com"
+	require.NoError(t, repo.SetConfig(repoCfg))
+
+	edit := func(sess *Session, path, content string) {
+		tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{
+			Name:      "write_file",
+			Arguments: fmt.Sprintf(`{"path":%q,"content":%q}`, path, content),
+		}}
+		sess.prepareUserMessage("edit " + path)
+		sess.processToolCalls(context.Background(), []llms.ToolCall{tc})
+		sess.autoCommitTurn("## Add " + path + "\n\nDone.")
+	}
+	commits := func() int {
+		iter, err := repo.Log(&gogit.LogOptions{})
+		if err != nil {
+			return 0 // no HEAD yet
+		}
+		n := 0
+		require.NoError(t, iter.ForEach(func(*object.Commit) error { n++; return nil }))
+		return n
+	}
+
+	// Disabled: the edit stays uncommitted
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{Branch: "feature"}, func(any) {})
+	require.NoError(t, err)
+	edit(sess, "off.txt", "off\n")
+	require.FileExists(t, "off.txt")
+	require.Equal(t, 0, commits())
+
+	// Enabled on the main branch: skipped
+	cfg := &Config{Git: GitConfig{AutoCommit: true}}
+	sess, err = NewSession(&mockLLMNoTools{}, cfg, RepoInfo{Branch: "master", IsMain: true}, func(any) {})
+	require.NoError(t, err)
+	edit(sess, "main.txt", "main\n")
+	require.Equal(t, 0, commits())
+
+	// Enabled on a feature branch: the edited file is committed, and only that file
+	sess, err = NewSession(&mockLLMNoTools{}, cfg, RepoInfo{Branch: "feature"}, func(any) {})
+	require.NoError(t, err)
+	edit(sess, "hello.txt", "hello\n")
+	require.Equal(t, 1, commits())
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(commit.Message, "Add hello.txt\n"), commit.Message)
+	require.Contains(t, commit.Message, "- hello.txt")
+	_, err = commit.File("hello.txt")
+	require.NoError(t, err)
+	_, err = commit.File("off.txt")
+	require.Error(t, err)
+
+	// Files the user staged keep the turn from committing, rather than slipping into its commit
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Add("off.txt")
+	require.NoError(t, err)
+	var notes []string
+	sess, err = NewSession(&mockLLMNoTools{}, cfg, RepoInfo{Branch: "feature"}, func(msg any) {
+		if note, ok := msg.(showContextMsg); ok {
+			notes = append(notes, note.content)
+		}
+	})
+	require.NoError(t, err)
+	edit(sess, "second.txt", "second\n")
+	require.Equal(t, 1, commits())
+	require.NotEmpty(t, notes)
+	require.Contains(t, notes[len(notes)-1], "off.txt is already staged")
+}
+
+func TestAutoCommitMessage(t *testing.T) {
+	require.Equal(t, "Update a.go, b.go\n\nEdited by asimi:\n- a.go\n- b.go\n", autoCommitMessage("", []string{"a.go", "b.go"}))
+	msg := autoCommitMessage("\n**"+strings.Repeat("x", 100)+"**", []string{"a.go"})
+	subject := strings.SplitN(msg, "\n", 2)[0]
+	require.Equal(t, 72, len([]rune(subject)))
+}
+
+func TestSession_PlanMode(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	toolNames := func() []string {
+		var names []string
+		for _, def := range sess.activeToolDefs() {
+			names = append(names, def.Function.Name)
+		}
+		return names
+	}
+	callWriteFile := func() string {
+		tc := llms.ToolCall{ID: "tc1", FunctionCall: &llms.FunctionCall{Name: "write_file", Arguments: `{"path":"plan.txt","content":"x"}`}}
+		msgs, _ := sess.processToolCalls(context.Background(), []llms.ToolCall{tc})
+		require.Len(t, msgs, 1)
+		return msgs[0].Parts[0].(llms.ToolCallResponse).Content
+	}
+
+	// Only a plan asked for in plan mode can be accepted
+	_, err = sess.AcceptPlan()
+	require.ErrorContains(t, err, "not in plan mode")
+
+	sess.SetPlanMode(true)
+	require.True(t, sess.PlanMode())
+	require.ElementsMatch(t, planModeTools, toolNames())
+	require.Contains(t, callWriteFile(), "not available in plan mode")
+	require.NoFileExists(t, "plan.txt")
+
+	sess.prepareUserMessage("how would you add a flag?")
+	last := sess.Messages[len(sess.Messages)-1].Parts[0].(llms.TextContent).Text
+	require.Contains(t, last, "Plan mode")
+
+	// Nothing to accept before the model replies with a plan
+	accepted, err := sess.AcceptPlan()
+	require.NoError(t, err)
+	require.False(t, accepted)
+	require.True(t, sess.PlanMode())
+
+	sess.Messages = append(sess.Messages, llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{llms.TextPart("<thinking>\nA flag, then a test\n</thinking>\n\n1. Add the flag\n2. Test it")}})
+	accepted, err = sess.AcceptPlan()
+	require.NoError(t, err)
+	require.True(t, accepted)
+	require.False(t, sess.PlanMode())
+	sess.prepareUserMessage(acceptPlanPrompt)
+	last = sess.Messages[len(sess.Messages)-1].Parts[0].(llms.TextContent).Text
+	require.Contains(t, last, "1. Add the flag\n2. Test it")
+	require.NotContains(t, last, "Plan mode")
+	require.NotContains(t, last, "A flag, then a test")
+
+	sess.SetPlanMode(false)
+	require.Contains(t, toolNames(), "write_file")
+	require.Contains(t, toolNames(), "run_in_shell")
+	callWriteFile()
+	require.FileExists(t, "plan.txt")
+}
+
+func TestSession_Brief(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{LLM: LLMConfig{BriefLines: 5}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	lastPrompt := func() string {
+		return sess.Messages[len(sess.Messages)-1].Parts[0].(llms.TextContent).Text
+	}
+
+	sess.prepareUserMessage("explain the build")
+	require.NotContains(t, lastPrompt(), "Respond concisely")
+
+	sess.SetBrief(true)
+	sess.prepareUserMessage("explain the tests")
+	require.True(t, strings.HasPrefix(lastPrompt(), "[Respond concisely, in 5 lines or fewer.]"), lastPrompt())
+	require.Contains(t, lastPrompt(), "explain the tests")
+	require.NotContains(t, sess.Messages[0].Parts[0].(llms.TextContent).Text, "Respond concisely")
+
+	sess.SetBrief(false)
+	sess.prepareUserMessage("explain the docs")
+	require.NotContains(t, lastPrompt(), "Respond concisely")
+}
+
+// optionsLLM keeps the messages and call options of the last request
+type optionsLLM struct {
+	llms.Model
+	messages []llms.MessageContent
+	opts     llms.CallOptions
+}
+
+func (m *optionsLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.messages = slices.Clone(messages)
+	m.opts = llms.CallOptions{}
+	for _, opt := range options {
+		opt(&m.opts)
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "ok"}}}, nil
+}
+
+func TestSession_ThinkingBudget(t *testing.T) {
+	t.Chdir(t.TempDir())
+	thinking := func(provider string, budget int) (*llms.ThinkingConfig, int) {
+		llm := &optionsLLM{}
+		sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: provider, Model: "claude-sonnet-4-5"}}, RepoInfo{}, func(any) {})
+		require.NoError(t, err)
+		sess.SetThinkingBudget(budget)
+		sess.prepareUserMessage("think it through")
+		_, err = sess.generateLLMResponse(context.Background(), nil)
+		require.NoError(t, err)
+		config, _ := llm.opts.Metadata["thinking_config"].(*llms.ThinkingConfig)
+		return config, llm.opts.MaxTokens
+	}
+
+	config, maxTokens := thinking("anthropic", 8000)
+	require.NotNil(t, config)
+	require.Equal(t, 8000, config.BudgetTokens)
+	require.Greater(t, maxTokens, 8000, "the reply needs room besides the thinking")
+
+	// Anthropic's minimum applies to smaller budgets
+	config, _ = thinking("anthropic", 100)
+	require.Equal(t, minThinkingBudget, config.BudgetTokens)
+
+	config, _ = thinking("anthropic", 0)
+	require.Nil(t, config)
+
+	config, _ = thinking("openai", 8000)
+	require.Nil(t, config)
+
+	// :think sets the budget, shown in the status bar
+	model := newTestModel(t)
+	model.session.config.Provider = "anthropic"
+	handleThinkCommand(model, []string{"16000"})
+	require.Equal(t, 16000, model.session.ThinkingBudget())
+	status := NewStatusComponent(200)
+	status.SetSession(model.session)
+	require.Contains(t, ansi.Strip(status.renderRightSection()), "THINK")
+	msg := handleThinkCommand(model, []string{"off"})().(showContextMsg).content
+	require.Contains(t, msg, "Thinking off")
+	require.Zero(t, model.session.ThinkingBudget())
+	require.Contains(t, handleThinkCommand(model, []string{"lots"})().(showContextMsg).content, "Usage")
+}
+
+// compactingLLM streams a summary in chunks, or blocks until cancelled when block is set
+type compactingLLM struct {
+	llms.Model
+	block   bool
+	started chan struct{}
+}
+
+func (m *compactingLLM) GenerateContent(ctx context.Context, _ []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if m.started != nil {
+		close(m.started)
+	}
+	if m.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	summary := strings.Repeat("summary ", 200)
+	if opts.StreamingFunc != nil {
+		for i := 0; i < len(summary); i += 100 {
+			if err := opts.StreamingFunc(ctx, []byte(summary[i:min(i+100, len(summary))])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: summary}}}, nil
+}
+
+func TestSession_HistoryPartial(t *testing.T) {
+	t.Chdir(t.TempDir())
+	systemPrompt := func(s *Session) string {
+		return s.Messages[0].Parts[len(s.Messages[0].Parts)-1].(llms.TextContent).Text
+	}
+	compacted := func(include bool) *Session {
+		sess, err := NewSession(&compactingLLM{}, &Config{LLM: LLMConfig{IncludeHistoryPartial: include}}, RepoInfo{}, func(any) {})
+		require.NoError(t, err)
+		require.NotContains(t, systemPrompt(sess), "## Conversation So Far")
+		sess.Messages = append(sess.Messages,
+			llms.TextParts(llms.ChatMessageTypeHuman, "add a flag"),
+			llms.TextParts(llms.ChatMessageTypeAI, "added --verbose"),
+			llms.TextParts(llms.ChatMessageTypeHuman, "now document it"),
+		)
+		_, err = sess.CompactHistory(context.Background(), "summarize")
+		require.NoError(t, err)
+		return sess
+	}
+
+	sess := compacted(true)
+	require.Contains(t, systemPrompt(sess), "## Conversation So Far")
+	require.Contains(t, systemPrompt(sess), strings.Repeat("summary ", 200))
+	require.Len(t, sess.Messages, 3)
+
+	sess.ClearHistory()
+	require.NotContains(t, systemPrompt(sess), "## Conversation So Far", "a new conversation starts without it")
+
+	require.NotContains(t, systemPrompt(compacted(false)), "## Conversation So Far")
+}
+
+func TestSession_CompactHistoryCancel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	llm := &compactingLLM{block: true, started: make(chan struct{})}
+	sess, err := NewSession(llm, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	sess.Messages = append(sess.Messages,
+		llms.TextParts(llms.ChatMessageTypeHuman, "add a flag"),
+		llms.TextParts(llms.ChatMessageTypeAI, "added --verbose"),
+		llms.TextParts(llms.ChatMessageTypeHuman, "now document it"),
+	)
+	original := slices.Clone(sess.Messages)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := sess.CompactHistory(ctx, "summarize")
+		done <- err
+	}()
+
+	<-llm.started
+	cancel()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("compaction did not stop after cancel")
+	}
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, original, sess.Messages)
+
+	// An already cancelled context doesn't touch the model
+	_, err = sess.CompactHistory(ctx, "summarize")
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, original, sess.Messages)
+}
+
+func TestSession_CompactHistoryProgress(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var mu sync.Mutex
+	var progress []int
+	notify := func(msg any) {
+		if p, ok := msg.(compactProgressMsg); ok {
+			mu.Lock()
+			progress = append(progress, p.chars)
+			mu.Unlock()
+		}
+	}
+	sess, err := NewSession(&compactingLLM{}, &Config{}, RepoInfo{}, notify)
+	require.NoError(t, err)
+	sess.Messages = append(sess.Messages,
+		llms.TextParts(llms.ChatMessageTypeHuman, "add a flag"),
+		llms.TextParts(llms.ChatMessageTypeAI, "added --verbose"),
+	)
+
+	summary, err := sess.CompactHistory(context.Background(), "summarize")
+	require.NoError(t, err)
+	require.Contains(t, summary, "summary")
+	require.Len(t, sess.Messages, 3)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []int{0, 600, 1200}, progress)
+}
+
+func TestAgentsReferences(t *testing.T) {
+	agents := "Read @docs/ARCHITECTURE.md first.\nStyle: see (@STYLE.md), `@Makefile` and @docs/ARCHITECTURE.md.\n" +
+		"Mail me@example.com, not @/etc/passwd or @../secret"
+	require.Equal(t, []string{"docs/ARCHITECTURE.md", "STYLE.md", "Makefile"}, agentsReferences(agents))
+}
+
+func TestSession_AutoAttachAgentsReferences(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll("docs", 0o755))
+	require.NoError(t, os.WriteFile("docs/ARCHITECTURE.md", []byte("layers: tui, session, tools"), 0o644))
+	require.NoError(t, os.WriteFile("big.txt", []byte(strings.Repeat("x", 100)), 0o644))
+	require.NoError(t, os.WriteFile("AGENTS.md", []byte("Always read @docs/ARCHITECTURE.md, @big.txt and @missing.go"), 0o644))
+
+	cfg := &Config{
+		Session: SessionConfig{AutoAttachReferences: true},
+		Tools:   ToolsConfig{MaxContextBytes: 50},
+	}
+	sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"docs/ARCHITECTURE.md": "layers: tui, session, tools"}, sess.ContextFiles)
+
+	sess.prepareUserMessage("hi")
+	prompt := sess.Messages[len(sess.Messages)-1].Parts[0].(llms.TextContent).Text
+	require.Contains(t, prompt, "--- Context from: docs/ARCHITECTURE.md ---")
+
+	// Off by default
+	sess, err = NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.Empty(t, sess.ContextFiles)
+}
+
+// truncatingLLM replies with its choices in turn, recording the messages of each call
+type truncatingLLM struct {
+	llms.Model
+	choices []llms.ContentChoice
+	calls   [][]llms.MessageContent
+}
+
+func (m *truncatingLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.calls = append(m.calls, slices.Clone(messages))
+	choice := m.choices[min(len(m.calls), len(m.choices))-1]
+	opts := &llms.CallOptions{}
+	for _, opt := range options {
+		opt(opts)
+	}
+	if opts.StreamingFunc != nil {
+		if err := opts.StreamingFunc(ctx, []byte(choice.Content)); err != nil {
+			return nil, err
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{&choice}}, nil
+}
+
+func TestSession_AutoContinueOnMaxTokens(t *testing.T) {
+	newLLM := func() *truncatingLLM {
+		return &truncatingLLM{choices: []llms.ContentChoice{
+			{Content: "The answer is a long", StopReason: "max_tokens"},
+			{Content: " explanation, now complete.", StopReason: "end_turn"},
+		}}
+	}
+	assistantTexts := func(sess *Session) []string {
+		var texts []string
+		for _, msg := range sess.Messages {
+			if msg.Role == llms.ChatMessageTypeAI {
+				texts = append(texts, msg.Parts[0].(llms.TextContent).Text)
+			}
+		}
+		return texts
+	}
+
+	// Off by default: the cut off reply is noted
+	sess, err := NewSession(newLLM(), &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	reply, err := sess.Ask(context.Background(), "explain")
+	require.NoError(t, err)
+	require.Equal(t, "The answer is a long\n\n[Response truncated due to length limit]", reply)
+
+	// On: the continuation completes the reply, stored as a single message
+	llm := newLLM()
+	cfg := &Config{LLM: LLMConfig{AutoContinueOnMaxTokens: true}}
+	done := make(chan struct{})
+	var streamed strings.Builder
+	sess, err = NewSession(llm, cfg, RepoInfo{}, func(msg any) {
+		switch msg := msg.(type) {
+		case streamChunkMsg:
+			streamed.WriteString(string(msg))
+		case streamCompleteMsg, streamMaxTokensReachedMsg, streamErrorMsg:
+			close(done)
+		}
+	})
+	require.NoError(t, err)
+	sess.AskStream(context.Background(), "explain")
+	<-done
+	require.Equal(t, "The answer is a long explanation, now complete.", streamed.String())
+	require.Equal(t, []string{"The answer is a long explanation, now complete."}, assistantTexts(sess))
+	require.Len(t, llm.calls, 2)
+	last := llm.calls[1][len(llm.calls[1])-1]
+	require.Equal(t, llms.ChatMessageTypeHuman, last.Role)
+	require.Equal(t, autoContinuePrompt, last.Parts[0].(llms.TextContent).Text)
+
+	// Continuations are capped, what came so far is kept
+	llm = &truncatingLLM{choices: []llms.ContentChoice{{Content: "more ", StopReason: "max_tokens"}}}
+	sess, err = NewSession(llm, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	reply, err = sess.Ask(context.Background(), "explain")
+	require.NoError(t, err)
+	require.Len(t, llm.calls, maxAutoContinuations+1)
+	require.Equal(t, strings.Repeat("more ", maxAutoContinuations+1)+"\n\n[Response truncated due to length limit]", reply)
+}
+
+// failingLLM fails every request with err
+type failingLLM struct {
+	llms.Model
+	err     error
+	calls   int
+	partial string // streamed before failing
+}
+
+func (m *failingLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.calls++
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if m.partial != "" && opts.StreamingFunc != nil {
+		opts.StreamingFunc(ctx, []byte(m.partial))
+	}
+	return nil, m.err
+}
+
+func TestSession_FallbackModels(t *testing.T) {
+	primary := &failingLLM{err: errors.New("API returned unexpected status code: 529: Overloaded")}
+	var built []string
+	fallbackClient = func(cfg *Config) (llms.Model, error) {
+		built = append(built, cfg.LLM.Provider+"/"+cfg.LLM.Model)
+		if cfg.LLM.Model == "down" {
+			return &failingLLM{err: errors.New("503 Service Unavailable")}, nil
+		}
+		return &sessionMockLLM{response: "answer from " + cfg.LLM.Model}, nil
+	}
+	t.Cleanup(func() { fallbackClient = getModelClient })
+
+	var notes []string
+	cfg := &Config{LLM: LLMConfig{Provider: "anthropic", Model: "big", FallbackModels: []string{"big", "down", "openai/small"}}}
+	sess, err := NewSession(primary, cfg, RepoInfo{}, func(msg any) {
+		if note, ok := msg.(showContextMsg); ok {
+			notes = append(notes, strings.TrimPrefix(note.content, systemPrefix))
+		}
+	})
+	require.NoError(t, err)
+
+	reply, err := sess.Ask(context.Background(), "hello")
+	require.NoError(t, err)
+	require.Equal(t, "answer from small", reply)
+	require.Equal(t, []string{"anthropic/down", "openai/small"}, built[:2], "the primary model is skipped")
+	require.Equal(t, "anthropic/big is unavailable, openai/small answered", notes[0])
+	require.Same(t, primary, sess.llm)
+	require.Equal(t, len(notes), primary.calls, "every request goes to the primary model first")
+
+	// Errors that another model would not fix are returned as-is, status-like numbers included
+	for _, msg := range []string{"400 invalid request", "API returned unexpected status code: 400: max_tokens: 5000 is too large", "request req_5030429 failed"} {
+		primary.err = errors.New(msg)
+		built = nil
+		_, err = sess.Ask(context.Background(), "hello")
+		require.ErrorContains(t, err, msg)
+		require.Empty(t, built, msg)
+	}
+	require.True(t, isRetryableLLMError(errors.New("API returned unexpected status code: 502")))
+	require.True(t, isRetryableLLMError(errors.New("HTTP 429 Too Many Requests")))
+
+	// What the primary model streamed before failing is dropped for the fallback's answer
+	primary.err = errors.New("API returned unexpected status code: 529: Overloaded")
+	primary.partial = "Half an ans"
+	done := make(chan struct{})
+	var discarded []string
+	sess, err = NewSession(primary, cfg, RepoInfo{}, func(msg any) {
+		switch msg := msg.(type) {
+		case streamDiscardedMsg:
+			discarded = append(discarded, string(msg))
+		case streamCompleteMsg, streamErrorMsg:
+			close(done)
+		}
+	})
+	require.NoError(t, err)
+	sess.AskStream(context.Background(), "hello")
+	<-done
+	require.Equal(t, []string{"Half an ans"}, discarded)
+	require.Equal(t, "answer from small", sess.lastReply())
+}
+
+func TestSession_FallbackModelOptions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fallback := &optionsLLM{}
+	fallbackClient = func(cfg *Config) (llms.Model, error) { return fallback, nil }
+	t.Cleanup(func() { fallbackClient = getModelClient })
+
+	primary := &failingLLM{err: errors.New("529 Overloaded")}
+	cfg := &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-5", ThinkingBudget: 8000, FallbackModels: []string{"openai/gpt-4o"}}}
+	sess, err := NewSession(primary, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	_, err = sess.Ask(context.Background(), "hello")
+	require.NoError(t, err)
+
+	// The fallback gets its own output limit and no thinking, not the primary's
+	require.Equal(t, 16_384, fallback.opts.MaxTokens)
+	require.NotContains(t, fallback.opts.Metadata, "thinking_config")
+	require.NotEmpty(t, fallback.opts.Tools)
+}
+
+func TestSession_ThinkingRequestPayload(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("notes.txt", []byte("hello\n"), 0644))
+	var requests []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, body)
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"thinking","thinking":"Read it","signature":"sig"},{"type":"tool_use","id":"toolu_1","name":"read_file","input":{"path":"notes.txt"}}],"stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":1}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"msg_2","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"It says hello"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer server.Close()
+
+	cfg := &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-5", APIKey: "test-key", BaseURL: server.URL + "/v1", ThinkingBudget: 2000}}
+	llm, err := getModelClient(cfg)
+	require.NoError(t, err)
+	sess, err := NewSession(llm, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	reply, err := sess.Ask(context.Background(), "What do the notes say?")
+	require.NoError(t, err)
+	require.Equal(t, "It says hello", reply)
+	require.Len(t, requests, 2)
+
+	// Anthropic only takes thinking with a temperature of 1
+	require.Equal(t, map[string]any{"type": "enabled", "budget_tokens": float64(2000)}, requests[0]["thinking"])
+	require.Equal(t, float64(1), requests[0]["temperature"])
+	// The tool result goes back without thinking, whose signed blocks the client doesn't send back
+	require.NotContains(t, requests[1], "thinking")
+	require.NotContains(t, fmt.Sprint(requests[1]["messages"]), "sig")
+}
+
+func TestSession_ModelNotFoundSuggestion(t *testing.T) {
+	notFound := errors.New(`API returned unexpected status code: 404: {"type":"error","error":{"type":"not_found_error","message":"model: claude-sonet-4-5"}}`)
+	listModels := providerModelIDs
+	providerModelIDs = func(cfg *Config) ([]string, error) {
+		require.Equal(t, "anthropic", cfg.LLM.Provider)
+		return []string{"claude-opus-4-1", "claude-sonnet-4-5", "claude-haiku-4-5"}, nil
+	}
+	t.Cleanup(func() { providerModelIDs = listModels })
+
+	cfg := &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonet-4-5"}}
+	sess, err := NewSession(&failingLLM{err: notFound}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	_, err = sess.Ask(context.Background(), "hello")
+	require.ErrorContains(t, err, `model "claude-sonet-4-5" not found, did you mean "claude-sonnet-4-5"?`)
+	require.ErrorIs(t, err, notFound)
+
+	model := newTestModel(t)
+	updated, _ := model.Update(streamErrorMsg{err: err})
+	toasts := updated.(TUIModel).commandLine.toasts
+	require.NotEmpty(t, toasts)
+	require.Contains(t, toasts[len(toasts)-1].Message, `did you mean "claude-sonnet-4-5"?`)
+
+	// Without a close match the error points at :models
+	cfg.LLM.Model = "gpt-5"
+	_, err = sess.Ask(context.Background(), "hello")
+	require.ErrorContains(t, err, `model "gpt-5" not found, use :models to pick one`)
+
+	// Other errors are left alone
+	sess.llm = &failingLLM{err: errors.New("400 invalid request")}
+	_, err = sess.Ask(context.Background(), "hello")
+	require.EqualError(t, err, "400 invalid request")
+}
+
+// gatedEchoLLM streams back the last prompt once gate is closed
+type gatedEchoLLM struct {
+	llms.Model
+	gate chan struct{}
+}
+
+func (m *gatedEchoLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	<-m.gate
+	opts := &llms.CallOptions{}
+	for _, opt := range options {
+		opt(opts)
+	}
+	prompt := messages[len(messages)-1].Parts[0].(llms.TextContent).Text
+	reply := "re: " + strings.TrimSpace(prompt)
+	if opts.StreamingFunc != nil {
+		if err := opts.StreamingFunc(ctx, []byte(reply)); err != nil {
+			return nil, err
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: reply}}}, nil
+}
+
+func TestSession_ConcurrentAskStream(t *testing.T) {
+	llm := &gatedEchoLLM{gate: make(chan struct{})}
+	completed := make(chan struct{}, 2)
+	sess, err := NewSession(llm, &Config{}, RepoInfo{}, func(msg any) {
+		if _, ok := msg.(streamCompleteMsg); ok {
+			completed <- struct{}{}
+		}
+	})
+	require.NoError(t, err)
+
+	// The second turn waits for the first instead of racing on the messages
+	sess.AskStream(context.Background(), "first")
+	require.True(t, sess.Busy())
+	sess.AskStream(context.Background(), "second")
+	close(llm.gate)
+	<-completed
+	<-completed
+	require.Eventually(t, func() bool { return !sess.Busy() }, time.Second, 10*time.Millisecond)
+
+	var turns []string
+	for _, msg := range sess.Messages[1:] {
+		turns = append(turns, string(msg.Role)+": "+strings.TrimSpace(msg.Parts[0].(llms.TextContent).Text))
+	}
+	require.Equal(t, []string{"human: first", "ai: re: first", "human: second", "ai: re: second"}, turns)
+
+	// The TUI rejects a prompt submitted while the previous one is answered
+	llm.gate = make(chan struct{})
+	model := newTestModel(t)
+	model.SetSession(sess)
+	updated, _ := model.Update(SubmitPromptMsg{Prompt: "third"})
+	updated, _ = updated.(TUIModel).Update(SubmitPromptMsg{Prompt: "fourth"})
+	result := updated.(TUIModel)
+	close(llm.gate)
+	<-completed
+	require.Contains(t, result.content.Chat.Messages, "You: third")
+	require.NotContains(t, result.content.Chat.Messages, "You: fourth")
+	toasts := result.commandLine.toasts
+	require.Contains(t, toasts[len(toasts)-1].Message, "Still answering")
+}
+
+// overlapLLM records how many of its requests run at the same time
+type overlapLLM struct {
+	llms.Model
+	inFlight, maxInFlight atomic.Int32
+}
+
+func (m *overlapLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	n := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		peak := m.maxInFlight.Load()
+		if n <= peak || m.maxInFlight.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "ok"}}}, nil
+}
+
+func TestGenerateContent_ConcurrencyLimit(t *testing.T) {
+	t.Cleanup(func() { initRequestLimit(nil) })
+	run := func(limit int) int32 {
+		initRequestLimit(&Config{LLM: LLMConfig{MaxConcurrentRequests: limit}})
+		llm := &overlapLLM{}
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := generateContent(context.Background(), llm, nil)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		return llm.maxInFlight.Load()
+	}
+
+	require.EqualValues(t, 1, run(1), "with a limit of 1 the requests run one after the other")
+	require.EqualValues(t, 2, run(2))
+
+	// A request waiting for a slot gives up when its context ends
+	initRequestLimit(&Config{LLM: LLMConfig{MaxConcurrentRequests: 1}})
+	requestSlots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	llm := &overlapLLM{}
+	_, err := generateContent(ctx, llm, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Zero(t, llm.maxInFlight.Load(), "the model is never called")
+}
+
+func TestSession_PromptPrefixSuffix(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sent := func(cfg *Config) (system, user string) {
+		llm := &optionsLLM{}
+		sess, err := NewSession(llm, cfg, RepoInfo{}, func(any) {})
+		require.NoError(t, err)
+		_, err = sess.Ask(context.Background(), "add a --verbose flag")
+		require.NoError(t, err)
+		return llm.messages[0].Parts[len(llm.messages[0].Parts)-1].(llms.TextContent).Text,
+			llm.messages[1].Parts[0].(llms.TextContent).Text
+	}
+
+	plainSystem, plainUser := sent(&Config{})
+	require.Equal(t, "add a --verbose flag", plainUser)
+
+	system, user := sent(&Config{Session: SessionConfig{
+		PromptPrefix: "Follow our style guide.",
+		PromptSuffix: "Keep the diff small.",
+	}})
+	require.Equal(t, "Follow our style guide.\n\nadd a --verbose flag\n\nKeep the diff small.", user)
+	require.Equal(t, plainSystem, system, "the system prompt is unchanged")
+}
+
+func TestStripPromptContext(t *testing.T) {
+	require.Equal(t, "fix it", stripPromptContext("--- Context from: a.go ---\npackage a\n--- End of Context from: a.go ---\nfix it"))
+	require.Equal(t, "plain prompt", stripPromptContext("plain prompt"))
+	// A prompt cut off inside its context comes back as it is
+	cut := "--- Context from: a.go ---\npackage a\n"
+	require.Equal(t, cut, stripPromptContext(cut))
+}
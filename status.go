@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -27,8 +28,28 @@ type StatusComponent struct {
 
 	// Shell runner info
 	shellRunnerInfo *ShellRunnerInfo
+
+	// Set by quiet auto-compaction, shown briefly instead of chat messages
+	compactedAt time.Time
+
+	// Sessions are enabled but not saved after each turn
+	autoSavePaused bool
+	profile        string // profile in use, shown before the model
+	contextGauge   bool   // context usage as a colored bar rather than a percentage
 }
 
+// compactedIndicatorDuration is how long the status bar shows a quiet auto-compaction
+const compactedIndicatorDuration = 30 * time.Second
+
+// contextGaugeCells is the width of the context usage bar
+const contextGaugeCells = 5
+
+// The context usage past which the gauge turns yellow, then red
+const (
+	contextGaugeWarnPercent  = 60.0
+	contextGaugeAlertPercent = 80.0
+)
+
 // NewStatusComponent creates a new status component
 func NewStatusComponent(width int) StatusComponent {
 	return StatusComponent{
@@ -44,6 +65,31 @@ func (s *StatusComponent) SetShellRunnerInfo(info *ShellRunnerInfo) {
 	s.shellRunnerInfo = info
 }
 
+// SetCompacted records a quiet auto-compaction for the status bar indicator
+func (s *StatusComponent) SetCompacted(at time.Time) {
+	s.compactedAt = at
+}
+
+// SetAutoSavePaused shows whether the session is saved after each turn
+func (s *StatusComponent) SetAutoSavePaused(paused bool) {
+	s.autoSavePaused = paused
+}
+
+// SetContextGauge shows the context usage as a colored bar rather than a percentage
+func (s *StatusComponent) SetContextGauge(on bool) {
+	s.contextGauge = on
+}
+
+// ContextGauge reports whether the context usage is shown as a bar
+func (s *StatusComponent) ContextGauge() bool {
+	return s.contextGauge
+}
+
+// SetProfile shows the name of the profile in use, none when empty
+func (s *StatusComponent) SetProfile(name string) {
+	s.profile = name
+}
+
 // SetProvider sets the current provider and model
 func (s *StatusComponent) SetProvider(provider, model string, connected bool) {
 	s.Provider = provider
@@ -375,6 +421,13 @@ func (s StatusComponent) renderMiddleSection() string {
 
 	// Format the output with icons
 	statusStr := fmt.Sprintf("🪣 %.0f%%", usagePercent)
+	gauge := ""
+	if s.contextGauge {
+		gauge, statusStr = renderContextGauge(usagePercent), ""
+	}
+	if usage := s.Session.TotalUsage(); usage.Input+usage.Output > 0 {
+		statusStr += "  " + usage.String()
+	}
 	if s.waitingForResponse && !s.waitingSince.IsZero() {
 		waitSeconds := int(time.Since(s.waitingSince).Seconds())
 		if waitSeconds >= 3 {
@@ -383,17 +436,64 @@ func (s StatusComponent) renderMiddleSection() string {
 	}
 
 	// Style with theme text color
-	return statusStyle.Render(statusStr)
+	return gauge + statusStyle.Render(statusStr)
+}
+
+// contextGaugeColor colors the context gauge by how full the context is
+func contextGaugeColor(percent float64) lipgloss.Color {
+	switch {
+	case percent >= contextGaugeAlertPercent:
+		return globalTheme.Error
+	case percent >= contextGaugeWarnPercent:
+		return globalTheme.Warning
+	default:
+		return globalTheme.Success
+	}
+}
+
+// renderContextGauge draws the context usage as a bar, e.g. [███░░] 62%
+func renderContextGauge(percent float64) string {
+	// Filled from the percentage shown, so the bar and the number agree
+	shown := math.Round(percent)
+	filled := min(max(int(shown*contextGaugeCells/100+0.5), 0), contextGaugeCells)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", contextGaugeCells-filled)
+	return lipgloss.NewStyle().Foreground(contextGaugeColor(percent)).Render(fmt.Sprintf("[%s] %.0f%%", bar, percent))
 }
 
 // renderRightSection renders the right section with provider info
 func (s StatusComponent) renderRightSection() string {
 
 	providerModel := shortenProviderModel(s.Provider, s.Model)
+	if s.profile != "" {
+		providerModel = s.profile + ":" + providerModel
+	}
 
 	providerStyle := lipgloss.NewStyle().Foreground(globalTheme.TextColor)
 
-	return fmt.Sprintf("%s %s ", providerStyle.Render(providerModel), s.getStatusIcon())
+	compacted := ""
+	if !s.compactedAt.IsZero() && time.Since(s.compactedAt) < compactedIndicatorDuration {
+		compacted = "🗜️ "
+	}
+
+	plan := ""
+	if s.Session != nil && s.Session.PlanMode() {
+		plan = lipgloss.NewStyle().Foreground(globalTheme.Warning).Render("PLAN") + " "
+	}
+
+	if s.Session != nil && s.Session.Brief() {
+		plan += lipgloss.NewStyle().Foreground(globalTheme.Warning).Render("BRIEF") + " "
+	}
+
+	if s.Session != nil && s.Session.ThinkingSupported() && s.Session.ThinkingBudget() > 0 {
+		plan += lipgloss.NewStyle().Foreground(globalTheme.Warning).Render(fmt.Sprintf("THINK %s", formatTokenCount(s.Session.ThinkingBudget()))) + " "
+	}
+
+	noSave := ""
+	if s.autoSavePaused {
+		noSave = lipgloss.NewStyle().Foreground(globalTheme.Warning).Render("NOSAVE") + " "
+	}
+
+	return fmt.Sprintf("%s%s%s%s %s ", plan, noSave, compacted, providerStyle.Render(providerModel), s.getStatusIcon())
 }
 
 // truncateString truncates a string to fit within maxWidth, adding "..." if needed
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -33,6 +35,10 @@ func (h *HistoryStore) AppendPrompt(host, org, project, branch, prompt string) e
 		return err
 	}
 
+	if skip, err := h.dedup("prompt_history", "prompt", branchID, prompt); err != nil || skip {
+		return err
+	}
+
 	// Insert prompt
 	_, err = h.db.conn.Exec(`
 		INSERT INTO prompt_history (branch_id, prompt, timestamp)
@@ -80,6 +86,10 @@ func (h *HistoryStore) AppendCommand(host, org, project, branch, command string)
 		return err
 	}
 
+	if skip, err := h.dedup("command_history", "command", branchID, command); err != nil || skip {
+		return err
+	}
+
 	// Insert command
 	_, err = h.db.conn.Exec(`
 		INSERT INTO command_history (branch_id, command, timestamp)
@@ -113,6 +123,32 @@ func (h *HistoryStore) AppendCommand(host, org, project, branch, command string)
 	return nil
 }
 
+// dedup applies the configured deduplication policy before value is added to table.
+// It reports whether value should be skipped as a repeat of the previous entry.
+func (h *HistoryStore) dedup(table, column string, branchID int64, value string) (bool, error) {
+	if h.cfg == nil {
+		return false, nil
+	}
+	switch h.cfg.Dedup {
+	case DedupConsecutive:
+		var last string
+		err := h.db.conn.QueryRow(fmt.Sprintf(
+			"SELECT %s FROM %s WHERE branch_id = ? ORDER BY timestamp DESC, id DESC LIMIT 1", column, table),
+			branchID,
+		).Scan(&last)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("failed to read the last %s: %w", column, err)
+		}
+		return err == nil && last == value, nil
+	case DedupGlobal:
+		_, err := h.db.conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE branch_id = ? AND %s = ?", table, column), branchID, value)
+		if err != nil {
+			return false, fmt.Errorf("failed to remove older copies of the %s: %w", column, err)
+		}
+	}
+	return false, nil
+}
+
 // LoadPromptHistory loads prompt history for a given host/org/project/branch
 func (h *HistoryStore) LoadPromptHistory(host, org, project, branch string, limit int) ([]HistoryEntry, error) {
 	// Get repository
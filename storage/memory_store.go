@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MemoryStore handles per-project memory notes persistence
+type MemoryStore struct {
+	db *DB
+}
+
+// NewMemoryStore creates a new memory store
+func NewMemoryStore(db *DB) *MemoryStore {
+	return &MemoryStore{db: db}
+}
+
+// MemoryEntry represents a single keyed memory note
+type MemoryEntry struct {
+	Key       string
+	Value     string
+	UpdatedAt time.Time
+}
+
+// Set stores a memory note, replacing any existing value for the key
+func (m *MemoryStore) Set(host, org, project, key, value string) error {
+	repoID, err := m.db.GetOrCreateRepository(host, org, project)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.conn.Exec(`
+		INSERT INTO memories (repository_id, key, value, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(repository_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		repoID,
+		key,
+		value,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set memory: %w", err)
+	}
+	return nil
+}
+
+// Get returns the memory note for a key and whether it exists
+func (m *MemoryStore) Get(host, org, project, key string) (string, bool, error) {
+	repo, err := m.db.GetRepository(host, org, project)
+	if err != nil {
+		return "", false, err
+	}
+	if repo == nil {
+		return "", false, nil
+	}
+
+	var value string
+	err = m.db.conn.QueryRow(
+		"SELECT value FROM memories WHERE repository_id = ? AND key = ?",
+		repo.ID, key,
+	).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get memory: %w", err)
+	}
+	return value, true, nil
+}
+
+// List returns all memory notes for a project ordered by key
+func (m *MemoryStore) List(host, org, project string) ([]MemoryEntry, error) {
+	repo, err := m.db.GetRepository(host, org, project)
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		return []MemoryEntry{}, nil // No repository means no memory
+	}
+
+	rows, err := m.db.conn.Query(`
+		SELECT key, value, updated_at FROM memories
+		WHERE repository_id = ?
+		ORDER BY key`,
+		repo.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memories: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []MemoryEntry
+	for rows.Next() {
+		var e MemoryEntry
+		var updated int64
+		if err := rows.Scan(&e.Key, &e.Value, &updated); err != nil {
+			return nil, fmt.Errorf("failed to scan memory: %w", err)
+		}
+		e.UpdatedAt = time.Unix(updated, 0)
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating memories: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Delete removes a memory note and reports whether it existed
+func (m *MemoryStore) Delete(host, org, project, key string) (bool, error) {
+	repo, err := m.db.GetRepository(host, org, project)
+	if err != nil {
+		return false, err
+	}
+	if repo == nil {
+		return false, nil
+	}
+
+	result, err := m.db.conn.Exec("DELETE FROM memories WHERE repository_id = ? AND key = ?", repo.ID, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete memory: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rows > 0, nil
+}
@@ -17,6 +17,14 @@ type SessionConfig struct {
 	ListLimit    int
 	AutoSave     bool
 	SaveInterval int
+	// MaxStorageBytes caps the size of the stored sessions, oldest removed first
+	MaxStorageBytes int64
+}
+
+// StorageUsage is the space the stored sessions take
+type StorageUsage struct {
+	Sessions int
+	Bytes    int64 // prompts and message contents
 }
 
 // HistoryConfig holds persistent history configuration
@@ -27,8 +35,16 @@ type HistoryConfig struct {
 	ListLimit    int
 	AutoSave     bool
 	SaveInterval int
+	Dedup        string // DedupNone, DedupConsecutive or DedupGlobal
 }
 
+// History deduplication policies
+const (
+	DedupNone        = "none"        // keep every entry
+	DedupConsecutive = "consecutive" // skip an entry equal to the previous one
+	DedupGlobal      = "global"      // drop older copies of an entry, keeping the newest
+)
+
 // DBSession maps directly to the sessions table with db tags
 // This is used for database operations only
 type DBSession struct {
@@ -54,9 +70,19 @@ type SessionData struct {
 	Model        string
 	WorkingDir   string
 	ProjectSlug  string
+	Branch       string // the branch the session is stored under, set when loaded
 	Messages     []llms.MessageContent
 	ContextFiles map[string]string
 	MessageCount int // Number of messages (for list views, avoids loading full messages)
+	Tags         []string
+	ToolMetrics  map[string]ToolMetric // per tool usage, by tool name
+}
+
+// ToolMetric is the usage of a tool over a session
+type ToolMetric struct {
+	Calls  int
+	Errors int
+	Total  time.Duration
 }
 
 // Repository represents a Git repository (host/org/project)
@@ -161,6 +187,27 @@ CREATE TABLE IF NOT EXISTS messages (
 CREATE INDEX IF NOT EXISTS idx_messages_session ON messages(session_id, sequence);
 CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at DESC);
 
+-- Session tags table (labels set with :tag)
+CREATE TABLE IF NOT EXISTS session_tags (
+    session_id TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (session_id, tag),
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_tags_tag ON session_tags(tag);
+
+-- Per tool usage of each session (shown by :metrics)
+CREATE TABLE IF NOT EXISTS session_tool_metrics (
+    session_id TEXT NOT NULL,
+    tool TEXT NOT NULL,
+    calls INTEGER NOT NULL,
+    errors INTEGER NOT NULL,
+    total_ms INTEGER NOT NULL,
+    PRIMARY KEY (session_id, tool),
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
 -- Prompt history table
 CREATE TABLE IF NOT EXISTS prompt_history (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -183,6 +230,17 @@ CREATE TABLE IF NOT EXISTS command_history (
 
 CREATE INDEX IF NOT EXISTS idx_command_history_branch ON command_history(branch_id, timestamp DESC);
 
+-- Project memory table (notes the model keeps across sessions)
+CREATE TABLE IF NOT EXISTS memories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    repository_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at INTEGER NOT NULL,
+    UNIQUE(repository_id, key),
+    FOREIGN KEY (repository_id) REFERENCES repositories(id) ON DELETE CASCADE
+);
+
 -- Schema version table
 CREATE TABLE IF NOT EXISTS schema_version (
     version INTEGER PRIMARY KEY,
@@ -6,12 +6,26 @@ import (
 	"fmt"
 	"log/slog"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/tmc/langchaingo/llms"
 )
 
+// tagsColumn selects the comma separated tags of the session s
+const tagsColumn = `COALESCE((SELECT GROUP_CONCAT(t.tag, ',') FROM session_tags t WHERE t.session_id = s.id), '') AS tags`
+
+// splitTags parses a tagsColumn value into sorted tags
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	list := strings.Split(tags, ",")
+	sort.Strings(list)
+	return list
+}
+
 // SessionStore handles session persistence
 type SessionStore struct {
 	db  *DB
@@ -68,6 +82,30 @@ func (s *SessionStore) SaveSession(session *SessionData, host, org, project, bra
 		return fmt.Errorf("failed to save session: %w", err)
 	}
 
+	// Replace the tags of this session
+	if _, err = tx.Exec("DELETE FROM session_tags WHERE session_id = ?", session.ID); err != nil {
+		return fmt.Errorf("failed to delete old tags: %w", err)
+	}
+	for _, tag := range session.Tags {
+		if _, err = tx.Exec("INSERT OR IGNORE INTO session_tags (session_id, tag) VALUES (?, ?)", session.ID, tag); err != nil {
+			return fmt.Errorf("failed to insert tag %q: %w", tag, err)
+		}
+	}
+
+	// Replace the tool metrics of this session
+	if _, err = tx.Exec("DELETE FROM session_tool_metrics WHERE session_id = ?", session.ID); err != nil {
+		return fmt.Errorf("failed to delete old tool metrics: %w", err)
+	}
+	for tool, metric := range session.ToolMetrics {
+		if _, err = tx.Exec(`
+			INSERT INTO session_tool_metrics (session_id, tool, calls, errors, total_ms)
+			VALUES (?, ?, ?, ?, ?)`,
+			session.ID, tool, metric.Calls, metric.Errors, metric.Total.Milliseconds(),
+		); err != nil {
+			return fmt.Errorf("failed to insert tool metrics of %q: %w", tool, err)
+		}
+	}
+
 	// Delete existing messages for this session
 	_, err = tx.Exec("DELETE FROM messages WHERE session_id = ?", session.ID)
 	if err != nil {
@@ -110,13 +148,13 @@ func (s *SessionStore) SaveSession(session *SessionData, host, org, project, bra
 func (s *SessionStore) LoadSession(sessionID string) (*SessionData, string, string, string, string, error) {
 	// Query session metadata with repository and branch info
 	var session SessionData
-	var host, org, project, branch string
+	var host, org, project, branch, tags string
 	var createdAt, lastUpdated int64
 
 	err := s.db.conn.QueryRow(`
 		SELECT s.id, s.created_at, s.last_updated, s.first_prompt,
 		       s.provider, s.model, s.working_dir,
-		       r.host, r.org, r.project, b.name
+		       r.host, r.org, r.project, b.name, `+tagsColumn+`
 		FROM sessions s
 		JOIN branches b ON s.branch_id = b.id
 		JOIN repositories r ON b.repository_id = r.id
@@ -134,6 +172,7 @@ func (s *SessionStore) LoadSession(sessionID string) (*SessionData, string, stri
 		&org,
 		&project,
 		&branch,
+		&tags,
 	)
 
 	if err == sql.ErrNoRows {
@@ -147,6 +186,8 @@ func (s *SessionStore) LoadSession(sessionID string) (*SessionData, string, stri
 	session.CreatedAt = time.Unix(createdAt, 0)
 	session.LastUpdated = time.Unix(lastUpdated, 0)
 	session.ProjectSlug = fmt.Sprintf("%s/%s/%s", host, org, project)
+	session.Branch = branch
+	session.Tags = splitTags(tags)
 	session.Messages = []llms.MessageContent{}     // Initialize empty slice
 	session.ContextFiles = make(map[string]string) // Initialize empty map
 
@@ -184,30 +225,77 @@ func (s *SessionStore) LoadSession(sessionID string) (*SessionData, string, stri
 		return nil, "", "", "", "", fmt.Errorf("error iterating messages: %w", err)
 	}
 
+	if session.ToolMetrics, err = s.loadToolMetrics(sessionID); err != nil {
+		return nil, "", "", "", "", err
+	}
+
 	slog.Debug("Session loaded", "id", sessionID, "messages", len(session.Messages))
 	return &session, host, org, project, branch, nil
 }
 
-// ListSessions lists sessions for a given host/org/project/branch
-func (s *SessionStore) ListSessions(host, org, project, branch string, limit int) ([]SessionData, error) {
+// loadToolMetrics loads the per tool usage of a session
+func (s *SessionStore) loadToolMetrics(sessionID string) (map[string]ToolMetric, error) {
+	rows, err := s.db.conn.Query(`
+		SELECT tool, calls, errors, total_ms
+		FROM session_tool_metrics
+		WHERE session_id = ?`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tool metrics: %w", err)
+	}
+	defer rows.Close()
+
+	metrics := make(map[string]ToolMetric)
+	for rows.Next() {
+		var tool string
+		var metric ToolMetric
+		var totalMs int64
+		if err := rows.Scan(&tool, &metric.Calls, &metric.Errors, &totalMs); err != nil {
+			return nil, fmt.Errorf("failed to scan tool metrics: %w", err)
+		}
+		metric.Total = time.Duration(totalMs) * time.Millisecond
+		metrics[tool] = metric
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tool metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// ListSessions lists sessions for a given host/org/project/branch, of all the branches when
+// branch is empty and only those tagged with tag unless it is empty
+func (s *SessionStore) ListSessions(host, org, project, branch, tag string, limit int) ([]SessionData, error) {
 	query := `
 		SELECT s.id, s.created_at, s.last_updated, s.first_prompt,
-		       s.provider, s.model, s.working_dir,
-		       COUNT(m.id) as message_count
+		       s.provider, s.model, s.working_dir, b.name,
+		       COUNT(m.id) as message_count, ` + tagsColumn + `
 		FROM sessions s
 		JOIN branches b ON s.branch_id = b.id
 		JOIN repositories r ON b.repository_id = r.id
 		LEFT JOIN messages m ON s.id = m.session_id
-		WHERE r.host = ? AND r.org = ? AND r.project = ? AND b.name = ?
+		WHERE r.host = ? AND r.org = ? AND r.project = ?`
+	args := []any{host, org, project}
+	if branch != "" {
+		query += `
+		  AND b.name = ?`
+		args = append(args, branch)
+	}
+	if tag != "" {
+		query += `
+		  AND s.id IN (SELECT session_id FROM session_tags WHERE tag = ?)`
+		args = append(args, tag)
+	}
+	query += `
 		GROUP BY s.id, s.created_at, s.last_updated, s.first_prompt,
-		         s.provider, s.model, s.working_dir
+		         s.provider, s.model, s.working_dir, b.name
 		ORDER BY s.last_updated DESC`
 
 	if limit > 0 {
 		query += fmt.Sprintf(" LIMIT %d", limit)
 	}
 
-	rows, err := s.db.conn.Query(query, host, org, project, branch)
+	rows, err := s.db.conn.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list sessions: %w", err)
 	}
@@ -218,6 +306,7 @@ func (s *SessionStore) ListSessions(host, org, project, branch string, limit int
 		var session SessionData
 		var createdAt, lastUpdated int64
 		var messageCount int
+		var tags string
 
 		err := rows.Scan(
 			&session.ID,
@@ -227,7 +316,9 @@ func (s *SessionStore) ListSessions(host, org, project, branch string, limit int
 			&session.Provider,
 			&session.Model,
 			&session.WorkingDir,
+			&session.Branch,
 			&messageCount,
+			&tags,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan session: %w", err)
@@ -237,6 +328,7 @@ func (s *SessionStore) ListSessions(host, org, project, branch string, limit int
 		session.LastUpdated = time.Unix(lastUpdated, 0)
 		session.ProjectSlug = fmt.Sprintf("%s/%s/%s", host, org, project)
 		session.MessageCount = messageCount
+		session.Tags = splitTags(tags)
 		session.Messages = []llms.MessageContent{} // Empty for list view
 		session.ContextFiles = make(map[string]string)
 
@@ -255,7 +347,7 @@ func (s *SessionStore) ListAllSessions(limit int) ([]SessionData, error) {
 	query := `
 		SELECT s.id, s.created_at, s.last_updated, s.first_prompt,
 		       s.provider, s.model, s.working_dir, r.host, r.org, r.project,
-		       COUNT(m.id) as message_count
+		       COUNT(m.id) as message_count, ` + tagsColumn + `
 		FROM sessions s
 		JOIN branches b ON s.branch_id = b.id
 		JOIN repositories r ON b.repository_id = r.id
@@ -278,7 +370,7 @@ func (s *SessionStore) ListAllSessions(limit int) ([]SessionData, error) {
 	for rows.Next() {
 		var session SessionData
 		var createdAt, lastUpdated int64
-		var host, org, project string
+		var host, org, project, tags string
 		var messageCount int
 
 		err := rows.Scan(
@@ -293,6 +385,7 @@ func (s *SessionStore) ListAllSessions(limit int) ([]SessionData, error) {
 			&org,
 			&project,
 			&messageCount,
+			&tags,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan session: %w", err)
@@ -302,6 +395,7 @@ func (s *SessionStore) ListAllSessions(limit int) ([]SessionData, error) {
 		session.LastUpdated = time.Unix(lastUpdated, 0)
 		session.ProjectSlug = fmt.Sprintf("%s/%s/%s", host, org, project)
 		session.MessageCount = messageCount
+		session.Tags = splitTags(tags)
 		session.Messages = []llms.MessageContent{} // Empty for list view
 		session.ContextFiles = make(map[string]string)
 
@@ -363,7 +457,7 @@ func (s *SessionStore) CleanupOldSessions() error {
 			DELETE FROM sessions
 			WHERE id NOT IN (
 				SELECT id FROM sessions
-				ORDER BY last_updated DESC
+				ORDER BY last_updated DESC, rowid DESC
 				LIMIT ?
 			)`,
 			s.cfg.MaxSessions,
@@ -376,6 +470,86 @@ func (s *SessionStore) CleanupOldSessions() error {
 	return nil
 }
 
+// sessionSizesQuery lists each session's size in bytes, newest first
+const sessionSizesQuery = `
+	SELECT s.id, LENGTH(CAST(s.first_prompt AS BLOB)) + COALESCE(SUM(LENGTH(CAST(m.content AS BLOB))), 0)
+	FROM sessions s
+	LEFT JOIN messages m ON m.session_id = s.id
+	GROUP BY s.id
+	ORDER BY s.last_updated DESC, s.rowid DESC`
+
+// TrimToStorageBudget deletes the oldest sessions until the rest fit in MaxStorageBytes and
+// returns how many it deleted. The newest session is kept even when it alone is over budget.
+func (s *SessionStore) TrimToStorageBudget() (int, error) {
+	if s.cfg == nil || s.cfg.MaxStorageBytes <= 0 {
+		return 0, nil
+	}
+	budget := s.cfg.MaxStorageBytes
+	rows, err := s.db.conn.Query(sessionSizesQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure sessions: %w", err)
+	}
+	var (
+		total   int64
+		expired []string
+	)
+	for rows.Next() {
+		var (
+			id   string
+			size int64
+		)
+		if err := rows.Scan(&id, &size); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan session size: %w", err)
+		}
+		total += size
+		if total > budget && total != size {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to measure sessions: %w", err)
+	}
+
+	for i, id := range expired {
+		if _, err := s.db.conn.Exec("DELETE FROM sessions WHERE id = ?", id); err != nil {
+			return i, fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+	return len(expired), nil
+}
+
+// SetMaxStorageBytes sets the size the stored sessions are trimmed to by TrimToStorageBudget
+func (s *SessionStore) SetMaxStorageBytes(limit int64) {
+	if s.cfg == nil {
+		s.cfg = &SessionConfig{}
+	}
+	s.cfg.MaxStorageBytes = limit
+}
+
+// Usage reports how many sessions are stored and the bytes they take
+func (s *SessionStore) Usage() (StorageUsage, error) {
+	var usage StorageUsage
+	rows, err := s.db.conn.Query(sessionSizesQuery)
+	if err != nil {
+		return usage, fmt.Errorf("failed to measure sessions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   string
+			size int64
+		)
+		if err := rows.Scan(&id, &size); err != nil {
+			return usage, fmt.Errorf("failed to scan session size: %w", err)
+		}
+		usage.Sessions++
+		usage.Bytes += size
+	}
+	return usage, rows.Err()
+}
+
 // SearchMessages searches for messages matching a regex pattern
 func (s *SessionStore) SearchMessages(pattern string, limit int) ([]SearchResult, error) {
 	// Compile regex
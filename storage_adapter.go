@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/afittestide/asimi/storage"
@@ -53,12 +54,26 @@ func parseProjectSlug(workingDir string) (host, org, project string) {
 // baseHistory contains common fields and logic for history stores
 type baseHistory struct {
 	store   *storage.HistoryStore
+	cfg     *storage.HistoryConfig
 	host    string
 	org     string
 	project string
 	branch  string
 }
 
+// SetDedup sets how repeated entries are collapsed: none, consecutive or global
+func (h *baseHistory) SetDedup(policy string) {
+	switch policy {
+	case storage.DedupNone, storage.DedupConsecutive, storage.DedupGlobal:
+	case "":
+		policy = storage.DedupNone
+	default:
+		slog.Warn("unknown history.dedup, keeping repeated entries", "dedup", policy)
+		policy = storage.DedupNone
+	}
+	h.cfg.Dedup = policy
+}
+
 // PromptHistory handles prompt history persistence
 type PromptHistory struct {
 	baseHistory
@@ -83,6 +98,7 @@ func NewPromptHistoryStore(db *storage.DB, repoInfo RepoInfo) (*PromptHistory, e
 	return &PromptHistory{
 		baseHistory: baseHistory{
 			store:   storage.NewHistoryStore(db, histCfg),
+			cfg:     histCfg,
 			host:    host,
 			org:     org,
 			project: project,
@@ -101,8 +117,11 @@ func (h *PromptHistory) Save(entries []storage.HistoryEntry) error {
 	return nil // No-op, SQLite saves on append
 }
 
-// Append adds a new entry to the prompt history
+// Append adds a new entry to the prompt history, skipping blank prompts
 func (h *PromptHistory) Append(prompt string) error {
+	if strings.TrimSpace(prompt) == "" {
+		return nil
+	}
 	return h.store.AppendPrompt(h.host, h.org, h.project, h.branch, prompt)
 }
 
@@ -135,6 +154,7 @@ func NewCommandHistoryStore(db *storage.DB, repoInfo RepoInfo) (*CommandHistory,
 	return &CommandHistory{
 		baseHistory: baseHistory{
 			store:   storage.NewHistoryStore(db, histCfg),
+			cfg:     histCfg,
 			host:    host,
 			org:     org,
 			project: project,
@@ -153,8 +173,11 @@ func (h *CommandHistory) Save(entries []storage.HistoryEntry) error {
 	return nil // No-op, SQLite saves on append
 }
 
-// Append adds a new entry to the command history
+// Append adds a new entry to the command history, skipping blank commands
 func (h *CommandHistory) Append(command string) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
 	return h.store.AppendCommand(h.host, h.org, h.project, h.branch, command)
 }
 
@@ -163,6 +186,69 @@ func (h *CommandHistory) Clear() error {
 	return h.store.ClearCommandHistory(h.host, h.org, h.project, h.branch)
 }
 
+// ProjectMemory holds the keyed notes the model keeps for the current project
+type ProjectMemory struct {
+	store   *storage.MemoryStore
+	host    string
+	org     string
+	project string
+}
+
+// currentProjectMemory is used by the memory tool and injected into new sessions' system prompt
+var currentProjectMemory *ProjectMemory
+
+// NewProjectMemory creates a project memory store using SQLite
+func NewProjectMemory(db *storage.DB, repoInfo RepoInfo) (*ProjectMemory, error) {
+	if db == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	host, org, project := parseProjectSlug(repoInfo.ProjectRoot)
+	return &ProjectMemory{
+		store:   storage.NewMemoryStore(db),
+		host:    host,
+		org:     org,
+		project: project,
+	}, nil
+}
+
+// Set stores a note under key
+func (m *ProjectMemory) Set(key, value string) error {
+	return m.store.Set(m.host, m.org, m.project, key, value)
+}
+
+// Get returns the note stored under key
+func (m *ProjectMemory) Get(key string) (string, bool, error) {
+	return m.store.Get(m.host, m.org, m.project, key)
+}
+
+// List returns all notes ordered by key
+func (m *ProjectMemory) List() ([]storage.MemoryEntry, error) {
+	return m.store.List(m.host, m.org, m.project)
+}
+
+// Delete removes the note stored under key
+func (m *ProjectMemory) Delete(key string) (bool, error) {
+	return m.store.Delete(m.host, m.org, m.project, key)
+}
+
+// Prompt renders the notes for the system prompt's Memory partial
+func (m *ProjectMemory) Prompt() string {
+	if m == nil {
+		return ""
+	}
+	entries, err := m.List()
+	if err != nil {
+		slog.Warn("failed to load project memory", "error", err)
+		return ""
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "- %s: %s\n", e.Key, e.Value)
+	}
+	return b.String()
+}
+
 // SessionStore adapter wraps the SQLite session store with the old interface
 type SessionStore struct {
 	store       *storage.SessionStore
@@ -175,6 +261,8 @@ type SessionStore struct {
 	stopChan    chan struct{}
 	closeOnce   sync.Once
 	wg          sync.WaitGroup // Track in-flight saves
+
+	preserveIncompleteToolCalls atomic.Bool // note interrupted tool calls rather than drop them
 }
 
 // NewSessionStore creates a new session store using SQLite
@@ -259,7 +347,10 @@ func (s *SessionStore) saveSessionSync(session *Session) error {
 		return fmt.Errorf("cannot save nil session")
 	}
 
-	// Remove unmatched tool calls before saving
+	// Remove unmatched tool calls before saving, or note them when preserving them
+	if s.preserveIncompleteToolCalls.Load() {
+		session.noteIncompleteToolCalls()
+	}
 	session.sanitizeMessages()
 
 	// Don't save empty sessions (only system messages)
@@ -285,24 +376,7 @@ func (s *SessionStore) saveSessionSync(session *Session) error {
 	session.LastUpdated = now
 
 	// Set FirstPrompt if not set
-	if session.FirstPrompt == "" && len(session.Messages) > 0 {
-		for _, msg := range session.Messages {
-			if msg.Role == llms.ChatMessageTypeHuman {
-				for _, part := range msg.Parts {
-					if textPart, ok := part.(llms.TextContent); ok {
-						session.FirstPrompt = textPart.Text
-						if len(session.FirstPrompt) > 100 {
-							session.FirstPrompt = session.FirstPrompt[:100] + "..."
-						}
-						break
-					}
-				}
-				if session.FirstPrompt != "" {
-					break
-				}
-			}
-		}
-	}
+	session.backfillFirstPrompt()
 
 	// Convert main.Session to storage.SessionData
 	storageSession := &storage.SessionData{
@@ -316,6 +390,8 @@ func (s *SessionStore) saveSessionSync(session *Session) error {
 		ProjectSlug:  session.ProjectSlug,
 		Messages:     session.Messages,
 		ContextFiles: session.ContextFiles,
+		Tags:         session.Tags,
+		ToolMetrics:  session.ToolMetrics(),
 	}
 
 	return s.store.SaveSession(storageSession, s.Host, s.Org, s.Project, s.Branch)
@@ -328,11 +404,10 @@ func (s *SessionStore) LoadSession(id string) (*Session, error) {
 		return nil, err
 	}
 
-	// Verify it's from the same repo/branch (optional check)
+	// Verify it's from the same repo (optional check)
 	_ = host
 	_ = org
 	_ = project
-	_ = branch
 
 	// Convert storage.SessionData to main.Session
 	session := &Session{
@@ -344,16 +419,34 @@ func (s *SessionStore) LoadSession(id string) (*Session, error) {
 		Model:        storageSession.Model,
 		WorkingDir:   storageSession.WorkingDir,
 		ProjectSlug:  storageSession.ProjectSlug,
+		Branch:       branch,
 		Messages:     storageSession.Messages,
 		ContextFiles: storageSession.ContextFiles,
+		Tags:         storageSession.Tags,
 	}
+	session.setToolMetrics(storageSession.ToolMetrics)
 
 	return session, nil
 }
 
 // ListSessions lists sessions for the current branch
 func (s *SessionStore) ListSessions(limit int) ([]Session, error) {
-	storageSessions, err := s.store.ListSessions(s.Host, s.Org, s.Project, s.Branch, limit)
+	return s.ListTaggedSessions("", limit)
+}
+
+// ListTaggedSessions lists the sessions of the current branch tagged with tag, all of them when tag is empty
+func (s *SessionStore) ListTaggedSessions(tag string, limit int) ([]Session, error) {
+	return s.listSessions(s.Branch, tag, limit)
+}
+
+// ListAllBranchesSessions lists the sessions of every branch of the project, tagged with tag
+// unless it is empty, each with the branch it is stored under
+func (s *SessionStore) ListAllBranchesSessions(tag string, limit int) ([]Session, error) {
+	return s.listSessions("", tag, limit)
+}
+
+func (s *SessionStore) listSessions(branch, tag string, limit int) ([]Session, error) {
+	storageSessions, err := s.store.ListSessions(s.Host, s.Org, s.Project, branch, tag, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -370,9 +463,11 @@ func (s *SessionStore) ListSessions(limit int) ([]Session, error) {
 			Model:        ss.Model,
 			WorkingDir:   ss.WorkingDir,
 			ProjectSlug:  ss.ProjectSlug,
+			Branch:       ss.Branch,
 			Messages:     ss.Messages,
 			ContextFiles: ss.ContextFiles,
 			MessageCount: ss.MessageCount,
+			Tags:         ss.Tags,
 		}
 	}
 
@@ -384,6 +479,32 @@ func (s *SessionStore) CleanupOldSessions() error {
 	return s.store.CleanupOldSessions()
 }
 
+// SetPreserveIncompleteToolCalls saves a trailing tool call that never got its result as a note
+// naming the tool, instead of dropping it
+func (s *SessionStore) SetPreserveIncompleteToolCalls(on bool) {
+	s.preserveIncompleteToolCalls.Store(on)
+}
+
+// SetMaxStorageBytes sets the size TrimStorage trims the stored sessions to, it deletes nothing
+func (s *SessionStore) SetMaxStorageBytes(limit int64) {
+	s.store.SetMaxStorageBytes(limit)
+}
+
+// TrimStorage deletes the oldest sessions until the rest fit in the size set with
+// SetMaxStorageBytes, and logs how many it deleted
+func (s *SessionStore) TrimStorage() (int, error) {
+	deleted, err := s.store.TrimToStorageBudget()
+	if deleted > 0 {
+		slog.Info("deleted the oldest sessions to fit session.max_storage_bytes", "count", deleted)
+	}
+	return deleted, err
+}
+
+// Usage reports the number of stored sessions, across projects, and the bytes they take
+func (s *SessionStore) Usage() (storage.StorageUsage, error) {
+	return s.store.Usage()
+}
+
 // Close closes the session store gracefully, waiting for pending saves to complete
 func (s *SessionStore) Close() {
 	s.closeOnce.Do(func() {
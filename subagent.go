@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// dispatchAgentToolName is the tool that hands a task to a subagent
+const dispatchAgentToolName = "dispatch_agent"
+
+// defaultSubagentTools are the tools of a subagent when tools.subagent_tools is unset, enough
+// to explore the project without changing it. run_in_shell can change anything, so it has to be
+// listed in tools.subagent_tools.
+var defaultSubagentTools = []string{"read_file", "read_many_files", "list_files", "web_fetch"}
+
+// maxSubagentTurns caps the model requests of a subagent, below llm.max_turns when it's lower
+const maxSubagentTurns = 30
+
+// subagentSystemPrompt is the system prompt of a subagent, followed by the environment
+const subagentSystemPrompt = `You are a subagent of asimi, a coding assistant, carrying out one task for it in the user's project. You share none of its conversation: the task below is all you know, so use your tools to find what you need.
+
+- Stay within the task. Explore as much as it takes, but don't change any files.
+- Your final reply is all the assistant will see of your work, not your tool calls. Make it a concise, self-contained summary of what you found, with file paths and line numbers where they help.
+- If the task can't be done, say why in your final reply instead of guessing.`
+
+// DispatchAgentInput is the input for the DispatchAgentTool
+type DispatchAgentInput struct {
+	Task string `json:"task"`
+}
+
+// DispatchAgentTool runs a task in a subagent, a child session with a fresh history and
+// the tools of tools.subagent_tools, and returns its final reply. The exploration stays out
+// of the parent's context, which only gets the summary.
+type DispatchAgentTool struct {
+	parent *Session
+}
+
+func (t DispatchAgentTool) Name() string {
+	return dispatchAgentToolName
+}
+
+func (t DispatchAgentTool) Description() string {
+	return "Hands a self-contained exploratory task, like finding all the usages of X, to a subagent with read-only tools that returns only a summary. The input should be a JSON object with a 'task' field."
+}
+
+func (t DispatchAgentTool) Call(ctx context.Context, input string) (string, error) {
+	var params DispatchAgentInput
+	if err := json.Unmarshal([]byte(input), &params); err != nil {
+		return "", fmt.Errorf("invalid input: %w", err)
+	}
+	if strings.TrimSpace(params.Task) == "" {
+		return "", fmt.Errorf("the task is empty")
+	}
+	if t.parent == nil {
+		return "", fmt.Errorf("%s is only available in a session", dispatchAgentToolName)
+	}
+
+	child, err := t.parent.newSubagent()
+	if err != nil {
+		return "", err
+	}
+	reply, err := child.Ask(ctx, params.Task)
+	t.parent.addSubagentUsage(child)
+	if err != nil {
+		return "", fmt.Errorf("subagent failed: %w", err)
+	}
+	return reply, nil
+}
+
+func (t DispatchAgentTool) ParameterSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"task": map[string]any{
+				"type":        "string",
+				"description": "The task, with all the context the subagent needs",
+			},
+		},
+		"required": []string{"task"},
+	}
+}
+
+// Format formats a dispatch_agent tool call for display
+func (t DispatchAgentTool) Format(input, result string, err error) string {
+	var params DispatchAgentInput
+	json.Unmarshal([]byte(input), &params)
+
+	task, _, _ := strings.Cut(strings.TrimSpace(params.Task), "\n")
+	if len(task) > 60 {
+		task = task[:57] + "..."
+	}
+	msg := NewChatMsgBuilder("Dispatch Agent ")
+	msg.WriteString(task)
+	msg.WriteLn()
+
+	if err != nil {
+		msg.Writef("Error: %v", err)
+	} else {
+		msg.Writef("Reported %d lines", strings.Count(strings.TrimSpace(result), "\n")+1)
+	}
+
+	return msg.String() + "\n"
+}
+
+// newSubagent creates the child session of a dispatch_agent call: the same model and project,
+// the subagent system prompt, an empty history and the subagent tools. It can't dispatch
+// agents of its own, and asks for the tools with the ask permission as the parent would.
+func (s *Session) newSubagent() (*Session, error) {
+	cfg := Config{}
+	if s.systemConfig != nil {
+		cfg = *s.systemConfig
+	}
+	if s.config != nil {
+		cfg.LLM = *s.config
+	}
+	cfg.LLM.MaxTurns = min(cfg.LLM.MaxTurns, maxSubagentTurns)
+	if cfg.LLM.MaxTurns <= 0 {
+		cfg.LLM.MaxTurns = maxSubagentTurns
+	}
+	cfg.LLM.IncludeHistoryPartial = false
+	cfg.Session.Persona = ""
+	cfg.Session.AutoAttachReferences = false
+	// The parent checkpoints the working tree before the call, and the subagent commits nothing
+	cfg.Git = GitConfig{}
+
+	child, err := NewSession(s.llm, &cfg, s.repoInfo, func(any) {})
+	if err != nil {
+		return nil, err
+	}
+	child.Messages[0] = child.systemMessage([]llms.ContentPart{
+		llms.TextPart(subagentSystemPrompt + "\n\n" + sessBuildEnvBlock(s.repoInfo)),
+	})
+
+	allowed := cfg.Tools.SubagentTools
+	if len(allowed) == 0 {
+		allowed = defaultSubagentTools
+	}
+	allowed = slices.DeleteFunc(slices.Clone(allowed), func(name string) bool { return name == dispatchAgentToolName })
+	if child.toolDefs, child.toolCatalog, err = restrictTools(child.toolDefs, child.toolCatalog, allowed); err != nil {
+		return nil, fmt.Errorf("tools.subagent_tools: %w", err)
+	}
+	child.approveTools = s.approveTools
+	if s.alwaysApprovedTools == nil {
+		s.alwaysApprovedTools = make(map[string]bool)
+	}
+	child.alwaysApprovedTools = s.alwaysApprovedTools
+	child.updateTokenCounts()
+	return child, nil
+}
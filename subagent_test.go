@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// subagentLLM dispatches a task as the parent and, as the subagent, lists the files then
+// reports. It records the history and tools of the last subagent.
+type subagentLLM struct {
+	llms.Model
+	mu           sync.Mutex
+	childTools   []string
+	childHistory int
+}
+
+func (m *subagentLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	usage := map[string]any{"InputTokens": 100, "OutputTokens": 10}
+	last := messages[len(messages)-1]
+	var result string
+	if last.Role == llms.ChatMessageTypeTool {
+		result = last.Parts[0].(llms.ToolCallResponse).Content
+	}
+	call := func(name, args string) *llms.ContentResponse {
+		return &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+			ToolCalls:      []llms.ToolCall{{ID: name + "-1", Type: "function", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}},
+			GenerationInfo: usage,
+		}}}
+	}
+	reply := func(text string) *llms.ContentResponse {
+		return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: text, GenerationInfo: usage}}}
+	}
+
+	var system strings.Builder
+	for _, part := range messages[0].Parts {
+		system.WriteString(part.(llms.TextContent).Text)
+	}
+	if strings.Contains(system.String(), "You are a subagent") {
+		if result == "" {
+			m.mu.Lock()
+			m.childHistory = len(messages)
+			m.childTools = nil
+			for _, tool := range opts.Tools {
+				m.childTools = append(m.childTools, tool.Function.Name)
+			}
+			m.mu.Unlock()
+			return call("list_files", `{"path":"."}`), nil
+		}
+		return reply("SUMMARY " + strings.Join(strings.Fields(result), " ")), nil
+	}
+	if result == "" {
+		return call(dispatchAgentToolName, `{"task":"List the files of the project"}`), nil
+	}
+	return reply("Parent got: " + result), nil
+}
+
+func TestSession_DispatchAgent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("findme.go", []byte("package main\n"), 0644))
+	llm := &subagentLLM{}
+	sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-20250514"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	_, err = sess.Ask(context.Background(), "earlier prompt")
+	require.NoError(t, err)
+	parentMessages := len(sess.Messages)
+
+	reply, err := sess.Ask(context.Background(), "What files are there?")
+	require.NoError(t, err)
+	require.Contains(t, reply, "Parent got: SUMMARY")
+	require.Contains(t, reply, "findme.go")
+
+	// The subagent starts with only its system prompt and the task, and its tools
+	require.Equal(t, 2, llm.childHistory)
+	require.ElementsMatch(t, defaultSubagentTools, llm.childTools)
+	// The parent only keeps the dispatch call and the summary
+	require.Len(t, sess.Messages, parentMessages+4)
+	for _, msg := range sess.Messages {
+		for _, part := range msg.Parts {
+			if call, ok := part.(llms.ToolCall); ok {
+				require.Equal(t, dispatchAgentToolName, call.FunctionCall.Name)
+			}
+		}
+	}
+
+	// The subagent's two requests count towards the parent's turn
+	turns := sess.TurnUsages()
+	require.Equal(t, 2+2, turns[len(turns)-1].Requests)
+}
+
+func TestNewSubagent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := &Config{Tools: ToolsConfig{SubagentTools: []string{"read_file", dispatchAgentToolName}}}
+	sess, err := NewSession(&mockLLMNoTools{}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.Contains(t, sess.toolCatalog, dispatchAgentToolName)
+
+	child, err := sess.newSubagent()
+	require.NoError(t, err)
+	// No subagents of subagents
+	require.Len(t, child.toolCatalog, 1)
+	require.Contains(t, child.toolCatalog, "read_file")
+	require.Equal(t, maxSubagentTurns, child.config.MaxTurns)
+	require.Len(t, child.Messages, 1)
+
+	cfg.Tools.SubagentTools = []string{"no_such_tool"}
+	_, err = sess.newSubagent()
+	require.ErrorContains(t, err, `tools.subagent_tools: unknown tool "no_such_tool"`)
+}
@@ -13,6 +13,7 @@ type Theme struct {
 	TextColor        lipgloss.Color
 	Warning          lipgloss.Color
 	Error            lipgloss.Color
+	Success          lipgloss.Color
 	PromptBackground lipgloss.Color
 	StatusBackground lipgloss.Color
 	TextError        lipgloss.Color
@@ -47,6 +48,7 @@ func NewTheme() *Theme {
 	textColor := lipgloss.Color("#01FAFA")
 	warning := lipgloss.Color("#F4DB53")
 	errorColor := lipgloss.Color("#F54545")
+	success := lipgloss.Color("#00FF00")
 	promptBackground := lipgloss.Color("#271D30")
 
 	textError := lipgloss.Color("#004444")
@@ -64,6 +66,7 @@ func NewTheme() *Theme {
 		TextColor:        textColor,
 		Warning:          warning,
 		Error:            errorColor,
+		Success:          success,
 		PromptBackground: promptBackground,
 		TextError:        textError,
 		PaneBackground:   paneBackground,
@@ -5,18 +5,30 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/tmc/langchaingo/tools"
 	"github.com/yargevad/filepathx"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var (
@@ -89,6 +101,77 @@ func validatePathWithinProject(path string) error {
 	return nil
 }
 
+var (
+	writeAllowlistMu sync.RWMutex
+	writeAllowlist   []string
+)
+
+// initWriteAllowlist sets the globs of the files the model may edit from the tools config
+func initWriteAllowlist(config *Config) {
+	var globs []string
+	if config != nil {
+		globs = config.Tools.WriteAllowlist
+	}
+	writeAllowlistMu.Lock()
+	defer writeAllowlistMu.Unlock()
+	writeAllowlist = globs
+}
+
+// validateWriteAllowed checks a file the model is about to modify against tools.write_allowlist.
+// The globs match paths relative to the current working directory, ** matching any number of
+// directories. Everything is allowed when the allowlist is empty.
+func validateWriteAllowed(file string) error {
+	writeAllowlistMu.RLock()
+	globs := writeAllowlist
+	writeAllowlistMu.RUnlock()
+	if len(globs) == 0 {
+		return nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	rel, err := filepath.Rel(cwd, absPath)
+	if err != nil {
+		return fmt.Errorf("failed to determine relative path: %w", err)
+	}
+	rel = filepath.ToSlash(rel)
+	for _, glob := range globs {
+		if matchPathGlob(strings.Split(strings.TrimPrefix(glob, "./"), "/"), strings.Split(rel, "/")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("access denied: '%s' is not in tools.write_allowlist (%s), only files matching it can be modified", file, strings.Join(globs, ", "))
+}
+
+// matchPathGlob matches the segments of a slash separated path against those of a glob,
+// where a ** segment matches zero or more path segments
+func matchPathGlob(glob, segments []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchPathGlob(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], segments[0]); !ok {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
 // ReadFileInput is the input for the ReadFileTool
 type ReadFileInput struct {
 	Path   string `json:"path"`
@@ -145,13 +228,11 @@ func (t ReadFileTool) Call(ctx context.Context, input string) (string, error) {
 		return "", err
 	}
 
-	content, err := os.ReadFile(params.Path)
+	contentStr, _, err := readTextFile(params.Path)
 	if err != nil {
 		return "", err
 	}
 
-	contentStr := string(content)
-
 	// If no offset or limit specified, return full content
 	if params.Offset == 0 && params.Limit == 0 {
 		return contentStr, nil
@@ -247,10 +328,13 @@ func (t WriteFileTool) Call(ctx context.Context, input string) (string, error) {
 	params.Path = strings.Trim(params.Path, `"'`)
 	params.Content = strings.Trim(params.Content, `"'`)
 
-	// Validate that the path is within the project root
+	// Validate that the path is within the project root and the write allowlist
 	if err := validatePathWithinProject(params.Path); err != nil {
 		return "", err
 	}
+	if err := validateWriteAllowed(params.Path); err != nil {
+		return "", err
+	}
 
 	// Create parent directory if it doesn't exist
 	dir := filepath.Dir(params.Path)
@@ -260,7 +344,8 @@ func (t WriteFileTool) Call(ctx context.Context, input string) (string, error) {
 		}
 	}
 
-	err = os.WriteFile(params.Path, []byte(params.Content), 0644)
+	// An existing file keeps its encoding
+	err = writeTextFile(params.Path, params.Content, existingFileEncoding(params.Path))
 	if err != nil {
 		return "", err
 	}
@@ -347,6 +432,9 @@ func (t ListDirectoryTool) Call(ctx context.Context, input string) (string, erro
 
 	var fileNames []string
 	for _, file := range files {
+		if isHiddenPath(file.Name()) {
+			continue
+		}
 		fileNames = append(fileNames, file.Name())
 	}
 	return strings.Join(fileNames, "\n"), nil
@@ -415,18 +503,19 @@ func (t ReplaceTextTool) Call(ctx context.Context, input string) (string, error)
 		return "", fmt.Errorf("invalid input: %w. The input should be a JSON object with 'path', 'old_text', and 'new_text' fields", err)
 	}
 
-	// Validate that the path is within the project root
+	// Validate that the path is within the project root and the write allowlist
 	if err := validatePathWithinProject(params.Path); err != nil {
 		return "", err
 	}
+	if err := validateWriteAllowed(params.Path); err != nil {
+		return "", err
+	}
 
-	content, err := os.ReadFile(params.Path)
+	oldContent, encodingName, err := readTextFile(params.Path)
 	if err != nil {
 		return "", err
 	}
 
-	oldContent := string(content)
-
 	// Check if old_string and new_string are identical
 	if params.OldText == params.NewText {
 		return fmt.Sprintf("No changes to apply. The old_string and new_string are identical in file: %s", params.Path), nil
@@ -441,7 +530,7 @@ func (t ReplaceTextTool) Call(ctx context.Context, input string) (string, error)
 		return fmt.Sprintf("No occurrences of '%s' found in %s", params.OldText, params.Path), nil
 	}
 
-	err = os.WriteFile(params.Path, []byte(newContent), 0644)
+	err = writeTextFile(params.Path, newContent, encodingName)
 	if err != nil {
 		return "", err
 	}
@@ -554,10 +643,17 @@ func (r *HostShellRunner) RunnerType() string {
 	return "host"
 }
 
+// containerRunner is a shell runner that runs commands in a container
+type containerRunner interface {
+	ContainerID() string // empty until the container is started
+	ImageName() string
+}
+
 // ShellRunnerInfo contains information about the current shell runner
 type ShellRunnerInfo struct {
 	Type        string // "podman" or "host"
 	ContainerID string // Container ID if using podman, empty otherwise
+	Image       string // Image of the container if using podman
 }
 
 // getShellRunnerInfo returns information about the current shell runner
@@ -573,9 +669,10 @@ func getShellRunnerInfo() ShellRunnerInfo {
 		Type: currentShellRunner.RunnerType(),
 	}
 
-	// If it's a podman runner, try to get the container ID
-	if podmanRunner, ok := currentShellRunner.(*PodmanShellRunner); ok {
-		info.ContainerID = podmanRunner.ContainerID()
+	// If it runs in a container, try to get the container ID
+	if container, ok := currentShellRunner.(containerRunner); ok {
+		info.ContainerID = container.ContainerID()
+		info.Image = container.ImageName()
 	}
 
 	return info
@@ -625,13 +722,42 @@ func initShellRunner(config *Config) {
 	repoInfo := GetRepoInfo()
 
 	// Auto-detect and assign shell runner
-	if isPodmanAvailable(config, repoInfo) {
-		slog.Info("using podman shell runner")
-		currentShellRunner = newPodmanShellRunner(config.RunInShell.AllowHostFallback, config, repoInfo)
-	} else {
+	sandbox := isPodmanAvailable(config, repoInfo)
+	if !sandbox {
 		slog.Info("using host shell runner (podman not available or image missing)")
-		currentShellRunner = newHostShellRunner(config)
 	}
+	currentShellRunner = newShellRunner(config, repoInfo, sandbox)
+}
+
+// newShellRunner returns a podman runner when sandbox is true and a host runner otherwise
+func newShellRunner(config *Config, repoInfo RepoInfo, sandbox bool) shellRunner {
+	if sandbox {
+		slog.Info("using podman shell runner")
+		return newPodmanShellRunner(config.RunInShell.AllowHostFallback, config, repoInfo)
+	}
+	return newHostShellRunner(config)
+}
+
+// setSandboxEnabled swaps the active shell runner between the podman sandbox and the host,
+// closing the previous runner. It fails if the sandbox is requested but podman or the image
+// is unavailable.
+func setSandboxEnabled(ctx context.Context, config *Config, enabled bool) error {
+	repoInfo := GetRepoInfo()
+	if enabled && !isPodmanAvailable(config, repoInfo) {
+		return fmt.Errorf("podman is not available or the sandbox image is missing")
+	}
+
+	shellRunnerMu.Lock()
+	prev := currentShellRunner
+	currentShellRunner = newShellRunner(config, repoInfo, enabled)
+	shellRunnerMu.Unlock()
+
+	if prev != nil {
+		if err := prev.Close(ctx); err != nil {
+			slog.Warn("failed to close previous shell runner", "type", prev.RunnerType(), "error", err)
+		}
+	}
+	return nil
 }
 
 func getShellRunner() shellRunner {
@@ -824,9 +950,18 @@ func hostRun(ctx context.Context, params RunInShellInput) (RunInShellOutput, err
 		cmd = exec.CommandContext(ctx, "bash", "-c", params.Command)
 	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Each stream keeps up to the scheduler's output limit
+	stdout, stderr := newOutputBuffer(toolOutputLimit(ctx)), newOutputBuffer(toolOutputLimit(ctx))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if emit := toolOutputFunc(ctx); emit != nil {
+		// stdout and stderr are copied by separate goroutines, so each gets its own line buffer
+		stdoutLines, stderrLines := &lineWriter{emit: emit}, &lineWriter{emit: emit}
+		cmd.Stdout = io.MultiWriter(stdout, stdoutLines)
+		cmd.Stderr = io.MultiWriter(stderr, stderrLines)
+		defer stdoutLines.Flush()
+		defer stderrLines.Flush()
+	}
 
 	runErr := cmd.Run()
 
@@ -896,6 +1031,54 @@ func requestHostCommandApproval(ctx context.Context, command string) (bool, erro
 	}
 }
 
+// ToolApproval is the user's answer to a ToolApprovalRequest
+type ToolApproval int
+
+const (
+	ToolDenied ToolApproval = iota
+	ToolApprovedOnce
+	ToolApprovedAlways // approved for the rest of the session
+)
+
+// ToolApprovalRequest asks the user to approve a tool call of a tool with the ask permission
+type ToolApprovalRequest struct {
+	Tool         string
+	Args         string
+	ResponseChan chan ToolApproval
+}
+
+// toolApprovalChan is used to send tool approval requests to the TUI
+var toolApprovalChan chan ToolApprovalRequest
+
+// SetToolApprovalChannel sets the channel used for tool approval requests
+// This should be called by the TUI during initialization
+func SetToolApprovalChannel(ch chan ToolApprovalRequest) {
+	toolApprovalChan = ch
+}
+
+// requestToolApproval sends an approval request to the TUI and waits for the answer
+func requestToolApproval(ctx context.Context, tool, args string) (ToolApproval, error) {
+	if toolApprovalChan == nil {
+		// No one to ask, as in non-interactive mode - deny for safety
+		slog.Warn("Tool approval requested but no approval channel configured", "tool", tool)
+		return ToolDenied, fmt.Errorf("no approval mechanism configured")
+	}
+
+	responseChan := make(chan ToolApproval, 1)
+	select {
+	case toolApprovalChan <- ToolApprovalRequest{Tool: tool, Args: args, ResponseChan: responseChan}:
+	case <-ctx.Done():
+		return ToolDenied, ctx.Err()
+	}
+
+	select {
+	case approval := <-responseChan:
+		return approval, nil
+	case <-ctx.Done():
+		return ToolDenied, ctx.Err()
+	}
+}
+
 type PodmanUnavailableError struct {
 	reason string
 }
@@ -937,6 +1120,8 @@ func (t ReadManyFilesTool) Call(ctx context.Context, input string) (string, erro
 			// For now, just continue.
 			continue
 		}
+		// Leave out what list_files hides, .git always and dotfiles without ui.show_hidden
+		matches = slices.DeleteFunc(matches, isHiddenPath)
 		allMatches = append(allMatches, matches...)
 	}
 
@@ -956,13 +1141,13 @@ func (t ReadManyFilesTool) Call(ctx context.Context, input string) (string, erro
 			continue
 		}
 
-		content, err := os.ReadFile(path)
+		content, _, err := readTextFile(path)
 		if err != nil {
 			// If we can't read a file, we can skip it and continue.
 			continue
 		}
 		contentBuilder.WriteString(fmt.Sprintf("---\t%s---\n", path))
-		contentBuilder.Write(content)
+		contentBuilder.WriteString(content)
 		contentBuilder.WriteString("\n")
 	}
 
@@ -1010,6 +1195,446 @@ func (t ReadManyFilesTool) Format(input, result string, err error) string {
 	return msg.String() + "\n"
 }
 
+// MemoryInput is the input for the MemoryTool
+type MemoryInput struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
+
+// MemoryTool keeps keyed notes for the project that persist across sessions
+type MemoryTool struct{}
+
+func (t MemoryTool) Name() string {
+	return "memory"
+}
+
+func (t MemoryTool) Description() string {
+	return "Manages durable notes about this project that persist across sessions and are shown in the system prompt of new sessions. Use it to remember user preferences, project conventions and facts worth keeping. The input should be a JSON object with 'action' (set, get, list or delete), 'key' and, for set, 'value'."
+}
+
+func (t MemoryTool) Call(ctx context.Context, input string) (string, error) {
+	var params MemoryInput
+	if err := json.Unmarshal([]byte(input), &params); err != nil {
+		return "", fmt.Errorf("invalid input: %w", err)
+	}
+
+	memory := currentProjectMemory
+	if memory == nil {
+		return "", fmt.Errorf("project memory is not available")
+	}
+
+	key := strings.TrimSpace(params.Key)
+	if key == "" && params.Action != "list" {
+		return "", fmt.Errorf("key is required for %q", params.Action)
+	}
+
+	switch params.Action {
+	case "set":
+		if err := memory.Set(key, params.Value); err != nil {
+			return "", fmt.Errorf("failed to save memory: %w", err)
+		}
+		return fmt.Sprintf("Saved %q", key), nil
+	case "get":
+		value, ok, err := memory.Get(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to read memory: %w", err)
+		}
+		if !ok {
+			return "", fmt.Errorf("no memory stored under %q", key)
+		}
+		return value, nil
+	case "list":
+		entries, err := memory.List()
+		if err != nil {
+			return "", fmt.Errorf("failed to list memory: %w", err)
+		}
+		if len(entries) == 0 {
+			return "No memories stored", nil
+		}
+		var b strings.Builder
+		for _, e := range entries {
+			fmt.Fprintf(&b, "%s: %s\n", e.Key, e.Value)
+		}
+		return b.String(), nil
+	case "delete":
+		ok, err := memory.Delete(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to delete memory: %w", err)
+		}
+		if !ok {
+			return "", fmt.Errorf("no memory stored under %q", key)
+		}
+		return fmt.Sprintf("Deleted %q", key), nil
+	default:
+		return "", fmt.Errorf("unknown action %q, expected set, get, list or delete", params.Action)
+	}
+}
+
+func (t MemoryTool) ParameterSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"action": map[string]any{
+				"type":        "string",
+				"enum":        []string{"set", "get", "list", "delete"},
+				"description": "The operation to perform",
+			},
+			"key": map[string]any{
+				"type":        "string",
+				"description": "Short name of the note, e.g. 'test-command'",
+			},
+			"value": map[string]any{
+				"type":        "string",
+				"description": "The note to store (set only)",
+			},
+		},
+		"required": []string{"action"},
+	}
+}
+
+// Format formats a memory tool call for display
+func (t MemoryTool) Format(input, result string, err error) string {
+	var params MemoryInput
+	json.Unmarshal([]byte(input), &params)
+
+	msg := NewChatMsgBuilder("Memory ")
+	msg.Writef("%s %s", params.Action, params.Key)
+	msg.WriteLn()
+
+	if err != nil {
+		msg.Writef("Error: %v", err)
+	} else {
+		switch params.Action {
+		case "get":
+			msg.Writef("Read %d characters", len(result))
+		case "list":
+			msg.Writef("Found %d notes", strings.Count(result, "\n"))
+		default:
+			msg.WriteString(result)
+		}
+	}
+
+	return msg.String() + "\n"
+}
+
+// WebFetchInput is the input for the WebFetchTool
+type WebFetchInput struct {
+	URL string `json:"url"`
+}
+
+// WebFetchTool downloads a web page as markdown, from the hosts of tools.web_fetch_allowlist
+type WebFetchTool struct {
+	config *Config
+}
+
+// defaultWebFetchMaxBytes caps a web_fetch download when tools.web_fetch_max_bytes is unset
+const defaultWebFetchMaxBytes = 512 * 1024
+
+// webFetchClient fetches the pages of web_fetch. It connects to public addresses only, checked
+// on the address dialed, so neither redirects nor DNS answers lead it to the local network.
+var webFetchClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: refuseLocalAddress}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private although not in RFC 1918
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// refuseLocalAddress stops web_fetch from connecting to loopback, private and link-local
+// addresses, where the services of the machine and its network listen
+func refuseLocalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("access denied: unexpected address %s", address)
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("access denied: %s is a local address", ip)
+	}
+	return nil
+}
+
+func (t WebFetchTool) Name() string {
+	return "web_fetch"
+}
+
+func (t WebFetchTool) Description() string {
+	return "Downloads a web page, such as documentation referenced in an issue, and returns its text as markdown without the navigation and other boilerplate. The input should be a JSON object with an 'url' field, an http or https URL."
+}
+
+func (t WebFetchTool) Call(ctx context.Context, input string) (string, error) {
+	var params WebFetchInput
+	if err := json.Unmarshal([]byte(input), &params); err != nil {
+		return "", fmt.Errorf("invalid input: %w", err)
+	}
+	target, err := url.Parse(strings.TrimSpace(params.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "", fmt.Errorf("invalid URL %q, expected an http or https URL", params.URL)
+	}
+	if err := t.checkHost(target); err != nil {
+		return "", err
+	}
+
+	// Redirects may not leave the allowlist either
+	client := *webFetchClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return t.checkHost(req.URL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid request: %w", err)
+	}
+	req.Header.Set("User-Agent", "asimi/"+version)
+	req.Header.Set("Accept", "text/html, text/plain, text/markdown, application/json;q=0.9, */*;q=0.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("fetching %s: HTTP %s", target, resp.Status)
+	}
+
+	maxBytes := defaultWebFetchMaxBytes
+	if t.config != nil && t.config.Tools.WebFetchMaxBytes > 0 {
+		maxBytes = t.config.Tools.WebFetchMaxBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", target, err)
+	}
+	truncated := len(body) > maxBytes
+	if truncated {
+		body = body[:maxBytes]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var text string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || (mediaType == "" && looksLikeHTML(body)):
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("parsing %s: %w", target, err)
+		}
+		text = htmlToMarkdown(doc, resp.Request.URL)
+	case mediaType == "" || strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "xml"):
+		text = strings.ToValidUTF8(string(body), "")
+	default:
+		return "", fmt.Errorf("%s is %s, web_fetch only reads text pages", target, mediaType)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "URL: %s\n\n%s\n", resp.Request.URL, text)
+	if truncated {
+		fmt.Fprintf(&b, "\n[Truncated: the page is larger than %d bytes]\n", maxBytes)
+	}
+	return b.String(), nil
+}
+
+// checkHost allows the hosts of tools.web_fetch_allowlist, exactly or, for a *.example.com
+// entry, any subdomain. Every host is allowed when the allowlist is empty.
+func (t WebFetchTool) checkHost(target *url.URL) error {
+	if t.config == nil || len(t.config.Tools.WebFetchAllowlist) == 0 {
+		return nil
+	}
+	host := strings.ToLower(target.Hostname())
+	for _, allowed := range t.config.Tools.WebFetchAllowlist {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("access denied: %s is not in tools.web_fetch_allowlist (%s)", host, strings.Join(t.config.Tools.WebFetchAllowlist, ", "))
+}
+
+func (t WebFetchTool) ParameterSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"url": map[string]any{
+				"type":        "string",
+				"description": "The http or https URL of the page to fetch",
+			},
+		},
+		"required": []string{"url"},
+	}
+}
+
+// Format formats a web_fetch tool call for display
+func (t WebFetchTool) Format(input, result string, err error) string {
+	var params WebFetchInput
+	json.Unmarshal([]byte(input), &params)
+
+	msg := NewChatMsgBuilder("Web Fetch ")
+	msg.WriteString(params.URL)
+	msg.WriteLn()
+
+	if err != nil {
+		msg.Writef("Error: %v", err)
+	} else {
+		msg.Writef("Read %d characters", len(result))
+	}
+
+	return msg.String() + "\n"
+}
+
+// looksLikeHTML sniffs a page served without a content type
+func looksLikeHTML(body []byte) bool {
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// webFetchSkippedElements hold scripts, styles and the boilerplate around a page's content
+var webFetchSkippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Button: true, atom.Svg: true, atom.Iframe: true, atom.Head: true,
+}
+
+// webFetchBlockElements start on a line of their own
+var webFetchBlockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Ul: true, atom.Ol: true, atom.Table: true, atom.Tr: true, atom.Blockquote: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Figure: true, atom.Hr: true,
+}
+
+// htmlToMarkdown renders the readable part of an HTML page as markdown: the main or article
+// element when the page has one, headings, lists, links and code kept, the rest as text
+func htmlToMarkdown(doc *html.Node, base *url.URL) string {
+	var b strings.Builder
+	if title := findElement(doc, atom.Title); title != nil {
+		if text := strings.Join(strings.Fields(nodeText(title)), " "); text != "" {
+			b.WriteString("# " + text + "\n\n")
+		}
+	}
+	root := findElement(doc, atom.Main)
+	if root == nil {
+		root = findElement(doc, atom.Article)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			text := strings.Join(strings.Fields(n.Data), " ")
+			if text == "" {
+				if n.Data != "" {
+					b.WriteString(" ")
+				}
+				return
+			}
+			if strings.TrimLeft(n.Data, " \t\r\n") != n.Data {
+				b.WriteString(" ")
+			}
+			b.WriteString(text)
+			if strings.TrimRight(n.Data, " \t\r\n") != n.Data {
+				b.WriteString(" ")
+			}
+			return
+		case html.ElementNode:
+			if webFetchSkippedElements[n.DataAtom] {
+				return
+			}
+			switch n.DataAtom {
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				level := int(n.Data[1] - '0')
+				b.WriteString("\n\n" + strings.Repeat("#", level) + " " + strings.Join(strings.Fields(nodeText(n)), " ") + "\n\n")
+				return
+			case atom.Pre:
+				b.WriteString("\n\n```\n" + strings.Trim(nodeText(n), "\n") + "\n```\n\n")
+				return
+			case atom.Code:
+				b.WriteString("`" + nodeText(n) + "`")
+				return
+			case atom.Br:
+				b.WriteString("\n")
+				return
+			case atom.Li:
+				b.WriteString("\n- ")
+			case atom.Td, atom.Th:
+				b.WriteString(" | ")
+			case atom.A:
+				text := strings.Join(strings.Fields(nodeText(n)), " ")
+				href := attr(n, "href")
+				link, err := base.Parse(href)
+				if text == "" || href == "" || strings.HasPrefix(href, "#") || err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+					b.WriteString(text)
+				} else {
+					b.WriteString("[" + text + "](" + link.String() + ")")
+				}
+				return
+			}
+			if webFetchBlockElements[n.DataAtom] {
+				b.WriteString("\n\n")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && webFetchBlockElements[n.DataAtom] {
+			b.WriteString("\n\n")
+		}
+	}
+	walk(root)
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// blankLinesPattern matches runs of blank lines, collapsed to one
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// findElement returns the first element of kind a under n, depth first
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// nodeText returns the text under n as it is
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
 type Tool interface {
 	tools.Tool
 	Format(input, result string, err error) string
@@ -1017,6 +1642,132 @@ type Tool interface {
 	ParameterSchema() map[string]any
 }
 
+// normalizeToolArguments turns the empty arguments some models send for tools without
+// parameters into an empty JSON object
+func normalizeToolArguments(argsJSON string) string {
+	if strings.TrimSpace(argsJSON) == "" {
+		return "{}"
+	}
+	return argsJSON
+}
+
+// validateToolArguments checks argsJSON against a tool's parameter schema: it must be
+// a JSON object with every required field, and known fields must match their declared
+// type and enum. Numeric strings are accepted for numbers, as some models send them.
+func validateToolArguments(schema map[string]any, argsJSON string) error {
+	var args map[string]any
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Errorf("arguments must be a JSON object: %w", err)
+	}
+	if args == nil {
+		return fmt.Errorf("arguments must be a JSON object, got null")
+	}
+
+	for _, name := range schemaStrings(schema["required"]) {
+		if _, ok := args[name]; !ok {
+			return fmt.Errorf("missing required field %q", name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	for name, value := range args {
+		property, ok := properties[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		if err := validateSchemaValue(property, value); err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validateSchemaValue checks a single value against its property schema
+func validateSchemaValue(property map[string]any, value any) error {
+	switch property["type"] {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("expected a string, got %s", jsonTypeName(value))
+		}
+	case "integer", "number":
+		switch v := value.(type) {
+		case float64:
+			if property["type"] == "integer" && v != float64(int64(v)) {
+				return fmt.Errorf("expected an integer, got %v", v)
+			}
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Errorf("expected a %s, got %q", property["type"], v)
+			}
+		default:
+			return fmt.Errorf("expected a %s, got %s", property["type"], jsonTypeName(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected a boolean, got %s", jsonTypeName(value))
+		}
+	case "object":
+		if _, ok := value.(map[string]any); !ok {
+			return fmt.Errorf("expected an object, got %s", jsonTypeName(value))
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("expected an array, got %s", jsonTypeName(value))
+		}
+		if itemSchema, ok := property["items"].(map[string]any); ok {
+			for i, item := range items {
+				if err := validateSchemaValue(itemSchema, item); err != nil {
+					return fmt.Errorf("item %d: %w", i, err)
+				}
+			}
+		}
+	}
+
+	if enum := schemaStrings(property["enum"]); len(enum) > 0 {
+		if str, ok := value.(string); ok && !slices.Contains(enum, str) {
+			return fmt.Errorf("%q is not one of %s", str, strings.Join(enum, ", "))
+		}
+	}
+	return nil
+}
+
+// schemaStrings reads a string list from a schema, which may be built as []string or decoded as []any
+func schemaStrings(v any) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// jsonTypeName names the JSON type of a decoded value for error messages
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
 func getAvailableTools(config *Config) []Tool {
 	return []Tool{
 		ReadFileTool{},
@@ -1025,6 +1776,9 @@ func getAvailableTools(config *Config) []Tool {
 		ReplaceTextTool{},
 		RunInShell{config: config},
 		ReadManyFilesTool{},
+		MemoryTool{},
+		WebFetchTool{config: config},
+		DispatchAgentTool{},
 	}
 }
 
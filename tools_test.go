@@ -5,12 +5,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/afittestide/asimi/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -518,6 +523,88 @@ func TestReplaceTextToolPathValidation(t *testing.T) {
 	})
 }
 
+func TestWriteAllowlist(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll(filepath.Join("src", "pkg"), 0o755))
+	require.NoError(t, os.WriteFile("README.md", []byte("old"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join("src", "pkg", "a.go"), []byte("old"), 0o644))
+
+	initWriteAllowlist(&Config{Tools: ToolsConfig{WriteAllowlist: []string{"src/**", "./docs/*.md"}}})
+	t.Cleanup(func() { initWriteAllowlist(nil) })
+
+	write := WriteFileTool{}
+	replace := ReplaceTextTool{}
+	ctx := context.Background()
+
+	_, err := write.Call(ctx, `{"path": "src/pkg/b.go", "content": "new"}`)
+	require.NoError(t, err)
+	_, err = write.Call(ctx, `{"path": "docs/guide.md", "content": "new"}`)
+	require.NoError(t, err)
+	_, err = replace.Call(ctx, `{"path": "src/pkg/a.go", "old_text": "old", "new_text": "new"}`)
+	require.NoError(t, err)
+
+	_, err = write.Call(ctx, `{"path": "main.go", "content": "new"}`)
+	require.ErrorContains(t, err, "not in tools.write_allowlist")
+	require.NoFileExists(t, "main.go")
+	_, err = write.Call(ctx, `{"path": "docs/api/ref.md", "content": "new"}`)
+	require.ErrorContains(t, err, "not in tools.write_allowlist")
+	_, err = replace.Call(ctx, `{"path": "README.md", "old_text": "old", "new_text": "new"}`)
+	require.ErrorContains(t, err, "not in tools.write_allowlist")
+	content, err := os.ReadFile("README.md")
+	require.NoError(t, err)
+	require.Equal(t, "old", string(content))
+
+	// An empty allowlist leaves every file of the project writable
+	initWriteAllowlist(nil)
+	_, err = write.Call(ctx, `{"path": "main.go", "content": "new"}`)
+	require.NoError(t, err)
+}
+
+func TestFileEncodingRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, setFileEncoding("latin-1"))
+	t.Cleanup(func() { initFileEncoding(nil) })
+	ctx := context.Background()
+	read := func(path string) []byte {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return data
+	}
+
+	latin1 := []byte("caf\xe9 na\xefve\n")
+	require.NoError(t, os.WriteFile("menu.txt", latin1, 0o644))
+
+	content, err := ReadFileTool{}.Call(ctx, `{"path": "menu.txt"}`)
+	require.NoError(t, err)
+	require.Equal(t, "café naïve\n", content)
+
+	_, err = ReplaceTextTool{}.Call(ctx, `{"path": "menu.txt", "old_text": "café", "new_text": "crème"}`)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cr\xe8me na\xefve\n"), read("menu.txt"), "the file stays latin-1")
+
+	_, err = WriteFileTool{}.Call(ctx, `{"path": "menu.txt", "content": "Grüße"}`)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Gr\xfc\xdfe"), read("menu.txt"))
+
+	_, err = WriteFileTool{}.Call(ctx, `{"path": "menu.txt", "content": "5 €"}`)
+	require.ErrorContains(t, err, "cannot be written as latin-1")
+	require.Equal(t, []byte("Gr\xfc\xdfe"), read("menu.txt"), "a failed write leaves the file alone")
+
+	// New files and UTF-8 files are written as UTF-8, a BOM is kept
+	_, err = WriteFileTool{}.Call(ctx, `{"path": "new.txt", "content": "Grüße"}`)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Grüße"), read("new.txt"))
+	require.NoError(t, os.WriteFile("bom.txt", []byte("\xef\xbb\xbfold"), 0o644))
+	content, err = ReadFileTool{}.Call(ctx, `{"path": "bom.txt"}`)
+	require.NoError(t, err)
+	require.Equal(t, "old", content)
+	_, err = ReplaceTextTool{}.Call(ctx, `{"path": "bom.txt", "old_text": "old", "new_text": "neu"}`)
+	require.NoError(t, err)
+	require.Equal(t, []byte("\xef\xbb\xbfneu"), read("bom.txt"))
+
+	require.ErrorContains(t, setFileEncoding("klingon"), "unknown encoding")
+}
+
 func TestPathValidationWithSymlinks(t *testing.T) {
 	// Skip on Windows as symlink behavior is different
 	if os.Getenv("GOOS") == "windows" {
@@ -994,3 +1081,142 @@ func TestSecurityDemonstration(t *testing.T) {
 		})
 	})
 }
+
+// useTestProjectMemory points the memory tool at a fresh SQLite database
+func useTestProjectMemory(t *testing.T) *ProjectMemory {
+	t.Helper()
+	db, err := storage.InitDB(filepath.Join(t.TempDir(), "asimi.sqlite"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	memory, err := NewProjectMemory(db, RepoInfo{ProjectRoot: "/test/project"})
+	require.NoError(t, err)
+
+	orig := currentProjectMemory
+	currentProjectMemory = memory
+	t.Cleanup(func() { currentProjectMemory = orig })
+	return memory
+}
+
+func TestMemoryTool(t *testing.T) {
+	useTestProjectMemory(t)
+	tool := MemoryTool{}
+	ctx := context.Background()
+
+	out, err := tool.Call(ctx, `{"action": "set", "key": "test-command", "value": "just test"}`)
+	require.NoError(t, err)
+	assert.Contains(t, out, "test-command")
+
+	out, err = tool.Call(ctx, `{"action": "get", "key": "test-command"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "just test", out)
+
+	// Setting an existing key replaces the value
+	_, err = tool.Call(ctx, `{"action": "set", "key": "test-command", "value": "go test ./..."}`)
+	require.NoError(t, err)
+	_, err = tool.Call(ctx, `{"action": "set", "key": "style", "value": "tabs"}`)
+	require.NoError(t, err)
+
+	out, err = tool.Call(ctx, `{"action": "list"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "style: tabs\ntest-command: go test ./...\n", out)
+
+	out, err = tool.Call(ctx, `{"action": "delete", "key": "style"}`)
+	require.NoError(t, err)
+	assert.Contains(t, out, "Deleted")
+
+	_, err = tool.Call(ctx, `{"action": "get", "key": "style"}`)
+	assert.ErrorContains(t, err, "no memory stored")
+
+	_, err = tool.Call(ctx, `{"action": "forget", "key": "style"}`)
+	assert.ErrorContains(t, err, "unknown action")
+}
+
+func TestMemoryToolWithoutStorage(t *testing.T) {
+	orig := currentProjectMemory
+	currentProjectMemory = nil
+	defer func() { currentProjectMemory = orig }()
+
+	_, err := MemoryTool{}.Call(context.Background(), `{"action": "list"}`)
+	assert.ErrorContains(t, err, "not available")
+}
+
+func TestWebFetchTool(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><title>Widgets</title><script>track()</script></head><body>
+<nav><a href="/">Home</a></nav>
+<main><h2>Install</h2><p>Run   <code>go get widgets</code> then see the <a href="/api">API</a>.</p>
+<ul><li>fast</li><li>small</li></ul><pre>w := widgets.New()
+w.Run()</pre></main>
+<footer>Copyright</footer></body></html>`)
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, strings.Repeat("a", 100))
+	})
+	mux.HandleFunc("/logo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:1/docs", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tool := WebFetchTool{config: &Config{}}
+	fetch := func(url string) (string, error) {
+		return tool.Call(context.Background(), fmt.Sprintf(`{"url":%q}`, url))
+	}
+
+	// Local addresses are refused when connecting, whatever the host name
+	_, err := fetch(server.URL + "/docs")
+	assert.ErrorContains(t, err, "access denied: 127.0.0.1 is a local address")
+	_, err = fetch(strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/docs")
+	assert.ErrorContains(t, err, "is a local address")
+	for _, address := range []string{"10.0.0.1:80", "192.168.1.1:443", "169.254.169.254:80", "[::1]:80", "[fe80::1]:80", "[::ffff:127.0.0.1]:80", "100.64.0.1:80"} {
+		assert.Error(t, refuseLocalAddress("tcp", address, nil), address)
+	}
+	assert.NoError(t, refuseLocalAddress("tcp", "93.184.215.14:443", nil))
+	assert.NoError(t, refuseLocalAddress("tcp6", "[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", nil))
+
+	// The test server is local, so the rest of the test fetches without the check
+	guarded := webFetchClient
+	webFetchClient = server.Client()
+	t.Cleanup(func() { webFetchClient = guarded })
+
+	result, err := fetch(server.URL + "/docs")
+	require.NoError(t, err)
+	assert.Contains(t, result, "# Widgets\n\n## Install\n\nRun `go get widgets` then see the [API]("+server.URL+"/api).")
+	assert.Contains(t, result, "- fast\n- small")
+	assert.Contains(t, result, "```\nw := widgets.New()\nw.Run()\n```")
+	assert.NotContains(t, result, "track()")
+	assert.NotContains(t, result, "Home")
+	assert.NotContains(t, result, "Copyright")
+
+	tool.config.Tools.WebFetchMaxBytes = 10
+	result, err = fetch(server.URL + "/notes.txt")
+	require.NoError(t, err)
+	assert.Contains(t, result, strings.Repeat("a", 10)+"\n")
+	assert.NotContains(t, result, strings.Repeat("a", 11))
+	assert.Contains(t, result, "[Truncated")
+
+	_, err = fetch(server.URL + "/logo.png")
+	assert.ErrorContains(t, err, "image/png")
+	_, err = fetch(server.URL + "/missing")
+	assert.ErrorContains(t, err, "404")
+	_, err = fetch("file:///etc/passwd")
+	assert.ErrorContains(t, err, "invalid URL")
+
+	// Only the allowlisted hosts, redirects included
+	tool.config.Tools.WebFetchAllowlist = []string{"*.example.com", "127.0.0.1"}
+	_, err = fetch(server.URL + "/notes.txt")
+	require.NoError(t, err)
+	_, err = fetch(server.URL + "/away")
+	assert.ErrorContains(t, err, "localhost is not in tools.web_fetch_allowlist")
+	_, err = fetch("https://example.org/")
+	assert.ErrorContains(t, err, "access denied")
+	assert.NoError(t, tool.checkHost(&url.URL{Host: "docs.example.com"}))
+}
@@ -3,17 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/afittestide/asimi/storage"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/tmc/langchaingo/llms"
+	"github.com/charmbracelet/x/ansi"
 )
 
 const (
@@ -36,19 +41,27 @@ type TUIModel struct {
 	modal          *BaseModal
 	providerModal  *ProviderSelectionModal
 	codeInputModal *CodeInputModal
+	endpointModal  *EndpointModal
 
 	// UI Flags & State
 	Mode                 string // Current UI mode for status display
 	showCompletionDialog bool
 	completionMode       string // "file" or "command"
 	sessionActive        bool
-	rawMode              bool // Toggle between chat and raw session view
-	updateAvailable      bool // True when a newer version is available
-	configCreated        bool // True when config file was created on first run
-
+	rawMode              bool     // Toggle between chat and raw session view
+	rawXOffset           int      // horizontal scroll of the raw session view in the scroll wrap mode
+	rawFilter            []string // raw entry kinds shown, e.g. TOOL for all TOOL_* entries; empty shows all
+	logView              bool     // :log-view shows the tool calls and turns of the raw history, one line each
+	updateAvailable      bool     // True when a newer version is available
+	configCreated        bool     // True when config file was created on first run
+
+	streaming              bool // ui.streaming, prompts stream the reply; off they show it whole once complete
 	streamingActive        bool
 	streamingCancel        context.CancelFunc
 	streamCompleteCallback func(*TUIModel) tea.Cmd // Optional callback to run after stream completes
+	compactCancel          context.CancelFunc      // Set while :compact runs, Esc cancels it
+	compactMsgIndex        int                     // Chat message showing :compact progress
+	scrollPendingKey       string                  // First key of a two-key scroll mode binding, e.g. ] in ]t
 
 	// Command registry
 	commandRegistry CommandRegistry
@@ -66,6 +79,7 @@ type TUIModel struct {
 	historyPendingPrompt          string
 	historyPresentSessionSnapshot int
 	historyPresentChatSnapshot    int
+	historyStopping               bool // a turn is being stopped to resubmit a historical prompt
 
 	// Persistent history stores (survive app restarts)
 	persistentPromptHistory  *PromptHistory
@@ -75,9 +89,27 @@ type TUIModel struct {
 	waitingForResponse bool
 	waitingStart       time.Time
 	ctrlCPressedTime   time.Time
+	lastInputTime      time.Time // Last key press, used to skip notifications while the user is active
 
 	// Host command approval state
 	pendingHostApproval *HostCommandApprovalRequest
+
+	// Tool call waiting for approval under an ask permission
+	pendingToolApproval *ToolApprovalRequest
+
+	// Shell command waiting for confirmation (tools.confirm_shell)
+	pendingShellCommand string
+	// Regenerated agents file waiting for the user to accept it
+	pendingAgentsRewrite *agentsRegeneratedMsg
+	pendingReplace       *replacePlan    // :replace waiting for confirmation
+	pendingCompact       bool            // :compact waiting for confirmation, with llm.confirm_compact
+	pendingCompactDiff   *CompactPreview // :compact --diff waiting for the summary to be accepted
+	pendingBranchResume  *branchResume   // session of another branch, waiting for checkout or import
+	pendingUpdate        bool            // new version waiting for the update to be confirmed
+	queuedDialogs        []tea.Msg       // messages opening a yes/no dialog while another is open
+
+	// Most recent `!` command result, used by :attach-last
+	lastShellResult *shellCommandResultMsg
 }
 
 type promptHistoryEntry struct {
@@ -88,6 +120,18 @@ type promptHistoryEntry struct {
 
 type waitingTickMsg struct{}
 
+// historyStopMsg reports whether the turn running when a historical prompt was submitted stopped
+type historyStopMsg struct {
+	prompt  string
+	stopped bool
+}
+
+// toolOutputTickMsg renders the live tool output coalesced since the last frame
+type toolOutputTickMsg struct{}
+
+// toolOutputFrame is how often live tool output is rendered at most
+const toolOutputFrame = 50 * time.Millisecond
+
 type shellCommandResultMsg struct {
 	command  string
 	output   string
@@ -100,6 +144,42 @@ type hostCommandApprovalMsg struct {
 	request HostCommandApprovalRequest
 }
 
+// toolApprovalMsg is sent when a tool call needs user approval under an ask permission
+type toolApprovalMsg struct {
+	request ToolApprovalRequest
+}
+
+// applyWrapMode sets how the chat and raw views handle long lines from ui.wrap_mode
+func (m *TUIModel) applyWrapMode() {
+	if m.config == nil {
+		return
+	}
+	switch m.config.UI.WrapMode {
+	case "", WrapModeWrap:
+	case WrapModeScroll:
+		m.content.Chat.WrapMode = WrapModeScroll
+		m.content.Chat.UpdateContent()
+	default:
+		slog.Warn("unknown ui.wrap_mode, wrapping long lines", "wrap_mode", m.config.UI.WrapMode)
+	}
+}
+
+// applyStartMode puts the prompt in the vi mode set by ui.start_mode
+func (m *TUIModel) applyStartMode() {
+	if m.config == nil {
+		return
+	}
+	switch m.config.UI.StartMode {
+	case "", ViModeInsert:
+	case ViModeNormal:
+		m.Mode = ViModeNormal
+		m.prompt.EnterViNormalMode()
+		m.status.SetMode(ViModeNormal)
+	default:
+		slog.Warn("unknown ui.start_mode, starting in insert mode", "start_mode", m.config.UI.StartMode)
+	}
+}
+
 // NewTUIModel creates a new TUI model
 // NewTUIModelWithStores creates a new TUI model with provided stores (for fx injection)
 func NewTUIModel(config *Config, repoInfo *RepoInfo, promptHistory *PromptHistory, commandHistory *CommandHistory, sessionStore *SessionStore, db *storage.DB) *TUIModel {
@@ -112,14 +192,22 @@ func NewTUIModel(config *Config, repoInfo *RepoInfo, promptHistory *PromptHistor
 	// Create status component and set repo info
 	status := NewStatusComponent(80)
 	status.SetRepoInfo(repoInfo)
+	if config != nil {
+		status.SetAutoSavePaused(config.Session.Enabled && !config.Session.AutoSave)
+		status.SetContextGauge(config.UI.ContextGauge)
+	}
 
 	// Initialize shell runner info for status display
 	shellInfo := getShellRunnerInfo()
 	status.SetShellRunnerInfo(&shellInfo)
 
 	markdownEnabled := false
+	foldLinesOver := 0
+	streaming := false
 	if config != nil {
 		markdownEnabled = config.UI.MarkdownEnabled
+		foldLinesOver = config.UI.FoldLinesOver
+		streaming = config.UI.Streaming
 	}
 
 	model := &TUIModel{
@@ -144,6 +232,7 @@ func NewTUIModel(config *Config, repoInfo *RepoInfo, promptHistory *PromptHistor
 		completionMode:       "",
 		sessionActive:        false,
 		rawMode:              false,
+		streaming:            streaming,
 		configCreated:        ConfigCreated, // Set from global flag
 
 		// Command registry
@@ -160,6 +249,9 @@ func NewTUIModel(config *Config, repoInfo *RepoInfo, promptHistory *PromptHistor
 
 	// Set the GetStatus callback for the chat component
 	model.content.Chat.GetStatus = func() string { return model.Mode }
+	model.content.Chat.FoldLinesOver = foldLinesOver
+	model.applyWrapMode()
+	model.applyStartMode()
 
 	// Set initial status info - show disconnected state initially
 	model.status.SetProvider(config.LLM.Provider, config.LLM.Model, false)
@@ -214,6 +306,7 @@ func (m *TUIModel) initHistory() {
 func (m *TUIModel) SetSession(session *Session) {
 	m.session = session
 	m.status.SetSession(session) // Pass session to status component
+	m.status.SetProfile(m.config.Profile)
 	if session != nil {
 		m.status.SetProvider(m.config.LLM.Provider, m.config.LLM.Model, true)
 	} else {
@@ -221,6 +314,78 @@ func (m *TUIModel) SetSession(session *Session) {
 	}
 }
 
+// maxQueuedMessages caps the messages a streamCoalescer holds for a UI that is behind
+const maxQueuedMessages = 1000
+
+// streamCoalescer sits between a session's notifications and program.Send, which blocks
+// while Update is busy. Chunks arriving while the UI is behind are merged into the one
+// still waiting, other messages are sent after them so the order is kept. Once the queue
+// is full, lines of live tool output are dropped: the chat only shows the last few and the
+// tool's result holds them all.
+type streamCoalescer struct {
+	send     func(any)
+	mu       sync.Mutex
+	queue    []any
+	flushing bool
+}
+
+func newStreamCoalescer(send func(any)) *streamCoalescer {
+	return &streamCoalescer{send: send}
+}
+
+// Notify queues msg for the UI, it never blocks the streaming goroutine
+func (c *streamCoalescer) Notify(msg any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) > 0 {
+		switch chunk := msg.(type) {
+		case streamChunkMsg:
+			if last, ok := c.queue[len(c.queue)-1].(streamChunkMsg); ok {
+				c.queue[len(c.queue)-1] = last + chunk
+				return
+			}
+		case streamReasoningChunkMsg:
+			if last, ok := c.queue[len(c.queue)-1].(streamReasoningChunkMsg); ok {
+				c.queue[len(c.queue)-1] = last + chunk
+				return
+			}
+		case ToolCallOutputChunkMsg:
+			if len(c.queue) >= maxQueuedMessages {
+				return
+			}
+		}
+	}
+	c.queue = append(c.queue, msg)
+	if !c.flushing {
+		c.flushing = true
+		go c.flush()
+	}
+}
+
+// flush sends the queued messages in order until the queue is empty
+func (c *streamCoalescer) flush() {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.flushing = false
+			c.mu.Unlock()
+			return
+		}
+		msg := c.queue[0]
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+		c.send(msg)
+	}
+}
+
+// programNotify delivers the notifications of the sessions and the approval requests to the
+// program through one queue, so an approval never overtakes the tool calls before it
+var programNotify = newStreamCoalescer(func(msg any) {
+	if program != nil {
+		program.Send(msg)
+	}
+})
+
 // reinitializeSession recreates the LLM client and session with current config
 func (m *TUIModel) reinitializeSession() error {
 	// Get the LLM client with the updated config
@@ -231,11 +396,7 @@ func (m *TUIModel) reinitializeSession() error {
 
 	// Create a new session with the LLM
 	repoInfo := GetRepoInfo()
-	sess, err := NewSession(llm, m.config, repoInfo, func(msg any) {
-		if program != nil {
-			program.Send(msg)
-		}
-	})
+	sess, err := NewSession(llm, m.config, repoInfo, programNotify.Notify)
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -258,6 +419,86 @@ func (m *TUIModel) saveSession() {
 	slog.Debug("session auto-save queued")
 }
 
+// autoCompactIfNeeded compacts the conversation synchronously when free tokens drop
+// below 10% of the context window. With ui.quiet_auto_compact the chat is left
+// untouched and only the status bar shows that compaction happened.
+func (m *TUIModel) autoCompactIfNeeded() {
+	info := m.session.GetContextInfo()
+	autoCompactThreshold := float64(info.TotalTokens) * 0.10
+	if float64(info.FreeTokens) >= autoCompactThreshold || len(m.session.Messages) <= 2 {
+		return
+	}
+	quiet := m.config != nil && m.config.UI.QuietAutoCompact
+
+	slog.Info("auto-compacting conversation", "free_tokens", info.FreeTokens, "threshold", autoCompactThreshold, "quiet", quiet)
+	if !quiet {
+		m.content.Chat.AddMessage("🗜️  Auto-compacting conversation history (low on context)...")
+	}
+
+	// not using summary as this is an automatic workflow and
+	// there's no reason to notfiy the user
+	_, err := m.session.CompactHistory(context.Background(), compactPrompt)
+	if err != nil {
+		slog.Warn("auto-compaction failed", "error", err)
+		if quiet {
+			m.commandLine.AddToast("Auto-compaction failed", "error", time.Second*3)
+		} else {
+			m.content.Chat.AddMessage(fmt.Sprintf("⚠️  Auto-compaction failed: %v", err))
+		}
+		return
+	}
+
+	newInfo := m.session.GetContextInfo()
+	slog.Info("auto-compaction completed", "old_used", info.UsedTokens, "new_used", newInfo.UsedTokens, "saved", info.UsedTokens-newInfo.UsedTokens)
+	if quiet {
+		m.status.SetCompacted(time.Now())
+		return
+	}
+	m.content.Chat.AddMessage(fmt.Sprintf("✅ Conversation compacted! Context usage: %s/%s tokens (%.1f%%)",
+		formatTokenCount(newInfo.UsedTokens),
+		formatTokenCount(newInfo.TotalTokens),
+		percentage(newInfo.UsedTokens, newInfo.TotalTokens)))
+}
+
+// tuiCrash holds the value of a panic recovered in Update or View, reported once the program exits
+var tuiCrash any
+
+// tuiCrashSaved and tuiCrashSaveErr record whether recoverFromPanic managed to save the session
+var (
+	tuiCrashSaved   bool
+	tuiCrashSaveErr error
+)
+
+// crashError describes a recovered panic for the terminal once the program exits, saying
+// whether the session was saved and where the stack trace is
+func crashError(logPath string) error {
+	switch {
+	case tuiCrashSaveErr != nil:
+		return fmt.Errorf("asimi crashed: %v. Saving the session failed: %v. The stack trace was logged to %s", tuiCrash, tuiCrashSaveErr, logPath)
+	case tuiCrashSaved:
+		return fmt.Errorf("asimi crashed: %v. The session was saved and the stack trace logged to %s", tuiCrash, logPath)
+	default:
+		return fmt.Errorf("asimi crashed: %v. The stack trace was logged to %s", tuiCrash, logPath)
+	}
+}
+
+// recoverFromPanic saves the session and logs the stack after a panic in Update or View,
+// so the program can quit cleanly and restore the terminal
+func (m *TUIModel) recoverFromPanic(r any) {
+	tuiCrash = r
+	slog.Error("recovered from panic in TUI", "panic", r, "stack", string(debug.Stack()))
+
+	if m.session != nil && m.sessionStore != nil {
+		if err := m.sessionStore.SaveSessionSync(m.session); err != nil {
+			tuiCrashSaveErr = err
+			slog.Error("failed to save session after panic", "error", err)
+		} else {
+			tuiCrashSaved = true
+			slog.Info("session saved after panic", "session_id", m.session.ID)
+		}
+	}
+}
+
 // shutdown performs graceful shutdown of the TUI, ensuring all pending saves complete
 func (m *TUIModel) shutdown() {
 	// Save the current session before closing
@@ -274,12 +515,20 @@ func (m TUIModel) Init() tea.Cmd {
 	approvalChan := make(chan HostCommandApprovalRequest, 1)
 	SetHostCommandApprovalChannel(approvalChan)
 
-	// Start a goroutine to listen for approval requests and forward them to the TUI
+	// Start a goroutine to listen for approval requests and forward them to the TUI,
+	// after the session notifications sent before them
 	go func() {
 		for request := range approvalChan {
-			if program != nil {
-				program.Send(hostCommandApprovalMsg{request: request})
-			}
+			programNotify.Notify(hostCommandApprovalMsg{request: request})
+		}
+	}()
+
+	// Same for the tool calls of tools with the ask permission
+	toolApprovalChan := make(chan ToolApprovalRequest, 1)
+	SetToolApprovalChannel(toolApprovalChan)
+	go func() {
+		for request := range toolApprovalChan {
+			programNotify.Notify(toolApprovalMsg{request: request})
 		}
 	}()
 
@@ -288,9 +537,16 @@ func (m TUIModel) Init() tea.Cmd {
 }
 
 // Update implements bubbletea.Model
-func (m TUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+func (m TUIModel) Update(msg tea.Msg) (model tea.Model, cmd tea.Cmd) {
 	start := time.Now()
 
+	defer func() {
+		if r := recover(); r != nil {
+			m.recoverFromPanic(r)
+			model, cmd = m, tea.Quit
+		}
+	}()
+
 	// Log all messages in debug mode
 
 	defer func() {
@@ -305,6 +561,7 @@ func (m TUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		m.lastInputTime = time.Now()
 		return m.handleKeyMsg(msg)
 
 	case tea.MouseMsg:
@@ -320,6 +577,12 @@ func (m TUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 }
 
+// redraw clears the screen and renders everything again, restoring the prompt cursor,
+// for when stray escape sequences garble the display
+func (m *TUIModel) redraw() tea.Cmd {
+	return tea.Batch(tea.ClearScreen, m.prompt.cursorCmd())
+}
+
 // handleKeyMsg processes keyboard input filtering out escape sequences
 func (m TUIModel) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	// TODO: This is till not good enough. Not sure how sends them and why
@@ -390,6 +653,10 @@ func (m TUIModel) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m.handleCtrlZ()
 	}
 
+	if keyStr == "ctrl+l" {
+		return m, m.redraw()
+	}
+
 	// Handle command line input when in command mode or yes/no mode - MUST be before other handlers
 	if m.commandLine.IsInCommandMode() || m.commandLine.IsInYesNoMode() {
 		cmd, handled := m.commandLine.HandleKey(msg)
@@ -422,6 +689,10 @@ func (m TUIModel) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.codeInputModal, cmd = m.codeInputModal.Update(msg)
 		return m, cmd
 	}
+	if m.endpointModal != nil {
+		m.endpointModal, cmd = m.endpointModal.Update(msg)
+		return m, cmd
+	}
 	if m.providerModal != nil {
 		m.providerModal, cmd = m.providerModal.Update(msg)
 		return m, cmd
@@ -603,7 +874,19 @@ func (m TUIModel) handleScrollModeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool)
 	}
 	chat := m.content.Chat
 
-	switch msg.String() {
+	key := msg.String()
+	if pending := m.scrollPendingKey; pending != "" {
+		m.scrollPendingKey = ""
+		if key == "t" {
+			m.jumpToToolCall(pending == "]")
+			return m, nil, true
+		}
+	}
+
+	switch key {
+	case "]", "[":
+		m.scrollPendingKey = key
+		return m, nil, true
 	case "ctrl+f":
 		chat.ScrollPageDown()
 		return m, nil, true
@@ -625,6 +908,13 @@ func (m TUIModel) handleScrollModeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool)
 	case "k", "up":
 		chat.ScrollUpOneLine()
 		return m, nil, true
+	case "z", "enter":
+		chat.ToggleFold()
+		return m, nil, true
+	case "h", "left":
+		return m, nil, m.scrollHorizontally(-horizontalScrollStep)
+	case "l", "right":
+		return m, nil, m.scrollHorizontally(horizontalScrollStep)
 	case ":":
 		// Exit scroll mode before entering command mode
 		// The command mode will be set by handleColonKey
@@ -638,6 +928,45 @@ func (m TUIModel) handleScrollModeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool)
 	return m, nil, false
 }
 
+// scrollHorizontally moves the chat or raw view sideways by cols columns.
+// It reports false when long lines are wrapped and there's nothing to scroll.
+func (m *TUIModel) scrollHorizontally(cols int) bool {
+	chat := m.content.Chat
+	if chat.WrapMode != WrapModeScroll {
+		return false
+	}
+	if !m.rawMode {
+		if cols < 0 {
+			chat.ScrollLeft(-cols)
+		} else {
+			chat.ScrollRight(cols)
+		}
+		return true
+	}
+
+	longest := 0
+	for _, entry := range chat.GetRawHistory(m.rawFilterKeep()) {
+		for _, line := range strings.Split(entry, "\n") {
+			longest = max(longest, ansi.StringWidth(line))
+		}
+	}
+	m.rawXOffset = max(0, min(m.rawXOffset+cols, longest-(m.width-4)))
+	return true
+}
+
+// jumpToToolCall moves the chat to the next or previous tool call for ]t and [t
+func (m *TUIModel) jumpToToolCall(forward bool) {
+	_, wrapped, ok := m.content.Chat.JumpToToolCall(forward)
+	switch {
+	case !ok:
+		m.commandLine.AddToast("No tool calls in this conversation", "info", time.Second*2)
+	case wrapped && forward:
+		m.commandLine.AddToast("Wrapped around to the first tool call", "info", time.Second*2)
+	case wrapped:
+		m.commandLine.AddToast("Wrapped around to the last tool call", "info", time.Second*2)
+	}
+}
+
 // handleViNormalMode handles key presses when in vi normal or visual mode
 func (m TUIModel) handleViNormalMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	key := msg.String()
@@ -760,7 +1089,7 @@ func (m TUIModel) handleViCommandLineMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.content.Chat.AddToRawHistory("COMMAND", content)
 				cmd, exists := m.commandRegistry.GetCommand(cmdName)
 				if exists {
-					command := cmd.Handler(&m, parts[1:])
+					command := cmd.Handler(&m, cmd.Args(content))
 					m.prompt.SetValue("")
 					m.prompt.EnterViInsertMode() // Return to insert mode after command
 					// Hide completion dialog
@@ -769,7 +1098,7 @@ func (m TUIModel) handleViCommandLineMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 					m.completionMode = ""
 					return m, command
 				}
-				m.commandLine.AddToast(fmt.Sprintf("Unknown command: %s", cmdName), "error", time.Second*3)
+				m.commandLine.AddToast(m.commandRegistry.UnknownCommandMessage(cmdName), "error", time.Second*3)
 				m.prompt.SetValue("")
 				m.prompt.EnterViInsertMode()
 				// Hide completion dialog
@@ -811,6 +1140,12 @@ func (m TUIModel) handleCtrlZ() (tea.Model, tea.Cmd) {
 
 // handleEscape handles the escape key and the first ctrl-c
 func (m TUIModel) handleEscape() (tea.Model, tea.Cmd) {
+	if m.compactCancel != nil {
+		slog.Info("escape_during_compaction", "cancelling_context", true)
+		m.compactCancel()
+		m.compactCancel = nil
+		return m, nil
+	}
 	if m.streamingActive && m.streamingCancel != nil {
 		slog.Info("escape_during_streaming", "cancelling_context", true)
 		m.streamingCancel()
@@ -862,12 +1197,15 @@ func (m TUIModel) handleCompletionSelection() (tea.Model, tea.Cmd) {
 	if selected != "" {
 		if m.completionMode == "file" {
 			filePath := selected
-			content, err := os.ReadFile(filePath)
+			content, _, err := readTextFile(filePath)
 			if err != nil {
 				m.commandLine.AddToast(fmt.Sprintf("Error reading file: %v", err), "error", time.Second*3)
 			} else if m.session != nil {
-				m.session.AddContextFile(filePath, string(content))
-				m.content.Chat.AddMessage(fmt.Sprintf("Loaded file: %s", filePath))
+				if err := m.session.AddContextFile(filePath, content); err != nil {
+					m.commandLine.AddToast(fmt.Sprintf("Not loaded: %v", err), "error", time.Second*4)
+				} else {
+					m.content.Chat.AddMessage(fmt.Sprintf("Loaded file: %s", filePath))
+				}
 			}
 			currentValue := m.prompt.Value()
 			lastAt := strings.LastIndex(currentValue, "@")
@@ -930,6 +1268,89 @@ func (m *TUIModel) stopWaitingForResponse() {
 	m.status.StopWaiting()
 }
 
+// promptWhileBusy rejects a prompt submitted while the previous one is still being answered,
+// as both turns would change the session's messages. The prompt is kept for a later submit.
+func (m *TUIModel) promptWhileBusy() bool {
+	if m.session == nil || !m.session.Busy() {
+		return false
+	}
+	m.commandLine.AddToast("Still answering the previous prompt, press Esc to stop it first", "warning", time.Second*3)
+	return true
+}
+
+// askSession sends a user prompt to the session, streaming the reply unless ui.streaming is off
+func (m *TUIModel) askSession(ctx context.Context, prompt string) {
+	if m.streaming {
+		m.session.AskStream(ctx, prompt)
+	} else {
+		m.session.AskWhole(ctx, prompt)
+	}
+}
+
+// Actions of ui.empty_enter_action, what Enter on an empty prompt does
+const (
+	EmptyEnterNoop     = "noop"
+	EmptyEnterRepeat   = "repeat"
+	EmptyEnterContinue = "continue"
+)
+
+// continuePrompt is the human turn of :continue and of Enter on an empty prompt with
+// ui.empty_enter_action = "continue"
+const continuePrompt = "Continue."
+
+// emptyEnterPrompt is the prompt Enter on an empty prompt submits with ui.empty_enter_action,
+// none before the first prompt of the session
+func (m *TUIModel) emptyEnterPrompt() string {
+	if m.config == nil || len(m.sessionPromptHistory) == 0 {
+		return ""
+	}
+	switch m.config.UI.EmptyEnterAction {
+	case "", EmptyEnterNoop:
+	case EmptyEnterRepeat:
+		return m.sessionPromptHistory[len(m.sessionPromptHistory)-1].Prompt
+	case EmptyEnterContinue:
+		return continuePrompt
+	default:
+		slog.Warn("unknown ui.empty_enter_action, Enter on an empty prompt does nothing", "empty_enter_action", m.config.UI.EmptyEnterAction)
+	}
+	return ""
+}
+
+// rollbackStopTimeout bounds the wait for a running turn to stop before resubmitting a historical prompt
+const rollbackStopTimeout = 5 * time.Second
+
+// submittingHistory reports whether the prompt is one the user navigated back to in the history
+func (m *TUIModel) submittingHistory() bool {
+	return m.historySaved && m.historyCursor < len(m.sessionPromptHistory)
+}
+
+// rollbackToHistory rolls the session and chat back to the historical prompt being resubmitted.
+// A turn still running must stop first, so it can't write to the history after the rollback.
+// Waiting for it would freeze the UI, so rollbackToHistory then reports false, leaving everything
+// as it was, and returns a command that stops the turn. Its historyStopMsg submits prompt again.
+func (m *TUIModel) rollbackToHistory(prompt string) (bool, tea.Cmd) {
+	entry := m.sessionPromptHistory[m.historyCursor]
+	if m.session != nil && m.session.Busy() {
+		if m.historyStopping {
+			m.commandLine.AddToast("The previous answer is still stopping, try again", "warning", time.Second*3)
+			return false, nil
+		}
+		m.historyStopping = true
+		session, cancel := m.session, m.streamingCancel
+		return false, func() tea.Msg {
+			return historyStopMsg{prompt: prompt, stopped: session.StopTurn(cancel, rollbackStopTimeout)}
+		}
+	}
+	m.cancelStreaming()
+	m.stopStreaming()
+	if m.session != nil {
+		m.session.RollbackTo(entry.SessionSnapshot)
+	}
+	m.content.Chat.TruncateTo(entry.ChatSnapshot)
+	m.content.Chat.ClearToolCallMessageIndex()
+	return true, nil
+}
+
 func (m *TUIModel) cancelStreaming() {
 	if m.streamingActive && m.streamingCancel != nil {
 		m.streamingCancel()
@@ -1018,32 +1439,22 @@ func (m TUIModel) handleEnterKey() (tea.Model, tea.Cmd) {
 
 	content := m.prompt.Value()
 	if content == "" {
-		return m, nil
+		if content = m.emptyEnterPrompt(); content == "" {
+			return m, nil
+		}
 	}
 
-	// Handle learning mode - append to agents file
+	// Handle learning mode - save to session.learning_target
 	if m.Mode == "learning" {
 		// Remove the leading "#" and trim whitespace
 		learningNote := strings.TrimSpace(strings.TrimPrefix(content, "#"))
 		if learningNote != "" {
-			// Determine agents file from config
-			agentsPath := "AGENTS.md"
-			if m.config != nil && m.config.Session.AgentsFile != "" {
-				agentsPath = m.config.Session.AgentsFile
-			}
-			f, err := os.OpenFile(agentsPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-			if err != nil {
-				m.commandLine.AddToast(fmt.Sprintf("Failed to open %s: %v", agentsPath, err), "error", time.Second*3)
+			if target, err := saveLearningNote(m.config, learningNote); err != nil {
+				m.commandLine.AddToast(fmt.Sprintf("Failed to save to %s: %v", learningTarget(m.config), err), "error", time.Second*3)
 			} else {
-				defer f.Close()
-				_, err = f.WriteString("\n" + learningNote + "\n")
-				if err != nil {
-					m.commandLine.AddToast(fmt.Sprintf("Failed to write to %s: %v", agentsPath, err), "error", time.Second*3)
-				} else {
-					m.commandLine.AddToast(fmt.Sprintf("Added to %s", agentsPath), "success", time.Second*2)
-					m.content.Chat.AddMessage(fmt.Sprintf("📝 Learning added: %s", learningNote))
-					m.sessionActive = true
-				}
+				m.commandLine.AddToast(fmt.Sprintf("Added to %s", target), "success", time.Second*2)
+				m.content.Chat.AddMessage(fmt.Sprintf("📝 Learning added: %s", learningNote))
+				m.sessionActive = true
 			}
 		}
 		// Return to normal mode
@@ -1060,7 +1471,7 @@ func (m TUIModel) handleEnterKey() (tea.Model, tea.Cmd) {
 			m.content.Chat.AddToRawHistory("COMMAND", content)
 			cmd, exists := m.commandRegistry.GetCommand(cmdName)
 			if exists {
-				command := cmd.Handler(&m, parts[1:])
+				command := cmd.Handler(&m, cmd.Args(content))
 				cmds = append(cmds, command)
 				m.prompt.SetValue("")
 				m.prompt.EnterViInsertMode()
@@ -1068,26 +1479,25 @@ func (m TUIModel) handleEnterKey() (tea.Model, tea.Cmd) {
 				// Ensure prompt has focus after command
 				m.prompt.Focus()
 			} else {
-				m.commandLine.AddToast(fmt.Sprintf("Unknown command: %s", cmdName), "error", time.Second*3)
+				m.commandLine.AddToast(m.commandRegistry.UnknownCommandMessage(cmdName), "error", time.Second*3)
 			}
 		}
+	} else if !m.submittingHistory() && m.promptWhileBusy() {
+		return m, nil
 	} else {
 		// Clear any lingering toast notifications before handling a new prompt
 		m.commandLine.ClearToasts()
 		refreshGitInfo()
 
 		// Check if we're submitting a historical prompt (user navigated history)
-		if m.historySaved && m.historyCursor < len(m.sessionPromptHistory) {
+		if m.submittingHistory() {
 			// User is submitting a historical prompt - rollback to that state
-			entry := m.sessionPromptHistory[m.historyCursor]
-			m.cancelStreaming()
-			m.stopStreaming()
-			if m.session != nil {
-				m.session.RollbackTo(entry.SessionSnapshot)
+			if rolledBack, stopCmd := m.rollbackToHistory(content); !rolledBack {
+				if stopCmd != nil {
+					m.prompt.SetValue("")
+				}
+				return m, stopCmd
 			}
-			m.content.Chat.TruncateTo(entry.ChatSnapshot)
-			m.content.Chat.ClearToolCallMessageIndex()
-
 			// Now continue with the normal flow from this rolled-back state
 			m.historySaved = false
 		}
@@ -1105,31 +1515,7 @@ func (m TUIModel) handleEnterKey() (tea.Model, tea.Cmd) {
 		m.content.Chat.AddMessage(fmt.Sprintf("You: %s", content))
 		if m.session != nil {
 			// Check if we need to auto-compact before sending the prompt (#54)
-			info := m.session.GetContextInfo()
-			// Auto-compact if free tokens are less than 10% of total
-			autoCompactThreshold := float64(info.TotalTokens) * 0.10
-			if float64(info.FreeTokens) < autoCompactThreshold && len(m.session.Messages) > 2 {
-				slog.Info("auto-compacting conversation", "free_tokens", info.FreeTokens, "threshold", autoCompactThreshold)
-				m.content.Chat.AddMessage("🗜️  Auto-compacting conversation history (low on context)...")
-
-				// Perform compaction synchronously before sending the prompt
-				ctx := context.Background()
-				// not using summary as this is an automatic workflow and
-				// there's no reason to notfiy the user
-				_, err := m.session.CompactHistory(ctx, compactPrompt)
-				if err != nil {
-					slog.Warn("auto-compaction failed", "error", err)
-					m.content.Chat.AddMessage(fmt.Sprintf("⚠️  Auto-compaction failed: %v", err))
-				} else {
-					// Get updated context info
-					newInfo := m.session.GetContextInfo()
-					m.content.Chat.AddMessage(fmt.Sprintf("✅ Conversation compacted! Context usage: %s/%s tokens (%.1f%%)",
-						formatTokenCount(newInfo.UsedTokens),
-						formatTokenCount(newInfo.TotalTokens),
-						percentage(newInfo.UsedTokens, newInfo.TotalTokens)))
-					slog.Info("auto-compaction completed", "old_used", info.UsedTokens, "new_used", newInfo.UsedTokens, "saved", info.UsedTokens-newInfo.UsedTokens)
-				}
-			}
+			m.autoCompactIfNeeded()
 
 			m.sessionActive = true
 			m.prompt.SetValue("")
@@ -1139,7 +1525,8 @@ func (m TUIModel) handleEnterKey() (tea.Model, tea.Cmd) {
 			}
 			ctx, cancel := context.WithCancel(context.Background())
 			m.streamingCancel = cancel
-			m.session.AskStream(ctx, content)
+			m.session.SetFirstPrompt(content)
+			m.askSession(ctx, content)
 		} else {
 			m.commandLine.AddToast("No model configured, use :models to configure a model", "error", time.Second*5)
 			m.prompt.SetValue("")
@@ -1211,6 +1598,248 @@ func (m TUIModel) handleAtKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// confirmShellCommand asks the user to approve a shell command before running it
+func (m TUIModel) confirmShellCommand(command string) (tea.Model, tea.Cmd) {
+	shellCmd := strings.TrimSpace(strings.TrimPrefix(command, "!"))
+	if shellCmd == "" {
+		return m.handleShellCommand(command)
+	}
+	m.pendingShellCommand = command
+	// Truncate command for display if too long
+	displayCmd := shellCmd
+	maxLen := 50
+	if len(displayCmd) > maxLen {
+		displayCmd = displayCmd[:maxLen] + "..."
+	}
+	return m, m.commandLine.EnterYesNoMode(fmt.Sprintf("Run `%s`?", displayCmd))
+}
+
+// askingYesNo reports whether a yes/no dialog waits for its answer. The pending state of a
+// dialog is cleared when its answer is handled, after the command line has left yes/no mode.
+func (m TUIModel) askingYesNo() bool {
+	return m.pendingHostApproval != nil || m.pendingToolApproval != nil || m.pendingShellCommand != "" ||
+		m.pendingAgentsRewrite != nil || m.pendingReplace != nil || m.pendingCompact ||
+		m.pendingCompactDiff != nil || m.pendingBranchResume != nil || m.pendingUpdate
+}
+
+// holdDialog queues msg, which opens a yes/no dialog, while another dialog waits for its answer
+// so that each answer goes to the dialog it was given to. It reports whether msg was queued.
+func (m *TUIModel) holdDialog(msg tea.Msg) bool {
+	if !m.askingYesNo() {
+		return false
+	}
+	m.queuedDialogs = append(m.queuedDialogs, msg)
+	return true
+}
+
+// answerYesNo hands the answer of the yes/no dialog to the request waiting for it
+func (m TUIModel) answerYesNo(msg yesNoResponseMsg) (tea.Model, tea.Cmd) {
+	// Check if this is a response to a host command approval request
+	if m.pendingHostApproval != nil {
+		// Send the response back to the waiting goroutine
+		m.pendingHostApproval.ResponseChan <- msg.answer
+		if msg.answer {
+			m.content.Chat.AddMessage(fmt.Sprintf("✓ Approved host command: %s", m.pendingHostApproval.Command))
+		} else {
+			m.content.Chat.AddMessage(fmt.Sprintf("✗ Denied host command: %s", m.pendingHostApproval.Command))
+		}
+		m.pendingHostApproval = nil
+		return m, nil
+	}
+
+	// Check if this is a response to a tool call approval request
+	if m.pendingToolApproval != nil {
+		request := *m.pendingToolApproval
+		m.pendingToolApproval = nil
+		m.prompt.Focus()
+		switch {
+		case msg.always:
+			request.ResponseChan <- ToolApprovedAlways
+			m.content.Chat.AddMessage(fmt.Sprintf("✓ Approved %s for the rest of the session", request.Tool))
+		case msg.answer:
+			request.ResponseChan <- ToolApprovedOnce
+			m.content.Chat.AddMessage(fmt.Sprintf("✓ Approved %s", request.Tool))
+		default:
+			request.ResponseChan <- ToolDenied
+			m.content.Chat.AddMessage(fmt.Sprintf("✗ Denied %s", request.Tool))
+		}
+		return m, nil
+	}
+
+	// Check if this is a response to the branch of a session picked from another branch
+	if m.pendingBranchResume != nil {
+		pending := *m.pendingBranchResume
+		m.pendingBranchResume = nil
+		m.prompt.Focus()
+		m.finishBranchResume(pending, msg.answer)
+		return m, nil
+	}
+
+	// Check if this is a response to the regenerated agents file preview
+	if m.pendingAgentsRewrite != nil {
+		rewrite := *m.pendingAgentsRewrite
+		m.pendingAgentsRewrite = nil
+		m.prompt.Focus()
+		if !msg.answer {
+			m.content.Chat.AddMessage(fmt.Sprintf("%sKept %s unchanged", systemPrefix, rewrite.path))
+			return m, nil
+		}
+		backup, err := writeRegeneratedAgentsFile(rewrite)
+		if err != nil {
+			slog.Error("failed to write regenerated agents file", "error", err)
+			m.content.Chat.AddMessage(fmt.Sprintf("%s❌ %v", systemPrefix, err))
+			return m, nil
+		}
+		m.content.Chat.AddMessage(fmt.Sprintf("%s✓ Wrote %s, previous version saved to %s", systemPrefix, rewrite.path, backup))
+		return m, nil
+	}
+
+	// Check if this is a response to the :compact confirmation
+	if m.pendingCompact {
+		m.pendingCompact = false
+		m.prompt.Focus()
+		if !msg.answer {
+			m.content.Chat.AddMessage(fmt.Sprintf("%sCompaction cancelled, the history is unchanged", systemPrefix))
+			return m, nil
+		}
+		return m, func() tea.Msg { return compactConversationMsg{} }
+	}
+
+	// Check if this is a response to the :compact --diff preview
+	if m.pendingCompactDiff != nil {
+		preview := m.pendingCompactDiff
+		m.pendingCompactDiff = nil
+		m.prompt.Focus()
+		if !msg.answer || m.session == nil {
+			m.content.Chat.AddMessage(fmt.Sprintf("%sCompaction discarded, the history is unchanged", systemPrefix))
+			return m, nil
+		}
+		m.session.ApplyCompactSummary(preview.Summary)
+		return m, func() tea.Msg { return compactCompleteMsg{summary: preview.Summary} }
+	}
+
+	// Check if this is a response to a :replace preview
+	if m.pendingReplace != nil {
+		plan := m.pendingReplace
+		m.pendingReplace = nil
+		m.prompt.Focus()
+		if !msg.answer {
+			m.content.Chat.AddMessage(fmt.Sprintf("%sReplace cancelled, no files changed", systemPrefix))
+			return m, nil
+		}
+		files, matches, skipped := applyReplace(context.Background(), plan)
+		slog.Info("applied replace", "glob", plan.Glob, "files", files, "matches", matches, "skipped", len(skipped))
+		out := NewChatMsgBuilder(systemPrefix)
+		out.WriteLnf("✓ Replaced %d matches in %d files", matches, files)
+		if len(skipped) > 0 {
+			out.WriteLn("Skipped:")
+			for _, path := range skipped {
+				out.WriteLn(path)
+			}
+		}
+		m.content.Chat.AddMessage(out.String())
+		return m, nil
+	}
+
+	// Check if this is a response to a shell command confirmation
+	if m.pendingShellCommand != "" {
+		command := m.pendingShellCommand
+		m.pendingShellCommand = ""
+		if msg.answer {
+			return m.handleShellCommand(command)
+		}
+		m.commandLine.AddToast("Shell command cancelled", "info", time.Second*3)
+		m.prompt.Focus()
+		return m, nil
+	}
+
+	// Check if this is a response to the update confirmation
+	if !m.pendingUpdate {
+		return m, nil
+	}
+	m.pendingUpdate = false
+	if msg.answer {
+		// User confirmed update
+		return m, handleUpdateConfirm(&m)
+	}
+	// User declined
+	cancelMsg := NewChatMsgBuilder(systemPrefix)
+	cancelMsg.WriteLn("Update cancelled.")
+	cancelMsg.WriteLn("Please run :update again when ready")
+	m.content.Chat.AddMessage(cancelMsg.String())
+	return m, nil
+}
+
+// notifyIdleThreshold is how long without input before completion notifications are sent
+const notifyIdleThreshold = 30 * time.Second
+
+// notifyWriter receives the notification escape sequences; tests replace it
+var notifyWriter io.Writer = os.Stdout
+
+// notificationSequence returns the terminal sequence for the given ui.notify mode
+func notificationSequence(mode, title, body string) string {
+	switch mode {
+	case "bell":
+		return "\a"
+	case "osc777":
+		return fmt.Sprintf("\x1b]777;notify;%s;%s\x07", title, body)
+	default:
+		return ""
+	}
+}
+
+// inQuietHours reports whether now falls inside a "HH:MM-HH:MM" window.
+// Windows may wrap around midnight, e.g. "22:00-07:00".
+func inQuietHours(window string, now time.Time) bool {
+	if window == "" {
+		return false
+	}
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		slog.Warn("invalid ui.quiet_hours, expected HH:MM-HH:MM", "value", window)
+		return false
+	}
+	start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+	end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+	if err1 != nil || err2 != nil {
+		slog.Warn("invalid ui.quiet_hours, expected HH:MM-HH:MM", "value", window)
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	startMin := start.Hour()*60 + start.Minute()
+	endMin := end.Hour()*60 + end.Minute()
+	if startMin <= endMin {
+		return minute >= startMin && minute < endMin
+	}
+	return minute >= startMin || minute < endMin
+}
+
+// completionNotifyCmd emits a bell or desktop notification when a response completes
+// while the user is away. Returns nil when notifications are off, the user typed
+// recently or it's quiet hours.
+func (m TUIModel) completionNotifyCmd(now time.Time) tea.Cmd {
+	if m.config == nil {
+		return nil
+	}
+	seq := notificationSequence(m.config.UI.Notify, "Asimi", "Response complete")
+	if seq == "" {
+		return nil
+	}
+	if !m.lastInputTime.IsZero() && now.Sub(m.lastInputTime) < notifyIdleThreshold {
+		return nil
+	}
+	if inQuietHours(m.config.UI.QuietHours, now) {
+		slog.Debug("skipping completion notification during quiet hours")
+		return nil
+	}
+	return func() tea.Msg {
+		if _, err := io.WriteString(notifyWriter, seq); err != nil {
+			slog.Debug("failed to write notification", "error", err)
+		}
+		return nil
+	}
+}
+
 // handleShellCommand executes a shell command using the run_in_shell tool
 func (m TUIModel) handleShellCommand(command string) (tea.Model, tea.Cmd) {
 	// Extract the shell command (everything after !)
@@ -1282,6 +1911,9 @@ func (m TUIModel) handleWindowSizeMsg(msg tea.WindowSizeMsg) (tea.Model, tea.Cmd
 func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case SubmitPromptMsg:
+		if !m.submittingHistory() && m.promptWhileBusy() {
+			return m, nil
+		}
 		var cmds []tea.Cmd
 		content := msg.Prompt
 
@@ -1289,15 +1921,10 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.commandLine.ClearToasts()
 		refreshGitInfo()
 
-		if m.historySaved && m.historyCursor < len(m.sessionPromptHistory) {
-			entry := m.sessionPromptHistory[m.historyCursor]
-			m.cancelStreaming()
-			m.stopStreaming()
-			if m.session != nil {
-				m.session.RollbackTo(entry.SessionSnapshot)
+		if m.submittingHistory() {
+			if rolledBack, stopCmd := m.rollbackToHistory(content); !rolledBack {
+				return m, stopCmd
 			}
-			m.content.Chat.TruncateTo(entry.ChatSnapshot)
-			m.content.Chat.ClearToolCallMessageIndex()
 			m.historySaved = false
 		}
 
@@ -1312,25 +1939,8 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		m.content.Chat.AddMessage(fmt.Sprintf("You: %s", content))
 		if m.session != nil {
-			info := m.session.GetContextInfo()
-			autoCompactThreshold := float64(info.TotalTokens) * 0.10
-			if float64(info.FreeTokens) < autoCompactThreshold && len(m.session.Messages) > 2 {
-				slog.Info("auto-compacting conversation", "free_tokens", info.FreeTokens, "threshold", autoCompactThreshold)
-				m.content.Chat.AddMessage("🗜️  Auto-compacting conversation history (low on context)...")
-				ctx := context.Background()
-				_, err := m.session.CompactHistory(ctx, compactPrompt)
-				if err != nil {
-					slog.Warn("auto-compaction failed", "error", err)
-					m.content.Chat.AddMessage(fmt.Sprintf("⚠️  Auto-compaction failed: %v", err))
-				} else {
-					newInfo := m.session.GetContextInfo()
-					m.content.Chat.AddMessage(fmt.Sprintf("✅ Conversation compacted! Context usage: %s/%s tokens (%.1f%%)",
-						formatTokenCount(newInfo.UsedTokens),
-						formatTokenCount(newInfo.TotalTokens),
-						percentage(newInfo.UsedTokens, newInfo.TotalTokens)))
-					slog.Info("auto-compaction completed", "old_used", info.UsedTokens, "new_used", newInfo.UsedTokens, "saved", info.UsedTokens-newInfo.UsedTokens)
-				}
-			}
+			// Check if we need to auto-compact before sending the prompt (#54)
+			m.autoCompactIfNeeded()
 
 			m.sessionActive = true
 			if waitCmd := m.startWaitingForResponse(); waitCmd != nil {
@@ -1338,7 +1948,8 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			ctx, cancel := context.WithCancel(context.Background())
 			m.streamingCancel = cancel
-			m.session.AskStream(ctx, content)
+			m.session.SetFirstPrompt(content)
+			m.askSession(ctx, content)
 		} else {
 			m.commandLine.AddToast("No model configured. Use :models to select a model", "error", time.Second*5)
 		}
@@ -1373,13 +1984,25 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.content.Chat.AddToRawHistory("TOOL_EXECUTING", fmt.Sprintf("%s with input: %s", msg.Call.Tool.Name(), msg.Call.Input))
 		m.content.Chat.HandleToolCallExecuting(msg)
 
+	case ToolCallOutputChunkMsg:
+		if m.content.Chat.HandleToolCallOutputChunk(msg) {
+			return m, tea.Tick(toolOutputFrame, func(time.Time) tea.Msg { return toolOutputTickMsg{} })
+		}
+
+	case toolOutputTickMsg:
+		m.content.Chat.FlushToolOutput()
+
 	case ToolCallSuccessMsg:
 		m.content.Chat.AddToRawHistory("TOOL_SUCCESS", fmt.Sprintf("%s\nInput: %s\nOutput: %s", msg.Call.Tool.Name(), msg.Call.Input, msg.Call.Result))
 		m.content.Chat.HandleToolCallSuccess(msg)
 		refreshGitInfo()
 
 	case ToolCallErrorMsg:
-		m.content.Chat.AddToRawHistory("TOOL_ERROR", fmt.Sprintf("%s\nInput: %s\nError: %v", msg.Call.Tool.Name(), msg.Call.Input, msg.Call.Error))
+		toolMessage, ok := m.content.Chat.GetToolCallMessageIndex(msg.Call.ID)
+		if !ok {
+			toolMessage = len(m.content.Chat.Messages)
+		}
+		m.content.Chat.AddToRawHistoryAt("TOOL_ERROR", fmt.Sprintf("%s\nInput: %s\nError: %v", msg.Call.Tool.Name(), msg.Call.Input, msg.Call.Error), toolMessage)
 		m.content.Chat.HandleToolCallError(msg)
 
 	case errMsg:
@@ -1417,6 +2040,28 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			slog.Debug("appended_to_last_message", "total_messages", len(m.content.Chat.Messages))
 		}
 
+	case streamDiscardedMsg:
+		// A fallback model answers again, drop what the failed model streamed
+		chat := m.content.Chat
+		if n := len(chat.Messages); n > 0 && strings.HasPrefix(chat.Messages[n-1], "Asimi:") {
+			chat.ReplaceLastMessage(strings.TrimSuffix(chat.Messages[n-1], string(msg)))
+		}
+
+	case streamEmptyResponseMsg:
+		// The model ended its turn with nothing to show, replace any blank bubble with a placeholder
+		m.content.Chat.AddToRawHistory("STREAM_EMPTY", "AI returned no content")
+		slog.Warn("streamEmptyResponseMsg", "messages_count", len(m.content.Chat.Messages))
+		chat := m.content.Chat
+		placeholder := "Asimi: " + emptyResponsePlaceholder
+		if len(chat.Messages) > 0 {
+			last := chat.Messages[len(chat.Messages)-1]
+			if strings.HasPrefix(last, "Asimi:") && strings.TrimSpace(strings.TrimPrefix(last, "Asimi:")) == "" {
+				chat.ReplaceLastMessage(placeholder)
+				break
+			}
+		}
+		chat.AddMessage(placeholder)
+
 	case streamReasoningChunkMsg:
 		// Handle reasoning/thinking chunks from models like Claude with extended thinking (#38)
 		m.content.Chat.AddToRawHistory("STREAM_REASONING_CHUNK", string(msg))
@@ -1451,10 +2096,16 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.streamCompleteCallback = nil // Clear after running
 		}
 
+		if m.session != nil {
+			if hint := m.session.RefusalHint(); hint != "" {
+				m.commandLine.AddToast(hint, "info", time.Second*5)
+			}
+		}
+
 		m.saveSession()
 		refreshGitInfo()
 
-		return m, guardrailCmd
+		return m, tea.Batch(guardrailCmd, m.completionNotifyCmd(time.Now()))
 
 	case streamInterruptedMsg:
 		// Streaming was interrupted by user
@@ -1511,9 +2162,30 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		// Update available - ask for confirmation
+		if m.holdDialog(msg) {
+			return m, nil
+		}
+		m.pendingUpdate = true
 		question := fmt.Sprintf("%sUpdate available: %s → %s. Do you want to update now?", systemPrefix, version, msg.latest)
 		return m, m.commandLine.EnterYesNoMode(question)
 
+	case agentsRegeneratedMsg:
+		if msg.err != nil {
+			m.content.Chat.AddMessage(fmt.Sprintf("%s❌ %v", systemPrefix, msg.err))
+			return m, nil
+		}
+		if strings.TrimSpace(msg.cleaned) == strings.TrimSpace(msg.current) {
+			m.content.Chat.AddMessage(fmt.Sprintf("%s%s is already tidy, nothing to change", systemPrefix, msg.path))
+			return m, nil
+		}
+		if m.holdDialog(msg) {
+			return m, nil
+		}
+		m.content.Chat.AddMessage(renderAgentsDiff(msg))
+		m.pendingAgentsRewrite = &msg
+		m.prompt.Blur()
+		return m, m.commandLine.EnterYesNoMode(fmt.Sprintf("Write the regenerated %s? The current one is backed up to %s.bak", msg.path, msg.path))
+
 	case updateAvailableMsg:
 		// Background update check found a new version - just set the flag
 		// The home view will display the notification
@@ -1521,32 +2193,20 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case yesNoResponseMsg:
-		// Check if this is a response to a host command approval request
-		if m.pendingHostApproval != nil {
-			// Send the response back to the waiting goroutine
-			m.pendingHostApproval.ResponseChan <- msg.answer
-			if msg.answer {
-				m.content.Chat.AddMessage(fmt.Sprintf("✓ Approved host command: %s", m.pendingHostApproval.Command))
-			} else {
-				m.content.Chat.AddMessage(fmt.Sprintf("✗ Denied host command: %s", m.pendingHostApproval.Command))
-			}
-			m.pendingHostApproval = nil
-			return m, nil
+		updated, cmd := m.answerYesNo(msg)
+		m = updated.(TUIModel)
+		// The next dialog that waited for this one opens now
+		if len(m.queuedDialogs) > 0 {
+			next := m.queuedDialogs[0]
+			m.queuedDialogs = m.queuedDialogs[1:]
+			cmd = tea.Batch(cmd, func() tea.Msg { return next })
 		}
-
-		// Otherwise, this is an update confirmation
-		if msg.answer {
-			// User confirmed update
-			return m, handleUpdateConfirm(&m)
-		}
-		// User declined
-		cancelMsg := NewChatMsgBuilder(systemPrefix)
-		cancelMsg.WriteLn("Update cancelled.")
-		cancelMsg.WriteLn("Please run :update again when ready")
-		m.content.Chat.AddMessage(cancelMsg.String())
-		return m, nil
+		return m, cmd
 
 	case hostCommandApprovalMsg:
+		if m.holdDialog(msg) {
+			return m, nil
+		}
 		// Store the pending approval request
 		m.pendingHostApproval = &msg.request
 		// Truncate command for display if too long
@@ -1557,6 +2217,14 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, m.commandLine.EnterYesNoMode(fmt.Sprintf("Allow `%s` to run?", displayCmd))
 
+	case toolApprovalMsg:
+		if m.holdDialog(msg) {
+			return m, nil
+		}
+		m.pendingToolApproval = &msg.request
+		m.prompt.Blur()
+		return m, m.commandLine.EnterYesNoAlwaysMode(fmt.Sprintf("Allow %s %s?", msg.request.Tool, truncateSnippet(msg.request.Args, 50)))
+
 	case updateCompleteMsg:
 		if msg.err != nil {
 			errMsg := NewChatMsgBuilder(systemPrefix)
@@ -1572,6 +2240,15 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.content.Chat.AddMessage(successMsg.String())
 		return m, nil
 
+	case historyStopMsg:
+		m.historyStopping = false
+		if !msg.stopped {
+			m.commandLine.AddToast("The previous answer is still stopping, try again", "warning", time.Second*3)
+			return m, nil
+		}
+		m.streamingCancel = nil
+		return m, func() tea.Msg { return SubmitPromptMsg{Prompt: msg.prompt} }
+
 	case waitingTickMsg:
 		if m.waitingForResponse {
 			return m, tea.Tick(time.Second, func(time.Time) tea.Msg { return waitingTickMsg{} })
@@ -1589,20 +2266,20 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			authURL, verifier, err := auth.authorize()
 			if err != nil {
 				slog.Warn("Anthropic Auth failed", "error", err)
-				m.commandLine.AddToast("Authorization failed", "error", 4000)
+				m.commandLine.AddToast("Authorization failed", "error", time.Second*4)
 				return m, nil
 			}
 
 			// Open browser
 			if err := openBrowser(authURL); err != nil {
-				m.commandLine.AddToast("Failed to open browser", "warning", 3000)
+				m.commandLine.AddToast("Failed to open browser", "warning", time.Second*3)
 			}
 
 			// Show code input modal
 			m.codeInputModal = NewCodeInputModal(authURL, verifier)
 			m.config.LLM.Provider = provider
 			m.config.LLM.Model = "claude-3-5-sonnet-latest"
-			m.commandLine.AddToast("Logged in", "success", 3000)
+			m.commandLine.AddToast("Logged in", "success", time.Second*3)
 		} else {
 			// Other providers use the standard OAuth flow
 			return m, m.performOAuthLogin(provider)
@@ -1611,27 +2288,51 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case showOauthFailed:
 		m.content.Chat.AddToRawHistory("OAUTH_ERROR", msg.err)
 		errToast := fmt.Sprintf("OAuth failed: %s", msg.err)
-		m.commandLine.AddToast(errToast, "error", 4000)
+		m.commandLine.AddToast(errToast, "error", time.Second*4)
 		m.content.Chat.AddMessage(errToast)
 		m.sessionActive = false
 
 	case modalCancelledMsg:
 		m.providerModal = nil
 		m.codeInputModal = nil
+		m.endpointModal = nil
 		// Return to chat view
-		m.commandLine.AddToast("Cancelled", "info", 2000)
+		m.commandLine.AddToast("Cancelled", "info", time.Second*2)
 		return m, m.content.ShowChat()
 
 	case authCodeEnteredMsg:
 		m.codeInputModal = nil
 		return m, m.completeAnthropicOAuth(msg.code, msg.verifier)
 
+	case endpointEnteredMsg:
+		m.endpointModal = nil
+		m.commandLine.AddToast("Connecting to "+msg.baseURL, "info", time.Second*2)
+		return m, checkEndpointCmd(msg)
+
+	case endpointCheckedMsg:
+		if msg.err == nil {
+			msg.err = m.applyEndpoint(msg.endpointEnteredMsg)
+		}
+		if msg.err != nil {
+			slog.Warn("endpoint setup failed", "base_url", msg.baseURL, "error", msg.err)
+			m.commandLine.AddToast(fmt.Sprintf("Endpoint not saved: %v", msg.err), "error", time.Second*5)
+			return m, nil
+		}
+		m.commandLine.AddToast(fmt.Sprintf("Using %s at %s", msg.model, msg.baseURL), "success", time.Second*3)
+
 	case modelSelectedMsg:
 		if msg.onSelect != nil {
 			return m, msg.onSelect
 		}
 		oldProvider := m.config.LLM.Provider
 		oldModel := m.config.LLM.Model
+		oldBaseURL := m.config.LLM.BaseURL
+
+		// Verify an Ollama model is pulled before touching the config, the selection comes back
+		// once it is
+		if msg.model.Provider == "ollama" && !msg.pulled {
+			return m, checkOllamaModel(msg.model)
+		}
 
 		// Update provider and model
 		m.config.LLM.Provider = msg.model.Provider
@@ -1643,6 +2344,8 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.config.LLM.AuthToken = ""
 			m.config.LLM.RefreshToken = ""
 			m.config.LLM.APIKey = ""
+			// The endpoint belongs to the previous provider
+			m.config.LLM.BaseURL = ""
 
 			// Credentials will be loaded by getModelClient() from keyring
 			// No need to load them here - getModelClient handles expiration and refresh
@@ -1651,18 +2354,20 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Save config and reinitialize session
 		if err := SaveConfig(m.config); err != nil {
 			slog.Error("Failed to save config", "error", err)
-			m.commandLine.AddToast("Failed to save config", "error", 4000)
+			m.commandLine.AddToast("Failed to save config", "error", time.Second*4)
 			// Revert changes
 			m.config.LLM.Provider = oldProvider
 			m.config.LLM.Model = oldModel
+			m.config.LLM.BaseURL = oldBaseURL
 		} else {
 			// Reinitialize session with new model
 			if err := m.reinitializeSession(); err != nil {
 				slog.Error("Failed to reinitialize session", "error", err)
-				m.commandLine.AddToast("Failed to re-init model. Please try again", "error", 4000)
+				m.commandLine.AddToast("Failed to re-init model. Please try again", "error", time.Second*4)
 				// Revert changes
 				m.config.LLM.Provider = oldProvider
 				m.config.LLM.Model = oldModel
+				m.config.LLM.BaseURL = oldBaseURL
 				if err := SaveConfig(m.config); err != nil {
 					slog.Error("Failed to save reverted config", "error", err)
 				}
@@ -1675,11 +2380,20 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if msg.model.Provider != oldProvider {
 					providerChanged = fmt.Sprintf(" (switched to %s)", msg.model.Provider)
 				}
-				m.commandLine.AddToast(fmt.Sprintf("Model changed to %s%s", modelName, providerChanged), "success", 3000)
+				m.commandLine.AddToast(fmt.Sprintf("Model changed to %s%s", modelName, providerChanged), "success", time.Second*3)
 			}
 		}
 		return m, nil
 
+	case ollamaModelCheckedMsg:
+		if msg.err != nil {
+			// Keep the current model
+			slog.Warn("refusing to switch to ollama model", "model", msg.model.ID, "error", msg.err)
+			m.commandLine.AddToast(msg.err.Error(), "error", time.Second*5)
+			return m, nil
+		}
+		return m, func() tea.Msg { return modelSelectedMsg{model: msg.model, pulled: true} }
+
 	case modelsLoadedMsg:
 		return m, m.content.ShowUnifiedModels(msg.models, m.config.LLM.Model)
 
@@ -1693,6 +2407,20 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case sessionsLoadedMsg:
 		return m, m.content.ShowResume(msg.sessions)
 
+	case promptEditedMsg:
+		defer os.Remove(msg.path)
+		if msg.err != nil {
+			m.commandLine.AddToast(fmt.Sprintf("Editor failed, the prompt is unchanged: %v", msg.err), "error", time.Second*4)
+			return m, nil
+		}
+		edited, err := os.ReadFile(msg.path)
+		if err != nil {
+			m.commandLine.AddToast(fmt.Sprintf("Cannot read the edited prompt: %v", err), "error", time.Second*4)
+			return m, nil
+		}
+		m.prompt.SetValue(strings.TrimRight(string(edited), "\n"))
+		return m, nil
+
 	case ChangeModeMsg:
 		// Centralized mode change handling
 		oldMode := m.Mode
@@ -1763,6 +2491,9 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		// Check if this is a shell command (starts with !)
 		if strings.HasPrefix(msg.command, "!") {
+			if m.config != nil && m.config.Tools.ConfirmShell {
+				return m.confirmShellCommand(msg.command)
+			}
 			return m.handleShellCommand(msg.command)
 		}
 
@@ -1775,7 +2506,7 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Use FindCommand for vim-style partial matching
 			cmd, matches, found := m.commandRegistry.FindCommand(cmdName)
 			if found {
-				c := cmd.Handler(&m, parts[1:])
+				c := cmd.Handler(&m, cmd.Args(":"+msg.command))
 				m.prompt.Focus()
 				return m, c
 			} else if len(matches) > 1 {
@@ -1790,7 +2521,7 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.commandLine.AddToast(errorMsg, "error", time.Second*3)
 			} else {
 				// Unknown command
-				m.commandLine.AddToast(fmt.Sprintf("Unknown command: %s", strings.TrimPrefix(cmdName, ":")), "error", time.Second*3)
+				m.commandLine.AddToast(m.commandRegistry.UnknownCommandMessage(cmdName), "error", time.Second*3)
 			}
 		}
 		m.prompt.Focus()
@@ -1842,71 +2573,24 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case sessionSelectedMsg:
+		if msg.session != nil && msg.exportType != "" {
+			return m, exportPickedSession(&m, msg.session, msg.exportType)
+		}
 		if msg.session != nil {
-			if m.session != nil {
-				// Copy all persisted fields from loaded session to existing session
-				m.session.ID = msg.session.ID
-				m.session.CreatedAt = msg.session.CreatedAt
-				m.session.LastUpdated = msg.session.LastUpdated
-				m.session.FirstPrompt = msg.session.FirstPrompt
-				m.session.Provider = msg.session.Provider
-				m.session.Model = msg.session.Model
-				m.session.WorkingDir = msg.session.WorkingDir
-				m.session.ProjectSlug = msg.session.ProjectSlug
-				m.session.ContextFiles = msg.session.ContextFiles
-
-				// Copy messages - need to make a proper copy
-				m.session.Messages = make([]llms.MessageContent, len(msg.session.Messages))
-				copy(m.session.Messages, msg.session.Messages)
-			} else {
-				// No active session - set the loaded session directly
-				m.session = msg.session
-				slog.Warn("Resumed session without active LLM - some features may be limited")
-			}
-
-			// Clear and rebuild chat UI from messages (reuses existing markdown renderer)
-			m.content.Chat.Clear()
-			for _, msgContent := range m.session.Messages {
-				// Skip system messages
-				if msgContent.Role == llms.ChatMessageTypeSystem {
-					continue
+			if current := branchSlugOrDefault(GetRepoInfo().Branch); msg.session.Branch != "" && msg.session.Branch != current {
+				if m.holdDialog(msg) {
+					return m, nil
 				}
-
-				if msgContent.Role == llms.ChatMessageTypeHuman || msgContent.Role == llms.ChatMessageTypeAI {
-					for _, part := range msgContent.Parts {
-						if textPart, ok := part.(llms.TextContent); ok {
-							prefix := "You: "
-							if msgContent.Role == llms.ChatMessageTypeAI {
-								prefix = "Asimi: "
-							}
-							m.content.Chat.AddMessage(prefix + textPart.Text)
-						}
-					}
-				}
-			}
-			if m.session != nil {
-				m.session.updateTokenCounts()
+				return m, m.offerBranchResume(msg.session, current)
 			}
-			m.sessionActive = true
-
-			// Reset in-session prompt history state to prevent rollback issues
-			// when the user enters a new prompt after resuming.
-			// We keep the persistent history (loaded from disk) but clear the
-			// session-specific rollback state.
-			m.sessionPromptHistory = make([]promptHistoryEntry, 0)
-			m.historyCursor = 0
-			m.historySaved = false
-			m.historyPendingPrompt = ""
-			m.historyPresentSessionSnapshot = 0
-			m.historyPresentChatSnapshot = 0
-
+			m.resumeSession(msg.session)
 			timeStr := formatRelativeTime(msg.session.LastUpdated)
-			m.commandLine.AddToast(fmt.Sprintf("Resumed session from %s", timeStr), "success", 3000)
+			m.commandLine.AddToast(fmt.Sprintf("Resumed session from %s", timeStr), "success", time.Second*3)
 		}
 		return m, nil
 
 	case sessionResumeErrorMsg:
-		m.commandLine.AddToast(fmt.Sprintf("Failed to resume session: %v", msg.err), "error", 4000)
+		m.commandLine.AddToast(fmt.Sprintf("Failed to resume session: %v", msg.err), "error", time.Second*4)
 		return m, m.content.ShowChat()
 
 	case llmInitSuccessMsg:
@@ -1917,14 +2601,14 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case llmInitErrorMsg:
 		// LLM initialization failed
 		slog.Warn("LLM initialization failed", "error", msg.err)
-		m.commandLine.AddToast("Running without a model, use `:models` to set", "warning", 5000)
+		m.commandLine.AddToast("Running without a model, use `:models` to set", "warning", time.Second*5)
 
 	case startConversationMsg:
 		// Handle starting a new conversation (used by init, new, and other commands)
 		slog.Debug("got startConversationMsg", "RunOnHost", msg.RunOnHost)
 
 		if m.session == nil {
-			m.commandLine.AddToast("No LLM session available", "error", 4000)
+			m.commandLine.AddToast("No LLM session available", "error", time.Second*4)
 			return m, nil
 		}
 
@@ -1969,23 +2653,22 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Store the callback for later use
 		m.streamCompleteCallback = msg.onStreamComplete
 
+		// Let the reply stream into the last AI message instead of a new one
+		if msg.continueLast {
+			m.content.Chat.ReopenLastAIMessage()
+		}
+
 		// Add initialization message if this is an init command (has a prompt and callback)
 		// If there's a prompt, send it to the AI
 		if msg.prompt != "" {
 			ctx, cancel := context.WithCancel(context.Background())
 			m.streamingCancel = cancel
 			m.sessionActive = true
+			m.session.markCommandPrompt(msg.prompt)
 
-			if waitCmd := m.startWaitingForResponse(); waitCmd != nil {
-				go func() {
-					m.session.AskStream(ctx, msg.prompt)
-				}()
-				return m, waitCmd
-			} else {
-				go func() {
-					m.session.AskStream(ctx, msg.prompt)
-				}()
-			}
+			waitCmd := m.startWaitingForResponse()
+			m.askSession(ctx, msg.prompt)
+			return m, waitCmd
 		} else {
 			// If RunOnHost is true and restoration callback is set, restore runner immediately
 			if msg.RunOnHost && msg.onStreamComplete != nil {
@@ -1999,17 +2682,38 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Handle conversation compaction
 		slog.Debug("got compactConversationMsg")
 		if m.session == nil {
-			m.commandLine.AddToast("No LLM session available for compaction", "error", 4000)
+			m.commandLine.AddToast("No LLM session available for compaction", "error", time.Second*4)
+			return m, nil
+		}
+
+		if m.compactCancel != nil {
+			m.commandLine.AddToast("Compaction already running", "info", time.Second*3)
 			return m, nil
 		}
 
 		// Add a message to show we're compacting
-		m.content.Chat.AddMessage("🗜️  Compacting conversation history...")
+		m.content.Chat.AddMessage("🗜️  Compacting conversation history... (Esc to cancel)")
+		m.compactMsgIndex = len(m.content.Chat.Messages) - 1
 
 		// Perform the compaction in a goroutine
+		ctx, cancel := context.WithCancel(context.Background())
+		m.compactCancel = cancel
+		session := m.session
 		go func() {
-			ctx := context.Background()
-			summary, err := m.session.CompactHistory(ctx, compactPrompt)
+			defer cancel()
+			if msg.diff {
+				preview, err := session.PreviewCompaction(ctx, compactPrompt)
+				if program == nil {
+					return
+				}
+				if err != nil {
+					program.Send(compactErrorMsg{err: err})
+					return
+				}
+				program.Send(compactPreviewMsg{preview: preview})
+				return
+			}
+			summary, err := session.CompactHistory(ctx, compactPrompt)
 			if err != nil {
 				if program != nil {
 					program.Send(compactErrorMsg{err: err})
@@ -2021,9 +2725,18 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}()
 
+	case compactProgressMsg:
+		chat := m.content.Chat
+		if m.compactCancel != nil && msg.chars > 0 && m.compactMsgIndex < len(chat.Messages) {
+			chat.Messages[m.compactMsgIndex] = fmt.Sprintf("🗜️  Compacting conversation history... %d characters of summary written (Esc to cancel)", msg.chars)
+			chat.UpdateContent()
+		}
+		return m, nil
+
 	case compactCompleteMsg:
 		// Compaction completed successfully
 		slog.Debug("compaction completed")
+		m.compactCancel = nil
 
 		// Get context info to show the improvement
 		info := m.session.GetContextInfo()
@@ -2034,13 +2747,29 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 			formatTokenCount(info.TotalTokens),
 			percentage(info.UsedTokens, info.TotalTokens)))
 
-		m.commandLine.AddToast("Conversation history compacted", "success", 3000)
+		m.commandLine.AddToast("Conversation history compacted", "success", time.Second*3)
+
+	case compactPreviewMsg:
+		m.compactCancel = nil
+		if m.holdDialog(msg) {
+			return m, nil
+		}
+		m.content.Chat.AddMessage(renderCompactPreview(msg.preview))
+		m.pendingCompactDiff = &msg.preview
+		m.prompt.Blur()
+		return m, m.commandLine.EnterYesNoMode("Apply the compaction?")
 
 	case compactErrorMsg:
+		m.compactCancel = nil
+		if errors.Is(msg.err, context.Canceled) {
+			slog.Info("compaction cancelled")
+			m.content.Chat.AddMessage("🗜️  Compaction cancelled, your conversation context was left unchanged.")
+			return m, nil
+		}
 		// Compaction failed
 		slog.Warn("compaction failed", "error", msg.err)
 		m.content.Chat.AddMessage(fmt.Sprintf("❌ Failed to compact conversation: %v\n\nYour conversation context was left unchanged.", msg.err))
-		m.commandLine.AddToast("Compaction failed - context unchanged", "error", 3000)
+		m.commandLine.AddToast("Compaction failed - context unchanged", "error", time.Second*3)
 
 	case containerLaunchMsg:
 		// Container launch notification
@@ -2053,6 +2782,7 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case shellCommandResultMsg:
 		// Shell command execution completed
 		m.content.Chat.AddShellCommandResult(msg)
+		m.lastShellResult = &msg
 		refreshGitInfo()
 		m.prompt.Focus()
 		return m, nil
@@ -2060,7 +2790,7 @@ func (m TUIModel) handleCustomMessages(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Restore focus to prompt if no modals are active and view is chat
-	if m.providerModal == nil && m.codeInputModal == nil &&
+	if m.providerModal == nil && m.codeInputModal == nil && m.endpointModal == nil &&
 		!m.commandLine.IsInCommandMode() &&
 		m.content.GetActiveView() == ViewChat {
 		m.prompt.Focus()
@@ -2238,9 +2968,19 @@ func (m *TUIModel) updateComponentDimensions() {
 }
 
 // View implements bubbletea.Model
-func (m TUIModel) View() string {
+func (m TUIModel) View() (view string) {
 	start := time.Now()
 
+	defer func() {
+		if r := recover(); r != nil {
+			m.recoverFromPanic(r)
+			view = ""
+			if program != nil {
+				go program.Quit()
+			}
+		}
+	}()
+
 	defer func() {
 		duration := time.Since(start)
 		if duration > 100*time.Millisecond {
@@ -2259,7 +2999,7 @@ func (m TUIModel) View() string {
 	mainContent := m.renderMainContent(modalHeight)
 	promptView := m.prompt.View()
 	commandLineView := m.commandLine.View()
-	view := m.composeBaseView(mainContent, promptView, commandLineView)
+	view = m.composeBaseView(mainContent, promptView, commandLineView)
 	if m.showCompletionDialog {
 		view = m.overlayCompletionDialog(view, promptView, commandLineView)
 	}
@@ -2283,6 +3023,8 @@ func (m TUIModel) renderMainContent(modalHeight int) string {
 	switch {
 	case m.rawMode:
 		return m.renderRawSessionView(m.width, contentHeight)
+	case m.logView:
+		return m.renderAgentLogView(m.width, contentHeight)
 	case !m.sessionActive:
 		return m.renderHomeView(m.width, contentHeight)
 	default:
@@ -2372,6 +3114,10 @@ func (m TUIModel) applyModalOverlays(view string) string {
 		result = lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, m.codeInputModal.Render())
 	}
 
+	if m.endpointModal != nil {
+		result = lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, m.endpointModal.Render())
+	}
+
 	return result
 }
 
@@ -2458,10 +3204,81 @@ func (m TUIModel) renderHomeView(width, height int) string {
 	return container
 }
 
-// renderRawSessionView renders the raw session view showing complete unfiltered history
+// rawFilterKeep returns the predicate selecting the raw entries of the :raw filter kinds,
+// nil when there is no filter
+func (m TUIModel) rawFilterKeep() func(kind string) bool {
+	if len(m.rawFilter) == 0 {
+		return nil
+	}
+	return func(kind string) bool {
+		for _, filter := range m.rawFilter {
+			if kind == filter || strings.HasPrefix(kind, filter+"_") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// rawLegend lists the kinds of raw entries with their counts and the active filter
+func (m TUIModel) rawLegend() string {
+	counts := m.content.Chat.RawHistoryKinds()
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%s %d", kind, counts[kind])
+	}
+	legend := "Types: " + strings.Join(kinds, " · ")
+	if len(m.rawFilter) > 0 {
+		legend += fmt.Sprintf("\nShowing %s, :raw filter to show all", strings.Join(m.rawFilter, ", "))
+	} else {
+		legend += "\nShowing all, :raw filter <type> to narrow down"
+	}
+	return legend
+}
+
+// agentLogKinds are the raw history entries the agent log shows
+var agentLogKinds = []string{"TOOL_SCHEDULED", "TOOL_EXECUTING", "TOOL_SUCCESS", "TOOL_ERROR", "STREAM_START", "STREAM_COMPLETE"}
+
+// renderAgentLogView renders the :log-view log, the first line of each tool and turn entry
+// of the raw history. It follows the newest entries as they arrive.
+func (m TUIModel) renderAgentLogView(width, height int) string {
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#F4DB53")).
+		Align(lipgloss.Center).
+		Width(width)
+	lines := []string{titleStyle.Render("Agent Log (:log-view or :q to return to chat)"), ""}
+
+	entries := m.content.Chat.GetRawHistory(func(kind string) bool { return slices.Contains(agentLogKinds, kind) })
+	lineWidth := max(width-2, 1)
+	var log []string
+	for _, entry := range entries {
+		first, _, _ := strings.Cut(entry, "\n")
+		log = append(log, " "+ansi.Truncate(first, lineWidth, "…"))
+	}
+	if len(log) == 0 {
+		log = append(log, " No tool calls yet")
+	}
+	if room := max(height-len(lines), 1); len(log) > room {
+		log = log[len(log)-room:]
+	}
+
+	return lipgloss.NewStyle().
+		Width(width).
+		Height(height).
+		Foreground(lipgloss.Color("#01FAFA")).
+		Background(lipgloss.Color("#000000")).
+		Render(strings.Join(append(lines, log...), "\n"))
+}
+
+// renderRawSessionView renders the raw session view showing the history, all of it unless filtered with :raw filter
 func (m TUIModel) renderRawSessionView(width, height int) string {
-	rawHistory := m.content.Chat.GetRawHistory()
-	if len(rawHistory) == 0 {
+	rawHistory := m.content.Chat.GetRawHistory(m.rawFilterKeep())
+	if len(m.content.Chat.RawHistoryKinds()) == 0 {
 		// Show empty state
 		emptyStyle := lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#004444")). // Terminal7 text-error
@@ -2488,6 +3305,11 @@ func (m TUIModel) renderRawSessionView(width, height int) string {
 		Width(width)
 
 	title := titleStyle.Render("Raw Session History (Press Ctrl+O to return to chat)")
+	legend := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#004444")).
+		Align(lipgloss.Center).
+		Width(width).
+		Render(m.rawLegend())
 
 	// Style for raw history entries
 	entryStyle := lipgloss.NewStyle().
@@ -2495,38 +3317,31 @@ func (m TUIModel) renderRawSessionView(width, height int) string {
 		PaddingLeft(1).
 		Width(width - 2)
 
-	// Render all history entries
+	// Render all history entries, wrapping long lines or cutting them at the
+	// horizontal scroll offset
+	lineWidth := max(width-4, 1)
 	var historyViews []string
 	for _, entry := range rawHistory {
-		// Word wrap long entries to fit the width
-		wrappedEntry := entry
-		if len(entry) > width-4 {
-			// Simple word wrap - in real implementation you might use wordwrap.String
-			for len(wrappedEntry) > width-4 {
-				breakPoint := width - 4
-				// Try to break at a space
-				for i := breakPoint; i > breakPoint-20 && i > 0; i-- {
-					if wrappedEntry[i] == ' ' {
-						breakPoint = i
-						break
-					}
-				}
-				historyViews = append(historyViews, entryStyle.Render(wrappedEntry[:breakPoint]))
-				wrappedEntry = "    " + wrappedEntry[breakPoint:] // Indent continuation lines
-			}
-			if len(wrappedEntry) > 0 {
-				historyViews = append(historyViews, entryStyle.Render(wrappedEntry))
+		if m.content.Chat.WrapMode == WrapModeScroll {
+			lines := strings.Split(entry, "\n")
+			for i, line := range lines {
+				lines[i] = ansi.Cut(line, m.rawXOffset, m.rawXOffset+lineWidth)
 			}
+			entry = strings.Join(lines, "\n")
 		} else {
-			historyViews = append(historyViews, entryStyle.Render(wrappedEntry))
+			entry = ansi.Wrap(entry, lineWidth, "")
 		}
+		historyViews = append(historyViews, entryStyle.Render(entry))
 		historyViews = append(historyViews, "") // Add spacing between entries
 	}
 
+	if len(historyViews) == 0 {
+		historyViews = append(historyViews, entryStyle.Render("No entries match the filter"))
+	}
 	historyContent := lipgloss.JoinVertical(lipgloss.Left, historyViews...)
 
-	// Combine title and content
-	content := lipgloss.JoinVertical(lipgloss.Left, title, "", historyContent)
+	// Combine title, legend and content
+	content := lipgloss.JoinVertical(lipgloss.Left, title, legend, "", historyContent)
 
 	// Create scrollable container
 	container := lipgloss.NewStyle().
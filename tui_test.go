@@ -1,14 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/afittestide/asimi/storage"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/charmbracelet/x/exp/teatest"
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/stretchr/testify/assert"
@@ -30,6 +41,9 @@ func mockConfig() *Config {
 			APIKey:   "",
 			BaseURL:  "",
 		},
+		UI: UIConfig{
+			Streaming: true,
+		},
 	}
 }
 
@@ -374,6 +388,101 @@ func TestChatComponentScrollLock(t *testing.T) {
 	require.False(t, chat.UserScrolled, "unlock at bottom should mark user as not scrolled")
 }
 
+func TestChatComponentFoldsLongMessages(t *testing.T) {
+	chat := NewChatComponent(50, 100, false)
+	chat.FoldLinesOver = 5
+
+	lines := make([]string, 40)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %02d", i)
+	}
+	long := strings.Join(lines, "\n")
+	chat.AddMessage("short")
+	chat.AddMessage(long)
+
+	view := ansi.Strip(chat.View())
+	require.Contains(t, view, "[+35 lines]")
+	require.Contains(t, view, "short")
+	require.Contains(t, view, "line 04")
+	require.NotContains(t, view, "line 05")
+	require.Equal(t, long, chat.Messages[2], "the full message is kept for copy and export")
+
+	// Expanding in scroll mode shows the whole message
+	require.True(t, chat.ToggleFold())
+	content := ansi.Strip(chat.Viewport.View())
+	require.NotContains(t, content, "[+35 lines]")
+	require.Contains(t, content, "line 39")
+
+	// And folds it back
+	require.True(t, chat.ToggleFold())
+	require.Contains(t, ansi.Strip(chat.Viewport.View()), "[+35 lines]")
+
+	// Folding is off by default
+	chat = NewChatComponent(50, 100, false)
+	chat.AddMessage(long)
+	require.Contains(t, ansi.Strip(chat.Viewport.View()), "line 39")
+	require.False(t, chat.ToggleFold())
+}
+
+func TestScrollModeJumpsBetweenToolCalls(t *testing.T) {
+	model := newTestModel(t)
+	model.Mode = "scroll"
+	chat := model.content.Chat
+	chat.SetSize(60, 5)
+
+	// Tool calls at messages 3, 7 and 11, separated by long answers
+	for i := 1; i <= 12; i++ {
+		if i%4 == 3 {
+			chat.AddMessage(fmt.Sprintf("read_file(%d.go)", i))
+			chat.SetToolCallMessageIndex(fmt.Sprintf("call_%d", i), i)
+			continue
+		}
+		chat.AddMessage(fmt.Sprintf("answer %d\nmore\nlines\nof\ntext", i))
+	}
+	chat.ScrollToTop()
+
+	press := func(keys ...string) {
+		for _, key := range keys {
+			updated, _, handled := model.handleScrollModeKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)})
+			require.True(t, handled)
+			*model = updated.(TUIModel)
+		}
+	}
+	topMessage := func() int {
+		for i, start := range chat.messageStarts {
+			if start == chat.Viewport.YOffset {
+				return i
+			}
+		}
+		return -1
+	}
+	toasts := func() int { return len(model.commandLine.toasts) }
+
+	press("]", "t")
+	require.Equal(t, 3, topMessage())
+	press("]", "t")
+	require.Equal(t, 7, topMessage())
+	press("]", "t")
+	require.Equal(t, 11, topMessage())
+	require.Equal(t, 0, toasts())
+
+	// Past the last one it wraps to the first with a toast
+	press("]", "t")
+	require.Equal(t, 3, topMessage())
+	require.Equal(t, 1, toasts())
+	require.Contains(t, model.commandLine.toasts[0].Message, "first tool call")
+
+	press("[", "t")
+	require.Equal(t, 11, topMessage())
+	require.Contains(t, model.commandLine.toasts[1].Message, "last tool call")
+	press("[", "t")
+	require.Equal(t, 7, topMessage())
+
+	// A bracket followed by another key is not a jump
+	press("]", "k")
+	require.NotEqual(t, 11, topMessage())
+}
+
 // TestCompletionDialog tests the completion dialog
 func TestCompletionDialog(t *testing.T) {
 	dialog := NewCompletionDialog()
@@ -791,6 +900,50 @@ func TestShowHelpMsgDisplaysRequestedTopic(t *testing.T) {
 	require.Equal(t, "modes", updatedModel.content.help.GetTopic())
 }
 
+func TestHelpIndexFiltersAndOpensTopic(t *testing.T) {
+	model := newTestModel(t)
+	newModel, _ := model.handleCustomMessages(showHelpMsg{topic: "index"})
+	m := newModel.(TUIModel)
+
+	send := func(msg tea.KeyMsg) {
+		updated, _ := m.Update(msg)
+		m = updated.(TUIModel)
+	}
+	typeText := func(text string) {
+		for _, r := range text {
+			send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+	}
+
+	send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'/'}})
+	require.Equal(t, ViewHelpIndex, m.content.GetActiveView())
+	require.Len(t, m.content.help.matches, len(helpTopicNames))
+
+	// Matches topic names and titles, e.g. "Vi Modes" and "Model Selection..."
+	typeText("mod")
+	var names []string
+	for _, topic := range m.content.help.matches {
+		names = append(names, topic.Name)
+	}
+	require.Equal(t, []string{"modes", "models"}, names)
+	require.Contains(t, m.content.View(), "Help index /mod")
+
+	send(tea.KeyMsg{Type: tea.KeyDown})
+	send(tea.KeyMsg{Type: tea.KeyEnter})
+	require.Equal(t, ViewHelp, m.content.GetActiveView())
+	require.Equal(t, "models", m.content.help.GetTopic())
+
+	// Backspace widens the filter again
+	send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'/'}})
+	typeText("xyz")
+	require.Empty(t, m.content.help.matches)
+	require.Contains(t, m.content.View(), "No matching help topics")
+	send(tea.KeyMsg{Type: tea.KeyBackspace})
+	send(tea.KeyMsg{Type: tea.KeyBackspace})
+	send(tea.KeyMsg{Type: tea.KeyBackspace})
+	require.Len(t, m.content.help.matches, len(helpTopicNames))
+}
+
 // Tests from tui_history_test.go
 
 // TestHistoryNavigation_EmptyHistory tests navigation with no history
@@ -1057,6 +1210,314 @@ func TestHistoryRollback_OnSubmit(t *testing.T) {
 	require.Equal(t, len(model.session.Messages), sessionLenBefore) // Session didn't change in this test
 }
 
+// stallingLLM streams a partial answer to the first prompt and then hangs until cancelled,
+// answering the later prompts right away
+type stallingLLM struct {
+	llms.Model
+	calls   atomic.Int32
+	stalled chan struct{}
+}
+
+func (m *stallingLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := &llms.CallOptions{}
+	for _, opt := range options {
+		opt(opts)
+	}
+	if m.calls.Add(1) == 1 {
+		if opts.StreamingFunc != nil {
+			if err := opts.StreamingFunc(ctx, []byte("partial answer")); err != nil {
+				return nil, err
+			}
+		}
+		close(m.stalled)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	prompt := messages[len(messages)-1].Parts[0].(llms.TextContent).Text
+	reply := "re: " + strings.TrimSpace(prompt)
+	if opts.StreamingFunc != nil {
+		if err := opts.StreamingFunc(ctx, []byte(reply)); err != nil {
+			return nil, err
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: reply}}}, nil
+}
+
+// TestHistoryRollback_DuringStream resubmits a historical prompt while the previous answer is
+// still streaming, which must stop that turn before the history is rolled back
+func TestHistoryRollback_DuringStream(t *testing.T) {
+	llm := &stallingLLM{stalled: make(chan struct{})}
+	model := NewTUIModel(mockConfig(), nil, nil, nil, nil, nil)
+	model.persistentPromptHistory = nil
+	model.initHistory()
+	sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+
+	model.prompt.SetValue("first")
+	next, _ := model.handleEnterKey()
+	updated := next.(TUIModel)
+	model = &updated
+	<-llm.stalled
+	require.True(t, sess.Busy())
+
+	// Navigated back to the first prompt and edited it
+	model.historySaved = true
+	model.historyCursor = 0
+	model.prompt.SetValue("second")
+	next, cmd := model.handleEnterKey()
+	updated = next.(TUIModel)
+	model = &updated
+
+	// The turn is stopped by a command, Update doesn't wait for it
+	require.NotNil(t, cmd)
+	require.True(t, sess.Busy())
+	msg := cmd()
+	require.Equal(t, historyStopMsg{prompt: "second", stopped: true}, msg)
+	next, cmd = model.Update(msg)
+	require.NotNil(t, cmd)
+	submit := cmd()
+	require.Equal(t, SubmitPromptMsg{Prompt: "second"}, submit)
+	next, _ = next.(TUIModel).Update(submit)
+	updated = next.(TUIModel)
+	model = &updated
+
+	select {
+	case <-sess.guard.idle():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resubmitted turn didn't finish")
+	}
+	unlock := sess.guard.lockMessages()
+	defer unlock()
+	require.Len(t, sess.Messages, 3)
+	require.Equal(t, llms.ChatMessageTypeHuman, sess.Messages[1].Role)
+	require.Contains(t, sess.Messages[1].Parts[0].(llms.TextContent).Text, "second")
+	require.Equal(t, "re: second", sess.Messages[2].Parts[0].(llms.TextContent).Text)
+	for _, msg := range sess.Messages[1:] {
+		for _, part := range msg.Parts {
+			if text, ok := part.(llms.TextContent); ok {
+				require.NotContains(t, text.Text, "partial answer")
+				require.NotContains(t, text.Text, "first")
+			}
+		}
+	}
+	require.Len(t, model.sessionPromptHistory, 1)
+}
+
+func TestEmptyEnterAction(t *testing.T) {
+	// submit presses Enter on prompt and returns the user prompts the model got once it answered
+	submit := func(t *testing.T, model *TUIModel, prompt string) []string {
+		model.prompt.SetValue(prompt)
+		next, _ := model.handleEnterKey()
+		*model = next.(TUIModel)
+		sess := model.session
+		var prompts []string
+		require.Eventually(t, func() bool {
+			unlock := sess.guard.lockMessages()
+			defer unlock()
+			prompts = prompts[:0]
+			for _, msg := range sess.Messages {
+				if msg.Role == llms.ChatMessageTypeHuman {
+					prompts = append(prompts, msg.Parts[0].(llms.TextContent).Text)
+				}
+			}
+			return !sess.Busy()
+		}, 5*time.Second, 10*time.Millisecond)
+		return prompts
+	}
+
+	for _, tc := range []struct {
+		action string
+		want   []string
+	}{
+		{"", []string{"fix the login bug"}},
+		{EmptyEnterNoop, []string{"fix the login bug"}},
+		{EmptyEnterRepeat, []string{"fix the login bug", "fix the login bug"}},
+		{EmptyEnterContinue, []string{"fix the login bug", continuePrompt}},
+	} {
+		t.Run(cmp.Or(tc.action, "unset"), func(t *testing.T) {
+			config := mockConfig()
+			config.UI.EmptyEnterAction = tc.action
+			model := NewTUIModel(config, nil, nil, nil, nil, nil)
+			model.persistentPromptHistory = nil
+			model.initHistory()
+			llm := &gatedEchoLLM{gate: make(chan struct{})}
+			close(llm.gate)
+			sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+			require.NoError(t, err)
+			model.SetSession(sess)
+
+			require.Empty(t, submit(t, model, ""), "nothing to repeat or continue before the first prompt")
+			require.Len(t, submit(t, model, "fix the login bug"), 1)
+			require.Equal(t, tc.want, submit(t, model, ""))
+		})
+	}
+}
+
+func TestStreamingOff(t *testing.T) {
+	const reply = "the build passes now"
+	// ask submits a prompt, or sends it like :init does with command, with :stream on or off
+	// and returns the notifications of its turn, applied to the model
+	ask := func(t *testing.T, stream string, command bool) (*TUIModel, []any) {
+		model := NewTUIModel(mockConfig(), nil, nil, nil, nil, nil)
+		model.persistentPromptHistory = nil
+		model.initHistory()
+		notes := make(chan any, 100)
+		sess, err := NewSession(&sessionMockLLM{response: reply}, &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(msg any) { notes <- msg })
+		require.NoError(t, err)
+		model.SetSession(sess)
+		handleStreamCommand(model, []string{stream})
+
+		var next tea.Model
+		if command {
+			next, _ = model.Update(startConversationMsg{prompt: "fix the build"})
+		} else {
+			model.prompt.SetValue("fix the build")
+			next, _ = model.handleEnterKey()
+		}
+		*model = next.(TUIModel)
+		require.True(t, model.waitingForResponse)
+		var received []any
+		for {
+			select {
+			case msg := <-notes:
+				received = append(received, msg)
+				next, _ := model.Update(msg)
+				*model = next.(TUIModel)
+				if _, ok := msg.(streamCompleteMsg); ok {
+					return model, received
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the turn never completed")
+			}
+		}
+	}
+	chunks := func(received []any) []string {
+		var chunks []string
+		for _, msg := range received {
+			if chunk, ok := msg.(streamChunkMsg); ok {
+				chunks = append(chunks, string(chunk))
+			}
+		}
+		return chunks
+	}
+
+	model, received := ask(t, "on", false)
+	require.Greater(t, len(chunks(received)), 1, "streaming shows the reply as it comes")
+
+	_, received = ask(t, "off", true)
+	require.Equal(t, []string{reply}, chunks(received), "commands like :init follow :stream off too")
+
+	model, received = ask(t, "off", false)
+	require.Equal(t, []string{reply}, chunks(received), "the whole reply at once")
+	require.False(t, model.waitingForResponse)
+	require.Equal(t, "Asimi:SUCCESS: "+reply, model.content.Chat.Messages[len(model.content.Chat.Messages)-1])
+
+	require.True(t, NewTUIModel(&Config{UI: defaultConfig().UI}, nil, nil, nil, nil, nil).streaming, "streaming by default")
+	require.Contains(t, handleStreamCommand(model, []string{"maybe"})().(showContextMsg).content, "Usage")
+}
+
+func TestToolApprovalPrompt(t *testing.T) {
+	for _, tc := range []struct {
+		keys []string
+		want ToolApproval
+	}{
+		{[]string{"y", "enter"}, ToolApprovedOnce},
+		{[]string{"a", "enter"}, ToolApprovedAlways},
+		{[]string{"n", "enter"}, ToolDenied},
+		{[]string{"esc"}, ToolDenied},
+	} {
+		t.Run(strings.Join(tc.keys, "+"), func(t *testing.T) {
+			model := newTestModel(t)
+			responses := make(chan ToolApproval, 1)
+			next, _ := model.Update(toolApprovalMsg{request: ToolApprovalRequest{Tool: "write_file", Args: `{"path":"main.go"}`, ResponseChan: responses}})
+			*model = next.(TUIModel)
+			require.True(t, model.commandLine.IsInYesNoMode())
+			require.Contains(t, model.commandLine.View(), `Allow write_file {"path":"main.go"}? (y/n/a)`)
+
+			var cmd tea.Cmd
+			for _, key := range tc.keys {
+				msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+				switch key {
+				case "enter":
+					msg = tea.KeyMsg{Type: tea.KeyEnter}
+				case "esc":
+					msg = tea.KeyMsg{Type: tea.KeyEsc}
+				}
+				cmd, _ = model.commandLine.HandleKey(msg)
+			}
+			for _, msg := range cmd().(tea.BatchMsg) {
+				next, _ := model.Update(msg())
+				*model = next.(TUIModel)
+			}
+			require.Equal(t, tc.want, <-responses)
+			require.Nil(t, model.pendingToolApproval)
+		})
+	}
+}
+
+// TestFirstPrompt_SkipsCommands labels a session started with :init by the first prompt the user typed
+func TestFirstPrompt_SkipsCommands(t *testing.T) {
+	tempDir := t.TempDir()
+	model := NewTUIModel(mockConfig(), nil, nil, nil, nil, nil)
+	model.persistentPromptHistory = nil
+	model.initHistory()
+	llm := &gatedEchoLLM{gate: make(chan struct{})}
+	close(llm.gate)
+	sess, err := NewSession(llm, &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	model.SetSession(sess)
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+	store, err := NewSessionStore(db, RepoInfo{ProjectRoot: tempDir}, 50, 30)
+	require.NoError(t, err)
+	defer store.Close()
+
+	humanMessages := func(n int) {
+		require.Eventually(t, func() bool {
+			unlock := sess.guard.lockMessages()
+			defer unlock()
+			count := 0
+			for _, msg := range sess.Messages {
+				if msg.Role == llms.ChatMessageTypeHuman {
+					count++
+				}
+			}
+			return count == n && !sess.Busy()
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// :init sends its own prompt, which doesn't label the session
+	next, _ := model.Update(startConversationMsg{prompt: "Analyze the project and write AGENTS.md", clearHistory: true})
+	updated := next.(TUIModel)
+	model = &updated
+	humanMessages(1)
+	require.NoError(t, store.SaveSessionSync(sess))
+	require.Empty(t, sess.FirstPrompt)
+
+	model.prompt.SetValue("fix the login bug")
+	next, _ = model.handleEnterKey()
+	updated = next.(TUIModel)
+	model = &updated
+	humanMessages(2)
+	require.Equal(t, "fix the login bug", sess.FirstPrompt)
+
+	require.NoError(t, store.SaveSessionSync(sess))
+	loaded, err := store.LoadSession(sess.ID)
+	require.NoError(t, err)
+	require.Equal(t, "fix the login bug", loaded.FirstPrompt)
+
+	// Saving backfills a missing label the same way, past the command prompt and the context files
+	sess.FirstPrompt = ""
+	last := slices.IndexFunc(sess.Messages, func(msg llms.MessageContent) bool {
+		return msg.Role == llms.ChatMessageTypeHuman && strings.Contains(msg.Parts[0].(llms.TextContent).Text, "login")
+	})
+	sess.Messages[last].Parts = []llms.ContentPart{llms.TextPart("--- Context from: main.go ---\npackage main\n--- End of Context from: main.go ---\nfix the login bug")}
+	require.NoError(t, store.SaveSessionSync(sess))
+	require.Equal(t, "fix the login bug", sess.FirstPrompt)
+}
+
 // TestNewSessionCommand_ResetsHistory tests that /new command resets history
 func TestNewSessionCommand_ResetsHistory(t *testing.T) {
 	model := newTestModel(t)
@@ -1292,6 +1753,33 @@ func TestStatusComponent_WaitingIndicatorView(t *testing.T) {
 	require.Contains(t, middleSection, "5s", "Middle section should show elapsed time")
 }
 
+// TestStatusComponent_ContextGauge tests the context gauge follows the session's usage and colors
+func TestStatusComponent_ContextGauge(t *testing.T) {
+	status := NewStatusComponent(200)
+	sess, err := NewSession(&mockLLMNoTools{}, &Config{}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	status.SetSession(sess)
+
+	setUsage := func(percent int) {
+		sess.systemPromptTokens, sess.systemToolsTokens, sess.memoryFilesTokens = 0, 0, 0
+		sess.messagesTokens = sess.GetContextInfo().TotalTokens * percent / 100
+	}
+
+	setUsage(62)
+	require.Contains(t, ansi.Strip(status.renderMiddleSection()), "🪣 62%")
+	status.SetContextGauge(true)
+	require.Contains(t, ansi.Strip(status.renderMiddleSection()), "[███░░] 62%")
+
+	setUsage(10)
+	require.Contains(t, ansi.Strip(status.renderMiddleSection()), "[█░░░░] 10%")
+	require.Equal(t, globalTheme.Success, contextGaugeColor(sess.GetContextUsagePercent()))
+	setUsage(62)
+	require.Equal(t, globalTheme.Warning, contextGaugeColor(sess.GetContextUsagePercent()))
+	setUsage(90)
+	require.Contains(t, ansi.Strip(status.renderMiddleSection()), "[█████] 90%")
+	require.Equal(t, globalTheme.Error, contextGaugeColor(sess.GetContextUsagePercent()))
+}
+
 // TestEscapeDuringStreaming_StopsWaiting tests that ESC during streaming stops waiting
 func TestEscapeDuringStreaming_StopsWaiting(t *testing.T) {
 	model := newTestModel(t)
@@ -1342,6 +1830,18 @@ func TestStreamChunkMsg_StopsWaiting(t *testing.T) {
 	require.True(t, updatedModel.waitingStart.After(initialWaitStart), "Waiting timer should be reset when chunk arrives")
 }
 
+// TestStreamDiscardedMsg_DropsPartialAnswer tests that the chunks of a failed model are dropped
+func TestStreamDiscardedMsg_DropsPartialAnswer(t *testing.T) {
+	model := newTestModel(t)
+	for _, msg := range []tea.Msg{streamChunkMsg("Half "), streamChunkMsg("an ans"), streamDiscardedMsg("Half an ans"), streamChunkMsg("The answer")} {
+		newModel, _ := model.handleCustomMessages(msg)
+		updated := newModel.(TUIModel)
+		model = &updated
+	}
+	messages := model.content.Chat.Messages
+	require.Equal(t, "Asimi: The answer", messages[len(messages)-1])
+}
+
 // TestStreamCompleteMsg_StopsWaiting tests that stream completion stops waiting
 func TestStreamCompleteMsg_StopsWaiting(t *testing.T) {
 	model := newTestModel(t)
@@ -1682,7 +2182,7 @@ func TestYesNoModePriority(t *testing.T) {
 	cl.SetWidth(80)
 
 	// Add a toast
-	cl.AddToast("Test toast", "info", 5000)
+	cl.AddToast("Test toast", "info", time.Second*5)
 
 	// Enter yes/no mode
 	cl.EnterYesNoMode("Confirm?")
@@ -1693,6 +2193,89 @@ func TestYesNoModePriority(t *testing.T) {
 	require.NotContains(t, view, "Test toast", "Expected toast to be hidden when in yes/no mode")
 }
 
+func TestShellCommandConfirmation(t *testing.T) {
+	restore := setShellRunnerForTesting(NewTestShellRunner())
+	defer restore()
+
+	model := newTestModel(t)
+	model.config.Tools.ConfirmShell = true
+	chatLen := len(model.content.Chat.Messages)
+
+	updated, cmd := model.Update(commandReadyMsg{command: "!rm -rf foo"})
+	m := updated.(TUIModel)
+	require.NotNil(t, cmd)
+	require.True(t, m.commandLine.IsInYesNoMode(), "Expected confirmation prompt")
+	require.Contains(t, m.commandLine.yesNoQuestion, "rm -rf foo")
+	require.Equal(t, "!rm -rf foo", m.pendingShellCommand)
+	require.Len(t, m.content.Chat.Messages, chatLen, "Command should not run before confirmation")
+
+	// Denying cancels the command
+	m.commandLine.ExitYesNoMode()
+	updated, cmd = m.Update(yesNoResponseMsg{answer: false})
+	m = updated.(TUIModel)
+	require.Nil(t, cmd)
+	require.Empty(t, m.pendingShellCommand)
+	require.Len(t, m.content.Chat.Messages, chatLen)
+	require.Equal(t, "Shell command cancelled", m.commandLine.toasts[len(m.commandLine.toasts)-1].Message)
+
+	// Approving runs the command
+	updated, _ = m.Update(commandReadyMsg{command: "!echo confirmed"})
+	m = updated.(TUIModel)
+	m.commandLine.ExitYesNoMode()
+	updated, cmd = m.Update(yesNoResponseMsg{answer: true})
+	m = updated.(TUIModel)
+	require.NotNil(t, cmd)
+	require.Contains(t, m.content.Chat.Messages[len(m.content.Chat.Messages)-1], "echo confirmed")
+	result, ok := cmd().(shellCommandResultMsg)
+	require.True(t, ok)
+	require.Contains(t, result.output, "confirmed")
+}
+
+func TestToolApprovalWaitsForOpenDialog(t *testing.T) {
+	model := newTestModel(t)
+	model.pendingCompact = true
+	model.commandLine.EnterYesNoMode("Compact the conversation?")
+
+	// The approval waits while the :compact confirmation is open
+	responses := make(chan ToolApproval, 1)
+	updated, cmd := model.Update(toolApprovalMsg{request: ToolApprovalRequest{Tool: "write_file", Args: "{}", ResponseChan: responses}})
+	m := updated.(TUIModel)
+	require.Nil(t, cmd)
+	require.Nil(t, m.pendingToolApproval)
+	require.Contains(t, m.commandLine.yesNoQuestion, "Compact the conversation?")
+
+	// Its answer goes to :compact, and the approval is asked next
+	m.commandLine.ExitYesNoMode()
+	updated, cmd = m.Update(yesNoResponseMsg{answer: true})
+	m = updated.(TUIModel)
+	require.False(t, m.pendingCompact)
+	require.Empty(t, responses)
+	var approval tea.Msg
+	for _, next := range cmd().(tea.BatchMsg) {
+		if msg := next(); msg != nil {
+			if _, ok := msg.(toolApprovalMsg); ok {
+				approval = msg
+			} else {
+				require.IsType(t, compactConversationMsg{}, msg)
+			}
+		}
+	}
+	require.NotNil(t, approval)
+
+	updated, _ = m.Update(approval)
+	m = updated.(TUIModel)
+	require.Contains(t, m.commandLine.yesNoQuestion, "Allow write_file")
+	m.commandLine.ExitYesNoMode()
+	updated, _ = m.Update(yesNoResponseMsg{answer: false})
+	m = updated.(TUIModel)
+	require.Equal(t, ToolDenied, <-responses)
+	require.False(t, m.askingYesNo())
+
+	// An answer nothing waits for doesn't start an update
+	_, cmd = m.Update(yesNoResponseMsg{answer: true})
+	require.Nil(t, cmd)
+}
+
 // Tests from main_branch_test.go
 
 func TestIsMainBranch(t *testing.T) {
@@ -1853,3 +2436,525 @@ func TestIsModelSelectable(t *testing.T) {
 		})
 	}
 }
+
+func TestCompletionNotification(t *testing.T) {
+	var out bytes.Buffer
+	orig := notifyWriter
+	notifyWriter = &out
+	defer func() { notifyWriter = orig }()
+
+	complete := func(m *TUIModel) {
+		_, cmd := m.handleCustomMessages(streamCompleteMsg{})
+		if cmd == nil {
+			return
+		}
+		if batch, ok := cmd().(tea.BatchMsg); ok {
+			for _, c := range batch {
+				if c != nil {
+					c()
+				}
+			}
+		}
+	}
+
+	model := newTestModel(t)
+	model.config.UI.Notify = "osc777"
+	complete(model)
+	require.Equal(t, "\x1b]777;notify;Asimi;Response complete\x07", out.String())
+
+	// Recent input means the user is watching
+	out.Reset()
+	model.lastInputTime = time.Now()
+	require.Nil(t, model.completionNotifyCmd(time.Now()))
+
+	// Quiet hours suppress the notification
+	model.lastInputTime = time.Time{}
+	now := time.Now()
+	model.config.UI.QuietHours = now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")
+	model.config.UI.Notify = "bell"
+	require.Nil(t, model.completionNotifyCmd(now))
+	complete(model)
+	require.Empty(t, out.String())
+
+	model.config.UI.QuietHours = ""
+	complete(model)
+	require.Equal(t, "\a", out.String())
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2025, 1, 1, h, m, 0, 0, time.Local) }
+	require.True(t, inQuietHours("22:00-07:00", at(23, 30)))
+	require.True(t, inQuietHours("22:00-07:00", at(6, 59)))
+	require.False(t, inQuietHours("22:00-07:00", at(7, 0)))
+	require.True(t, inQuietHours("12:00-13:00", at(12, 15)))
+	require.False(t, inQuietHours("12:00-13:00", at(14, 0)))
+	require.False(t, inQuietHours("", at(12, 0)))
+	require.False(t, inQuietHours("noon", at(12, 0)))
+}
+
+func TestRecoverFromPanicSavesSessionAndLogs(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := storage.InitDB(filepath.Join(tempDir, "asimi.sqlite"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	repoInfo := repoInfoWithProjectRoot(t)
+	store, err := NewSessionStore(db, repoInfo, 50, 30)
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(previous)
+	defer func() { tuiCrash, tuiCrashSaved, tuiCrashSaveErr = nil, false, nil }()
+
+	model := newTestModel(t)
+	model.sessionStore = store
+	model.session = &Session{
+		ID:          "crash-session",
+		CreatedAt:   time.Now(),
+		LastUpdated: time.Now(),
+		FirstPrompt: "before the crash",
+		WorkingDir:  repoInfo.ProjectRoot,
+		Messages: []llms.MessageContent{
+			llms.TextParts(llms.ChatMessageTypeHuman, "before the crash"),
+			llms.TextParts(llms.ChatMessageTypeAI, "working on it"),
+		},
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				model.recoverFromPanic(r)
+			}
+		}()
+		panic("boom")
+	}()
+
+	assert.Equal(t, "boom", tuiCrash)
+	assert.Contains(t, logs.String(), "recovered from panic in TUI")
+	assert.Contains(t, logs.String(), "boom")
+	assert.Contains(t, logs.String(), "goroutine")
+
+	saved, err := store.LoadSession("crash-session")
+	require.NoError(t, err)
+	assert.Equal(t, "before the crash", saved.FirstPrompt)
+	assert.Contains(t, crashError("asimi.log").Error(), "The session was saved")
+}
+
+func TestCrashErrorReportsFailedSave(t *testing.T) {
+	defer func() { tuiCrash, tuiCrashSaved, tuiCrashSaveErr = nil, false, nil }()
+	tuiCrash = "boom"
+
+	assert.Equal(t, "asimi crashed: boom. The stack trace was logged to asimi.log", crashError("asimi.log").Error())
+
+	tuiCrashSaveErr = errors.New("disk full")
+	msg := crashError("asimi.log").Error()
+	assert.Contains(t, msg, "Saving the session failed: disk full")
+	assert.NotContains(t, msg, "was saved")
+}
+
+func TestQuietAutoCompactKeepsChatClean(t *testing.T) {
+	for _, quiet := range []bool{false, true} {
+		model := newTestModel(t)
+		model.config.UI.QuietAutoCompact = quiet
+		sess, err := NewSession(fake.NewFakeLLM([]string{"summary of the work so far"}), &Config{LLM: LLMConfig{Provider: "fake"}}, RepoInfo{}, func(any) {})
+		require.NoError(t, err)
+		sess.Messages = append(sess.Messages,
+			llms.TextParts(llms.ChatMessageTypeHuman, "first"),
+			llms.TextParts(llms.ChatMessageTypeAI, "reply"))
+		model.SetSession(sess)
+		// Pretend the history nearly fills the context window
+		sess.messagesTokens = sess.GetContextInfo().TotalTokens
+		chatBefore := len(model.content.Chat.Messages)
+
+		model.autoCompactIfNeeded()
+
+		require.Len(t, sess.Messages, 3, "compaction should replace history with the summary")
+		assert.Contains(t, sess.Messages[1].Parts[0].(llms.TextContent).Text, "summary of the work so far")
+		if quiet {
+			assert.Len(t, model.content.Chat.Messages, chatBefore)
+			assert.Contains(t, model.status.View(), "🗜️")
+		} else {
+			assert.Len(t, model.content.Chat.Messages, chatBefore+2)
+			assert.NotContains(t, model.status.View(), "🗜️")
+		}
+	}
+}
+
+func TestRefusalHint(t *testing.T) {
+	t.Chdir(t.TempDir())
+	model := newTestModel(t)
+	reply := func(text string) []string {
+		model.session.Messages = append(model.session.Messages,
+			llms.TextParts(llms.ChatMessageTypeHuman, "run the tests"),
+			llms.TextParts(llms.ChatMessageTypeAI, text))
+		model.commandLine.toasts = nil
+		updated, _ := model.Update(streamCompleteMsg{})
+		*model = updated.(TUIModel)
+		var messages []string
+		for _, toast := range model.commandLine.toasts {
+			messages = append(messages, toast.Message)
+		}
+		return messages
+	}
+
+	model.session.SetPlanMode(true)
+	toasts := reply("Sorry, I can't run commands in this mode, here is the plan instead.")
+	require.Len(t, toasts, 1)
+	require.Contains(t, toasts[0], "run_in_shell")
+	require.Contains(t, toasts[0], ":act")
+
+	require.Empty(t, reply("I cannot read your files right now."), "read_file is available in plan mode")
+	require.Empty(t, reply("All tests pass."))
+
+	model.session.SetPlanMode(false)
+	require.Empty(t, reply("I'm unable to run commands here."), "run_in_shell is available")
+
+	// Without the tool the hint names the persona only when there is one
+	var err error
+	model.session.toolDefs, model.session.toolCatalog, err = restrictTools(model.session.toolDefs, model.session.toolCatalog, []string{"read_file"})
+	require.NoError(t, err)
+	toasts = reply("I can't browse the web, so I don't know the latest release.")
+	require.Equal(t, []string{"Tip: web_fetch isn't among the tools of this session"}, toasts)
+	model.session.persona = "reviewer"
+	toasts = reply("I'm not able to open URLs.")
+	require.Equal(t, []string{"Tip: the reviewer persona leaves out web_fetch, switch with :persona"}, toasts)
+}
+
+func TestAutoSaveCommandPausesSaving(t *testing.T) {
+	t.Chdir(t.TempDir())
+	model := newTestModel(t)
+	model.config.Session.Enabled = true
+	model.config.Session.AutoSave = true
+	store := &SessionStore{saveChan: make(chan *Session, 10)}
+	model.sessionStore = store
+	model.session.Messages = append(model.session.Messages, llms.TextParts(llms.ChatMessageTypeHuman, "hi"))
+
+	completeStream := func() {
+		updated, _ := model.Update(streamCompleteMsg{})
+		*model = updated.(TUIModel)
+	}
+	run := func(args ...string) string {
+		msg := handleAutoSaveCommand(model, args)()
+		return msg.(showContextMsg).content
+	}
+
+	completeStream()
+	require.Len(t, store.saveChan, 1)
+
+	require.Contains(t, run("off"), "Auto-save paused for this run")
+	require.False(t, model.config.Session.AutoSave)
+	require.Contains(t, model.status.View(), "NOSAVE")
+	completeStream()
+	require.Len(t, store.saveChan, 1, "no save is queued while auto-save is off")
+	require.NoFileExists(t, filepath.Join(".agents", "asimi.conf"), "the setting isn't persisted unless asked")
+
+	// Resuming saves right away and after each turn again
+	require.Contains(t, run("on", "save"), "saved to .agents/asimi.conf")
+	require.Len(t, store.saveChan, 2)
+	require.NotContains(t, model.status.View(), "NOSAVE")
+	completeStream()
+	require.Len(t, store.saveChan, 3)
+	conf, err := os.ReadFile(filepath.Join(".agents", "asimi.conf"))
+	require.NoError(t, err)
+	require.Contains(t, string(conf), `auto_save = "true"`)
+
+	require.Contains(t, run("maybe"), "Usage")
+}
+
+func TestLoopCommandReset(t *testing.T) {
+	model := newTestModel(t)
+	run := func(args ...string) string {
+		msg := handleLoopCommand(model, args)()
+		return msg.(showContextMsg).content
+	}
+
+	require.Contains(t, run(), "No repeated tool calls")
+	model.session.checkToolCallLoop("read_file", `{"path":"a.go"}`)
+	model.session.checkToolCallLoop("read_file", `{"path":"a.go"}`)
+	require.Contains(t, run(), "repeated 2/3 times")
+
+	require.Contains(t, run("reset"), "reset")
+	key, count := model.session.ToolLoopState()
+	require.Empty(t, key)
+	require.Zero(t, count)
+
+	require.Contains(t, run("clear"), "Usage")
+}
+
+func TestRawFilter(t *testing.T) {
+	model := newTestModel(t)
+	model.content.Chat.AddToRawHistory("STREAM_CHUNK", "partial reply")
+	model.content.Chat.AddToRawHistory("TOOL_CALL", "read_file")
+	model.content.Chat.AddToRawHistory("TOOL_SUCCESS", "file content")
+
+	require.Nil(t, handleRawCommand(model, []string{"filter", "tool_*"}))
+	require.True(t, model.rawMode)
+	require.Equal(t, []string{"TOOL"}, model.rawFilter)
+
+	view := ansi.Strip(model.renderRawSessionView(80, 50))
+	require.Contains(t, view, "TOOL_CALL")
+	require.Contains(t, view, "TOOL_SUCCESS")
+	require.NotContains(t, view, "partial reply")
+	require.Contains(t, view, "STREAM_CHUNK 1", "the legend still counts the hidden entries")
+	require.Contains(t, view, "Showing TOOL")
+
+	handleRawCommand(model, []string{"filter"})
+	require.Empty(t, model.rawFilter)
+	require.Contains(t, ansi.Strip(model.renderRawSessionView(80, 50)), "partial reply")
+
+	handleRawCommand(model, nil)
+	require.False(t, model.rawMode)
+	require.NotNil(t, handleRawCommand(model, []string{"clear"}))
+}
+
+func TestWrapModeLongLines(t *testing.T) {
+	long := "start-" + strings.Repeat("x", 100) + "-end"
+
+	chat := NewChatComponent(40, 100, false)
+	chat.AddMessage("Asimi: " + long)
+	view := ansi.Strip(chat.Viewport.View())
+	require.Contains(t, view, "start-")
+	require.Contains(t, view, "-end", "long words are broken, not cut off")
+	for _, line := range strings.Split(view, "\n") {
+		require.LessOrEqual(t, ansi.StringWidth(line), 40)
+	}
+
+	chat.WrapMode = WrapModeScroll
+	chat.UpdateContent()
+	view = ansi.Strip(chat.Viewport.View())
+	require.Contains(t, view, "start-")
+	require.NotContains(t, view, "-end")
+	chat.ScrollRight(200)
+	view = ansi.Strip(chat.Viewport.View())
+	require.Contains(t, view, "-end")
+	require.NotContains(t, view, "start-")
+
+	// The raw session view follows the same mode
+	config := mockConfig()
+	for _, mode := range []string{WrapModeWrap, WrapModeScroll} {
+		config.UI.WrapMode = mode
+		model := NewTUIModel(config, nil, nil, nil, nil, nil)
+		model.width = 40
+		model.rawMode = true
+		model.Mode = "scroll"
+		model.content.Chat.AddToRawHistory("USER", long)
+
+		raw := ansi.Strip(model.renderRawSessionView(40, 50))
+		require.Contains(t, raw, "start-", mode)
+		if mode == WrapModeWrap {
+			require.Contains(t, raw, "-end")
+			_, _, handled := model.handleScrollModeKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("l")})
+			require.False(t, handled, "nothing to scroll when wrapping")
+			continue
+		}
+		require.NotContains(t, raw, "-end")
+		updated, _, handled := model.handleScrollModeKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("l")})
+		require.True(t, handled)
+		for range 20 {
+			updated, _, _ = updated.(TUIModel).handleScrollModeKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("l")})
+		}
+		raw = ansi.Strip(updated.(TUIModel).renderRawSessionView(40, 50))
+		require.Contains(t, raw, "-end")
+		require.NotContains(t, raw, "start-")
+	}
+}
+
+func TestStartModeNormal(t *testing.T) {
+	config := mockConfig()
+	config.UI.StartMode = "normal"
+	model := NewTUIModel(config, nil, nil, nil, nil, nil)
+
+	require.Equal(t, ViModeNormal, model.Mode)
+	require.True(t, model.prompt.IsViNormalMode())
+	require.Equal(t, "NORMAL", model.status.mode)
+
+	// i still enters insert mode
+	updated, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("i")})
+	updated, _ = updated.(TUIModel).Update(cmd())
+	require.Equal(t, ViModeInsert, updated.(TUIModel).Mode)
+
+	// Insert remains the default
+	model = NewTUIModel(mockConfig(), nil, nil, nil, nil, nil)
+	require.Equal(t, ViModeInsert, model.Mode)
+	require.True(t, model.prompt.IsViInsertMode())
+}
+
+func TestStreamCoalescer(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []any
+		done     = make(chan struct{})
+	)
+	// A UI that takes a while with each message
+	coalescer := newStreamCoalescer(func(msg any) {
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		received = append(received, msg)
+		mu.Unlock()
+		if _, ok := msg.(streamCompleteMsg); ok {
+			close(done)
+		}
+	})
+
+	var want strings.Builder
+	coalescer.Notify(streamStartMsg{})
+	for i := range 500 {
+		chunk := fmt.Sprintf("chunk %d ", i)
+		want.WriteString(chunk)
+		coalescer.Notify(streamChunkMsg(chunk))
+	}
+	coalescer.Notify(streamCompleteMsg{})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream was not flushed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.IsType(t, streamStartMsg{}, received[0])
+	require.IsType(t, streamCompleteMsg{}, received[len(received)-1])
+	var got strings.Builder
+	for _, msg := range received[1 : len(received)-1] {
+		chunk, ok := msg.(streamChunkMsg)
+		require.True(t, ok, "only chunks between start and complete, got %T", msg)
+		got.WriteString(string(chunk))
+	}
+	require.Equal(t, want.String(), got.String(), "merging keeps the content and its order")
+	require.Less(t, len(received), 100, "chunks are merged while the UI is behind")
+}
+
+func TestStreamCoalescerCapsToolOutput(t *testing.T) {
+	release := make(chan struct{})
+	var received []any
+	done := make(chan struct{})
+	coalescer := newStreamCoalescer(func(msg any) {
+		<-release // a UI stuck in Update
+		received = append(received, msg)
+		if _, ok := msg.(toolApprovalMsg); ok {
+			close(done)
+		}
+	})
+
+	call := &ToolCall{ID: "1", Tool: RunInShell{}}
+	coalescer.Notify(ToolCallExecutingMsg{Call: call})
+	for i := range 3 * maxQueuedMessages {
+		coalescer.Notify(ToolCallOutputChunkMsg{Call: call, Chunk: fmt.Sprintf("line %d", i)})
+	}
+	coalescer.Notify(ToolCallSuccessMsg{Call: call})
+	coalescer.Notify(toolApprovalMsg{request: ToolApprovalRequest{Tool: "write_file"}})
+	coalescer.mu.Lock()
+	queued := len(coalescer.queue)
+	coalescer.mu.Unlock()
+	require.LessOrEqual(t, queued, maxQueuedMessages+2)
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queue was not flushed")
+	}
+	// Other messages are never dropped and keep their order
+	require.IsType(t, ToolCallExecutingMsg{}, received[0])
+	require.IsType(t, ToolCallSuccessMsg{}, received[len(received)-2])
+	require.IsType(t, toolApprovalMsg{}, received[len(received)-1])
+}
+
+func TestLogView(t *testing.T) {
+	model := newTestModel(t)
+	model.width, model.height = 100, 40
+	model.content.Chat.AddToRawHistory("STREAM_CHUNK", "partial reply")
+
+	require.Nil(t, handleLogViewCommand(model, nil))
+	require.True(t, model.logView)
+	view := ansi.Strip(model.renderAgentLogView(100, 30))
+	require.Contains(t, view, "No tool calls yet")
+	require.NotContains(t, view, "partial reply")
+
+	call := &ToolCall{ID: "1", Tool: ReadFileTool{}, Input: `{"path":"main.go"}`, Result: "package main"}
+	updated, _ := model.Update(ToolCallScheduledMsg{Call: call})
+	m := updated.(TUIModel)
+	require.Contains(t, ansi.Strip(m.View()), "TOOL_SCHEDULED")
+	require.Contains(t, ansi.Strip(m.View()), "read_file")
+
+	updated, _ = m.Update(ToolCallSuccessMsg{Call: call})
+	m = updated.(TUIModel)
+	view = ansi.Strip(m.View())
+	require.Contains(t, view, "TOOL_SUCCESS")
+	require.NotContains(t, view, "package main", "the log shows only the first line of an entry")
+
+	require.Nil(t, handleQuitCommand(&m, nil))
+	require.False(t, m.logView)
+}
+
+func TestToolOutputRenderedOncePerFrame(t *testing.T) {
+	model := newTestModel(t)
+	call := &ToolCall{ID: "1", Tool: RunInShell{}, Input: `{"command":"make"}`}
+	updated, _ := model.Update(ToolCallScheduledMsg{Call: call})
+	m := updated.(TUIModel)
+
+	updated, cmd := m.Update(ToolCallOutputChunkMsg{Call: call, Chunk: "compiling one"})
+	m = updated.(TUIModel)
+	require.NotNil(t, cmd, "the first chunk schedules a render")
+	updated, cmd = m.Update(ToolCallOutputChunkMsg{Call: call, Chunk: "compiling two"})
+	m = updated.(TUIModel)
+	require.Nil(t, cmd, "later chunks wait for the scheduled render")
+	require.NotContains(t, ansi.Strip(m.content.Chat.Viewport.View()), "compiling")
+
+	updated, _ = m.Update(toolOutputTickMsg{})
+	m = updated.(TUIModel)
+	view := ansi.Strip(m.content.Chat.Viewport.View())
+	require.Contains(t, view, "compiling one")
+	require.Contains(t, view, "compiling two")
+
+	_, cmd = m.Update(ToolCallOutputChunkMsg{Call: call, Chunk: "linking"})
+	require.NotNil(t, cmd, "output after a render schedules the next one")
+}
+
+func TestLearningTarget(t *testing.T) {
+	t.Chdir(t.TempDir())
+	note := func(m TUIModel, text string) TUIModel {
+		m.Mode = "learning"
+		m.prompt.SetValue(text)
+		updated, _ := m.handleEnterKey()
+		return updated.(TUIModel)
+	}
+
+	// By default notes are appended to the agents file
+	model := newTestModel(t)
+	m := note(*model, "# We use snake_case")
+	data, err := os.ReadFile("AGENTS.md")
+	require.NoError(t, err)
+	require.Equal(t, "\nWe use snake_case\n", string(data))
+
+	// A configured file, with categorized notes under their sections
+	m.config.Session.LearningTarget = "NOTES.md"
+	require.NoError(t, os.WriteFile("NOTES.md", []byte("# Notes\n\n## TODO\n\n- write docs\n\n## Other\n\nkeep this\n"), 0o644))
+	m = note(m, "#todo: cover the error paths")
+	m = note(m, "#Bug: resume drops the persona")
+	m = note(m, "#plain note")
+	data, err = os.ReadFile("NOTES.md")
+	require.NoError(t, err)
+	require.Equal(t, "# Notes\n\n## TODO\n\n- write docs\n- cover the error paths\n\n## Other\n\nkeep this\n\n## Bugs\n\n- resume drops the persona\n\nplain note\n", string(data))
+	require.Contains(t, m.commandLine.toasts[len(m.commandLine.toasts)-2].Message, "Added to NOTES.md, Bugs")
+	data, err = os.ReadFile("AGENTS.md")
+	require.NoError(t, err)
+	require.Equal(t, "\nWe use snake_case\n", string(data), "the agents file is left alone")
+
+	// The project memory keeps notes under their category
+	memory := useTestProjectMemory(t)
+	m.config.Session.LearningTarget = "memory"
+	m = note(m, "#todo: bump the deps")
+	m = note(m, "#todo: and the vendor dir")
+	entries, err := memory.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, e := range entries {
+		require.True(t, strings.HasPrefix(e.Key, "todo-"), e.Key)
+	}
+	require.ElementsMatch(t, []string{"bump the deps", "and the vendor dir"}, []string{entries[0].Value, entries[1].Value})
+	require.Equal(t, "normal", m.prompt.ViCurrentMode)
+}
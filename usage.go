@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// modelPrice is what a model costs in USD per million tokens
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// modelPrices are the list prices of the models, matched by their longest known prefix like
+// modelMaxOutputTokens. Cached input is counted at the input price, so costs err high.
+var modelPrices = map[string]modelPrice{
+	// OpenAI
+	"gpt-3.5-turbo": {0.5, 1.5},
+	"gpt-4":         {30, 60},
+	"gpt-4-turbo":   {10, 30},
+	"gpt-4o":        {2.5, 10},
+	"gpt-4o-mini":   {0.15, 0.6},
+	"gpt-4.1":       {2, 8},
+	"gpt-4.1-mini":  {0.4, 1.6},
+	"gpt-4.1-nano":  {0.1, 0.4},
+	"gpt-5":         {1.25, 10},
+	"gpt-5-mini":    {0.25, 2},
+	"gpt-5-nano":    {0.05, 0.4},
+	"o1":            {15, 60},
+	"o3":            {2, 8},
+	"o4-mini":       {1.1, 4.4},
+
+	// Anthropic
+	"claude-3-opus":     {15, 75},
+	"claude-3-sonnet":   {3, 15},
+	"claude-3-haiku":    {0.25, 1.25},
+	"claude-3-5-sonnet": {3, 15},
+	"claude-3-5-haiku":  {0.8, 4},
+	"claude-3-7-sonnet": {3, 15},
+	"claude-sonnet-4":   {3, 15},
+	"claude-haiku-4":    {1, 5},
+	"claude-opus-4":     {15, 75},
+	"claude-opus-4-5":   {5, 25},
+
+	// Google
+	"gemini-1.5-flash": {0.075, 0.3},
+	"gemini-1.5-pro":   {1.25, 5},
+	"gemini-2.0-flash": {0.1, 0.4},
+	"gemini-2.5-flash": {0.3, 2.5},
+	"gemini-2.5-pro":   {1.25, 10},
+}
+
+// lookupModelPrice returns the price of a model, false when it isn't in modelPrices
+func lookupModelPrice(model string) (modelPrice, bool) {
+	model = strings.ToLower(model)
+	best, price := "", modelPrice{}
+	for prefix, p := range modelPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, price = prefix, p
+		}
+	}
+	return price, best != ""
+}
+
+// TokenUsage counts the tokens of model requests and what they cost. Unpriced is set when
+// some of the tokens were used by a model missing from modelPrices, so Cost falls short.
+type TokenUsage struct {
+	Input    int
+	Output   int
+	Cost     float64
+	Unpriced bool
+}
+
+func (u *TokenUsage) add(other TokenUsage) {
+	u.Input += other.Input
+	u.Output += other.Output
+	u.Cost += other.Cost
+	u.Unpriced = u.Unpriced || other.Unpriced
+}
+
+// TurnUsage is the usage of the requests answering one prompt
+type TurnUsage struct {
+	Prompt   string
+	Requests int
+	TokenUsage
+}
+
+// usageLog guards the usage of each turn, written by the streaming goroutine
+type usageLog struct {
+	mu    sync.Mutex
+	turns []TurnUsage
+}
+
+// usageKeys are the GenerationInfo keys of the input and output token counts, Anthropic's
+// then the OpenAI ones Ollama and Google AI report too
+var usageKeys = [][2]string{
+	{"InputTokens", "OutputTokens"},
+	{"PromptTokens", "CompletionTokens"},
+}
+
+// responseUsage reads the token counts the provider reported with a response, false when it
+// reported none
+func responseUsage(resp *llms.ContentResponse) (input, output int, ok bool) {
+	if resp == nil || len(resp.Choices) == 0 {
+		return 0, 0, false
+	}
+	info := resp.Choices[0].GenerationInfo
+	for _, keys := range usageKeys {
+		in, inOK := intValue(info[keys[0]])
+		out, outOK := intValue(info[keys[1]])
+		if inOK || outOK {
+			// Anthropic counts the cached part of the prompt apart
+			for _, key := range []string{"CacheCreationInputTokens", "CacheReadInputTokens"} {
+				if cached, ok := intValue(info[key]); ok {
+					in += cached
+				}
+			}
+			return in, out, in+out > 0
+		}
+	}
+	return 0, 0, false
+}
+
+func intValue(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// beginTurnUsage starts counting the usage of the turn answering prompt
+func (s *Session) beginTurnUsage(prompt string) {
+	if s.usage == nil {
+		return
+	}
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	s.usage.turns = append(s.usage.turns, TurnUsage{Prompt: prompt})
+}
+
+// recordUsage adds the tokens of a response of model to the current turn
+func (s *Session) recordUsage(model string, resp *llms.ContentResponse) {
+	input, output, ok := responseUsage(resp)
+	if s.usage == nil || !ok {
+		return
+	}
+	usage := TokenUsage{Input: input, Output: output}
+	if price, priced := lookupModelPrice(model); priced {
+		usage.Cost = (float64(input)*price.Input + float64(output)*price.Output) / 1_000_000
+	} else {
+		usage.Unpriced = true
+	}
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	if len(s.usage.turns) == 0 {
+		s.usage.turns = append(s.usage.turns, TurnUsage{})
+	}
+	turn := &s.usage.turns[len(s.usage.turns)-1]
+	turn.Requests++
+	turn.add(usage)
+}
+
+// addSubagentUsage adds the requests of a dispatch_agent subagent to the current turn
+func (s *Session) addSubagentUsage(child *Session) {
+	if s.usage == nil {
+		return
+	}
+	var requests int
+	for _, turn := range child.TurnUsages() {
+		requests += turn.Requests
+	}
+	usage := child.TotalUsage()
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	if len(s.usage.turns) == 0 {
+		s.usage.turns = append(s.usage.turns, TurnUsage{})
+	}
+	turn := &s.usage.turns[len(s.usage.turns)-1]
+	turn.Requests += requests
+	turn.add(usage)
+}
+
+// TurnUsages returns the usage of each turn of the session, oldest first
+func (s *Session) TurnUsages() []TurnUsage {
+	if s.usage == nil {
+		return nil
+	}
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	return append([]TurnUsage(nil), s.usage.turns...)
+}
+
+// TotalUsage returns the usage of the whole session
+func (s *Session) TotalUsage() TokenUsage {
+	var total TokenUsage
+	for _, turn := range s.TurnUsages() {
+		total.add(turn.TokenUsage)
+	}
+	return total
+}
+
+// String shows the tokens and cost, e.g. 12.3k tok $0.042, the cost with a + when part of
+// the tokens couldn't be priced and left out when none could
+func (u TokenUsage) String() string {
+	s := formatTokenCount(u.Input+u.Output) + " tok"
+	switch {
+	case u.Unpriced && u.Cost == 0:
+		return s
+	case u.Unpriced:
+		return s + " " + formatCost(u.Cost) + "+"
+	}
+	return s + " " + formatCost(u.Cost)
+}
+
+// formatCost shows a cost in dollars, with a tenth of a cent under a dollar
+func formatCost(cost float64) string {
+	if cost < 1 {
+		return fmt.Sprintf("$%.3f", cost)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// usageLLM replies with the usage Anthropic reports
+type usageLLM struct{ llms.Model }
+
+func (m *usageLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+		Content:        "Done",
+		GenerationInfo: map[string]any{"InputTokens": 1000, "OutputTokens": 200, "CacheReadInputTokens": 0},
+	}}}, nil
+}
+
+func TestSession_Usage(t *testing.T) {
+	t.Chdir(t.TempDir())
+	sess, err := NewSession(&usageLLM{}, &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-20250514"}}, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+	require.Equal(t, TokenUsage{}, sess.TotalUsage())
+
+	_, err = sess.Ask(context.Background(), "first")
+	require.NoError(t, err)
+	_, err = sess.Ask(context.Background(), "second")
+	require.NoError(t, err)
+
+	turns := sess.TurnUsages()
+	require.Len(t, turns, 2)
+	require.Equal(t, "first", turns[0].Prompt)
+	require.Positive(t, turns[0].Requests)
+	require.Equal(t, 1000*turns[0].Requests, turns[0].Input)
+	require.Equal(t, 200*turns[0].Requests, turns[0].Output)
+	// $3 per million input tokens and $15 per million output tokens
+	require.InDelta(t, float64(turns[0].Requests)*0.006, turns[0].Cost, 1e-9)
+	require.False(t, turns[0].Unpriced)
+
+	total := sess.TotalUsage()
+	require.Equal(t, turns[0].Input+turns[1].Input, total.Input)
+	require.True(t, strings.HasSuffix(total.String(), formatCost(total.Cost)), total.String())
+}
+
+func TestSession_FallbackUsage(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fallbackClient = func(cfg *Config) (llms.Model, error) { return &usageLLM{}, nil }
+	t.Cleanup(func() { fallbackClient = getModelClient })
+	cfg := &Config{LLM: LLMConfig{Provider: "anthropic", Model: "claude-sonnet-4-20250514", FallbackModels: []string{"openai/gpt-4o"}}}
+	sess, err := NewSession(&failingLLM{err: errors.New("529 Overloaded")}, cfg, RepoInfo{}, func(any) {})
+	require.NoError(t, err)
+
+	_, err = sess.Ask(context.Background(), "hello")
+	require.NoError(t, err)
+	// Charged at gpt-4o's $2.50 and $10 per million tokens, not the primary model's prices
+	turns := sess.TurnUsages()
+	require.InDelta(t, float64(turns[0].Requests)*0.0045, turns[0].Cost, 1e-9)
+}
+
+func TestResponseUsage(t *testing.T) {
+	response := func(info map[string]any) *llms.ContentResponse {
+		return &llms.ContentResponse{Choices: []*llms.ContentChoice{{GenerationInfo: info}}}
+	}
+
+	input, output, ok := responseUsage(response(map[string]any{"PromptTokens": 120, "CompletionTokens": 30, "TotalTokens": 150}))
+	require.True(t, ok)
+	require.Equal(t, 120, input)
+	require.Equal(t, 30, output)
+
+	input, _, ok = responseUsage(response(map[string]any{"InputTokens": 10, "OutputTokens": 5, "CacheCreationInputTokens": 100, "CacheReadInputTokens": 1000}))
+	require.True(t, ok)
+	require.Equal(t, 1110, input)
+
+	_, _, ok = responseUsage(response(map[string]any{"PromptTokens": 0, "CompletionTokens": 0}))
+	require.False(t, ok, "streams that don't report usage")
+	_, _, ok = responseUsage(response(nil))
+	require.False(t, ok)
+}
+
+func TestLookupModelPrice(t *testing.T) {
+	price, ok := lookupModelPrice("claude-opus-4-5-20251101")
+	require.True(t, ok)
+	require.Equal(t, modelPrice{5, 25}, price)
+	price, ok = lookupModelPrice("claude-opus-4-1-20250805")
+	require.True(t, ok)
+	require.Equal(t, modelPrice{15, 75}, price)
+	price, ok = lookupModelPrice("gpt-4o-mini-2024-07-18")
+	require.True(t, ok)
+	require.Equal(t, modelPrice{0.15, 0.6}, price)
+	_, ok = lookupModelPrice("llama3.2")
+	require.False(t, ok)
+
+	require.Equal(t, "1.2k tok", TokenUsage{Input: 1000, Output: 200, Unpriced: true}.String())
+	require.Equal(t, "1.2k tok $0.006", TokenUsage{Input: 1000, Output: 200, Cost: 0.006}.String())
+	require.Equal(t, "$12.50", formatCost(12.5))
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,6 +19,7 @@ import (
 	"time"
 
 	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 // RepoInfo contains information about the git repository and worktree
@@ -207,6 +212,249 @@ func diffLines(original, current []string) (added int, deleted int) {
 	return added, deleted
 }
 
+// commitPaths stages paths in the repository containing dir and commits them.
+// It returns the new commit hash, or "" when the paths have no changes to commit.
+// As a commit takes the whole index, it refuses when other files are already staged.
+func commitPaths(dir string, paths []string, message string) (string, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true, // linked worktrees keep their objects in the main repo
+	})
+	if err != nil {
+		return "", fmt.Errorf("opening repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("opening worktree: %w", err)
+	}
+	root := worktree.Filesystem.Root()
+
+	var rels []string
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			slog.Warn("not committing file outside the repository", "path", path)
+			continue
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return "", fmt.Errorf("reading status: %w", err)
+	}
+	for name, file := range status {
+		if file.Staging != gogit.Unmodified && file.Staging != gogit.Untracked && !slices.Contains(rels, name) {
+			return "", fmt.Errorf("%s is already staged, commit or unstage it first", name)
+		}
+	}
+
+	for _, rel := range rels {
+		if _, err := worktree.Add(rel); err != nil {
+			return "", fmt.Errorf("staging %s: %w", rel, err)
+		}
+	}
+
+	if status, err = worktree.Status(); err != nil {
+		return "", fmt.Errorf("reading status: %w", err)
+	}
+	staged := false
+	for _, file := range status {
+		if file.Staging != gogit.Unmodified && file.Staging != gogit.Untracked {
+			staged = true
+			break
+		}
+	}
+	if !staged {
+		return "", nil
+	}
+
+	hash, err := worktree.Commit(message, &gogit.CommitOptions{})
+	if err != nil {
+		return "", fmt.Errorf("committing: %w", err)
+	}
+	return hash.String(), nil
+}
+
+// asimiArtifacts are the files asimi writes into the project that don't belong in git, relative
+// to the working directory. Exports and dumps go to the temp directory
+func asimiArtifacts(cfg *Config) []string {
+	return []string{agentsFileName(cfg) + ".bak"}
+}
+
+// ensureGitignore lists paths, relative to dir, in the .gitignore at the root of the repository
+// containing dir, appending the missing ones, and returns the entries it added. Outside a
+// repository it does nothing
+func ensureGitignore(dir string, paths []string) ([]string, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true,
+	})
+	if errors.Is(err, gogit.ErrRepositoryNotExists) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("opening worktree: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(worktree.Filesystem.Root())
+	if err != nil {
+		return nil, err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return nil, err
+	}
+
+	gitignore := filepath.Join(root, ".gitignore")
+	data, err := os.ReadFile(gitignore)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listed := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		listed[strings.TrimSpace(line)] = true
+	}
+	var added []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, filepath.Join(dir, path))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		entry := "/" + filepath.ToSlash(rel)
+		if listed[entry] {
+			continue
+		}
+		listed[entry] = true
+		added = append(added, entry)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += strings.Join(added, "\n") + "\n"
+	return added, os.WriteFile(gitignore, []byte(content), 0o644)
+}
+
+// changedFiles lists the files changed in the last n commits of the repository
+// containing dir, sorted and relative to dir. Files since deleted are left out.
+func changedFiles(dir string, n int) ([]string, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("opening worktree: %w", err)
+	}
+	root := worktree.Filesystem.Root()
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("reading HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("reading commit %s: %w", head.Hash(), err)
+	}
+
+	changed := map[string]bool{}
+	for i := 0; i < n && commit != nil; i++ {
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("reading tree of %s: %w", commit.Hash, err)
+		}
+		var parent *object.Commit
+		var parentTree *object.Tree
+		if commit.NumParents() > 0 {
+			if parent, err = commit.Parent(0); err != nil {
+				return nil, fmt.Errorf("reading parent of %s: %w", commit.Hash, err)
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return nil, fmt.Errorf("reading tree of %s: %w", parent.Hash, err)
+			}
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return nil, fmt.Errorf("diffing %s: %w", commit.Hash, err)
+		}
+		for _, change := range changes {
+			if change.To.Name != "" {
+				changed[change.To.Name] = true
+			}
+		}
+		commit = parent
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for name := range changed {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(absDir, path); err == nil {
+			path = rel
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// blameFile attributes each line of path, as committed at HEAD, to the commit that last changed it
+func blameFile(path string) (*gogit.BlameResult, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := gogit.PlainOpenWithOptions(filepath.Dir(abs), &gogit.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("opening worktree: %w", err)
+	}
+	rel, err := filepath.Rel(worktree.Filesystem.Root(), abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("%s is outside the repository", path)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("reading HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("reading commit %s: %w", head.Hash(), err)
+	}
+	result, err := gogit.Blame(commit, filepath.ToSlash(rel))
+	if err != nil {
+		return nil, fmt.Errorf("blaming %s: %w", rel, err)
+	}
+	return result, nil
+}
+
 // GetRepoInfo returns information about the current git repository and worktree
 func GetRepoInfo() RepoInfo {
 
@@ -294,6 +542,36 @@ func GetRepoInfo() RepoInfo {
 	return repoInfo
 }
 
+var (
+	showHiddenMu    sync.RWMutex
+	showHiddenFiles = true
+)
+
+// initShowHidden sets whether file completion and the file tools include dotfiles from the ui config
+func initShowHidden(config *Config) {
+	show := true
+	if config != nil {
+		show = config.UI.ShowHidden
+	}
+	showHiddenMu.Lock()
+	defer showHiddenMu.Unlock()
+	showHiddenFiles = show
+}
+
+// isHiddenPath reports whether a path is left out of file listings: anything in .git, and
+// any dotfile or dotfile directory when ui.show_hidden is off
+func isHiddenPath(path string) bool {
+	showHiddenMu.RLock()
+	show := showHiddenFiles
+	showHiddenMu.RUnlock()
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(path)), "/") {
+		if part == ".git" || (!show && len(part) > 1 && part[0] == '.' && part != "..") {
+			return true
+		}
+	}
+	return false
+}
+
 func getFileTree(root string) ([]string, error) {
 	var files []string
 	// Directories to ignore at any level
@@ -310,11 +588,14 @@ func getFileTree(root string) ([]string, error) {
 		}
 
 		if info.IsDir() {
-			if ignoreDirs[info.Name()] {
+			if ignoreDirs[info.Name()] || (path != root && isHiddenPath(info.Name())) {
 				return filepath.SkipDir
 			}
 			return nil
 		}
+		if isHiddenPath(info.Name()) {
+			return nil
+		}
 
 		// We only want files.
 		// Let's make sure the path is relative to the root.
@@ -719,3 +1000,151 @@ func summarizeStatus(status gogit.Status) string {
 	}
 	return result
 }
+
+// gitStatusPlaceholder in a prompt is replaced with the working tree status
+const gitStatusPlaceholder = "{{git_status}}"
+
+// gitStatusContext names the context entry :context git adds
+const gitStatusContext = "git status"
+
+// gitStatusReport summarizes the working tree status of the repository holding dir,
+// listing the changed files in `git status --short` format
+func gitStatusReport(dir string) (string, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return "", fmt.Errorf("not a git repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Git status on branch %s: ", cmp.Or(readCurrentBranch(repo), "(detached)"))
+	if status.IsClean() {
+		b.WriteString("clean\n")
+		return b.String(), nil
+	}
+	b.WriteString(summarizeStatus(status) + "\n")
+	paths := make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		entry := status[path]
+		if entry.Staging == gogit.Unmodified && entry.Worktree == gogit.Unmodified {
+			continue
+		}
+		fmt.Fprintf(&b, "%c%c %s\n", entry.Staging, entry.Worktree, path)
+	}
+	return b.String(), nil
+}
+
+// expandGitStatus replaces {{git_status}} in a prompt with the status of the current repository
+func expandGitStatus(prompt string) string {
+	if !strings.Contains(prompt, gitStatusPlaceholder) {
+		return prompt
+	}
+	report, err := gitStatusReport(".")
+	if err != nil {
+		report = fmt.Sprintf("(git status unavailable: %v)", err)
+	}
+	return strings.ReplaceAll(prompt, gitStatusPlaceholder, strings.TrimSuffix(report, "\n"))
+}
+
+// redactedMask replaces any secret found in tool output or log attributes
+const redactedMask = "[REDACTED]"
+
+// defaultRedactPatterns match well known secret formats: API keys, bearer tokens
+// and provider specific access tokens
+var defaultRedactPatterns = []string{
+	`\bsk-ant-[A-Za-z0-9_\-]{8,}`,
+	`\bsk-[A-Za-z0-9_\-]{16,}`,
+	`(?i)\bbearer\s+[A-Za-z0-9._~+/=\-]{16,}`,
+	`\bgh[pousr]_[A-Za-z0-9]{20,}`,
+	`AKIA[0-9A-Z]{16}`,
+	`AIza[0-9A-Za-z_\-]{35}`,
+}
+
+var (
+	redactPatternsMu sync.RWMutex
+	redactPatterns   = compileRedactPatterns(nil)
+)
+
+// compileRedactPatterns compiles the default patterns followed by the extra ones.
+// Invalid extra patterns are logged and skipped.
+func compileRedactPatterns(extra []string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(defaultRedactPatterns)+len(extra))
+	for _, p := range defaultRedactPatterns {
+		patterns = append(patterns, regexp.MustCompile(p))
+	}
+	for _, p := range extra {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			slog.Warn("ignoring invalid redact pattern", "pattern", p, "error", err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns
+}
+
+// initRedactor sets the secret patterns from the security config
+func initRedactor(config *Config) {
+	var extra []string
+	if config != nil {
+		extra = config.Security.RedactPatterns
+	}
+	compiled := compileRedactPatterns(extra)
+
+	redactPatternsMu.Lock()
+	defer redactPatternsMu.Unlock()
+	redactPatterns = compiled
+}
+
+// redactSecrets masks every value matching a secret pattern
+func redactSecrets(s string) string {
+	if s == "" {
+		return s
+	}
+	redactPatternsMu.RLock()
+	defer redactPatternsMu.RUnlock()
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, redactedMask)
+	}
+	return s
+}
+
+// redactLogAttr is used as slog's ReplaceAttr to keep secrets out of the logs
+func redactLogAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
+
+// formatBytes renders a size in B, KB, MB or GB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, suffix := float64(n)/unit, "KB"
+	for _, next := range []string{"MB", "GB"} {
+		if size < unit {
+			break
+		}
+		size, suffix = size/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", size, suffix)
+}